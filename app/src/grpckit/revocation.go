@@ -0,0 +1,53 @@
+package grpckit
+
+import (
+	"app/logger"
+	"context"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// 切断された時に繋ぎ直すまでの間隔
+	revocationRetryInterval = time.Second * 5
+)
+
+// 認証サーバーからトークンの失効を受け取り続ける (切断された時は繋ぎ直す)
+func WatchRevocations(onRevocation func(*Revocation)) error {
+	conn, err := grpc.NewClient(os.Getenv("GRPC_ADDR"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+
+	revocationClient := NewRevocationServiceClient(conn)
+
+	go func() {
+		// 最後に受け取った失効の日時 (繋ぎ直した時に取りこぼしを受け取る)
+		var since int64
+
+		for {
+			stream, err := revocationClient.WatchRevocations(context.Background(), &WatchRevocationsRequest{Since: since})
+			if err == nil {
+				for {
+					revocation, err := stream.Recv()
+					if err != nil {
+						logger.PrintErr("失効の受信が切断されました", err)
+						break
+					}
+
+					onRevocation(revocation)
+					since = max(since, revocation.RevokedAt)
+				}
+			} else {
+				logger.PrintErr("失効の購読に失敗しました", err)
+			}
+
+			time.Sleep(revocationRetryInterval)
+		}
+	}()
+
+	return nil
+}
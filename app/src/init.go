@@ -11,6 +11,9 @@ func SetupRouter(router *echo.Echo) {
 	// logger 設定
 	router.Use(middleware.Logger())
 
-	// hello world
-	router.GET("/hello", controllers.Hello,middlewares.RequireAuth)
+	// hello world (必要なラベルは LABEL_POLICY_FILE で設定する)
+	hellog := middlewares.PolicyGroup(router, "/hello")
+	{
+		hellog.GET("", controllers.Hello)
+	}
 }
\ No newline at end of file
@@ -2,6 +2,7 @@ package main
 
 import (
 	"app/grpckit"
+	"app/logger"
 	"app/middlewares"
 	"app/models"
 	"app/services"
@@ -24,6 +25,13 @@ func Init() {
 	// GRPC クライアント初期化
 	grpckit.Init()
 
+	// 認証サーバーで失効させたトークンを受け取る
+	if err := grpckit.WatchRevocations(func(revocation *grpckit.Revocation) {
+		middlewares.RevokeTokens(revocation.UserID, revocation.SessionID, revocation.RevokedAt)
+	}); err != nil {
+		logger.PrintErr(err)
+	}
+
 	// result,err := grpckit.SearchUser("", "test")
 
 	// // エラー処理
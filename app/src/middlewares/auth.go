@@ -25,6 +25,11 @@ func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 			return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
 		}
 
+		// 認証サーバーで失効させたトークンの時
+		if IsTokenRevoked(claim) {
+			return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+		}
+
 		// contextにトークンを格納
 		ctx.Set("claim", claim)
 		// トークンを格納
@@ -6,13 +6,35 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"os"
+	"strings"
 )
 
 var (
 	pubKey ed25519.PublicKey
+
+	// 受け付けるトークンの発行者 (認証サーバーの PUBLIC_URL)
+	tokenIssuer = "https://localhost:8370/auth"
+
+	// このアプリのクライアントID (設定した時だけ、このクライアント向けに発行したトークンも受け付ける)
+	tokenAudience string
 )
 
 func Init() {
+	// 環境変数から発行者とクライアントIDを取得
+	if issuer := os.Getenv("AUTH_ISSUER"); issuer != "" {
+		tokenIssuer = strings.TrimSuffix(issuer, "/")
+	}
+	tokenAudience = os.Getenv("APP_AUDIENCE")
+
+	// 認証サーバーの JWKS (kid で鍵を選ぶ)
+	initJwks()
+
+	// ルートグループごとに必要なラベル (読み込めない時は全て拒否になるので起動しない)
+	if err := initLabelPolicy(); err != nil {
+		logger.PrintErr(err)
+		os.Exit(1)
+	}
+
 	// PEMブロックの解析
 	block, _ := pem.Decode([]byte(os.Getenv("JWT_PUBLIC_KEY")))
 	if block == nil {
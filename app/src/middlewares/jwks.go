@@ -0,0 +1,185 @@
+package middlewares
+
+import (
+	"app/logger"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// JWKS を取り直す間隔
+	jwksRefreshInterval = time.Minute * 10
+
+	// 知らない kid の時に取り直す最短間隔
+	jwksMissInterval = time.Second * 10
+)
+
+var (
+	// 認証サーバーの JWKS の URL
+	jwksURL string
+
+	// 取得した公開鍵 (kid ごと)
+	jwksKeys      = map[string]ed25519.PublicKey{}
+	jwksFetchedAt time.Time  // 最後に取得を始めた時刻
+	jwksMutex     sync.Mutex // jwksKeys と jwksFetchedAt を守る (取得中は持たない)
+
+	jwksClient = &http.Client{Timeout: time.Second * 5}
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+}
+
+func initJwks() {
+	// 環境変数から取得
+	jwksURL = os.Getenv("JWKS_URL")
+	if jwksURL == "" {
+		logger.Println("JWKS_URL が設定されていないため JWT_PUBLIC_KEY で検証します")
+		return
+	}
+
+	// 最初の取得
+	claimJwksFetch(0)
+	refreshJwks()
+}
+
+// JWKS を取得する (ロックは持たない)
+func fetchJwks() (map[string]ed25519.PublicKey, error) {
+	// 取得する
+	res, err := jwksClient.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status: " + res.Status)
+	}
+
+	// デコードする
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]ed25519.PublicKey{}
+	for _, key := range body.Keys {
+		// Ed25519 以外は使わない
+		if key.Kty != "OKP" || key.Crv != "Ed25519" || key.Kid == "" {
+			continue
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			continue
+		}
+
+		keys[key.Kid] = ed25519.PublicKey(x)
+	}
+
+	return keys, nil
+}
+
+// 前回の取得から interval 以上経っていれば取得する権利を取る
+// (取得時刻を先に進めるので、同時に取得するリクエストは 1 つだけになる)
+func claimJwksFetch(interval time.Duration) bool {
+	jwksMutex.Lock()
+	defer jwksMutex.Unlock()
+
+	if !jwksFetchedAt.IsZero() && time.Since(jwksFetchedAt) <= interval {
+		return false
+	}
+
+	jwksFetchedAt = time.Now()
+	return true
+}
+
+// JWKS を取得して差し替える (取得中は他のリクエストを止めない)
+func refreshJwks() {
+	keys, err := fetchJwks()
+	if err != nil {
+		logger.PrintErr("JWKS の取得に失敗しました: %v", err)
+		return
+	}
+
+	jwksMutex.Lock()
+	defer jwksMutex.Unlock()
+
+	jwksKeys = keys
+}
+
+// 取得済みの鍵から探す
+func findJwk(keyID string) (ed25519.PublicKey, bool) {
+	jwksMutex.Lock()
+	defer jwksMutex.Unlock()
+
+	key, ok := jwksKeys[keyID]
+	return key, ok
+}
+
+// JWK Thumbprint を計算する (認証サーバーの kid と同じ方式)
+func jwkThumbprint(publicKey ed25519.PublicKey) string {
+	canonical := `{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(publicKey) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// kid から公開鍵を探す (見つからない時は取り直す)
+func lookupJwk(keyID string) (ed25519.PublicKey, bool) {
+	// 古くなった時は取り直す
+	if claimJwksFetch(jwksRefreshInterval) {
+		refreshJwks()
+	}
+
+	if key, ok := findJwk(keyID); ok {
+		return key, true
+	}
+
+	// ローテーションされた直後かもしれない
+	if claimJwksFetch(jwksMissInterval) {
+		refreshJwks()
+	}
+
+	return findJwk(keyID)
+}
+
+// トークンの kid から検証に使う公開鍵を選ぶ
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+
+	// JWKS を使わない時と kid がない時は設定された公開鍵
+	if jwksURL == "" || keyID == "" {
+		if len(pubKey) == 0 {
+			return nil, errors.New("no public key configured")
+		}
+
+		return pubKey, nil
+	}
+
+	// 設定された公開鍵と同じ時は取得しない
+	if len(pubKey) != 0 && keyID == jwkThumbprint(pubKey) {
+		return pubKey, nil
+	}
+
+	key, ok := lookupJwk(keyID)
+	if !ok {
+		return nil, errors.New("unknown key id: " + keyID)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// JWKS を返すテスト用のサーバーに差し替える (終了時に戻す)
+func useTestJwks(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	jwksMutex.Lock()
+	previousURL, previousKeys, previousFetchedAt := jwksURL, jwksKeys, jwksFetchedAt
+	jwksURL, jwksKeys, jwksFetchedAt = server.URL, map[string]ed25519.PublicKey{}, time.Time{}
+	jwksMutex.Unlock()
+
+	t.Cleanup(func() {
+		jwksMutex.Lock()
+		defer jwksMutex.Unlock()
+
+		jwksURL, jwksKeys, jwksFetchedAt = previousURL, previousKeys, previousFetchedAt
+	})
+}
+
+// 公開鍵を JWKS で返す
+func writeTestJwks(t *testing.T, writer http.ResponseWriter, keys map[string]ed25519.PublicKey) {
+	body := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+
+	for kid, publicKey := range keys {
+		body.Keys = append(body.Keys, jwk{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(publicKey), Kid: kid})
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(body); err != nil {
+		t.Error(err)
+	}
+}
+
+func newTestPublicKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicKey
+}
+
+func TestLookupJwk(t *testing.T) {
+	publicKey := newTestPublicKey(t)
+	var fetches atomic.Int32
+
+	useTestJwks(t, func(writer http.ResponseWriter, request *http.Request) {
+		fetches.Add(1)
+		writeTestJwks(t, writer, map[string]ed25519.PublicKey{"key-1": publicKey})
+	})
+
+	// 最初は取得する
+	key, ok := lookupJwk("key-1")
+	if !ok || !key.Equal(publicKey) {
+		t.Fatal("key-1 was not found")
+	}
+
+	// 取得済みの鍵は取り直さない
+	if _, ok := lookupJwk("key-1"); !ok || fetches.Load() != 1 {
+		t.Fatalf("expected 1 fetch, got %d", fetches.Load())
+	}
+
+	// 知らない kid でもすぐには取り直さない
+	if _, ok := lookupJwk("unknown"); ok || fetches.Load() != 1 {
+		t.Fatalf("expected 1 fetch after a miss, got %d", fetches.Load())
+	}
+}
+
+func TestLookupJwkDoesNotBlockDuringFetch(t *testing.T) {
+	publicKey := newTestPublicKey(t)
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	useTestJwks(t, func(writer http.ResponseWriter, request *http.Request) {
+		started <- struct{}{}
+		<-release
+		writeTestJwks(t, writer, map[string]ed25519.PublicKey{"key-1": publicKey, "key-2": publicKey})
+	})
+
+	// 取得済みの鍵
+	jwksMutex.Lock()
+	jwksKeys = map[string]ed25519.PublicKey{"key-1": publicKey}
+	jwksFetchedAt = time.Now().Add(-jwksRefreshInterval * 2)
+	jwksMutex.Unlock()
+
+	// 古くなったので取り直す (サーバーは応答を止めている)
+	done := make(chan bool)
+	go func() {
+		_, ok := lookupJwk("key-2")
+		done <- ok
+	}()
+	<-started
+
+	// 取得中も取得済みの鍵はすぐに使える
+	lookedUp := make(chan bool)
+	go func() {
+		_, ok := lookupJwk("key-1")
+		lookedUp <- ok
+	}()
+
+	select {
+	case ok := <-lookedUp:
+		if !ok {
+			t.Fatal("key-1 was not found during the fetch")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup was blocked by the fetch")
+	}
+
+	// 取得が終わると新しい鍵が使える
+	close(release)
+	if ok := <-done; !ok {
+		t.Fatal("key-2 was not found after the fetch")
+	}
+}
@@ -2,52 +2,134 @@ package middlewares
 
 import (
 	"app/logger"
+	"errors"
+	"slices"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	// アクセストークンの typ ヘッダ (RFC 9068)
+	accessTokenType = "at+jwt"
+)
+
+var (
+	// アクセストークンではない時のエラー
+	ErrNotAccessToken = errors.New("not an access token")
+
+	// 他のクライアント向けのトークンの時のエラー
+	ErrInvalidAudience = errors.New("token is not issued for this app")
+
+	// クレームが足りない、もしくは型が違う時のエラー
+	ErrInvalidClaims = errors.New("invalid token claims")
+)
+
 type AccessTokenClaim struct {
 	UserID   string   // ユーザーID
 	Labels   []string // ラベル
 	ProvCode string   // プロバイダーコード
 	ProvUid  string   // プロバイダーUID
+
+	SessionID string // 発行元のセッション (ないトークンもある)
+	IssuedAt  int64  // 発行日時 (失効の判定に使う)
+
+	// アカウントの種類 (user / service_account)
+	PrincipalType string
+}
+
+// サービスアカウント (人間以外) のトークンか
+func (claim AccessTokenClaim) IsServiceAccount() bool {
+	return claim.PrincipalType == "service_account"
 }
 
 func ValidateToken(tokenString string) (AccessTokenClaim, error) {
+	return validateToken(tokenString, verificationKey, tokenIssuer, tokenAudience)
+}
+
+// keyFunc で選んだ鍵でトークンを検証する
+// 発行者が issuer で、aud がないか audience を含むアクセストークンだけ受け付ける
+func validateToken(tokenString string, keyFunc jwt.Keyfunc, issuer string, audience string) (AccessTokenClaim, error) {
 	logger.Println("トークンを検証します")
 
 	// トークンをパースする
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return pubKey, nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+		// ID トークンなどは受け付けない
+		if tokenType, _ := token.Header["typ"].(string); tokenType != accessTokenType {
+			return nil, ErrNotAccessToken
+		}
+
+		return keyFunc(token)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithExpirationRequired(), jwt.WithIssuer(issuer))
 
 	// エラー処理
 	if err != nil {
 		return AccessTokenClaim{}, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		labels := claims["labels"].([]interface{})
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return AccessTokenClaim{}, ErrInvalidClaims
+	}
 
-		return AccessTokenClaim{
-			UserID:   claims["userID"].(string),
-			Labels:   interfaceToString(labels),
-			ProvCode: claims["provCode"].(string),
-			ProvUid:  claims["provUid"].(string),
-		}, nil
-	} else {
-		logger.PrintErr(err)
+	// 他のクライアントに発行したトークンの時 (このアプリ向けのトークンには aud がない)
+	audiences, err := claims.GetAudience()
+	if err != nil {
+		return AccessTokenClaim{}, err
+	}
+	if len(audiences) > 0 && (audience == "" || !slices.Contains(audiences, audience)) {
+		return AccessTokenClaim{}, ErrInvalidAudience
 	}
 
-	return AccessTokenClaim{}, err
+	// ユーザーID
+	userID, _ := claims["userID"].(string)
+	if userID == "" {
+		return AccessTokenClaim{}, ErrInvalidClaims
+	}
+
+	// ラベル (ない時は空)
+	labels := []string{}
+	if values, ok := claims["labels"].([]interface{}); ok {
+		if labels, ok = interfaceToString(values); !ok {
+			return AccessTokenClaim{}, ErrInvalidClaims
+		}
+	}
+
+	// 古いトークンには principalType がない
+	principalType, _ := claims["principalType"].(string)
+	if principalType == "" {
+		principalType = "user"
+	}
+
+	// プロバイダ
+	provCode, _ := claims["provCode"].(string)
+	provUid, _ := claims["provUid"].(string)
+
+	// 発行元のセッションと発行日時
+	sessionID, _ := claims["sid"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+
+	return AccessTokenClaim{
+		UserID:        userID,
+		Labels:        labels,
+		ProvCode:      provCode,
+		ProvUid:       provUid,
+		SessionID:     sessionID,
+		IssuedAt:      int64(issuedAt),
+		PrincipalType: principalType,
+	}, nil
 }
 
-func interfaceToString(values []interface{}) []string {
-	var result []string
+// 文字列の配列にする (文字列以外がある時は false)
+func interfaceToString(values []interface{}) ([]string, bool) {
+	result := []string{}
 	for _, value := range values {
-		result = append(result, value.(string))
+		str, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+
+		result = append(result, str)
 	}
 
-	return result
+	return result, true
 } 
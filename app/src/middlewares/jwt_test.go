@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"app/middlewares/middlewaretest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateToken(t *testing.T) {
+	// 発行するトークンの typ がずれていないか
+	if middlewaretest.AccessTokenType != accessTokenType {
+		t.Fatalf("middlewaretest.AccessTokenType = %q, want %q", middlewaretest.AccessTokenType, accessTokenType)
+	}
+
+	signer := middlewaretest.NewSigner(t)
+
+	tokenString := signer.MintAccessToken(t, middlewaretest.Claims{
+		UserID:    "user-1",
+		Labels:    []string{"admin", "staff"},
+		ProvCode:  "basic",
+		SessionID: "session-1",
+	})
+
+	claim, err := validateToken(tokenString, signer.KeyFunc, middlewaretest.Issuer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claim.UserID != "user-1" || claim.SessionID != "session-1" || claim.ProvCode != "basic" ||
+		!claim.HasAllLabels("admin", "staff") || claim.IsServiceAccount() || claim.IssuedAt == 0 {
+		t.Fatalf("unexpected claim: %+v", claim)
+	}
+
+	// 別の鍵で署名したトークン
+	if _, err := validateToken(middlewaretest.NewSigner(t).MintAccessToken(t, middlewaretest.Claims{UserID: "user-1"}), signer.KeyFunc, middlewaretest.Issuer, ""); err == nil {
+		t.Fatal("expected signature error")
+	}
+}
+
+func TestValidateTokenRejects(t *testing.T) {
+	signer := middlewaretest.NewSigner(t)
+
+	// クレームを書き換えたトークン
+	withClaims := func(edit func(claims jwt.MapClaims)) string {
+		claims := middlewaretest.AccessTokenClaims(middlewaretest.Claims{UserID: "user-1", Labels: []string{"admin"}})
+		edit(claims)
+
+		return signer.Sign(t, claims, accessTokenType)
+	}
+
+	cases := []struct {
+		name  string
+		token string
+	}{
+		// ID トークン (typ がない)
+		{"id token", signer.Sign(t, middlewaretest.AccessTokenClaims(middlewaretest.Claims{UserID: "user-1"}), "")},
+		{"wrong typ", signer.Sign(t, middlewaretest.AccessTokenClaims(middlewaretest.Claims{UserID: "user-1"}), "JWT")},
+		{"other issuer", withClaims(func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" })},
+		{"no issuer", withClaims(func(claims jwt.MapClaims) { delete(claims, "iss") })},
+		{"no expiry", withClaims(func(claims jwt.MapClaims) { delete(claims, "exp") })},
+		{"expired", withClaims(func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() })},
+		// 他のクライアントに発行したトークン
+		{"other audience", withClaims(func(claims jwt.MapClaims) { claims["aud"] = "third-party" })},
+		// 型が違うクレーム (panic しない)
+		{"no user id", withClaims(func(claims jwt.MapClaims) { delete(claims, "userID") })},
+		{"user id type", withClaims(func(claims jwt.MapClaims) { claims["userID"] = 1 })},
+		{"label type", withClaims(func(claims jwt.MapClaims) { claims["labels"] = []interface{}{"admin", 1} })},
+	}
+
+	for _, c := range cases {
+		if _, err := validateToken(c.token, signer.KeyFunc, middlewaretest.Issuer, ""); err == nil {
+			t.Fatalf("%s: expected error", c.name)
+		}
+	}
+}
+
+func TestValidateTokenAudience(t *testing.T) {
+	signer := middlewaretest.NewSigner(t)
+
+	claims := middlewaretest.AccessTokenClaims(middlewaretest.Claims{UserID: "user-1"})
+	claims["aud"] = "this-app"
+	tokenString := signer.Sign(t, claims, accessTokenType)
+
+	// このアプリのクライアントIDを設定した時だけ受け付ける
+	if _, err := validateToken(tokenString, signer.KeyFunc, middlewaretest.Issuer, "this-app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validateToken(tokenString, signer.KeyFunc, middlewaretest.Issuer, ""); err == nil {
+		t.Fatal("expected audience error without APP_AUDIENCE")
+	}
+
+	// ラベルや provCode がないトークンも受け付ける
+	claims = jwt.MapClaims{
+		"iss":    middlewaretest.Issuer,
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"userID": "service-1",
+	}
+	claim, err := validateToken(signer.Sign(t, claims, accessTokenType), signer.KeyFunc, middlewaretest.Issuer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.UserID != "service-1" || len(claim.Labels) != 0 {
+		t.Fatalf("unexpected claim: %+v", claim)
+	}
+}
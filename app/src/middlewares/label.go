@@ -0,0 +1,94 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ラベルの一致条件
+	labelMatchAny = "any" // どれか一つ
+	labelMatchAll = "all" // 全て
+)
+
+// ラベルを持っているか
+func (claim AccessTokenClaim) HasLabel(label string) bool {
+	return slices.Contains(claim.Labels, label)
+}
+
+// どれか一つのラベルを持っているか (空の時は true)
+func (claim AccessTokenClaim) HasAnyLabel(labels ...string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+
+	for _, label := range labels {
+		if claim.HasLabel(label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 全てのラベルを持っているか
+func (claim AccessTokenClaim) HasAllLabels(labels ...string) bool {
+	for _, label := range labels {
+		if !claim.HasLabel(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ラベルが足りない時のレスポンス (どのミドルウェアでも同じ形にする)
+func labelForbidden(ctx echo.Context, match string, labels []string) error {
+	return ctx.JSON(http.StatusForbidden, echo.Map{
+		"error":          "forbidden",
+		"match":          match,
+		"requiredLabels": labels,
+	})
+}
+
+// ラベルを確認するミドルウェアを作る (RequireAuth の後に使う)
+func requireLabels(match string, labels []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			// RequireAuth が格納したクレームを取得
+			claim, ok := ctx.Get("claim").(AccessTokenClaim)
+			if !ok {
+				return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+			}
+
+			// ラベルを確認
+			allowed := claim.HasAllLabels(labels...)
+			if match == labelMatchAny {
+				allowed = claim.HasAnyLabel(labels...)
+			}
+
+			if !allowed {
+				return labelForbidden(ctx, match, labels)
+			}
+
+			return next(ctx)
+		}
+	}
+}
+
+// ラベルを持っている時だけ通す
+func RequireLabel(label string) echo.MiddlewareFunc {
+	return requireLabels(labelMatchAll, []string{label})
+}
+
+// どれか一つのラベルを持っている時だけ通す
+func RequireAnyLabel(labels ...string) echo.MiddlewareFunc {
+	return requireLabels(labelMatchAny, labels)
+}
+
+// 全てのラベルを持っている時だけ通す
+func RequireAllLabels(labels ...string) echo.MiddlewareFunc {
+	return requireLabels(labelMatchAll, labels)
+}
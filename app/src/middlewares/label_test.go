@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ミドルウェアを通した時のステータスコード (claim が nil の時は認証されていない)
+func runWithClaim(middleware echo.MiddlewareFunc, claim *AccessTokenClaim) int {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, recorder)
+
+	if claim != nil {
+		ctx.Set("claim", *claim)
+	}
+
+	handler := middleware(func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	})
+	if err := handler(ctx); err != nil {
+		return http.StatusInternalServerError
+	}
+
+	return recorder.Code
+}
+
+func TestRequireLabels(t *testing.T) {
+	staff := &AccessTokenClaim{UserID: "user-1", Labels: []string{"staff", "auditor"}}
+
+	cases := []struct {
+		name       string
+		middleware echo.MiddlewareFunc
+		claim      *AccessTokenClaim
+		expected   int
+	}{
+		{"label", RequireLabel("staff"), staff, http.StatusOK},
+		{"missing label", RequireLabel("admin"), staff, http.StatusForbidden},
+		{"any", RequireAnyLabel("admin", "auditor"), staff, http.StatusOK},
+		{"any none", RequireAnyLabel("admin", "owner"), staff, http.StatusForbidden},
+		{"any empty", RequireAnyLabel(), staff, http.StatusOK},
+		{"all", RequireAllLabels("staff", "auditor"), staff, http.StatusOK},
+		{"all partial", RequireAllLabels("staff", "admin"), staff, http.StatusForbidden},
+		{"no labels", RequireLabel("staff"), &AccessTokenClaim{UserID: "user-2"}, http.StatusForbidden},
+		{"unauthenticated", RequireLabel("staff"), nil, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		if code := runWithClaim(c.middleware, c.claim); code != c.expected {
+			t.Fatalf("%s: expected %d, got %d", c.name, c.expected, code)
+		}
+	}
+}
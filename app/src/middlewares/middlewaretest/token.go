@@ -0,0 +1,113 @@
+// テストで使うアクセストークンを発行する
+//
+// middlewares のテストからも使うので middlewares には依存しない
+package middlewaretest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// テストで使う発行者
+	Issuer = "https://auth.example.com"
+
+	// アクセストークンの typ ヘッダ (RFC 9068)
+	AccessTokenType = "at+jwt"
+)
+
+// 発行するトークンの内容
+type Claims struct {
+	UserID    string   // ユーザーID
+	Labels    []string // ラベル
+	ProvCode  string   // プロバイダーコード
+	ProvUid   string   // プロバイダーUID
+	SessionID string   // 発行元のセッション
+
+	// アカウントの種類 (空の時は user)
+	PrincipalType string
+}
+
+// テスト用の署名鍵 (検証に使う鍵は KeyFunc で渡し、設定された公開鍵は書き換えない)
+type Signer struct {
+	privateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+func NewSigner(t testing.TB) *Signer {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Signer{privateKey: privateKey, PublicKey: publicKey}
+}
+
+// 検証に使う鍵を返す
+func (signer *Signer) KeyFunc(token *jwt.Token) (interface{}, error) {
+	return signer.PublicKey, nil
+}
+
+// クレームとヘッダの typ を指定して署名する
+func (signer *Signer) Sign(t testing.TB, claims jwt.MapClaims, tokenType string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	if tokenType != "" {
+		token.Header["typ"] = tokenType
+	}
+
+	tokenString, err := token.SignedString(signer.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokenString
+}
+
+// 認証サーバーが発行するものと同じ形のクレーム
+func AccessTokenClaims(claim Claims) jwt.MapClaims {
+	// ラベルがない時も配列にする
+	labels := claim.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	principalType := claim.PrincipalType
+	if principalType == "" {
+		principalType = "user"
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":           Issuer,
+		"sub":           claim.UserID,
+		"iat":           now.Unix(),
+		"exp":           now.Add(time.Minute * 10).Unix(),
+		"labels":        labels,
+		"userID":        claim.UserID,
+		"provCode":      claim.ProvCode,
+		"provUid":       claim.ProvUid,
+		"principalType": principalType,
+	}
+
+	// セッションから発行したトークンの時
+	if claim.SessionID != "" {
+		claims["sid"] = claim.SessionID
+	}
+
+	return claims
+}
+
+// アクセストークンを発行する
+func (signer *Signer) MintAccessToken(t testing.TB, claim Claims) string {
+	t.Helper()
+
+	return signer.Sign(t, AccessTokenClaims(claim), AccessTokenType)
+}
@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"app/logger"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ルートグループに必要なラベル
+type LabelRule struct {
+	Any []string `json:"any"` // どれか一つを持っていれば通す
+	All []string `json:"all"` // 全て持っている時だけ通す
+}
+
+var (
+	// グループ (パスの接頭辞) ごとのルール
+	labelPolicy = map[string]LabelRule{}
+)
+
+// ラベルのポリシーを読み込む
+//
+// LABEL_POLICY_FILE に次の形の JSON を置く
+//
+//	{
+//		"/admin": { "all": ["admin"] },
+//		"/reports": { "any": ["staff", "auditor"] },
+//		"/hello": {}
+//	}
+//
+// ルールのないグループは誰も通さない。認証だけ確認するグループは空のルールを書く。
+// ファイルを読み込めない時はエラーを返す (起動を止める)
+func initLabelPolicy() error {
+	path := os.Getenv("LABEL_POLICY_FILE")
+	if path == "" {
+		logger.Println("LABEL_POLICY_FILE が設定されていないため、ポリシーを使うグループは全て拒否します")
+		return nil
+	}
+
+	policy, err := loadLabelPolicy(path)
+	if err != nil {
+		return err
+	}
+
+	labelPolicy = policy
+	logger.Println("ラベルのポリシーを読み込みました", len(policy))
+
+	return nil
+}
+
+// ファイルからポリシーを読み込む
+func loadLabelPolicy(path string) (map[string]LabelRule, error) {
+	// ファイルを読み込む
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ラベルのポリシーの読み込みに失敗しました: %w", err)
+	}
+
+	policy := map[string]LabelRule{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("ラベルのポリシーの解析に失敗しました: %w", err)
+	}
+
+	return policy, nil
+}
+
+// ポリシーに書かれたラベルを確認するミドルウェア (RequireAuth の後に使う)
+func RequirePolicy(group string) echo.MiddlewareFunc {
+	return policyMiddleware(labelPolicy, group)
+}
+
+// policy のルールでグループを確認するミドルウェアを作る
+func policyMiddleware(policy map[string]LabelRule, group string) echo.MiddlewareFunc {
+	rule, ok := policy[group]
+
+	// ルールがない時は拒否する
+	if !ok {
+		logger.Println("ラベルのポリシーがないため全て拒否します", group)
+
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				return labelForbidden(ctx, labelMatchAll, []string{})
+			}
+		}
+	}
+
+	requireAny := requireLabels(labelMatchAny, rule.Any)
+	requireAll := requireLabels(labelMatchAll, rule.All)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return requireAll(requireAny(next))
+	}
+}
+
+// 認証とポリシーを設定したルートグループを作る
+func PolicyGroup(router *echo.Echo, prefix string, middleware ...echo.MiddlewareFunc) *echo.Group {
+	return router.Group(prefix, append([]echo.MiddlewareFunc{RequireAuth, RequirePolicy(prefix)}, middleware...)...)
+}
@@ -0,0 +1,117 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// テスト用のポリシーファイルを作る
+func writePolicyFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "labelpolicy.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadLabelPolicy(t *testing.T) {
+	policy, err := loadLabelPolicy(writePolicyFile(t, `{
+		"/admin": { "all": ["admin"] },
+		"/reports": { "any": ["staff", "auditor"] },
+		"/hello": {}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policy) != 3 || policy["/admin"].All[0] != "admin" || len(policy["/reports"].Any) != 2 {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	// 読み込めない時と解析できない時はエラーにする
+	if _, err := loadLabelPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	if _, err := loadLabelPolicy(writePolicyFile(t, `{"/admin": {"all": "admin"}`)); err == nil {
+		t.Fatal("expected error for invalid json")
+	}
+}
+
+func TestInitLabelPolicyFailsOnError(t *testing.T) {
+	t.Setenv("LABEL_POLICY_FILE", filepath.Join(t.TempDir(), "missing.json"))
+
+	if err := initLabelPolicy(); err == nil {
+		t.Fatal("expected error for missing policy file")
+	}
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	policy := map[string]LabelRule{
+		"/admin":   {All: []string{"admin", "staff"}},
+		"/reports": {Any: []string{"staff", "auditor"}},
+		"/hello":   {},
+	}
+
+	staff := &AccessTokenClaim{UserID: "user-1", Labels: []string{"staff"}}
+	admin := &AccessTokenClaim{UserID: "user-2", Labels: []string{"admin", "staff"}}
+	nobody := &AccessTokenClaim{UserID: "user-3"}
+
+	cases := []struct {
+		group    string
+		claim    *AccessTokenClaim
+		expected int
+	}{
+		{"/admin", admin, http.StatusOK},
+		{"/admin", staff, http.StatusForbidden},
+		{"/reports", staff, http.StatusOK},
+		{"/reports", nobody, http.StatusForbidden},
+		// 空のルールは認証だけ確認する
+		{"/hello", nobody, http.StatusOK},
+		{"/hello", nil, http.StatusUnauthorized},
+		// ルールのないグループは拒否する
+		{"/unlisted", admin, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if code := runWithClaim(policyMiddleware(policy, c.group), c.claim); code != c.expected {
+			t.Fatalf("%s %+v: expected %d, got %d", c.group, c.claim, c.expected, code)
+		}
+	}
+}
+
+func TestPolicyMiddlewareForbiddenShape(t *testing.T) {
+	// ルールのないグループもラベルが足りない時と同じ形で拒否する
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	recorder := httptest.NewRecorder()
+	ctx := echo.New().NewContext(request, recorder)
+	ctx.Set("claim", AccessTokenClaim{UserID: "user-1", Labels: []string{"admin"}})
+
+	handler := policyMiddleware(map[string]LabelRule{}, "/unlisted")(func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	})
+	if err := handler(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Error          string   `json:"error"`
+		Match          string   `json:"match"`
+		RequiredLabels []string `json:"requiredLabels"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != http.StatusForbidden || body.Error != "forbidden" || body.Match != labelMatchAll || body.RequiredLabels == nil {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 失効を覚えておく期間 (認証サーバーのアクセストークンの有効期間より長くする)
+	revocationRetention = time.Minute * 15
+)
+
+var (
+	// ユーザーごとの全てのトークンを失効させた日時
+	revokedUsers = map[string]int64{}
+
+	// 失効させたセッションと日時
+	revokedSessions = map[string]int64{}
+
+	revocationMutex sync.RWMutex
+)
+
+// 認証サーバーから届いた失効を記録する (sessionID が空の時はユーザーの全てのトークン)
+func RevokeTokens(userID string, sessionID string, revokedAt int64) {
+	revocationMutex.Lock()
+	defer revocationMutex.Unlock()
+
+	if sessionID != "" {
+		revokedSessions[sessionID] = max(revokedSessions[sessionID], revokedAt)
+	} else {
+		revokedUsers[userID] = max(revokedUsers[userID], revokedAt)
+	}
+
+	// 発行済みのトークンが全て期限切れになったものを消す
+	oldest := time.Now().Add(-revocationRetention).Unix()
+	for key, at := range revokedUsers {
+		if at < oldest {
+			delete(revokedUsers, key)
+		}
+	}
+	for key, at := range revokedSessions {
+		if at < oldest {
+			delete(revokedSessions, key)
+		}
+	}
+}
+
+// 失効させたトークンか
+// iat は秒単位なので、ユーザーの失効と同じ秒に発行されたものも失効として扱う
+func IsTokenRevoked(claim AccessTokenClaim) bool {
+	revocationMutex.RLock()
+	defer revocationMutex.RUnlock()
+
+	// セッションが終了している時
+	if claim.SessionID != "" {
+		if _, ok := revokedSessions[claim.SessionID]; ok {
+			return true
+		}
+	}
+
+	// ユーザーの全てのトークンを失効させた後に発行されていない時
+	if revokedAt, ok := revokedUsers[claim.UserID]; ok && claim.IssuedAt <= revokedAt {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsTokenRevoked(t *testing.T) {
+	now := time.Now().Unix()
+
+	RevokeTokens("revoked-user", "", now)
+	RevokeTokens("other-user", "revoked-session", now)
+
+	cases := []struct {
+		name     string
+		claim    AccessTokenClaim
+		expected bool
+	}{
+		{"issued before", AccessTokenClaim{UserID: "revoked-user", IssuedAt: now - 1}, true},
+		// iat は秒単位なので同じ秒に発行されたものも失効させる
+		{"same second", AccessTokenClaim{UserID: "revoked-user", IssuedAt: now}, true},
+		{"issued after", AccessTokenClaim{UserID: "revoked-user", IssuedAt: now + 1}, false},
+		{"revoked session", AccessTokenClaim{UserID: "other-user", SessionID: "revoked-session", IssuedAt: now + 1}, true},
+		{"other session", AccessTokenClaim{UserID: "other-user", SessionID: "live-session", IssuedAt: now - 1}, false},
+		{"other user", AccessTokenClaim{UserID: "unrelated-user", IssuedAt: now - 1}, false},
+	}
+
+	for _, c := range cases {
+		if revoked := IsTokenRevoked(c.claim); revoked != c.expected {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.expected, revoked)
+		}
+	}
+}
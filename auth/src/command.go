@@ -0,0 +1,37 @@
+package main
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"fmt"
+	"os"
+)
+
+// サブコマンドを実行する (実行した時は true)
+func runCommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	switch args[1] {
+	case "rotate-keys":
+		// データベースだけ初期化する
+		if err := models.Init(); err != nil {
+			logger.PrintErr(err)
+			os.Exit(1)
+		}
+
+		// 署名鍵をローテーションする
+		key, err := services.RotateSigningKey()
+		if err != nil {
+			logger.PrintErr(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(key.KeyID)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"auth/utils"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 認可リクエストを受け付けて同意画面を表示する
+func AuthorizePage(ctx echo.Context) error {
+	args := services.AuthorizeArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, false)
+	}
+
+	// 検証する
+	page, err := services.BeginAuthorize(args)
+
+	// エラー処理
+	if err != nil {
+		// クライアントに返せるエラー
+		var oauthErr *services.OauthError
+		if errors.As(err, &oauthErr) && oauthErr.RedirectURL != "" {
+			return ctx.Redirect(http.StatusFound, oauthErr.RedirectURL)
+		}
+
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, false)
+	}
+
+	// 他のサイトに埋め込ませない
+	ctx.Response().Header().Set("X-Frame-Options", "DENY")
+
+	return ctx.Render(http.StatusOK, "oauth2-authorize.html", echo.Map{
+		"clientName":     page.ClientName,
+		"scopes":         page.Scopes,
+		"loginMethods":   page.LoginMethods,
+		"passwordLogins": page.PasswordLogins,
+	})
+}
+
+// ログイン中のユーザーが認可する
+func Authorize(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	args := services.AuthorizeDecisionArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	args.UserID = session.UserID
+	args.SessionID = session.SessionID
+
+	// 認可する
+	result, err := services.Authorize(args)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// クライアントに返せるエラー
+		var oauthErr *services.OauthError
+		if errors.As(err, &oauthErr) && oauthErr.RedirectURL != "" {
+			return ctx.JSON(http.StatusOK, services.AuthorizeResult{RedirectURL: oauthErr.RedirectURL})
+		}
+
+		if errors.Is(err, services.ErrOauthClientNotFound) || errors.Is(err, services.ErrRedirectURIMismatch) {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// トークンエンドポイントのエラーを返す (RFC 6749 5.2)
+func oauthTokenError(ctx echo.Context, err error, basicAuth bool) error {
+	var oauthErr *services.OauthError
+	if !errors.As(err, &oauthErr) {
+		logger.PrintErr(err)
+		oauthErr = &services.OauthError{Status: http.StatusInternalServerError, Code: "server_error", Description: "internal server error"}
+	}
+
+	// Basic 認証に失敗した時
+	if oauthErr.Status == http.StatusUnauthorized && basicAuth {
+		ctx.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+
+	return ctx.JSON(oauthErr.Status, echo.Map{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
+
+// Basic 認証のクライアント情報を取り込む (値はフォームエンコードされている)
+func clientCredentials(ctx echo.Context, clientID *string, clientSecret *string) (bool, error) {
+	basicID, basicSecret, basicAuth := ctx.Request().BasicAuth()
+	if !basicAuth {
+		return false, nil
+	}
+
+	// 複数の方法で認証している時
+	if *clientSecret != "" {
+		return true, &services.OauthError{Status: http.StatusBadRequest, Code: "invalid_request", Description: "multiple client authentication methods"}
+	}
+
+	decodedID, idErr := url.QueryUnescape(basicID)
+	decodedSecret, secretErr := url.QueryUnescape(basicSecret)
+	if idErr != nil || secretErr != nil || (*clientID != "" && *clientID != decodedID) {
+		return true, &services.OauthError{Status: http.StatusUnauthorized, Code: "invalid_client", Description: "client authentication failed"}
+	}
+
+	*clientID = decodedID
+	*clientSecret = decodedSecret
+
+	return true, nil
+}
+
+// 認可コードをトークンに交換する
+func ExchangeToken(ctx echo.Context) error {
+	// レスポンスをキャッシュさせない
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ctx.Response().Header().Set("Pragma", "no-cache")
+
+	args := services.TokenArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		return oauthTokenError(ctx, &services.OauthError{Status: http.StatusBadRequest, Code: "invalid_request", Description: err.Error()}, false)
+	}
+
+	// クライアントの認証情報
+	basicAuth, err := clientCredentials(ctx, &args.ClientID, &args.ClientSecret)
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	// 交換する
+	token, err := services.ExchangeToken(args)
+
+	// エラー処理
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	return ctx.JSON(http.StatusOK, token)
+}
+
+// トークンが有効か調べる
+func Introspect(ctx echo.Context) error {
+	// レスポンスをキャッシュさせない
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+
+	args := services.IntrospectArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		return oauthTokenError(ctx, &services.OauthError{Status: http.StatusBadRequest, Code: "invalid_request", Description: err.Error()}, false)
+	}
+
+	// クライアントの認証情報
+	basicAuth, err := clientCredentials(ctx, &args.ClientID, &args.ClientSecret)
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	// 調べる
+	result, err := services.Introspect(args)
+
+	// エラー処理
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// トークンを失効させる
+func Revoke(ctx echo.Context) error {
+	args := services.RevokeArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		return oauthTokenError(ctx, &services.OauthError{Status: http.StatusBadRequest, Code: "invalid_request", Description: err.Error()}, false)
+	}
+
+	// クライアントの認証情報
+	basicAuth, err := clientCredentials(ctx, &args.ClientID, &args.ClientSecret)
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	// 失効させる
+	if err := services.Revoke(args); err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+// クライアント管理のエラーを返す
+func oauthClientError(ctx echo.Context, err error) error {
+	logger.PrintErr(err)
+
+	if errors.Is(err, services.ErrOauthClientNotFound) {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// クライアント一覧を取得
+func GetOauthClients(ctx echo.Context) error {
+	// サービスから取得
+	clients, err := services.GetOauthClients()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, clients)
+}
+
+// クライアントを作成
+func CreateOauthClient(ctx echo.Context) error {
+	bindData := services.OauthClient{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	client, err := services.CreateOauthClient(bindData)
+
+	// エラー処理
+	if err != nil {
+		return oauthClientError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, client)
+}
+
+// クライアントを更新
+func UpdateOauthClient(ctx echo.Context) error {
+	bindData := services.OauthClient{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	client, err := services.UpdateOauthClient(ctx.Param("id"), bindData)
+
+	// エラー処理
+	if err != nil {
+		return oauthClientError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, client)
+}
+
+// クライアントシークレットを再生成
+func RegenerateOauthClientSecret(ctx echo.Context) error {
+	// 再生成する
+	client, err := services.RegenerateOauthClientSecret(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		return oauthClientError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, client)
+}
+
+// クライアントを削除
+func DeleteOauthClient(ctx echo.Context) error {
+	// 削除する
+	err := services.DeleteOauthClient(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		return oauthClientError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"auth/logger"
 	"auth/services"
+	"auth/utils"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -30,18 +31,20 @@ func CreateBasicUser(ctx echo.Context) error {
 
 	// ユーザーを作成する
 	token, result := services.CreateBasicUser(services.CreateBasicUserArgs{
-		Name:        args.Name,
-		Email:       args.Email,
-		Password:    args.Password,
+		Name:      args.Name,
+		Email:     args.Email,
+		Password:  args.Password,
+		RemoteIP:  ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
 	})
 
 	// エラー処理
-	if result.Error != nil {
+	if !result.Success {
 		logger.PrintErr(result.Error)
 		return ctx.JSON(result.Code, echo.Map{"error": result.Error.Error()})
 	}
 
-	return ctx.JSON(result.Code, echo.Map{"token": token})
+	return ctx.JSON(result.Code, echo.Map{"token": token, "message": result.Message})
 }
 
 type LoginBasicUserArgs struct {
@@ -64,16 +67,128 @@ func LoginBasicUser(ctx echo.Context) error {
 	}
 
 	// ユーザーをログインする
-	token, result := services.LoginBasicUser(services.LoginBasicUserArgs{
-		Email:    args.Email,
-		Password: args.Password,
+	login, result := services.LoginBasicUser(services.LoginBasicUserArgs{
+		Email:     args.Email,
+		Password:  args.Password,
+		RemoteIP:  ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
 	})
 
 	// エラー処理
-	if result.Error != nil {
+	if !result.Success {
 		logger.PrintErr(result.Error)
 		return ctx.JSON(result.Code, echo.Map{"error": result.Error.Error()})
 	}
 
-	return ctx.JSON(result.Code, echo.Map{"token": token})
+	return ctx.JSON(result.Code, login)
+}
+
+type VerifyEmailArgs struct {
+	Token string `json:"token"`
+}
+
+// メールアドレスを確認する
+func VerifyEmail(ctx echo.Context) error {
+	// リクエストボディを取得
+	args := VerifyEmailArgs{}
+
+	// バインド
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 確認する
+	if err := services.VerifyEmail(args.Token); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+// メールのリンクからメールアドレスを確認する
+func VerifyEmailPage(ctx echo.Context) error {
+	// 確認する
+	if err := services.VerifyEmail(ctx.QueryParam("token")); err != nil {
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, false)
+	}
+
+	return ctx.Render(http.StatusOK, "message-screen.html", echo.Map{
+		"Title":   "メールアドレスを確認しました",
+		"Message": "ログイン画面からログインしてください。",
+	})
+}
+
+type ResendVerificationArgs struct {
+	Email string `json:"email"`
+}
+
+// 確認メールを再送信する
+func ResendVerification(ctx echo.Context) error {
+	// リクエストボディを取得
+	args := ResendVerificationArgs{}
+
+	// バインド
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 再送信する (存在するかどうかは返さない)
+	if err := services.ResendVerificationEmail(args.Email, ctx.RealIP()); err != nil {
+		return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+type ForgotPasswordArgs struct {
+	Email string `json:"email"`
+}
+
+// パスワードリセットを要求する
+func ForgotPassword(ctx echo.Context) error {
+	// リクエストボディを取得
+	args := ForgotPasswordArgs{}
+
+	// バインド
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 要求する (存在するかどうかは返さない)
+	if err := services.RequestPasswordReset(args.Email, ctx.RealIP()); err != nil {
+		return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+// パスワード再設定画面
+func ResetPasswordPage(ctx echo.Context) error {
+	return ctx.Render(http.StatusOK, "password-reset.html", echo.Map{
+		"token": ctx.QueryParam("token"),
+	})
+}
+
+// パスワードを再設定する
+func ResetPassword(ctx echo.Context) error {
+	// リクエストボディを取得
+	args := services.ResetPasswordArgs{}
+
+	// バインド
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 再設定する
+	if err := services.ResetPassword(args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
 }
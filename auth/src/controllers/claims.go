@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// クレーム設定のエラーを返す
+func claimError(ctx echo.Context, err error) error {
+	logger.PrintErr(err)
+
+	switch {
+	case errors.Is(err, services.ErrClaimMappingNotFound), errors.Is(err, services.ErrUserNotFound):
+		return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// クレームの設定一覧を取得
+func GetClaimMappings(ctx echo.Context) error {
+	// サービスから取得
+	mappings, err := services.GetClaimMappings()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, mappings)
+}
+
+// クレームの設定を作成
+func CreateClaimMapping(ctx echo.Context) error {
+	bindData := services.ClaimMapping{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	mapping, err := services.CreateClaimMapping(bindData)
+
+	// エラー処理
+	if err != nil {
+		return claimError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, mapping)
+}
+
+// クレームの設定を更新
+func UpdateClaimMapping(ctx echo.Context) error {
+	// ID を取得
+	mappingID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	bindData := services.ClaimMapping{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	mapping, err := services.UpdateClaimMapping(uint(mappingID), bindData)
+
+	// エラー処理
+	if err != nil {
+		return claimError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, mapping)
+}
+
+// クレームの設定を削除
+func DeleteClaimMapping(ctx echo.Context) error {
+	// ID を取得
+	mappingID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 削除する
+	if err := services.DeleteClaimMapping(uint(mappingID)); err != nil {
+		return claimError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
+
+// ユーザーの属性を取得
+func GetUserAttributes(ctx echo.Context) error {
+	// 取得する
+	attributes, err := services.GetUserAttributes(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		return claimError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, attributes)
+}
+
+// ユーザーの属性を置き換える
+func UpdateUserAttributes(ctx echo.Context) error {
+	bindData := map[string]string{}
+
+	// bind する (パスパラメータを混ぜないように本文だけ)
+	if err := (&echo.DefaultBinder{}).BindBody(ctx, &bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	attributes, err := services.UpdateUserAttributes(ctx.Param("id"), bindData)
+
+	// エラー処理
+	if err != nil {
+		return claimError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, attributes)
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// デバイスコードとユーザーコードを発行する
+func DeviceAuthorization(ctx echo.Context) error {
+	// レスポンスをキャッシュさせない
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+
+	args := services.DeviceAuthorizationArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		return oauthTokenError(ctx, &services.OauthError{Status: http.StatusBadRequest, Code: "invalid_request", Description: err.Error()}, false)
+	}
+
+	// クライアントの認証情報
+	basicAuth, err := clientCredentials(ctx, &args.ClientID, &args.ClientSecret)
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	// 発行する
+	result, err := services.DeviceAuthorization(args)
+
+	// エラー処理
+	if err != nil {
+		return oauthTokenError(ctx, err, basicAuth)
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// ユーザーコードの確認画面を表示する
+func DevicePage(ctx echo.Context) error {
+	page := services.BeginDeviceVerification(ctx.QueryParam("user_code"))
+
+	// 他のサイトに埋め込ませない
+	ctx.Response().Header().Set("X-Frame-Options", "DENY")
+
+	return ctx.Render(http.StatusOK, "oauth2-device.html", echo.Map{
+		"userCode":       page.UserCode,
+		"loginMethods":   page.LoginMethods,
+		"passwordLogins": page.PasswordLogins,
+	})
+}
+
+// ログイン中のユーザーがユーザーコードを承認する
+func DecideDevice(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	args := services.DeviceDecisionArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	args.UserID = session.UserID
+	args.SessionID = session.SessionID
+	args.RemoteIP = ctx.RealIP()
+
+	// 承認する
+	result, err := services.DecideDeviceCode(args)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, services.ErrInvalidUserCode) {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+
+		// ユーザーコードの入力が多すぎる時
+		if errors.Is(err, services.ErrTooManyRequests) {
+			return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// 連携チケットを要求したブラウザを識別するクッキー
+	linkBindingCookie = "link_binding"
+)
+
+// 連携一覧を取得する
+func GetIdentities(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 取得する
+	identities, err := services.GetIdentities(session.UserID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, identities)
+}
+
+// 連携を開始する (返した URL をポップアップで開く)
+func LinkIdentity(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 開始する
+	start, err := services.BeginLinkIdentity(session.UserID, ctx.Param("provider"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	// チケットをこのブラウザに結び付ける
+	ctx.SetCookie(&http.Cookie{
+		Name:     linkBindingCookie,
+		Value:    start.Binding,
+		Path:     "/",
+		MaxAge:   0,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+
+	return ctx.JSON(http.StatusOK, echo.Map{"url": start.URL})
+}
+
+// 連携チケットを持つ認証開始のリクエストが、チケットを要求したブラウザからか確認する
+func checkLinkTicket(ctx echo.Context) error {
+	ticket := ctx.QueryParam("link")
+
+	// 連携でない時
+	if ticket == "" {
+		return nil
+	}
+
+	// クッキーを取得
+	binding := ""
+	if cookie, err := ctx.Cookie(linkBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+
+	return services.CheckLinkTicket(ticket, binding)
+}
+
+// 連携を解除する
+func UnlinkIdentity(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// ID を取得
+	identityID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 解除する
+	err = services.UnlinkIdentity(session.UserID, uint(identityID))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		switch {
+		case errors.Is(err, services.ErrIdentityNotFound):
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrLastLoginMethod):
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
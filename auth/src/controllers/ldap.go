@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type LoginLdapUserArgs struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LDAP でログイン
+func LoginLdapUser(ctx echo.Context) error {
+	// リクエストボディを取得
+	args := LoginLdapUserArgs{}
+
+	// バインド
+	err := ctx.Bind(&args)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// ログインする
+	login, result := services.LoginLdapUser(services.LoginLdapUserArgs{
+		Username:  args.Username,
+		Password:  args.Password,
+		RemoteIP:  ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	})
+
+	// エラー処理
+	if !result.Success {
+		logger.PrintErr(result.Error)
+		return ctx.JSON(result.Code, echo.Map{"error": result.Error.Error()})
+	}
+
+	return ctx.JSON(result.Code, login)
+}
+
+// LDAP プロバイダ取得
+func GetLdapProvider(ctx echo.Context) error {
+	// サービスから取得
+	provider, err := services.GetLdapProvider()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, provider)
+}
+
+// LDAP プロバイダ更新
+func UpdateLdapProvider(ctx echo.Context) error {
+	bindData := services.UpdateLdapProviderArgs{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	err := services.UpdateLdapProvider(bindData)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
+
+// LDAP サーバーへの接続を確認
+func TestLdapProvider(ctx echo.Context) error {
+	bindData := services.LdapConfig{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 接続する
+	err := services.TestLdapProvider(bindData)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadGateway, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TotpCodeArgs struct {
+	Code string `json:"code"`
+}
+
+// TOTP のエラーをステータスコードに変換する
+func totpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidTotpCode):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrTotpAlreadyEnabled), errors.Is(err, services.ErrTotpNotEnabled):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrMfaLocked):
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusInternalServerError
+}
+
+// 二要素認証の状態を取得
+func GetMfaStatus(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// ユーザーを取得
+	user, result := models.GetUser(session.UserID)
+
+	// エラー処理
+	if result.Error != nil {
+		logger.PrintErr(result.Error)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": result.Error.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"totpEnabled": user.TotpEnabled == 1})
+}
+
+// TOTP の登録を開始する
+func SetupTotp(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// シークレットを生成
+	setup, err := services.SetupTotp(session.UserID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(totpErrorStatus(err), echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, setup)
+}
+
+// TOTP を有効にする
+func ConfirmTotp(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// bind する
+	args := TotpCodeArgs{}
+	if err := ctx.Bind(&args); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 有効にする
+	if err := services.ConfirmTotp(session.UserID, args.Code); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(totpErrorStatus(err), echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+// TOTP を無効にする
+func DisableTotp(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// bind する
+	args := TotpCodeArgs{}
+	if err := ctx.Bind(&args); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 無効にする
+	if err := services.DisableTotp(session.UserID, args.Code); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(totpErrorStatus(err), echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+type VerifyMfaArgs struct {
+	MfaToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+// 待機トークンとコードをリフレッシュトークンに交換する
+func VerifyMfa(ctx echo.Context) error {
+	// bind する
+	args := VerifyMfaArgs{}
+	if err := ctx.Bind(&args); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 検証する
+	token, err := services.VerifyMfa(services.VerifyMfaArgs{
+		MfaToken:  args.MfaToken,
+		Code:      args.Code,
+		RemoteIP:  ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	})
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// BANされている時
+		if errors.Is(err, services.ErrUserBanned) {
+			return ctx.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+
+		// 同時ログイン数の上限に達している時
+		if errors.Is(err, services.ErrSessionLimitReached) {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		// 失敗が続いてロックされている時
+		if errors.Is(err, services.ErrMfaLocked) {
+			return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"token": token})
+}
+
+// 二要素認証画面
+func MfaPage(ctx echo.Context) error {
+	return ctx.Render(http.StatusOK, "mfa.html", echo.Map{
+		"isPopup": ctx.QueryParam("popup"),
+	})
+}
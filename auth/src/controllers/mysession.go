@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 自分のセッション一覧を取得
+func GetMySessions(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 取得する
+	sessions, err := services.GetMySessions(session.UserID, session.SessionID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, sessions)
+}
+
+// 自分のセッションを終了する
+func RevokeMySession(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 終了する
+	err := services.RevokeMySession(session.UserID, ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
+
+// 今のセッション以外を全て終了する
+func RevokeOtherSessions(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 終了する
+	revoked, err := services.RevokeOtherSessions(session.UserID, session.SessionID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success", "revoked": revoked})
+}
@@ -1,15 +1,15 @@
 package controllers
 
 import (
-	"auth/logger"
 	"auth/oauth2"
 	"auth/services"
 	"auth/utils"
+	"errors"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/markbates/goth"
 )
 
 func StartOauth(ctx echo.Context) error {
@@ -24,19 +24,53 @@ func StartOauth(ctx echo.Context) error {
 	// popup 認証かどうか
 	isPopup := ctx.QueryParam("popup")
 
+	// 連携チケットを要求したブラウザか確認する
+	if err := checkLinkTicket(ctx); err != nil {
+		return utils.ErrorScreen(ctx, http.StatusForbidden, utils.GenID(), err, isPopup == "1")
+	}
+
 	// 認証を開始
 	oauth2.StartOauth(ctx, oauth2.OauthArgs{
 		ProviderName: provider,
 		IsMobile:     isMobile == "1",
 		IsPopup:      isPopup == "1",
+		LinkTicket:   ctx.QueryParam("link"),
 	})
 
 	return nil
 }
 
+// response_mode=form_post のコールバック (Apple など)
+// クロスサイトの POST では SameSite=Lax のクッキーが送られないので、応答を保存して GET に変換する
+func FormPostCallbackOauth(ctx echo.Context) error {
+	// フォームを取得
+	form, err := ctx.FormParams()
+
+	// エラー処理
+	if err != nil {
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, false)
+	}
+
+	// 保存する (認可コードを URL に載せない)
+	handle := oauth2.SaveFormPost(ctx.Param("provider"), form)
+
+	// 同じパスに GET でリダイレクト (パスの接頭辞を保つため相対パス)
+	return ctx.Redirect(http.StatusSeeOther, "callback?form_post="+url.QueryEscape(handle))
+}
+
 func CallbackOauth(ctx echo.Context) error {
 	provider := ctx.Param("provider")
 
+	// form_post から変換された時は保存した応答を使う
+	if handle := ctx.QueryParam("form_post"); handle != "" {
+		form, ok := oauth2.TakeFormPost(handle, provider)
+		if !ok {
+			return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), errors.New("form_post response not found or expired"), false)
+		}
+
+		ctx.Request().URL.RawQuery = form.Encode()
+	}
+
 	// oauth を完了
 	oauthResponse, err := oauth2.CallbackOauth(ctx, provider)
 
@@ -46,74 +80,86 @@ func CallbackOauth(ctx echo.Context) error {
 		return utils.ErrorScreen(ctx, http.StatusInternalServerError, utils.GenID(), err, oauthResponse.IsPopup)
 	}
 
+	return finishExternalLogin(ctx, provider, oauthResponse)
+}
+
+// 外部プロバイダ (Oauth, SAML) の認証結果でログインまたは連携する
+func finishExternalLogin(ctx echo.Context, provider string, oauthResponse oauth2.OauthResponse) error {
 	// ユーザー
 	user := oauthResponse.User
 
+	// プロバイダごとの割り当てでプロフィールを取得
+	profile := oauth2.GetProfile(provider, user)
+
 	// popup
 	isPopup := "0"
 	if oauthResponse.IsPopup {
 		isPopup = "1"
 	}
 
+	// アカウント連携の場合
+	if oauthResponse.LinkTicket != "" {
+		// 連携する
+		err := services.LinkIdentity(services.LinkIdentityArgs{
+			LinkTicket:     oauthResponse.LinkTicket,
+			ProviderCode:   provider,
+			ProviderUserID: user.UserID,
+			Email:          profile.Email,
+		})
+
+		// エラー処理
+		if err != nil {
+			return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, oauthResponse.IsPopup)
+		}
+
+		return ctx.Render(http.StatusOK, "oauth-callback.html", echo.Map{"token": "", "mfaToken": "", "mfaURL": "", "linked": "1", "isPopup": isPopup})
+	}
+
 	// ユーザーを作成
-	token, err := services.LoginOauthUser(services.OauthUserArgs{
-		Name:           GetName(user),
-		Email:          user.Email,
+	login, err := services.LoginOauthUser(services.OauthUserArgs{
+		Name:           profile.Name,
+		Email:          profile.Email,
 		ProviderCode:   provider,
 		ProviderUserID: user.UserID,
 		RemoteIP:       ctx.RealIP(),
 		UserAgent:      ctx.Request().UserAgent(),
-		AvaterURL:      user.AvatarURL,
+		AvaterURL:      profile.AvatarURL,
+		EmailVerified:  oauth2.IsEmailVerified(provider, user),
 	})
 
 	// エラー処理
 	if err != nil {
+		// 同時ログイン数の上限に達している時
+		if errors.Is(err, services.ErrSessionLimitReached) {
+			return utils.ErrorScreen(ctx, http.StatusConflict, utils.GenID(), err, oauthResponse.IsPopup)
+		}
+
 		// return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 		return utils.ErrorScreen(ctx, http.StatusInternalServerError, utils.GenID(), err, oauthResponse.IsPopup)
 	}
 
-	logger.Println(token)
-
 	// キャッシュを無しにする
 	ctx.Response().Header().Set("Expires", time.Unix(0, 0).Format(time.RFC1123))
 	ctx.Response().Header().Set("Cache-Control", "no-cache, private, max-age=0")
 	ctx.Response().Header().Set("Pragma", "no-cache")
 	ctx.Response().Header().Set("X-Accel-Expires", "0")
 
+	// 二要素認証が必要な場合
+	if login.MfaRequired {
+		// モバイル場合
+		if oauthResponse.IsMobile {
+			return ctx.Redirect(http.StatusFound, "authbase://?mfa_token="+url.QueryEscape(login.MfaToken))
+		}
+
+		return ctx.Render(http.StatusOK, "oauth-callback.html", echo.Map{"token": "", "mfaToken": login.MfaToken, "mfaURL": services.PublicURL + "/mfa", "linked": "0", "isPopup": isPopup})
+	}
+
 	// モバイル場合
 	if oauthResponse.IsMobile {
-		return ctx.Redirect(http.StatusFound, "authbase://?token="+token)
+		return ctx.Redirect(http.StatusFound, "authbase://?token="+login.Token)
 	}
 
-	return ctx.Render(http.StatusOK, "oauth-callback.html", echo.Map{"token": token, "isPopup": isPopup})
+	return ctx.Render(http.StatusOK, "oauth-callback.html", echo.Map{"token": login.Token, "mfaToken": "", "mfaURL": "", "linked": "0", "isPopup": isPopup})
 	// return ctx.JSON(http.StatusOK, echo.Map{"token": token})
 	// return ctx.Redirect(http.StatusFound, "/auth/")
 }
-
-func GetName(user goth.User) string {
-	result := ""
-
-	logger.Println(user)
-
-	if user.Name != "" {
-		return user.Name
-	}
-
-	if user.NickName != "" {
-		return user.NickName
-	}
-
-	if user.FirstName != "" {
-		result = user.FirstName
-	}
-
-	if user.LastName != "" {
-		if result != "" {
-			result += " "
-		}
-
-		result += user.LastName
-	}
-
-	return result
-}
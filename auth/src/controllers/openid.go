@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ディスカバリドキュメントを返す
+func OpenIDConfiguration(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, services.GetOpenIDConfiguration())
+}
+
+// 公開鍵を返す
+func Jwks(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, services.GetJwks())
+}
+
+// アクセストークンからユーザー情報を返す
+func Userinfo(ctx echo.Context) error {
+	// ヘッダから Bearer トークンを取得
+	header := ctx.Request().Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		ctx.Response().Header().Set("WWW-Authenticate", `Bearer`)
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_request"})
+	}
+
+	// ユーザー情報を取得
+	claims, err := services.GetUserinfo(token)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// openid スコープがない時
+		if errors.Is(err, services.ErrInsufficientScope) {
+			ctx.Response().Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			return ctx.JSON(http.StatusForbidden, echo.Map{"error": "insufficient_scope"})
+		}
+
+		ctx.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_token"})
+	}
+
+	return ctx.JSON(http.StatusOK, claims)
+}
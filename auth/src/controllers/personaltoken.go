@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// パーソナルアクセストークン一覧を取得
+func GetPersonalTokens(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 取得する
+	tokens, err := services.GetPersonalTokens(session.UserID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, tokens)
+}
+
+// パーソナルアクセストークンを作成
+func CreatePersonalToken(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	args := services.PersonalTokenArgs{}
+
+	// bind する
+	if err := ctx.Bind(&args); err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 作成する
+	token, err := services.CreatePersonalToken(session.UserID, args)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusCreated, token)
+}
+
+// パーソナルアクセストークンを失効させる
+func DeletePersonalToken(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 削除する
+	err := services.DeletePersonalToken(session.UserID, ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, services.ErrPersonalTokenNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
@@ -3,30 +3,12 @@ package controllers
 import (
 	"auth/logger"
 	"auth/services"
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 )
 
-// ProviderConfig は各認証プロバイダーの設定を表す構造体です。
-type ProviderConfig struct {
-	Enabled      bool   `json:"enabled"`
-	ClientID     string `json:"clientId"`     // JSONキーは camelCase
-	ClientSecret string `json:"clientSecret"` // JSONキーは camelCase
-	CallbackURL  string `json:"callbackUrl"`  // JSONキーは camelCase
-}
-
-// AuthConfig は認証プロバイダー全体のコンフィグレーションを表す構造体です。
-// JSONのトップレベルのキー（"google", "discord" など）に対応するフィールドを持ちます。
-type AuthConfig struct {
-	Google    ProviderConfig `json:"google"`
-	Discord   ProviderConfig `json:"discord"`
-	Github    ProviderConfig `json:"github"`
-	Microsoft ProviderConfig `json:"microsoft"`
-	// 他のプロバイダーがあればここに追加
-	// MyCustomProvider ProviderConfig `json:"mycustomprovider"`
-}
-
 // プロバイダ一覧を取得する関数
 func GetProviders(ctx echo.Context) error {
 	// プロバイダ一覧を取得
@@ -45,11 +27,51 @@ func GetOauthProviders(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK,providers)
 }
 
+// basic プロバイダ取得
+func GetBasicProvider(ctx echo.Context) error {
+	// サービスから取得
+	provider, err := services.GetBasicProvider()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError,echo.Map{
+			"error" : err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK,provider)
+}
+
 // basic プロバイダ更新
 func BasicUpdate(ctx echo.Context) error {
+	bindData := services.UpdateBasicProviderArgs{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest,echo.Map{
+			"error" : err.Error(),
+		})
+	}
+
 	// Basic 認証を更新する
-	// services.UpdateBasicProvider()
-	return nil
+	err := services.UpdateBasicProvider(bindData)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest,echo.Map{
+			"error" : err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK,echo.Map{
+		"result" : "success",
+	})
 }
 
 // プロバイダを更新
@@ -80,4 +102,97 @@ func UpdateOauthProviders(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK,echo.Map{
 		"result" : "success",
 	})
-}
\ No newline at end of file
+}
+// プロバイダ管理のエラーをステータスコードに変換
+func providerError(ctx echo.Context, err error) error {
+	logger.PrintErr(err)
+
+	switch {
+	case errors.Is(err, services.ErrProviderNotFound):
+		return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrProviderExists), errors.Is(err, services.ErrProviderInUse):
+		return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// OIDC プロバイダ一覧を取得
+func GetOidcProviders(ctx echo.Context) error {
+	// サービスから取得
+	providers, err := services.GetOidcProviders()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, providers)
+}
+
+// OIDC プロバイダを作成
+func CreateOidcProvider(ctx echo.Context) error {
+	bindData := services.OidcProvider{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	provider, err := services.CreateOidcProvider(bindData)
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, provider)
+}
+
+// OIDC プロバイダを更新
+func UpdateOidcProvider(ctx echo.Context) error {
+	bindData := services.OidcProvider{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	provider, err := services.UpdateOidcProvider(ctx.Param("code"), bindData)
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, provider)
+}
+
+// OIDC プロバイダを削除
+func DeleteOidcProvider(ctx echo.Context) error {
+	// 削除する
+	err := services.DeleteOidcProvider(ctx.Param("code"))
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/oauth2"
+	"auth/services"
+	"auth/utils"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SP のメタデータを返す
+func SamlMetadata(ctx echo.Context) error {
+	// メタデータを生成
+	metadata, err := oauth2.SamlMetadata(ctx.Param("provider"), services.PublicURL)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, oauth2.ErrSamlProviderNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.Blob(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAML 認証を開始する
+func StartSaml(ctx echo.Context) error {
+	// 連携チケットを要求したブラウザか確認する
+	if err := checkLinkTicket(ctx); err != nil {
+		return utils.ErrorScreen(ctx, http.StatusForbidden, utils.GenID(), err, ctx.QueryParam("popup") == "1")
+	}
+
+	return oauth2.StartSaml(ctx, services.PublicURL, oauth2.OauthArgs{
+		ProviderName: ctx.Param("provider"),
+		IsMobile:     ctx.QueryParam("ismobile") == "1",
+		IsPopup:      ctx.QueryParam("popup") == "1",
+		LinkTicket:   ctx.QueryParam("link"),
+	})
+}
+
+// アサーションを受け取る (ACS)
+func SamlAcs(ctx echo.Context) error {
+	provider := ctx.Param("provider")
+
+	// アサーションを検証
+	samlResponse, err := oauth2.CallbackSaml(ctx, services.PublicURL, provider)
+
+	// エラー処理
+	if err != nil {
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, samlResponse.IsPopup)
+	}
+
+	return finishExternalLogin(ctx, provider, samlResponse)
+}
+
+// SAML プロバイダ一覧を取得
+func GetSamlProviders(ctx echo.Context) error {
+	// サービスから取得
+	providers, err := services.GetSamlProviders()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, providers)
+}
+
+// SAML プロバイダを作成
+func CreateSamlProvider(ctx echo.Context) error {
+	bindData := services.SamlProvider{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	provider, err := services.CreateSamlProvider(bindData)
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, provider)
+}
+
+// SAML プロバイダを更新
+func UpdateSamlProvider(ctx echo.Context) error {
+	bindData := services.SamlProvider{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	provider, err := services.UpdateSamlProvider(ctx.Param("code"), bindData)
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, provider)
+}
+
+// SAML プロバイダを削除
+func DeleteSamlProvider(ctx echo.Context) error {
+	// 削除する
+	err := services.DeleteSamlProvider(ctx.Param("code"))
+
+	// エラー処理
+	if err != nil {
+		return providerError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
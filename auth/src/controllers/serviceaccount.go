@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// サービスアカウント管理のエラーを返す
+func serviceAccountError(ctx echo.Context, err error) error {
+	logger.PrintErr(err)
+
+	if errors.Is(err, services.ErrServiceAccountNotFound) {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// サービスアカウント一覧を取得
+func GetServiceAccounts(ctx echo.Context) error {
+	// サービスから取得
+	accounts, err := services.GetServiceAccounts()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, accounts)
+}
+
+// サービスアカウントを作成
+func CreateServiceAccount(ctx echo.Context) error {
+	bindData := services.ServiceAccount{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	account, err := services.CreateServiceAccount(bindData)
+
+	// エラー処理
+	if err != nil {
+		return serviceAccountError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, account)
+}
+
+// サービスアカウントを更新
+func UpdateServiceAccount(ctx echo.Context) error {
+	bindData := services.ServiceAccount{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	account, err := services.UpdateServiceAccount(ctx.Param("id"), bindData)
+
+	// エラー処理
+	if err != nil {
+		return serviceAccountError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, account)
+}
+
+// クライアントシークレットを再生成
+func RegenerateServiceAccountSecret(ctx echo.Context) error {
+	// 再生成する
+	account, err := services.RegenerateServiceAccountSecret(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		return serviceAccountError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, account)
+}
+
+// サービスアカウントを削除
+func DeleteServiceAccount(ctx echo.Context) error {
+	// 削除する
+	err := services.DeleteServiceAccount(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		return serviceAccountError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 同時ログイン数の上限設定のエラーを返す
+func sessionLimitError(ctx echo.Context, err error) error {
+	logger.PrintErr(err)
+
+	if errors.Is(err, services.ErrSessionLimitNotFound) {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// 上限の設定一覧を取得
+func GetSessionLimits(ctx echo.Context) error {
+	// サービスから取得
+	limits, err := services.GetSessionLimits()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, limits)
+}
+
+// 上限の設定を作成
+func CreateSessionLimit(ctx echo.Context) error {
+	bindData := services.SessionLimit{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 作成する
+	limit, err := services.CreateSessionLimit(bindData)
+
+	// エラー処理
+	if err != nil {
+		return sessionLimitError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusCreated, limit)
+}
+
+// 上限の設定を更新
+func UpdateSessionLimit(ctx echo.Context) error {
+	// ID を取得
+	limitID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	bindData := services.SessionLimit{}
+
+	// bind する
+	if err := ctx.Bind(&bindData); err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// 更新する
+	limit, err := services.UpdateSessionLimit(uint(limitID), bindData)
+
+	// エラー処理
+	if err != nil {
+		return sessionLimitError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, limit)
+}
+
+// 上限の設定を削除
+func DeleteSessionLimit(ctx echo.Context) error {
+	// ID を取得
+	limitID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	// 削除する
+	if err := services.DeleteSessionLimit(uint(limitID)); err != nil {
+		return sessionLimitError(ctx, err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"result": "success",
+	})
+}
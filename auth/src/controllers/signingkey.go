@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/services"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 署名鍵一覧を取得
+func GetSigningKeys(ctx echo.Context) error {
+	// サービスから取得
+	keys, err := services.GetSigningKeys()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, keys)
+}
+
+// 署名鍵をローテーションする
+func RotateSigningKey(ctx echo.Context) error {
+	// ローテーションする
+	key, err := services.RotateSigningKey()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, key)
+}
@@ -2,33 +2,55 @@ package controllers
 
 import (
 	"auth/logger"
-	"auth/models"
 	"auth/services"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
+// リフレッシュトークンかパーソナルアクセストークンをアクセストークンに交換する
 func GetToken(ctx echo.Context) error {
-	// セッションを取得
-	session, ok := ctx.Get("session").(*models.Session)
+	// レスポンスをキャッシュさせない
+	ctx.Response().Header().Set("Cache-Control", "no-store")
 
-	// エラー処理
-	if !ok {
+	// ヘッダからリフレッシュトークンを取得
+	refreshToken := strings.TrimPrefix(ctx.Request().Header.Get("Authorization"), "Bearer ")
+	if refreshToken == "" {
 		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
 	}
 
-	// ユーザーIDを取得
-	userID := session.UserID
-
-	// トークンを取得
-	token, err := services.GetAccessToken(userID)
+	// 交換する
+	result, err := services.RefreshAccessToken(refreshToken)
 
 	// エラー処理
 	if err != nil {
 		logger.PrintErr(err)
+
+		// BANされている時
+		if errors.Is(err, services.ErrUserBanned) {
+			return ctx.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+
+		// トークンが無効な時
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) || errors.Is(err, services.ErrInvalidPersonalToken) {
+			return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+		}
+
 		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
-	return ctx.JSON(http.StatusOK, echo.Map{"message": "success", "token": token})
-}
\ No newline at end of file
+	response := echo.Map{
+		"message":   "success",
+		"token":     result.Token,
+		"expiresIn": result.ExpiresIn,
+	}
+
+	// ローテーションした時
+	if result.RefreshToken != "" {
+		response["refreshToken"] = result.RefreshToken
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
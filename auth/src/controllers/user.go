@@ -4,6 +4,7 @@ import (
 	"auth/logger"
 	"auth/models"
 	"auth/services"
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -127,6 +128,44 @@ func ToggleBan(ctx echo.Context) error {
 	})
 }
 
+// ユーザーを強制的にログアウトさせる
+func ForceLogout(ctx echo.Context) error {
+	// ログアウトさせる
+	err := services.ForceLogout(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, services.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"result": "success"})
+}
+
+// ユーザーの二要素認証をリセットする
+func ResetMfa(ctx echo.Context) error {
+	// リセットする
+	err := services.ResetMfa(ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		if errors.Is(err, services.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"result": "success"})
+}
+
 // アイコンを更新する
 func ChangeIcon(ctx echo.Context) error {
 	// ユーザーID を取得
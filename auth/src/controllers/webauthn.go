@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// パスキー登録を開始する
+func BeginPasskeyRegistration(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 開始する
+	ceremony, err := services.BeginPasskeyRegistration(session.UserID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// 進行中の登録、認証が多すぎる時
+		if errors.Is(err, services.ErrTooManyRequests) {
+			return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, ceremony)
+}
+
+// パスキー登録を完了する (ボディは navigator.credentials.create の結果)
+func FinishPasskeyRegistration(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 完了する
+	passkey, err := services.FinishPasskeyRegistration(session.UserID, ctx.QueryParam("ceremony"), ctx.QueryParam("name"), ctx.Request())
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, passkey)
+}
+
+// パスキーログインを開始する
+func BeginPasskeyLogin(ctx echo.Context) error {
+	// 開始する
+	ceremony, err := services.BeginPasskeyLogin(ctx.RealIP())
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// リクエストが多すぎる時
+		if errors.Is(err, services.ErrTooManyRequests) {
+			return ctx.JSON(http.StatusTooManyRequests, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, ceremony)
+}
+
+// パスキーログインを完了する (ボディは navigator.credentials.get の結果)
+func FinishPasskeyLogin(ctx echo.Context) error {
+	// 完了する
+	token, err := services.FinishPasskeyLogin(ctx.QueryParam("ceremony"), ctx.Request(), services.SessionArgs{
+		RemoteIP:  ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	})
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// BANされている時
+		if errors.Is(err, services.ErrUserBanned) {
+			return ctx.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+		}
+
+		// 同時ログイン数の上限に達している時
+		if errors.Is(err, services.ErrSessionLimitReached) {
+			return ctx.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"token": token})
+}
+
+// パスキー一覧を取得する
+func GetPasskeys(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 取得する
+	passkeys, err := services.GetPasskeys(session.UserID)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, passkeys)
+}
+
+// パスキーを削除する
+func DeletePasskey(ctx echo.Context) error {
+	// セッションを取得
+	session, ok := ctx.Get("session").(*models.Session)
+
+	// エラー処理
+	if !ok {
+		return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+	}
+
+	// 削除する
+	err := services.DeletePasskey(session.UserID, ctx.Param("id"))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+
+		// 見つからない時
+		if errors.Is(err, services.ErrPasskeyNotFound) {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "success"})
+}
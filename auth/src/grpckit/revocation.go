@@ -0,0 +1,59 @@
+package grpckit
+
+import (
+	"auth/models"
+	"auth/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 失効を下流のサービスに配信する
+type RevocationServer struct {
+}
+
+// WatchRevocations implements RevocationServiceServer.
+func (server *RevocationServer) WatchRevocations(req *WatchRevocationsRequest, stream grpc.ServerStreamingServer[Revocation]) error {
+	// 取りこぼさないように先に購読する
+	revocations, unsubscribe := services.SubscribeRevocations()
+	defer unsubscribe()
+
+	// 接続していない間の失効を送る
+	missed, err := services.GetRevocationsSince(req.Since)
+	if err != nil {
+		return err
+	}
+
+	for _, revocation := range missed {
+		if err := stream.Send(ModelRevocationToRevocation(revocation)); err != nil {
+			return err
+		}
+	}
+
+	// 新しい失効を送り続ける
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case revocation, ok := <-revocations:
+			// 受け取りが遅くて購読を解除された時 (クライアントは Since を付けて繋ぎ直す)
+			if !ok {
+				return status.Error(codes.Unavailable, "revocation stream overflowed")
+			}
+
+			if err := stream.Send(ModelRevocationToRevocation(revocation)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func ModelRevocationToRevocation(revocation models.TokenRevocation) *Revocation {
+	return &Revocation{
+		UserID:    revocation.UserID,
+		SessionID: revocation.SessionID,
+		RevokedAt: revocation.RevokedAt,
+		Reason:    revocation.Reason,
+	}
+}
@@ -0,0 +1,204 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v3.12.4
+// source: revocation.proto
+
+package grpckit
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// 失効の購読リクエスト
+type WatchRevocationsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Since         int64                  `protobuf:"varint,1,opt,name=Since,proto3" json:"Since,omitempty"` //この日時以降の失効も最初に送る (unix 秒, 0 はトークンの有効期間分)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRevocationsRequest) Reset() {
+	*x = WatchRevocationsRequest{}
+	mi := &file_revocation_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRevocationsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRevocationsRequest) ProtoMessage() {}
+
+func (x *WatchRevocationsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_revocation_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRevocationsRequest.ProtoReflect.Descriptor instead.
+func (*WatchRevocationsRequest) Descriptor() ([]byte, []int) {
+	return file_revocation_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchRevocationsRequest) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+// 失効の通知
+type Revocation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserID        string                 `protobuf:"bytes,1,opt,name=UserID,proto3" json:"UserID,omitempty"`        //対象のユーザーID
+	SessionID     string                 `protobuf:"bytes,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`  //対象のセッション (空はユーザーの全てのトークン)
+	RevokedAt     int64                  `protobuf:"varint,3,opt,name=RevokedAt,proto3" json:"RevokedAt,omitempty"` //この日時より前に発行されたトークンを拒否する (unix 秒)
+	Reason        string                 `protobuf:"bytes,4,opt,name=Reason,proto3" json:"Reason,omitempty"`        //失効の理由 (ban / password_reset / mfa_reset / admin_logout / delete)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Revocation) Reset() {
+	*x = Revocation{}
+	mi := &file_revocation_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Revocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Revocation) ProtoMessage() {}
+
+func (x *Revocation) ProtoReflect() protoreflect.Message {
+	mi := &file_revocation_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Revocation.ProtoReflect.Descriptor instead.
+func (*Revocation) Descriptor() ([]byte, []int) {
+	return file_revocation_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Revocation) GetUserID() string {
+	if x != nil {
+		return x.UserID
+	}
+	return ""
+}
+
+func (x *Revocation) GetSessionID() string {
+	if x != nil {
+		return x.SessionID
+	}
+	return ""
+}
+
+func (x *Revocation) GetRevokedAt() int64 {
+	if x != nil {
+		return x.RevokedAt
+	}
+	return 0
+}
+
+func (x *Revocation) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_revocation_proto protoreflect.FileDescriptor
+
+const file_revocation_proto_rawDesc = "" +
+	"\n" +
+	"\x10revocation.proto\x12\agrpckit\"/\n" +
+	"\x17WatchRevocationsRequest\x12\x14\n" +
+	"\x05Since\x18\x01 \x01(\x03R\x05Since\"x\n" +
+	"\n" +
+	"Revocation\x12\x16\n" +
+	"\x06UserID\x18\x01 \x01(\tR\x06UserID\x12\x1c\n" +
+	"\tSessionID\x18\x02 \x01(\tR\tSessionID\x12\x1c\n" +
+	"\tRevokedAt\x18\x03 \x01(\x03R\tRevokedAt\x12\x16\n" +
+	"\x06Reason\x18\x04 \x01(\tR\x06Reason2b\n" +
+	"\x11RevocationService\x12M\n" +
+	"\x10WatchRevocations\x12 .grpckit.WatchRevocationsRequest\x1a\x13.grpckit.Revocation\"\x000\x01B\fZ\n" +
+	"../grpckitb\x06proto3"
+
+var (
+	file_revocation_proto_rawDescOnce sync.Once
+	file_revocation_proto_rawDescData []byte
+)
+
+func file_revocation_proto_rawDescGZIP() []byte {
+	file_revocation_proto_rawDescOnce.Do(func() {
+		file_revocation_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_revocation_proto_rawDesc), len(file_revocation_proto_rawDesc)))
+	})
+	return file_revocation_proto_rawDescData
+}
+
+var file_revocation_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_revocation_proto_goTypes = []any{
+	(*WatchRevocationsRequest)(nil), // 0: grpckit.WatchRevocationsRequest
+	(*Revocation)(nil),              // 1: grpckit.Revocation
+}
+var file_revocation_proto_depIdxs = []int32{
+	0, // 0: grpckit.RevocationService.WatchRevocations:input_type -> grpckit.WatchRevocationsRequest
+	1, // 1: grpckit.RevocationService.WatchRevocations:output_type -> grpckit.Revocation
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_revocation_proto_init() }
+func file_revocation_proto_init() {
+	if File_revocation_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_revocation_proto_rawDesc), len(file_revocation_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_revocation_proto_goTypes,
+		DependencyIndexes: file_revocation_proto_depIdxs,
+		MessageInfos:      file_revocation_proto_msgTypes,
+	}.Build()
+	File_revocation_proto = out.File
+	file_revocation_proto_goTypes = nil
+	file_revocation_proto_depIdxs = nil
+}
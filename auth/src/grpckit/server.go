@@ -24,6 +24,9 @@ func Init() {
 
 	RegisterAuthBaseServiceServer(grpcServer, &GrpcServer{})
 
+	// トークンの失効を配信する
+	RegisterRevocationServiceServer(grpcServer, &RevocationServer{})
+
 	// 以下でリッスンし続ける
 	if err := grpcServer.Serve(listen); err != nil {
 		log.Fatalf("failed to serve: %s", err)
@@ -2,10 +2,15 @@ package main
 
 import (
 	"auth/controllers"
+	"auth/logger"
 	"auth/middlewares"
 	"html/template"
 	"io"
+	"net"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -27,10 +32,102 @@ func (temp *TemplateRenderer) Render(writer io.Writer, name string, data interfa
 	return temp.templates.ExecuteTemplate(writer, name, data)
 }
 
+// クライアントの IP の取得方法
+// 信頼するプロキシから来た X-Forwarded-For だけを使う (それ以外は接続元の IP)
+//
+//	TRUSTED_PROXIES: 信頼するプロキシの CIDR、IP またはホスト名 (カンマ区切り, 未設定の時は nginx)
+func ipExtractor() echo.IPExtractor {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		value = "nginx"
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	trusted := 0
+	for _, entry := range strings.Split(value, ",") {
+		ipNets, err := trustedProxyRanges(strings.TrimSpace(entry))
+		if err != nil {
+			logger.PrintErr("TRUSTED_PROXIES が不正です", entry, err)
+			continue
+		}
+
+		for _, ipNet := range ipNets {
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+		trusted += len(ipNets)
+	}
+
+	// 信頼するプロキシがない時は接続元の IP を使う
+	if trusted == 0 {
+		logger.PrintErr("信頼するプロキシがないため X-Forwarded-For を無視します")
+		return echo.ExtractIPDirect()
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// CIDR、IP、ホスト名を信頼する範囲に変換する
+func trustedProxyRanges(entry string) ([]*net.IPNet, error) {
+	// CIDR の時
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.IPNet{ipNet}, nil
+	}
+
+	// IP の時はそのまま、ホスト名の時は名前解決する
+	ips := []net.IP{net.ParseIP(entry)}
+	if ips[0] == nil {
+		var err error
+		ips, err = lookupProxyHost(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ipNets := []*net.IPNet{}
+	for _, ip := range ips {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return ipNets, nil
+}
+
+// プロキシのホスト名を解決する (起動直後は解決できないことがあるので数回試す)
+func lookupProxyHost(host string) ([]net.IP, error) {
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		var ips []net.IP
+		ips, err = net.LookupIP(host)
+		if err == nil {
+			return ips, nil
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return nil, err
+}
+
 func SetupRouter(router *echo.Echo) {
 	// logger 設定
 	router.Use(middleware.Logger())
 
+	// クライアントの IP (レート制限に使う)
+	router.IPExtractor = ipExtractor()
+
 	// テンプレート
 	renderer := &TemplateRenderer{
 		templates: template.Must(template.ParseGlob("templates/*.html")),
@@ -41,11 +138,31 @@ func SetupRouter(router *echo.Echo) {
 
 	// ルーティング設定
 	// ベーシックユーザーグループ
-	// basicg := router.Group("/basic")
-	// {
-	// 	basicg.POST("/signup", controllers.CreateBasicUser)
-	// 	basicg.POST("/login", controllers.LoginBasicUser)
-	// }
+	basicg := router.Group("/basic")
+	{
+		basicg.POST("/signup", controllers.CreateBasicUser)
+		basicg.POST("/login", controllers.LoginBasicUser)
+		basicg.GET("/verify", controllers.VerifyEmailPage)
+		basicg.POST("/verify", controllers.VerifyEmail)
+		basicg.POST("/verify/resend", controllers.ResendVerification)
+		basicg.POST("/password/forgot", controllers.ForgotPassword)
+		basicg.GET("/password/reset", controllers.ResetPasswordPage)
+		basicg.POST("/password/reset", controllers.ResetPassword)
+	}
+
+	// saml グループ
+	samlg := router.Group("/saml")
+	{
+		samlg.GET("/:provider", controllers.StartSaml)
+		samlg.GET("/:provider/metadata", controllers.SamlMetadata)
+		samlg.POST("/:provider/acs", controllers.SamlAcs)
+	}
+
+	// ldap グループ
+	ldapg := router.Group("/ldap")
+	{
+		ldapg.POST("/login", controllers.LoginLdapUser)
+	}
 
 	// React のビルド出力ディレクトリを指定
 	buildDir := "dashboard"
@@ -76,20 +193,73 @@ func SetupRouter(router *echo.Echo) {
 	// info エンドポイント
 	router.GET("/info/:userid", controllers.GetInfo)
 
-	// 情報を取得する
-	router.GET("/me", controllers.GetMe, middlewares.RequireAuth)
+	// 情報を取得する (パーソナルアクセストークンでも取得できる)
+	router.GET("/me", controllers.GetMe, middlewares.AllowPersonalToken(""), middlewares.RequireAuth)
 
-	// token を取得する
-	router.GET("/token", controllers.GetToken, middlewares.RequireAuth)
+	// リフレッシュトークンを token に交換する
+	router.POST("/token", controllers.GetToken)
 
 	// アイコンを変更する
-	router.POST("/icon", controllers.ChangeIcon, middlewares.RequireAuth)
+	router.POST("/icon", controllers.ChangeIcon, middlewares.RequireAuth, middlewares.RequireSession)
 
 	// アイコンを取得する
 	router.GET("/icon/:userid",controllers.GetIcon)
 
 	// ログアウト
-	router.POST("/logout", controllers.Logout, middlewares.RequireAuth)
+	router.POST("/logout", controllers.Logout, middlewares.RequireAuth, middlewares.RequireSession)
+
+	// 二要素認証
+	router.GET("/mfa", controllers.MfaPage)
+	router.POST("/mfa/verify", controllers.VerifyMfa)
+
+	// 二要素認証の設定グループ
+	mfag := router.Group("/me/mfa", middlewares.RequireAuth, middlewares.RequireSession)
+	{
+		mfag.GET("", controllers.GetMfaStatus)
+		mfag.POST("/totp/setup", controllers.SetupTotp)
+		mfag.POST("/totp/confirm", controllers.ConfirmTotp)
+		mfag.DELETE("/totp", controllers.DisableTotp)
+	}
+
+	// パスキー管理グループ
+	passkeyg := router.Group("/me/passkeys", middlewares.RequireAuth, middlewares.RequireSession)
+	{
+		passkeyg.GET("", controllers.GetPasskeys)
+		passkeyg.DELETE("/:id", controllers.DeletePasskey)
+	}
+
+	// アカウント連携グループ
+	identityg := router.Group("/me/identities", middlewares.RequireAuth, middlewares.RequireSession)
+	{
+		identityg.GET("", controllers.GetIdentities)
+		identityg.POST("/link/:provider", controllers.LinkIdentity)
+		identityg.DELETE("/:id", controllers.UnlinkIdentity)
+	}
+
+	// パーソナルアクセストークン管理グループ
+	tokeng := router.Group("/me/tokens", middlewares.RequireAuth, middlewares.RequireSession)
+	{
+		tokeng.GET("", controllers.GetPersonalTokens)
+		tokeng.POST("", controllers.CreatePersonalToken)
+		tokeng.DELETE("/:id", controllers.DeletePersonalToken)
+	}
+
+	// セッション管理グループ
+	sessiong := router.Group("/me/sessions", middlewares.RequireAuth, middlewares.RequireSession)
+	{
+		sessiong.GET("", controllers.GetMySessions)
+		sessiong.DELETE("/others", controllers.RevokeOtherSessions)
+		sessiong.DELETE("/:id", controllers.RevokeMySession)
+	}
+
+	// webauthn グループ
+	webauthng := router.Group("/webauthn")
+	{
+		webauthng.POST("/register/begin", controllers.BeginPasskeyRegistration, middlewares.RequireAuth, middlewares.RequireSession)
+		webauthng.POST("/register/finish", controllers.FinishPasskeyRegistration, middlewares.RequireAuth, middlewares.RequireSession)
+		webauthng.POST("/login/begin", controllers.BeginPasskeyLogin)
+		webauthng.POST("/login/finish", controllers.FinishPasskeyLogin)
+	}
 
 	// admin グループ
 	adming := router.Group("/admin")
@@ -106,8 +276,28 @@ func SetupRouter(router *echo.Echo) {
 	{
 		oauthg.GET("/:provider", controllers.StartOauth)
 		oauthg.GET("/:provider/callback", controllers.CallbackOauth)
+		oauthg.POST("/:provider/callback", controllers.FormPostCallbackOauth)
+	}
+
+	// 認証サーバーグループ
+	oauth2g := router.Group("/oauth2")
+	{
+		oauth2g.GET("/authorize", controllers.AuthorizePage)
+		oauth2g.POST("/authorize", controllers.Authorize, middlewares.RequireAuth, middlewares.RequireSession)
+		oauth2g.POST("/token", controllers.ExchangeToken)
+		oauth2g.POST("/introspect", controllers.Introspect)
+		oauth2g.POST("/revoke", controllers.Revoke)
+		oauth2g.POST("/device_authorization", controllers.DeviceAuthorization)
+		oauth2g.GET("/device", controllers.DevicePage)
+		oauth2g.POST("/device", controllers.DecideDevice, middlewares.RequireAuth, middlewares.RequireSession)
 	}
 
+	// OpenID Connect
+	router.GET("/.well-known/openid-configuration", controllers.OpenIDConfiguration)
+	router.GET("/.well-known/jwks.json", controllers.Jwks)
+	router.GET("/userinfo", controllers.Userinfo)
+	router.POST("/userinfo", controllers.Userinfo)
+
 	// api グループ
 	apig := router.Group("/api")
 	{
@@ -128,6 +318,18 @@ func SetupRouter(router *echo.Echo) {
 
 			// BAN を切り替える
 			userg.PUT("/ban", controllers.ToggleBan)
+
+			// ユーザーの属性を取得する
+			userg.GET("/:id/attributes", controllers.GetUserAttributes)
+
+			// ユーザーの属性を更新する
+			userg.PUT("/:id/attributes", controllers.UpdateUserAttributes)
+
+			// 強制的にログアウトさせる
+			userg.POST("/:id/logout", controllers.ForceLogout)
+
+			// 二要素認証をリセットする
+			userg.DELETE("/:id/mfa", controllers.ResetMfa)
 		}
 
 		// プロバイダグループ
@@ -145,8 +347,108 @@ func SetupRouter(router *echo.Echo) {
 			// プロバイダー一覧を更新する
 			providerg.POST("", controllers.UpdateProviders)
 
+			// basic プロバイダ取得
+			providerg.GET("/basic", controllers.GetBasicProvider)
+
 			// basic プロバイダ更新
-			// providerg.PUT("/basic",controllers.BasicUpdate)
+			providerg.PUT("/basic", controllers.BasicUpdate)
+
+			// OIDC プロバイダ一覧を取得
+			providerg.GET("/oidc", controllers.GetOidcProviders)
+
+			// OIDC プロバイダを作成
+			providerg.POST("/oidc", controllers.CreateOidcProvider)
+
+			// OIDC プロバイダを更新
+			providerg.PUT("/oidc/:code", controllers.UpdateOidcProvider)
+
+			// OIDC プロバイダを削除
+			providerg.DELETE("/oidc/:code", controllers.DeleteOidcProvider)
+
+			// SAML プロバイダ一覧を取得
+			providerg.GET("/saml", controllers.GetSamlProviders)
+
+			// SAML プロバイダを作成
+			providerg.POST("/saml", controllers.CreateSamlProvider)
+
+			// SAML プロバイダを更新
+			providerg.PUT("/saml/:code", controllers.UpdateSamlProvider)
+
+			// SAML プロバイダを削除
+			providerg.DELETE("/saml/:code", controllers.DeleteSamlProvider)
+
+			// ldap プロバイダ取得
+			providerg.GET("/ldap", controllers.GetLdapProvider)
+
+			// ldap プロバイダ更新
+			providerg.PUT("/ldap", controllers.UpdateLdapProvider)
+
+			// ldap サーバーへの接続を確認
+			providerg.POST("/ldap/test", controllers.TestLdapProvider)
+		}
+
+		// 認証サーバーのクライアントグループ
+		clientg := apig.Group("/oauth2/clients")
+		{
+			// クライアント一覧を取得
+			clientg.GET("", controllers.GetOauthClients)
+
+			// クライアントを作成
+			clientg.POST("", controllers.CreateOauthClient)
+
+			// クライアントを更新
+			clientg.PUT("/:id", controllers.UpdateOauthClient)
+
+			// クライアントシークレットを再生成
+			clientg.POST("/:id/secret", controllers.RegenerateOauthClientSecret)
+
+			// クライアントを削除
+			clientg.DELETE("/:id", controllers.DeleteOauthClient)
+		}
+
+		// サービスアカウントグループ
+		serviceg := apig.Group("/service-accounts")
+		{
+			// サービスアカウント一覧を取得
+			serviceg.GET("", controllers.GetServiceAccounts)
+
+			// サービスアカウントを作成
+			serviceg.POST("", controllers.CreateServiceAccount)
+
+			// サービスアカウントを更新
+			serviceg.PUT("/:id", controllers.UpdateServiceAccount)
+
+			// クライアントシークレットを再生成
+			serviceg.POST("/:id/secret", controllers.RegenerateServiceAccountSecret)
+
+			// サービスアカウントを削除
+			serviceg.DELETE("/:id", controllers.DeleteServiceAccount)
+		}
+
+		// アクセストークンのクレーム設定グループ
+		claimg := apig.Group("/claims")
+		{
+			// 設定一覧を取得
+			claimg.GET("", controllers.GetClaimMappings)
+
+			// 設定を作成
+			claimg.POST("", controllers.CreateClaimMapping)
+
+			// 設定を更新
+			claimg.PUT("/:id", controllers.UpdateClaimMapping)
+
+			// 設定を削除
+			claimg.DELETE("/:id", controllers.DeleteClaimMapping)
+		}
+
+		// 署名鍵グループ
+		keyg := apig.Group("/keys")
+		{
+			// 署名鍵一覧を取得
+			keyg.GET("", controllers.GetSigningKeys)
+
+			// 署名鍵をローテーションする
+			keyg.POST("/rotate", controllers.RotateSigningKey)
 		}
 
 		// ラベルグループを作る
@@ -174,5 +476,21 @@ func SetupRouter(router *echo.Echo) {
 			// セッションを削除する
 			sessiong.DELETE("", controllers.DeleteSession)
 		}
+
+		// 同時ログイン数の上限グループ
+		sessionlimitg := apig.Group("/session-limits")
+		{
+			// 上限一覧を取得
+			sessionlimitg.GET("", controllers.GetSessionLimits)
+
+			// 上限を作成
+			sessionlimitg.POST("", controllers.CreateSessionLimit)
+
+			// 上限を更新
+			sessionlimitg.PUT("/:id", controllers.UpdateSessionLimit)
+
+			// 上限を削除
+			sessionlimitg.DELETE("/:id", controllers.DeleteSessionLimit)
+		}
 	}
 }
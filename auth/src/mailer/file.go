@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"auth/utils"
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ディレクトリに .eml として書き出す (開発用)
+type FileSender struct {
+	dir string
+}
+
+func NewFileSender(dir string) *FileSender {
+	return &FileSender{dir: dir}
+}
+
+func (sender *FileSender) Send(mail Mail) error {
+	// ディレクトリを作成する
+	if err := os.MkdirAll(sender.dir, 0755); err != nil {
+		return err
+	}
+
+	// ファイル名
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), utils.GenID())
+
+	// 書き出す
+	return os.WriteFile(filepath.Join(sender.dir, name), buildMessage(mail), 0600)
+}
+
+// メールのメッセージを組み立てる
+func buildMessage(mail Mail) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", From)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(mail.Body)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"auth/logger"
+	"errors"
+	"os"
+)
+
+// 送信するメール
+type Mail struct {
+	To      string // 宛先
+	Subject string // 件名
+	Body    string // 本文 (text/plain)
+}
+
+// メール送信インターフェース
+type Sender interface {
+	Send(mail Mail) error
+}
+
+var (
+	// 現在の送信者
+	current Sender = NewMemorySender()
+
+	// 送信元アドレス
+	From = "noreply@localhost"
+)
+
+// 環境変数から送信者を初期化する
+//
+//	MAIL_DRIVER: smtp / file / memory (デフォルト: file)
+func Init() {
+	// 送信元
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		From = from
+	}
+
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		current = NewSMTPSender(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+	case "memory":
+		current = NewMemorySender()
+	default:
+		// ディレクトリ
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "./mails"
+		}
+
+		current = NewFileSender(dir)
+	}
+
+	logger.Println("mailer initialized")
+}
+
+// 送信者を差し替える
+func SetSender(sender Sender) {
+	current = sender
+}
+
+// 現在の送信者を取得する
+func GetSender() Sender {
+	return current
+}
+
+// メールを送信する
+func Send(mail Mail) error {
+	// 宛先がない時
+	if mail.To == "" {
+		return errors.New("mail recipient is empty")
+	}
+
+	return current.Send(mail)
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import "sync"
+
+// メモリに保存する (テスト用)
+type MemorySender struct {
+	mutex sync.Mutex
+	mails []Mail
+}
+
+func NewMemorySender() *MemorySender {
+	return &MemorySender{mails: []Mail{}}
+}
+
+func (sender *MemorySender) Send(mail Mail) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	// 追加する
+	sender.mails = append(sender.mails, mail)
+	return nil
+}
+
+// 送信されたメール一覧を取得する
+func (sender *MemorySender) Sent() []Mail {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	// コピーを返す
+	mails := make([]Mail, len(sender.mails))
+	copy(mails, sender.mails)
+	return mails
+}
+
+// 送信されたメールを削除する
+func (sender *MemorySender) Reset() {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	sender.mails = []Mail{}
+}
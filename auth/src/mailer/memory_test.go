@@ -0,0 +1,28 @@
+package mailer
+
+import "testing"
+
+func TestMemorySender(t *testing.T) {
+	sender := NewMemorySender()
+	SetSender(sender)
+
+	if err := Send(Mail{To: "alice@example.com", Subject: "hello", Body: "body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 宛先がない時は送らない
+	if err := Send(Mail{Subject: "no recipient"}); err == nil {
+		t.Fatal("expected error for empty recipient")
+	}
+
+	mails := sender.Sent()
+	if len(mails) != 1 || mails[0].To != "alice@example.com" || mails[0].Subject != "hello" {
+		t.Fatalf("unexpected mails: %+v", mails)
+	}
+
+	// 返した一覧を書き換えても影響しない
+	mails[0].To = "changed"
+	if sender.Sent()[0].To != "alice@example.com" {
+		t.Fatal("Mails must return a copy")
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+type SMTPConfig struct {
+	Host     string // SMTP ホスト
+	Port     string // SMTP ポート
+	Username string // ユーザー名 (空の時は認証しない)
+	Password string // パスワード
+}
+
+// SMTP で送信する
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	// ポートの初期値
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	return &SMTPSender{config: config}
+}
+
+func (sender *SMTPSender) Send(mail Mail) error {
+	// 認証情報
+	var auth smtp.Auth
+	if sender.config.Username != "" {
+		auth = smtp.PlainAuth("", sender.config.Username, sender.config.Password, sender.config.Host)
+	}
+
+	// 送信する (サーバーが対応していれば STARTTLS を使う)
+	addr := net.JoinHostPort(sender.config.Host, sender.config.Port)
+	return smtp.SendMail(addr, auth, From, []string{mail.To}, buildMessage(mail))
+}
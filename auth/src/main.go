@@ -2,10 +2,12 @@ package main
 
 import (
 	"auth/grpckit"
+	"auth/mailer"
 	"auth/models"
 	"auth/oauth2"
 	"auth/services"
 	"net/http"
+	"os"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/labstack/echo/v4"
@@ -15,6 +17,9 @@ func Init() {
 	// モデル初期化
 	models.Init()
 
+	// メール送信初期化
+	mailer.Init()
+
 	// サービス初期化
 	services.Init()
 
@@ -26,6 +31,11 @@ func Init() {
 }
 
 func main() {
+	// サブコマンド (rotate-keys など)
+	if runCommand(os.Args) {
+		return
+	}
+
 	// 初期化
 	Init()
 
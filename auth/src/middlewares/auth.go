@@ -4,7 +4,9 @@ import (
 	"auth/logger"
 	"auth/models"
 	"auth/services"
+	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/labstack/echo/v4"
 )
@@ -18,15 +20,36 @@ func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 			return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
 		}
 
-		// トークンを検証
-		session, err := services.GetSession(token)
-		if err != nil {
-			logger.PrintErr(err)
-			return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+		var session *models.Session
+
+		if services.IsPersonalToken(token) {
+			// パーソナルアクセストークンの時 (セッションを持たない)
+			personalToken, err := services.AuthenticatePersonalToken(token)
+			if err != nil {
+				logger.PrintErr(err)
+				return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+			}
+
+			session = &models.Session{UserID: personalToken.UserID}
+			ctx.Set("personalToken", personalToken)
+		} else {
+			// トークンを検証
+			var err error
+			session, err = services.GetSession(token)
+			if err != nil {
+				logger.PrintErr(err)
+
+				// 期限切れの時は再ログインを促す
+				if errors.Is(err, services.ErrSessionExpired) {
+					return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+				}
+
+				return ctx.JSON(http.StatusUnauthorized, echo.Map{"error": "unauthorized"})
+			}
 		}
 
 		// ユーザーを取得する
-		user,result := models.GetUser(session.UserID)
+		user,result := models.Sessions().GetUser(session.UserID)
 
 		// エラー処理
 		if result.Error != nil {
@@ -39,10 +62,65 @@ func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 			return ctx.JSON(http.StatusForbidden, echo.Map{"error": "Your account has been banned"})
 		}
 
+		// パーソナルアクセストークンはルートが許可したスコープの範囲でだけ使える
+		if personalToken, ok := ctx.Get("personalToken").(*models.PersonalAccessToken); ok {
+			if err := checkPersonalTokenScope(ctx, personalToken, user); err != nil {
+				return ctx.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+			}
+		}
+
 		// セッションを設定
 		ctx.Set("session", session)
 
 		// 認証処理
 		return next(ctx)
 	}
-}
\ No newline at end of file
+}
+
+// パーソナルアクセストークンを受け付けるミドルウェア (RequireAuth の前に使う)
+// scope が空でなければ、トークンとユーザーの両方にそのラベルがある時だけ受け付ける
+func AllowPersonalToken(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("personalTokenScope", scope)
+			return next(ctx)
+		}
+	}
+}
+
+// ルートが許可したスコープをトークンが持っているか
+func checkPersonalTokenScope(ctx echo.Context, personalToken *models.PersonalAccessToken, user *models.User) error {
+	// 許可されていないルートの時
+	scope, ok := ctx.Get("personalTokenScope").(string)
+	if !ok {
+		return services.ErrPersonalTokenNotAllowed
+	}
+
+	// トークンで使えるラベル
+	labels, err := services.PersonalTokenLabels(personalToken, user)
+	if err != nil {
+		logger.PrintErr(err)
+		return services.ErrPersonalTokenNotAllowed
+	}
+	ctx.Set("personalTokenLabels", labels)
+
+	// スコープが必要な時
+	if scope != "" && !slices.Contains(labels, scope) {
+		return services.ErrPersonalTokenScope
+	}
+
+	return nil
+}
+
+// ブラウザのセッションを必須にするミドルウェア (RequireAuth の後に使う)
+// パーソナルアクセストークンでアカウントの認証情報を変更させない
+func RequireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		// パーソナルアクセストークンの時
+		if ctx.Get("personalToken") != nil {
+			return ctx.JSON(http.StatusForbidden, echo.Map{"error": services.ErrPersonalTokenNotAllowed.Error()})
+		}
+
+		return next(ctx)
+	}
+}
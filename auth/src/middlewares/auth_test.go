@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"auth/models"
+	"auth/services"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestContext() echo.Context {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	return echo.New().NewContext(request, httptest.NewRecorder())
+}
+
+func TestPersonalTokenNotAllowedByDefault(t *testing.T) {
+	ctx := newTestContext()
+
+	// AllowPersonalToken がないルートでは受け付けない
+	err := checkPersonalTokenScope(ctx, &models.PersonalAccessToken{Scopes: "admin"}, &models.User{})
+	if !errors.Is(err, services.ErrPersonalTokenNotAllowed) {
+		t.Fatalf("expected ErrPersonalTokenNotAllowed, got %v", err)
+	}
+}
+
+func TestRequireSessionRejectsPersonalToken(t *testing.T) {
+	handler := RequireSession(func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	})
+
+	// セッションの時
+	ctx := newTestContext()
+	if err := handler(ctx); err != nil || ctx.Response().Status != http.StatusOK {
+		t.Fatalf("session should pass, got %d (%v)", ctx.Response().Status, err)
+	}
+
+	// パーソナルアクセストークンの時
+	ctx = newTestContext()
+	ctx.Set("personalToken", &models.PersonalAccessToken{})
+	if err := handler(ctx); err != nil || ctx.Response().Status != http.StatusForbidden {
+		t.Fatalf("personal token should be rejected, got %d (%v)", ctx.Response().Status, err)
+	}
+}
+
+func TestAllowPersonalTokenSetsScope(t *testing.T) {
+	ctx := newTestContext()
+
+	handler := AllowPersonalToken("deploy")(func(ctx echo.Context) error {
+		return nil
+	})
+	if err := handler(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if scope, ok := ctx.Get("personalTokenScope").(string); !ok || scope != "deploy" {
+		t.Fatalf("unexpected scope: %v", ctx.Get("personalTokenScope"))
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// アクセストークンに追加するクレームの設定
+type ClaimMapping struct {
+	ID        uint   `gorm:"primarykey"`                                           // ID
+	Audience  string `gorm:"type:varchar(255);uniqueIndex:idx_claim_mapping_name"` // 対象 (クライアントID, 空はファーストパーティ, * は全て)
+	ClaimName string `gorm:"type:varchar(255);uniqueIndex:idx_claim_mapping_name"` // クレーム名
+	Source    string `gorm:"type:varchar(32)"`                                     // 値の取得元 (name / email など)
+	Attribute string `gorm:"type:varchar(255)"`                                    // Source が attribute の時の属性名
+	CreatedAt int64  `gorm:"autoCreateTime"`                                       // 作成日
+}
+
+// ユーザーの任意の属性
+type UserAttribute struct {
+	UserID string `gorm:"type:varchar(255);primaryKey"` // ユーザーID
+	Key    string `gorm:"type:varchar(255);primaryKey"` // 属性名
+	Value  string `gorm:"type:text"`                    // 値
+}
+
+// ここからクレームの設定
+func GetClaimMappings() ([]ClaimMapping, error) {
+	var mappings []ClaimMapping
+
+	// 取得する
+	err := dbconn.Order("audience, claim_name").Find(&mappings).Error
+	return mappings, err
+}
+
+// 対象ごとの設定を取得
+func GetClaimMappingsFor(audiences ...string) ([]ClaimMapping, error) {
+	var mappings []ClaimMapping
+
+	// 取得する
+	err := dbconn.Where("audience IN ?", audiences).Find(&mappings).Error
+	return mappings, err
+}
+
+func GetClaimMapping(id uint) (*ClaimMapping, GetResult) {
+	var mapping ClaimMapping
+
+	// 取得する
+	err := dbconn.Where(&ClaimMapping{ID: id}).First(&mapping).Error
+
+	return &mapping, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+func CreateClaimMapping(mapping *ClaimMapping) error {
+	return dbconn.Create(mapping).Error
+}
+
+func UpdateClaimMapping(mapping *ClaimMapping) error {
+	return dbconn.Save(mapping).Error
+}
+
+func DeleteClaimMapping(id uint) error {
+	return dbconn.Delete(&ClaimMapping{}, id).Error
+}
+
+// ここまで
+
+// ここからユーザーの属性
+func GetUserAttributes(userID string) ([]UserAttribute, error) {
+	var attributes []UserAttribute
+
+	// 取得する
+	err := dbconn.Where(&UserAttribute{UserID: userID}).Order("`key`").Find(&attributes).Error
+	return attributes, err
+}
+
+// ユーザーの属性を置き換える
+func ReplaceUserAttributes(userID string, attributes []UserAttribute) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&UserAttribute{UserID: userID}).Delete(&UserAttribute{}).Error; err != nil {
+			return err
+		}
+
+		if len(attributes) == 0 {
+			return nil
+		}
+
+		return tx.Create(&attributes).Error
+	})
+}
+
+// ユーザーの属性を全て削除する
+func DeleteUserAttributes(userID string) error {
+	return dbconn.Where(&UserAttribute{UserID: userID}).Delete(&UserAttribute{}).Error
+}
+
+// ここまで
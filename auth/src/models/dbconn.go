@@ -1,6 +1,7 @@
 package models
 
 import (
+	"auth/logger"
 	"os"
 
 	"gorm.io/driver/mysql"
@@ -34,8 +35,24 @@ func Init() error {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Provider{})
 	db.AutoMigrate(&Session{})
+	db.AutoMigrate(&SessionLimit{})
+	db.AutoMigrate(&RefreshToken{})
+	db.AutoMigrate(&RevokedAccessToken{})
+	db.AutoMigrate(&TokenRevocation{})
 	db.AutoMigrate(&Label{})
 	db.AutoMigrate(&AdminUser{})
+	db.AutoMigrate(&OneTimeToken{})
+	db.AutoMigrate(&WebauthnCredential{})
+	db.AutoMigrate(&Identity{})
+	db.AutoMigrate(&OauthClient{})
+	db.AutoMigrate(&AuthorizationCode{})
+	db.AutoMigrate(&OauthConsent{})
+	db.AutoMigrate(&DeviceCode{})
+	db.AutoMigrate(&ServiceAccount{})
+	db.AutoMigrate(&PersonalAccessToken{})
+	db.AutoMigrate(&ClaimMapping{})
+	db.AutoMigrate(&UserAttribute{})
+	db.AutoMigrate(&SigningKey{})
 
 	// グローバル変数に格納
 	dbconn = db
@@ -43,6 +60,11 @@ func Init() error {
 	// プロバイダを初期化する
 	InitProviders()
 
+	// 連携を移行する
+	if err := migrateIdentities(); err != nil {
+		logger.PrintErr(err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// デバイスコードの状態
+type DeviceCodeStatus string
+
+const (
+	DevicePending  DeviceCodeStatus = "pending"  // ユーザーの承認待ち
+	DeviceApproved DeviceCodeStatus = "approved" // 承認済み (トークン未発行)
+	DeviceDenied   DeviceCodeStatus = "denied"   // 拒否された
+	DeviceUsed     DeviceCodeStatus = "used"     // トークンを発行済み
+)
+
+// デバイス認可 (RFC 8628)
+type DeviceCode struct {
+	DeviceCodeHash string           `gorm:"type:varchar(255);primaryKey"`     // デバイスコードのハッシュ
+	UserCode       string           `gorm:"type:varchar(255);uniqueIndex"`    // ユーザーが入力するコード (区切りなし)
+	ClientID       string           `gorm:"type:varchar(255);index"`          // クライアントID
+	Scope          string           `gorm:"type:text"`                        // 要求されたスコープ
+	Status         DeviceCodeStatus `gorm:"type:varchar(32);default:pending"` // 状態
+	UserID         string           `gorm:"type:varchar(255)"`                // 承認したユーザー
+	SessionID      string           `gorm:"type:varchar(255)"`                // 承認したセッション
+	Interval       int64            // ポーリング間隔 (秒)
+	LastPolledAt   int64            `gorm:"default:0"`      // 最後にポーリングされた日時
+	ExpiresAt      int64            `gorm:"index"`          // 有効期限
+	CreatedAt      int64            `gorm:"autoCreateTime"` // 作成日
+}
+
+func CreateDeviceCode(code *DeviceCode) error {
+	return dbconn.Create(code).Error
+}
+
+// デバイスコードを取得
+func GetDeviceCode(deviceCodeHash string) (*DeviceCode, GetResult) {
+	var code DeviceCode
+
+	// 取得する
+	err := dbconn.Where(&DeviceCode{DeviceCodeHash: deviceCodeHash}).First(&code).Error
+
+	return &code, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ユーザーコードから取得
+func GetDeviceCodeByUserCode(userCode string) (*DeviceCode, GetResult) {
+	var code DeviceCode
+
+	// 取得する
+	err := dbconn.Where(&DeviceCode{UserCode: userCode}).First(&code).Error
+
+	return &code, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// 承認または拒否する (承認待ちで期限内の時だけ)
+func DecideDeviceCode(userCode string, status DeviceCodeStatus, userID string, sessionID string, now int64) (bool, error) {
+	result := dbconn.Model(&DeviceCode{}).
+		Where("user_code = ? AND status = ? AND expires_at >= ?", userCode, DevicePending, now).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"user_id":    userID,
+			"session_id": sessionID,
+		})
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// ポーリングを記録する
+func PollDeviceCode(deviceCodeHash string, interval int64, now int64) error {
+	return dbconn.Model(&DeviceCode{}).
+		Where(&DeviceCode{DeviceCodeHash: deviceCodeHash}).
+		Updates(map[string]interface{}{
+			"interval":       interval,
+			"last_polled_at": now,
+		}).Error
+}
+
+// トークンを発行済みにする (既に発行していた時は false)
+func UseDeviceCode(deviceCodeHash string) (bool, error) {
+	result := dbconn.Model(&DeviceCode{}).
+		Where("device_code_hash = ? AND status = ?", deviceCodeHash, DeviceApproved).
+		Update("status", DeviceUsed)
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// 期限切れのコードを削除する
+func DeleteExpiredDeviceCodes(now int64) error {
+	return dbconn.Where("expires_at < ?", now).Delete(&DeviceCode{}).Error
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ユーザーに紐づく外部プロバイダのアカウント
+type Identity struct {
+	ID        uint         `gorm:"primarykey"`                                                 // プライマリキー
+	UserID    string       `gorm:"type:varchar(255);index"`                                    // ユーザーID
+	ProvCode  ProviderCode `gorm:"type:varchar(255);uniqueIndex:idx_identity_prov,length:255"` // 認証プロバイダコード
+	ProvUID   string       `gorm:"type:varchar(255);uniqueIndex:idx_identity_prov,length:255"` // 認証プロバイダUID
+	Email     string       `gorm:"type:varchar(255)"`                                          // プロバイダ側のメールアドレス
+	CreatedAt int64        `gorm:"autoCreateTime"`                                             // 連携日
+}
+
+// プロバイダとUIDから取得
+func GetIdentity(provCode ProviderCode, provUID string) (*Identity, GetResult) {
+	var identity Identity
+
+	// 取得する
+	err := dbconn.Where(&Identity{ProvCode: provCode, ProvUID: provUID}).First(&identity).Error
+
+	return &identity, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ユーザーに連携を追加する
+func (usr *User) AddIdentity(identity *Identity) error {
+	identity.UserID = usr.UserID
+	return dbconn.Create(identity).Error
+}
+
+// ユーザーの連携一覧を取得する
+func (usr *User) GetIdentities() ([]Identity, error) {
+	var identities []Identity
+
+	// 取得する
+	err := dbconn.Where(&Identity{UserID: usr.UserID}).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// ユーザーの連携を削除する (削除した時は true)
+func (usr *User) DeleteIdentity(id uint) (bool, error) {
+	// 削除する
+	result := dbconn.Where("user_id = ? AND id = ?", usr.UserID, id).Delete(&Identity{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// 全ての連携を取得する (ユーザーIDごと)
+func GetAllIdentities() (map[string][]Identity, error) {
+	var identities []Identity
+
+	// 取得する
+	err := dbconn.Order("created_at").Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// ユーザーごとに分ける
+	result := map[string][]Identity{}
+	for _, identity := range identities {
+		result[identity.UserID] = append(result[identity.UserID], identity)
+	}
+
+	return result, nil
+}
+
+// users テーブルの ProvCode / ProvUID から連携を作成する
+func migrateIdentities() error {
+	var users []User
+
+	// 外部プロバイダのユーザーを取得する
+	err := dbconn.Where("prov_code <> ? AND prov_uid <> ''", Basic).Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		// 既にある時
+		if _, result := GetIdentity(user.ProvCode, user.ProvUID); result.IsExists {
+			continue
+		}
+
+		// 連携を作成する
+		err := user.AddIdentity(&Identity{
+			ProvCode: user.ProvCode,
+			ProvUID:  user.ProvUID,
+			Email:    user.Email,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// プロバイダが確認したメールアドレスなので確認済みにする
+		if user.EmailVerifiedAt == 0 {
+			err := dbconn.Model(&User{}).Where(&User{UserID: user.UserID}).Update("email_verified_at", user.CreatedAt).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
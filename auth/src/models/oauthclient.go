@@ -0,0 +1,174 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 認証サーバーに登録されたクライアント
+type OauthClient struct {
+	ClientID     string `gorm:"type:varchar(255);primaryKey"` // クライアントID
+	ClientName   string // 同意画面に表示する名前
+	SecretHash   string `gorm:"type:varchar(255)"` // クライアントシークレットのハッシュ (公開クライアントは空)
+	RedirectURIs string `gorm:"type:text"`         // 登録されたリダイレクトURI (改行区切り)
+	Scopes       string `gorm:"type:text"`         // 許可するスコープ (スペース区切り)
+	IsPublic     int    `gorm:"default:0"`         // 公開クライアントか (シークレットを持たない)
+	SkipConsent  int    `gorm:"default:0"`         // 同意画面を表示しないか (ファーストパーティ用)
+	CreatedAt    int64  `gorm:"autoCreateTime"`    // 作成日
+}
+
+// 認可コード (コードはハッシュ化して保存する)
+type AuthorizationCode struct {
+	CodeHash            string `gorm:"type:varchar(255);primaryKey"` // 認可コードのハッシュ
+	ClientID            string `gorm:"type:varchar(255);index"`      // クライアントID
+	UserID              string `gorm:"type:varchar(255);index"`      // ユーザーID
+	SessionID           string `gorm:"type:varchar(255)"`            // 認可したセッション
+	RedirectURI         string `gorm:"type:text"`                    // 認可リクエストで指定されたリダイレクトURI (省略した時は空)
+	Scope               string `gorm:"type:text"`                    // 認可したスコープ
+	CodeChallenge       string `gorm:"type:varchar(255)"`            // PKCE のチャレンジ
+	CodeChallengeMethod string `gorm:"type:varchar(16)"`             // PKCE の方式
+	Nonce               string `gorm:"type:text"`                    // OpenID Connect の nonce
+	ExpiresAt           int64  `gorm:"index"`                        // 有効期限
+	UsedAt              int64  `gorm:"default:0"`                    // 使用日時 (0 は未使用)
+	CreatedAt           int64  `gorm:"autoCreateTime"`               // 作成日
+}
+
+// ユーザーがクライアントに同意したスコープ
+type OauthConsent struct {
+	UserID    string `gorm:"type:varchar(255);primaryKey"` // ユーザーID
+	ClientID  string `gorm:"type:varchar(255);primaryKey"` // クライアントID
+	Scope     string `gorm:"type:text"`                    // 同意したスコープ (スペース区切り)
+	UpdatedAt int64  `gorm:"autoUpdateTime"`               // 更新日
+}
+
+// ここからクライアント
+func CreateOauthClient(client *OauthClient) error {
+	return dbconn.Create(client).Error
+}
+
+// クライアントを取得
+func GetOauthClient(clientID string) (*OauthClient, GetResult) {
+	var client OauthClient
+
+	// 取得する
+	err := dbconn.Where(&OauthClient{ClientID: clientID}).First(&client).Error
+
+	return &client, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// クライアント一覧を取得
+func GetOauthClients() ([]OauthClient, error) {
+	var clients []OauthClient
+
+	// 取得する
+	err := dbconn.Order("created_at").Find(&clients).Error
+	return clients, err
+}
+
+// クライアントを更新
+func UpdateOauthClient(client *OauthClient) error {
+	return dbconn.Save(client).Error
+}
+
+// クライアントを削除 (発行済みのコード、トークンと同意も削除する)
+func DeleteOauthClient(clientID string) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		// 認可コード
+		if err := tx.Where(&AuthorizationCode{ClientID: clientID}).Delete(&AuthorizationCode{}).Error; err != nil {
+			return err
+		}
+
+		// 同意
+		if err := tx.Where(&OauthConsent{ClientID: clientID}).Delete(&OauthConsent{}).Error; err != nil {
+			return err
+		}
+
+		// デバイスコード
+		if err := tx.Where(&DeviceCode{ClientID: clientID}).Delete(&DeviceCode{}).Error; err != nil {
+			return err
+		}
+
+		// リフレッシュトークン
+		if err := tx.Where(&RefreshToken{ClientID: clientID}).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+
+		// クレームの設定
+		if err := tx.Where(&ClaimMapping{Audience: clientID}).Delete(&ClaimMapping{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where(&OauthClient{ClientID: clientID}).Delete(&OauthClient{}).Error
+	})
+}
+
+// ここまで
+
+// ここから認可コード
+func CreateAuthorizationCode(code *AuthorizationCode) error {
+	return dbconn.Create(code).Error
+}
+
+// 認可コードを取得
+func GetAuthorizationCode(codeHash string) (*AuthorizationCode, GetResult) {
+	var code AuthorizationCode
+
+	// 取得する
+	err := dbconn.Where(&AuthorizationCode{CodeHash: codeHash}).First(&code).Error
+
+	return &code, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// 認可コードを使用済みにする (既に使用済みの時は false)
+func UseAuthorizationCode(codeHash string, now int64) (bool, error) {
+	// 未使用の時だけ更新する
+	result := dbconn.Model(&AuthorizationCode{}).
+		Where("code_hash = ? AND used_at = 0 AND expires_at > ?", codeHash, now).
+		Update("used_at", now)
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// 期限切れの認可コードを削除する
+func DeleteExpiredAuthorizationCodes(now int64) error {
+	return dbconn.Where("expires_at < ?", now).Delete(&AuthorizationCode{}).Error
+}
+
+// ここまで
+
+// ここから同意
+func GetOauthConsent(userID string, clientID string) (*OauthConsent, GetResult) {
+	var consent OauthConsent
+
+	// 取得する
+	err := dbconn.Where(&OauthConsent{UserID: userID, ClientID: clientID}).First(&consent).Error
+
+	return &consent, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// 同意を保存する
+func SaveOauthConsent(consent *OauthConsent) error {
+	return dbconn.Save(consent).Error
+}
+
+// ユーザーの同意を全て削除する
+func DeleteUserOauthConsents(userID string) error {
+	return dbconn.Where(&OauthConsent{UserID: userID}).Delete(&OauthConsent{}).Error
+}
+
+// ここまで
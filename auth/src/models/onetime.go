@@ -0,0 +1,94 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type TokenPurpose string
+
+const (
+	// メールアドレス確認
+	PurposeVerifyEmail TokenPurpose = "verify_email"
+
+	// パスワードリセット
+	PurposeResetPassword TokenPurpose = "reset_password"
+
+	// 二要素認証の待機中
+	PurposeMfa TokenPurpose = "mfa"
+
+	// 外部アカウントの連携
+	PurposeLinkIdentity TokenPurpose = "link_identity"
+)
+
+type OneTimeToken struct {
+	TokenID   string       `gorm:"type:varchar(255);primaryKey"` // トークンID (jti)
+	UserID    string       `gorm:"type:varchar(255);index"`      // ユーザーID
+	Purpose   TokenPurpose `gorm:"type:varchar(64);index"`       // 用途
+	ExpiresAt int64        // 有効期限
+	UsedAt    int64        `gorm:"default:0"`      // 使用日時 (0 は未使用)
+	Attempts  int          `gorm:"default:0"`      // 検証の失敗回数
+	CreatedAt int64        `gorm:"autoCreateTime"` // 作成日
+}
+
+func CreateOneTimeToken(token *OneTimeToken) error {
+	return dbconn.Create(token).Error
+}
+
+// ワンタイムトークンを取得
+func GetOneTimeToken(tokenID string) (*OneTimeToken, GetResult) {
+	var token OneTimeToken
+
+	// 取得する
+	err := dbconn.Where(&OneTimeToken{TokenID: tokenID}).First(&token).Error
+
+	return &token, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ワンタイムトークンを使用済みにする (既に使用済みの時は false)
+func UseOneTimeToken(tokenID string, now int64) (bool, error) {
+	// 未使用の時だけ更新する
+	result := dbconn.Model(&OneTimeToken{}).
+		Where("token_id = ? AND used_at = 0 AND expires_at > ?", tokenID, now).
+		Update("used_at", now)
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// 失敗回数を増やして現在の回数を返す
+func IncrementOneTimeTokenAttempts(tokenID string) (int, error) {
+	// 増やす
+	err := dbconn.Model(&OneTimeToken{}).
+		Where("token_id = ?", tokenID).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+
+	// エラー処理
+	if err != nil {
+		return 0, err
+	}
+
+	// 取得する
+	token, result := GetOneTimeToken(tokenID)
+	return token.Attempts, result.Error
+}
+
+// ユーザーの未使用トークンを全て無効にする
+func RevokeOneTimeTokens(userID string, purpose TokenPurpose, now int64) error {
+	return dbconn.Model(&OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at = 0", userID, purpose).
+		Update("used_at", now).Error
+}
+
+// 期限切れのトークンを削除する
+func DeleteExpiredOneTimeTokens(now int64) error {
+	return dbconn.Where("expires_at < ?", now).Delete(&OneTimeToken{}).Error
+}
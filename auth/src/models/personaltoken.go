@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ユーザーが発行したパーソナルアクセストークン (トークンはハッシュ化して保存する)
+type PersonalAccessToken struct {
+	TokenID    string `gorm:"type:varchar(255);primaryKey"`  // トークンID
+	TokenHash  string `gorm:"type:varchar(255);uniqueIndex"` // トークンのハッシュ
+	UserID     string `gorm:"type:varchar(255);index"`       // ユーザーID
+	Name       string `gorm:"type:varchar(255)"`             // 用途が分かる名前
+	Scopes     string `gorm:"type:text"`                     // 使えるラベル (スペース区切り)
+	ExpiresAt  int64  // 有効期限
+	LastUsedAt int64  `gorm:"default:0"`      // 最後に使われた日時
+	CreatedAt  int64  `gorm:"autoCreateTime"` // 作成日
+}
+
+func CreatePersonalAccessToken(token *PersonalAccessToken) error {
+	return dbconn.Create(token).Error
+}
+
+// ハッシュからトークンを取得
+func GetPersonalAccessToken(tokenHash string) (*PersonalAccessToken, GetResult) {
+	var token PersonalAccessToken
+
+	// 取得する
+	err := dbconn.Where(&PersonalAccessToken{TokenHash: tokenHash}).First(&token).Error
+
+	return &token, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ユーザーのトークン一覧を取得
+func GetUserPersonalAccessTokens(userID string) ([]PersonalAccessToken, error) {
+	var tokens []PersonalAccessToken
+
+	// 取得する
+	err := dbconn.Where(&PersonalAccessToken{UserID: userID}).Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// 使われた日時を記録する
+func TouchPersonalAccessToken(tokenID string, now int64) error {
+	return dbconn.Model(&PersonalAccessToken{}).Where(&PersonalAccessToken{TokenID: tokenID}).Update("last_used_at", now).Error
+}
+
+// ユーザーのトークンを削除する (見つからない時は false)
+func DeletePersonalAccessToken(userID string, tokenID string) (bool, error) {
+	result := dbconn.Where(&PersonalAccessToken{UserID: userID, TokenID: tokenID}).Delete(&PersonalAccessToken{})
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// ユーザーのトークンを全て削除する
+func DeleteUserPersonalAccessTokens(userID string) error {
+	return dbconn.Where(&PersonalAccessToken{UserID: userID}).Delete(&PersonalAccessToken{}).Error
+}
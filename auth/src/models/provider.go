@@ -2,7 +2,6 @@ package models
 
 import (
 	"auth/logger"
-	"os"
 )
 
 type ProviderCode string
@@ -12,27 +11,40 @@ const (
 	Github    ProviderCode = "github"
 	Discord   ProviderCode = "discord"
 	Microsoft ProviderCode = "microsoftonline"
+	Gitlab    ProviderCode = "gitlab"
+	Slack     ProviderCode = "slack"
+	Twitch    ProviderCode = "twitch"
+	Line      ProviderCode = "line"
+	Apple     ProviderCode = "apple"
+	Facebook  ProviderCode = "facebook"
+	Bitbucket ProviderCode = "bitbucket"
+	Amazon    ProviderCode = "amazon"
 	Basic     ProviderCode = "basic"
+	Ldap      ProviderCode = "ldap"
 )
 
-var (
-	// Oauth プロバイダ一覧
-	OauthProvides  = []ProviderCode{
-		Google,
-		Github,
-		Discord,
-		Microsoft,
-	}
+type ProviderKind string
+
+const (
+	KindBuiltin ProviderKind = ""     // コードに組み込まれたプロバイダ
+	KindOidc    ProviderKind = "oidc" // ダッシュボードから登録する OpenID Connect プロバイダ
+	KindSaml    ProviderKind = "saml" // ダッシュボードから登録する SAML IdP
 )
 
 type Provider struct {
-    ProviderName string       `gorm:"primaryKey"` // 認証プロバイダ名
-    ClientID     string       // 認証プロバイダのクライアントID
-    ClientSecret string       // 認証プロバイダのクライアントシークレット
-    CallbackURL  string       // 認証プロバイダのコールバックURL
-    ProviderCode ProviderCode `gorm:"type:varchar(255);uniqueIndex"` // 認証プロバイダのコード
-    IsEnabled    int          `gorm:"default:0"` // 認証プロバイダの有効状態
-    Users        []User       `gorm:"foreignKey:ProvCode;references:ProviderCode"` // プロバイダが持つユーザー
+	ProviderName             string       `gorm:"primaryKey"` // 認証プロバイダ名
+	ClientID                 string       // 認証プロバイダのクライアントID
+	ClientSecret             string       // 認証プロバイダのクライアントシークレット
+	CallbackURL              string       // 認証プロバイダのコールバックURL
+	ProviderCode             ProviderCode `gorm:"type:varchar(255);uniqueIndex"` // 認証プロバイダのコード
+	IsEnabled                int          `gorm:"default:0"`                     // 認証プロバイダの有効状態
+	RequireEmailVerification int          `gorm:"default:0"`                     // メールアドレス確認をセッション作成の条件にするか (basic のみ)
+	Kind                     ProviderKind `gorm:"type:varchar(32);default:''"`   // プロバイダの種類
+	IssuerURL                string       // OIDC の issuer (oidc のみ)
+	Scopes                   string       // 要求するスコープ (スペース区切り, oidc のみ)
+	ClaimMapping             string       `gorm:"type:text"`                                   // クレームの割り当て (JSON, oidc のみ)
+	Config                   string       `gorm:"type:text"`                                   // プロバイダ固有の設定 (JSON, ldap など)
+	Users                    []User       `gorm:"foreignKey:ProvCode;references:ProviderCode"` // プロバイダが持つユーザー
 }
 
 // プロバイダを取得
@@ -54,98 +66,41 @@ func CreateProvider(provider *Provider) error {
 	return dbconn.Create(provider).Error
 }
 
-// プロバイダを初期化する
+// プロバイダを初期化する (Oauth プロバイダは oauth2 パッケージが登録する)
 func InitProviders() {
-	// Google
-	err := CreateProvider(&Provider{
-		ProviderName: "Google",
-		ClientID:     os.Getenv("GoogleClientID"),
-		ClientSecret: os.Getenv("GoogleClientSecret"),
-		CallbackURL:  os.Getenv("GoogleCallback"),
-		ProviderCode: Google,
-		IsEnabled:    0,
-		Users:        []User{},
-	})
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
+	// basic と ldap
+	providers := []Provider{
+		{ProviderName: "Basic", ProviderCode: Basic},
+		{ProviderName: "LDAP", ProviderCode: Ldap},
 	}
 
-	// GitHub
-	err = CreateProvider(&Provider{
-		ProviderName: "GitHub",
-		ClientID:     os.Getenv("GithubClientID"),
-		ClientSecret: os.Getenv("GithubClientSecret"),
-		CallbackURL:  os.Getenv("GithubCallback"),
-		ProviderCode: Github,
-		IsEnabled:    0,
-		Users:        []User{},
-	})
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
-
-	// Discord
-	err = CreateProvider(&Provider{
-		ProviderName: "Discord",
-		ClientID:     os.Getenv("DiscordClientID"),
-		ClientSecret: os.Getenv("DiscordClientSecret"),
-		CallbackURL:  os.Getenv("DiscordCallback"),
-		ProviderCode: Discord,
-		IsEnabled:    0,
-		Users:        []User{},
-	})
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
+	for _, provider := range providers {
+		// 既に存在する時
+		if _, err := GetProvider(provider.ProviderCode); err == nil {
+			continue
+		}
 
-	// Microsoft
-	err = CreateProvider(&Provider{
-		ProviderName: "Microsoft",
-		ClientID:     os.Getenv("MicrosoftClientID"),
-		ClientSecret: os.Getenv("MicrosoftClientSecret"),
-		CallbackURL:  os.Getenv("MicrosoftCallback"),
-		ProviderCode: Microsoft,
-		IsEnabled:    0,
-		Users:        []User{},
-	})
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
+		// 作成する (無効の状態)
+		provider.IsEnabled = 0
+		provider.Users = []User{}
+		err := CreateProvider(&provider)
 
-	// basic
-	err = CreateProvider(&Provider{
-		ProviderName: "Basic",
-		ClientID:     "",
-		ClientSecret: "",
-		CallbackURL:  "",
-		ProviderCode: Basic,
-		IsEnabled:    0,
-		Users:        []User{},
-	})
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
+		// エラー処理
+		if err != nil {
+			logger.PrintErr(err)
+		}
 	}
 
 	// 完了
 	logger.Println("Providers initialized")
 }
 
-// Oauth のプロバイダ取得
-func GetOauthProviders() []Provider {
+// Oauth のプロバイダ取得 (指定した順番)
+func GetOauthProviders(codes []ProviderCode) []Provider {
 	// 返すデータ
 	returnProviders := []Provider{}
 
-	for _, providerName := range OauthProvides {
+	for _, providerName := range codes {
 		// プロバイダを取得する
 		provider,err := GetProvider(providerName)
 
@@ -160,4 +115,47 @@ func GetOauthProviders() []Provider {
 	}
 	
 	return returnProviders
-}
\ No newline at end of file
+}
+
+// OIDC プロバイダ一覧を取得
+func GetOidcProviders() ([]Provider, error) {
+	return GetProvidersByKind(KindOidc)
+}
+
+// 種類を指定してプロバイダ一覧を取得
+func GetProvidersByKind(kind ProviderKind) ([]Provider, error) {
+	var providers []Provider
+
+	// 取得する
+	err := dbconn.Where(&Provider{Kind: kind}).Order("provider_code").Find(&providers).Error
+	return providers, err
+}
+
+// プロバイダを削除
+func DeleteProvider(providerCode ProviderCode) error {
+	return dbconn.Where(&Provider{ProviderCode: providerCode}).Delete(&Provider{}).Error
+}
+
+// プロバイダを更新 (プロバイダコードで指定)
+func UpdateProviderByCode(providerCode ProviderCode, provider Provider) error {
+	// プロバイダ名は主キーなので Save ではなく Updates を使う
+	return dbconn.Model(&Provider{}).Where(&Provider{ProviderCode: providerCode}).Select(
+		"ProviderName", "ClientID", "ClientSecret", "CallbackURL", "IsEnabled", "IssuerURL", "Scopes", "ClaimMapping", "Config",
+	).Updates(&provider).Error
+}
+
+// プロバイダを使っているユーザー数を取得
+func CountProviderUsers(providerCode ProviderCode) (int64, error) {
+	var userCount int64
+	var identityCount int64
+
+	// 登録に使ったユーザー
+	err := dbconn.Model(&User{}).Where(&User{ProvCode: providerCode}).Count(&userCount).Error
+	if err != nil {
+		return 0, err
+	}
+
+	// 連携しているユーザー
+	err = dbconn.Model(&Identity{}).Where(&Identity{ProvCode: providerCode}).Count(&identityCount).Error
+	return userCount + identityCount, err
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// リフレッシュトークン (トークンはハッシュ化して保存する)
+type RefreshToken struct {
+	TokenHash     string `gorm:"type:varchar(255);primaryKey"` // トークンのハッシュ
+	FamilyID      string `gorm:"type:varchar(255);index"`      // ローテーションで引き継ぐ系列のID
+	SessionID     string `gorm:"type:varchar(255);index"`      // 紐づくセッション
+	UserID        string `gorm:"type:varchar(255);index"`      // ユーザーID
+	ClientID      string `gorm:"type:varchar(255)"`            // 発行先のクライアント (空はファーストパーティ)
+	Scope         string `gorm:"type:text"`                    // 認可されたスコープ
+	ExpiresAt     int64  // 系列の絶対的な有効期限
+	IdleExpiresAt int64  `gorm:"index"`          // 使われないまま失効する日時
+	UsedAt        int64  `gorm:"default:0"`      // ローテーションした日時 (0 は未使用)
+	RevokedAt     int64  `gorm:"default:0"`      // 失効させた日時 (0 は有効)
+	CreatedAt     int64  `gorm:"autoCreateTime"` // 作成日
+}
+
+func CreateRefreshToken(token *RefreshToken) error {
+	return dbconn.Create(token).Error
+}
+
+// リフレッシュトークンを取得
+func GetRefreshToken(tokenHash string) (*RefreshToken, GetResult) {
+	var token RefreshToken
+
+	// 取得する
+	err := dbconn.Where(&RefreshToken{TokenHash: tokenHash}).First(&token).Error
+
+	return &token, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ローテーション済みにする (既に使われていた時は false)
+func UseRefreshToken(tokenHash string, now int64) (bool, error) {
+	// 未使用の時だけ更新する
+	result := dbconn.Model(&RefreshToken{}).
+		Where("token_hash = ? AND used_at = 0 AND revoked_at = 0", tokenHash).
+		Update("used_at", now)
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// 系列のトークンを全て失効させる
+func RevokeRefreshTokenFamily(familyID string, now int64) error {
+	return dbconn.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at = 0", familyID).
+		Update("revoked_at", now).Error
+}
+
+// セッションのトークンがあるか
+func HasSessionRefreshTokens(sessionID string) (bool, error) {
+	var count int64
+
+	// 数える
+	err := dbconn.Model(&RefreshToken{}).Where(&RefreshToken{SessionID: sessionID}).Count(&count).Error
+	return count > 0, err
+}
+
+// セッションのトークンを削除する
+func DeleteSessionRefreshTokens(tx *gorm.DB, sessionIDs ...string) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	return tx.Where("session_id IN ?", sessionIDs).Delete(&RefreshToken{}).Error
+}
+
+// 期限切れのトークンを削除する
+func DeleteExpiredRefreshTokens(now int64) error {
+	return dbconn.Where("idle_expires_at < ? OR expires_at < ?", now, now).Delete(&RefreshToken{}).Error
+}
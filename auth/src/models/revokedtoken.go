@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 失効させたアクセストークン (期限が切れるまで jti を保持する)
+type RevokedAccessToken struct {
+	JTI       string `gorm:"type:varchar(255);primaryKey"` // トークンの jti
+	ExpiresAt int64  `gorm:"index"`                        // トークンの有効期限
+	CreatedAt int64  `gorm:"autoCreateTime"`               // 失効させた日時
+}
+
+// アクセストークンを失効させる (既に失効している時は何もしない)
+func RevokeAccessToken(jti string, expiresAt int64) error {
+	err := dbconn.Where(&RevokedAccessToken{JTI: jti}).
+		FirstOrCreate(&RevokedAccessToken{JTI: jti, ExpiresAt: expiresAt}).Error
+	Sessions().InvalidateAccessToken(jti)
+
+	return err
+}
+
+// 失効しているか
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	var token RevokedAccessToken
+
+	// 取得する
+	err := dbconn.Where(&RevokedAccessToken{JTI: jti}).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// 指定した日時以降に失効させたものを取得
+func GetRevokedAccessTokensSince(since int64) ([]RevokedAccessToken, error) {
+	var tokens []RevokedAccessToken
+
+	// 取得する
+	err := dbconn.Where("created_at >= ?", since).Find(&tokens).Error
+	return tokens, err
+}
+
+// 期限切れのものを削除する
+func DeleteExpiredRevokedAccessTokens(now int64) error {
+	return dbconn.Where("expires_at < ?", now).Delete(&RevokedAccessToken{}).Error
+}
+
+// ユーザーやセッションのトークンの一括失効 (下流のサービスに配信する)
+type TokenRevocation struct {
+	ID        uint   `gorm:"primarykey"`              // ID
+	UserID    string `gorm:"type:varchar(255);index"` // 対象のユーザーID
+	SessionID string `gorm:"type:varchar(255)"`       // 対象のセッション (空はユーザーの全てのトークン)
+	Reason    string `gorm:"type:varchar(32)"`        // 失効の理由
+	RevokedAt int64  `gorm:"index"`                   // この日時より前に発行されたトークンを拒否する
+}
+
+func CreateTokenRevocation(revocation *TokenRevocation) error {
+	err := dbconn.Create(revocation).Error
+	revocation.invalidate(Sessions())
+
+	return err
+}
+
+// 失効の対象を取得先から捨てる
+func (revocation TokenRevocation) invalidate(store SessionStore) {
+	if revocation.SessionID != "" {
+		store.InvalidateSessions(revocation.SessionID)
+		return
+	}
+
+	store.InvalidateUser(revocation.UserID)
+}
+
+// 指定した日時以降の失効を取得 (古い順)
+func GetTokenRevocationsSince(since int64) ([]TokenRevocation, error) {
+	var revocations []TokenRevocation
+
+	// 取得する
+	err := dbconn.Where("revoked_at >= ?", since).Order("revoked_at, id").Find(&revocations).Error
+	return revocations, err
+}
+
+// 指定した ID より後の失効を取得 (古い順)
+func GetTokenRevocationsAfter(id uint) ([]TokenRevocation, error) {
+	var revocations []TokenRevocation
+
+	// 取得する
+	err := dbconn.Where("id > ?", id).Order("id").Find(&revocations).Error
+	return revocations, err
+}
+
+// 最後に記録した失効の ID (ない時は 0)
+func GetLatestTokenRevocationID() (uint, error) {
+	var id uint
+
+	// 取得する
+	err := dbconn.Model(&TokenRevocation{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	return id, err
+}
+
+// 他のプロセスで記録した失効を取得先に反映する (捨てる)
+func InvalidateRevocations(store SessionStore, revocations []TokenRevocation, tokens []RevokedAccessToken) {
+	for _, revocation := range revocations {
+		revocation.invalidate(store)
+	}
+
+	for _, token := range tokens {
+		store.InvalidateAccessToken(token.JTI)
+	}
+}
+
+// ユーザーの全てのトークンを最後に失効させた日時 (ない時は 0)
+func GetUserRevokedAt(userID string, since int64) (int64, error) {
+	var revokedAt int64
+
+	// 取得する
+	err := dbconn.Model(&TokenRevocation{}).
+		Where("user_id = ? AND session_id = '' AND revoked_at >= ?", userID, since).
+		Select("COALESCE(MAX(revoked_at), 0)").Scan(&revokedAt).Error
+	return revokedAt, err
+}
+
+// 古い失効を削除する (発行済みのトークンが全て期限切れになったもの)
+func DeleteExpiredTokenRevocations(before int64) error {
+	return dbconn.Where("revoked_at < ?", before).Delete(&TokenRevocation{}).Error
+}
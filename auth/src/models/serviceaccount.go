@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// サービスアカウントのトークンに入れるプロバイダコード (ログインには使わない)
+const ServiceAccountCode ProviderCode = "service_account"
+
+// バックエンドのジョブが使う人間以外のアカウント
+type ServiceAccount struct {
+	AccountID   string  `gorm:"type:varchar(255);primaryKey"` // アカウントID (client_id)
+	Name        string  `gorm:"type:varchar(255)"`            // 表示名
+	Description string  `gorm:"type:text"`                    // 説明
+	SecretHash  string  `gorm:"type:varchar(255)"`            // クライアントシークレットのハッシュ
+	IsEnabled   int     // 有効か
+	Labels      []Label `gorm:"many2many:service_account_labels;constraint:OnDelete:CASCADE"` // スコープとして使うラベル
+	LastUsedAt  int64   `gorm:"default:0"`                                                    // 最後にトークンを発行した日時
+	CreatedAt   int64   `gorm:"autoCreateTime"`                                               // 作成日
+}
+
+func CreateServiceAccount(account *ServiceAccount) error {
+	return dbconn.Create(account).Error
+}
+
+// サービスアカウントを取得 (ラベルも読み込む)
+func GetServiceAccount(accountID string) (*ServiceAccount, GetResult) {
+	var account ServiceAccount
+
+	// 取得する
+	err := dbconn.Preload("Labels").Where(&ServiceAccount{AccountID: accountID}).First(&account).Error
+
+	return &account, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// サービスアカウント一覧を取得
+func GetServiceAccounts() ([]ServiceAccount, error) {
+	var accounts []ServiceAccount
+
+	// 取得する
+	err := dbconn.Preload("Labels").Order("created_at").Find(&accounts).Error
+	return accounts, err
+}
+
+// サービスアカウントを更新 (ラベルは置き換える)
+func UpdateServiceAccount(account *ServiceAccount) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Labels").Save(account).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(account).Association("Labels").Replace(account.Labels)
+	})
+}
+
+// トークンを発行した日時を記録する
+func TouchServiceAccount(accountID string, now int64) error {
+	return dbconn.Model(&ServiceAccount{}).Where(&ServiceAccount{AccountID: accountID}).Update("last_used_at", now).Error
+}
+
+// サービスアカウントを削除
+func DeleteServiceAccount(accountID string) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		account := ServiceAccount{AccountID: accountID}
+
+		// ラベルとの関連
+		if err := tx.Model(&account).Association("Labels").Clear(); err != nil {
+			return err
+		}
+
+		return tx.Where(&ServiceAccount{AccountID: accountID}).Delete(&ServiceAccount{}).Error
+	})
+}
@@ -1,11 +1,18 @@
 package models
 
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
 type Session struct {
     SessionID string `gorm:"primaryKey"` // セッションID
     UserID    string // ユーザーID
     UserAgent string // ユーザーエージェント
     RemoteIP  string // リモートIP
     CreatedAt int64  `gorm:"autoCreateTime"` // セッション作成日
+    ExpiresAt int64  `gorm:"index"` // 再ログインが必要になる日時 (作成日からの絶対期限)
+    LastSeenAt int64 `gorm:"index"` // 最後に使われた日時 (アイドル期限に使う)
 }
 
 // セッションを追加
@@ -21,10 +28,65 @@ func (usr *User) NewSession(session *Session) error {
 	return nil
 }
 
+// ユーザーの行をロックしてセッションを追加する (終了させたセッションIDを返す)
+// evict にはロック中に取得したユーザーのセッションを渡し、終了させるセッションIDを返させる
+// evict がエラーを返した時は追加しない
+func CreateUserSession(session *Session, evict func(sessions []Session) ([]string, error)) ([]string, error) {
+	var evicted []string
+
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+		// 同時にログインした時に数え間違えないようにユーザーの行をロックする
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&User{UserID: session.UserID}).First(&User{}).Error; err != nil {
+			return err
+		}
+
+		// ユーザーのセッションを取得
+		var sessions []Session
+		if err := tx.Where(&Session{UserID: session.UserID}).Find(&sessions).Error; err != nil {
+			return err
+		}
+
+		// 終了させるセッションを決める
+		sessionIDs, err := evict(sessions)
+		if err != nil {
+			return err
+		}
+
+		// リフレッシュトークンも削除する
+		if len(sessionIDs) > 0 {
+			if err := DeleteSessionRefreshTokens(tx, sessionIDs...); err != nil {
+				return err
+			}
+
+			if err := tx.Where("session_id IN ?", sessionIDs).Unscoped().Delete(&Session{}).Error; err != nil {
+				return err
+			}
+		}
+
+		evicted = sessionIDs
+		return tx.Create(session).Error
+	})
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	Sessions().InvalidateSessions(evicted...)
+	return evicted, nil
+}
+
 // セッションを削除
 func (usr *User) DeleteSession(sessionid string) error {
-	// ユーザのセッションから削除
-	err := dbconn.Model(usr).Association("Sessions").Unscoped().Delete(&Session{SessionID: sessionid})
+	// ユーザのセッションから削除 (リフレッシュトークンも削除する)
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(usr).Association("Sessions").Unscoped().Delete(&Session{SessionID: sessionid}); err != nil {
+			return err
+		}
+
+		return DeleteSessionRefreshTokens(tx, sessionid)
+	})
+	Sessions().InvalidateSessions(sessionid)
 	
 	// エラー処理
 	if err != nil {
@@ -34,6 +96,21 @@ func (usr *User) DeleteSession(sessionid string) error {
 	return nil
 }
 
+// ユーザーのセッションを全て削除
+func DeleteUserSessions(userid string) error {
+	// 削除する (リフレッシュトークンも削除する)
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&RefreshToken{UserID: userid}).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where(&Session{UserID: userid}).Unscoped().Delete(&Session{}).Error
+	})
+	Sessions().InvalidateUser(userid)
+
+	return err
+}
+
 // セッション取得
 func GetSession(sessionid string) (*Session, error) {
 	var session Session
@@ -54,7 +131,96 @@ func GetAllSessions() ([]Session,error) {
 
 // セッションを削除
 func DeleteSession(sessionid string) error {
-	// 取得する
-	err := dbconn.Where(&Session{SessionID: sessionid}).Unscoped().Delete(&Session{}).Error
+	// 削除する (リフレッシュトークンも削除する)
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteSessionRefreshTokens(tx, sessionid); err != nil {
+			return err
+		}
+
+		return tx.Where(&Session{SessionID: sessionid}).Unscoped().Delete(&Session{}).Error
+	})
+	Sessions().InvalidateSessions(sessionid)
+
 	return err
-}
\ No newline at end of file
+}
+
+// セッションが使われた日時を記録する
+func TouchSession(sessionid string, now int64) error {
+	err := dbconn.Model(&Session{}).Where(&Session{SessionID: sessionid}).Update("last_seen_at", now).Error
+	Sessions().InvalidateSessions(sessionid)
+
+	return err
+}
+
+// 有効期限がないセッションに期限を設定する (以前のセッションの移行)
+func BackfillSessionExpiry(lifetime int64, now int64) error {
+	return dbconn.Model(&Session{}).Where("expires_at = 0 OR expires_at IS NULL").Updates(map[string]interface{}{
+		"expires_at":   gorm.Expr("created_at + ?", lifetime),
+		"last_seen_at": now,
+	}).Error
+}
+
+// 期限切れのセッションを削除する (削除した数を返す)
+func DeleteExpiredSessions(now int64, idleBefore int64) (int64, error) {
+	var deleted int64
+	var sessionIDs []string
+
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+
+		// 期限切れのセッションを探す
+		if err := tx.Model(&Session{}).Where("expires_at < ? OR last_seen_at < ?", now, idleBefore).Pluck("session_id", &sessionIDs).Error; err != nil {
+			return err
+		}
+
+		if len(sessionIDs) == 0 {
+			return nil
+		}
+
+		// リフレッシュトークンも削除する
+		if err := DeleteSessionRefreshTokens(tx, sessionIDs...); err != nil {
+			return err
+		}
+
+		result := tx.Where("session_id IN ?", sessionIDs).Unscoped().Delete(&Session{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	Sessions().InvalidateSessions(sessionIDs...)
+
+	return deleted, err
+}
+
+// ユーザーのセッション一覧を取得 (最近使われた順)
+func GetUserSessions(userid string) ([]Session, error) {
+	var sessions []Session
+
+	// 取得する
+	err := dbconn.Where(&Session{UserID: userid}).Order("last_seen_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// 指定したセッション以外のユーザーのセッションを削除する (削除したセッションIDを返す)
+func DeleteOtherUserSessions(userid string, keepSessionID string) ([]string, error) {
+	var sessionIDs []string
+
+	err := dbconn.Transaction(func(tx *gorm.DB) error {
+		// 削除するセッションを探す
+		if err := tx.Model(&Session{}).Where("user_id = ? AND session_id <> ?", userid, keepSessionID).Pluck("session_id", &sessionIDs).Error; err != nil {
+			return err
+		}
+
+		if len(sessionIDs) == 0 {
+			return nil
+		}
+
+		// リフレッシュトークンも削除する
+		if err := DeleteSessionRefreshTokens(tx, sessionIDs...); err != nil {
+			return err
+		}
+
+		return tx.Where("session_id IN ?", sessionIDs).Unscoped().Delete(&Session{}).Error
+	})
+	Sessions().InvalidateSessions(sessionIDs...)
+
+	return sessionIDs, err
+}
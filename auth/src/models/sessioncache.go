@@ -0,0 +1,229 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// プロセス内の LRU でセッションとユーザーと失効を覚える取得先
+//
+// 同じプロセスでの変更はすぐに捨てる。他のプロセスでの失効は InvalidateRevocations で
+// 取り込んだ時に捨て、それ以外の変更は ttl が過ぎるまで見えない。
+type CachedSessionStore struct {
+	backend SessionStore
+	ttl     time.Duration
+
+	mutex         sync.Mutex
+	sessions      *lruCache[Session]
+	users         *lruCache[User]
+	revokedTokens *lruCache[bool]  // jti ごとの失効
+	revokedAt     *lruCache[int64] // ユーザーごとの一括失効の日時
+
+	// 捨てるたびに増やす (取得中に捨てられた古い値を覚えないため)
+	generation uint64
+}
+
+// 取得先を作る (size はセッションとユーザーと失効それぞれの最大数)
+func NewCachedSessionStore(backend SessionStore, size int, ttl time.Duration) *CachedSessionStore {
+	return &CachedSessionStore{
+		backend:       backend,
+		ttl:           ttl,
+		sessions:      newLruCache[Session](size),
+		users:         newLruCache[User](size),
+		revokedTokens: newLruCache[bool](size),
+		revokedAt:     newLruCache[int64](size),
+	}
+}
+
+func (store *CachedSessionStore) GetSession(sessionID string) (*Session, error) {
+	store.mutex.Lock()
+	session, ok := store.sessions.get(sessionID)
+	generation := store.generation
+	store.mutex.Unlock()
+
+	// 覚えている時は複製を返す (呼び出し側が書き換えても影響しない)
+	if ok {
+		return &session, nil
+	}
+
+	// 見つからない時は覚えない
+	fetched, err := store.backend.GetSession(sessionID)
+	if err != nil {
+		return fetched, err
+	}
+
+	store.mutex.Lock()
+	if store.generation == generation {
+		store.sessions.add(sessionID, *fetched, time.Now().Add(store.ttl))
+	}
+	store.mutex.Unlock()
+
+	return fetched, nil
+}
+
+func (store *CachedSessionStore) GetUser(userID string) (*User, GetResult) {
+	store.mutex.Lock()
+	user, ok := store.users.get(userID)
+	generation := store.generation
+	store.mutex.Unlock()
+
+	if ok {
+		return &user, GetResult{Error: nil, IsExists: true}
+	}
+
+	fetched, result := store.backend.GetUser(userID)
+	if result.Error != nil {
+		return fetched, result
+	}
+
+	store.mutex.Lock()
+	if store.generation == generation {
+		store.users.add(userID, *fetched, time.Now().Add(store.ttl))
+	}
+	store.mutex.Unlock()
+
+	return fetched, result
+}
+
+func (store *CachedSessionStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	return cachedFetch(store, store.revokedTokens, jti, func() (bool, error) {
+		return store.backend.IsAccessTokenRevoked(jti)
+	})
+}
+
+// 取得した時の since で覚える (古い失効は期限切れのトークンにしか効かないので結果は変わらない)
+func (store *CachedSessionStore) GetUserRevokedAt(userID string, since int64) (int64, error) {
+	return cachedFetch(store, store.revokedAt, userID, func() (int64, error) {
+		return store.backend.GetUserRevokedAt(userID, since)
+	})
+}
+
+// 覚えていない時は fetch で取得して覚える (エラーの時は覚えない)
+func cachedFetch[V any](store *CachedSessionStore, cache *lruCache[V], key string, fetch func() (V, error)) (V, error) {
+	store.mutex.Lock()
+	value, ok := cache.get(key)
+	generation := store.generation
+	store.mutex.Unlock()
+
+	if ok {
+		return value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	store.mutex.Lock()
+	if store.generation == generation {
+		cache.add(key, value, time.Now().Add(store.ttl))
+	}
+	store.mutex.Unlock()
+
+	return value, nil
+}
+
+func (store *CachedSessionStore) InvalidateSessions(sessionIDs ...string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.generation++
+	for _, sessionID := range sessionIDs {
+		store.sessions.remove(sessionID)
+	}
+}
+
+func (store *CachedSessionStore) InvalidateUser(userID string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.generation++
+	store.users.remove(userID)
+	store.revokedAt.remove(userID)
+	store.sessions.removeFunc(func(session Session) bool {
+		return session.UserID == userID
+	})
+}
+
+func (store *CachedSessionStore) InvalidateAccessToken(jti string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.generation++
+	store.revokedTokens.remove(jti)
+}
+
+// 期限付きの LRU (排他は呼び出し側で行う)
+type lruCache[V any] struct {
+	size    int
+	order   *list.List // 先頭が最近使ったもの
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func newLruCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (cache *lruCache[V]) get(key string) (V, bool) {
+	var zero V
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return zero, false
+	}
+
+	// 期限切れの時は捨てる
+	entry := elem.Value.(*lruEntry[V])
+	if time.Now().After(entry.expiresAt) {
+		cache.order.Remove(elem)
+		delete(cache.entries, key)
+		return zero, false
+	}
+
+	cache.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (cache *lruCache[V]) add(key string, value V, expiresAt time.Time) {
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value = &lruEntry[V]{key: key, value: value, expiresAt: expiresAt}
+		cache.order.MoveToFront(elem)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+
+	// 溢れた時は一番使われていないものを捨てる
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (cache *lruCache[V]) remove(key string) {
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.Remove(elem)
+		delete(cache.entries, key)
+	}
+}
+
+func (cache *lruCache[V]) removeFunc(match func(V) bool) {
+	for key, elem := range cache.entries {
+		if match(elem.Value.(*lruEntry[V]).value) {
+			cache.order.Remove(elem)
+			delete(cache.entries, key)
+		}
+	}
+}
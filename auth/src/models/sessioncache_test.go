@@ -0,0 +1,279 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// テスト用の取得先 (取得した回数を数える)
+type fakeSessionStore struct {
+	sessions      map[string]Session
+	users         map[string]User
+	revokedTokens map[string]bool
+	revokedAt     map[string]int64
+
+	calls map[string]int
+
+	// 取得中に呼ぶ (取得と捨てるのが重なった時の確認)
+	onFetch func()
+}
+
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{
+		sessions:      map[string]Session{},
+		users:         map[string]User{},
+		revokedTokens: map[string]bool{},
+		revokedAt:     map[string]int64{},
+		calls:         map[string]int{},
+	}
+}
+
+func (store *fakeSessionStore) fetched(key string) {
+	store.calls[key]++
+	if store.onFetch != nil {
+		store.onFetch()
+	}
+}
+
+func (store *fakeSessionStore) GetSession(sessionID string) (*Session, error) {
+	store.fetched("session:" + sessionID)
+
+	session, ok := store.sessions[sessionID]
+	if !ok {
+		return &Session{}, gorm.ErrRecordNotFound
+	}
+
+	return &session, nil
+}
+
+func (store *fakeSessionStore) GetUser(userID string) (*User, GetResult) {
+	store.fetched("user:" + userID)
+
+	user, ok := store.users[userID]
+	if !ok {
+		return &User{}, GetResult{Error: gorm.ErrRecordNotFound, IsExists: false}
+	}
+
+	return &user, GetResult{Error: nil, IsExists: true}
+}
+
+func (store *fakeSessionStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	store.fetched("jti:" + jti)
+	return store.revokedTokens[jti], nil
+}
+
+func (store *fakeSessionStore) GetUserRevokedAt(userID string, since int64) (int64, error) {
+	store.fetched("revokedAt:" + userID)
+	return store.revokedAt[userID], nil
+}
+
+func (store *fakeSessionStore) InvalidateSessions(sessionIDs ...string) {}
+
+func (store *fakeSessionStore) InvalidateUser(userID string) {}
+
+func (store *fakeSessionStore) InvalidateAccessToken(jti string) {}
+
+// テスト用のデータを入れた取得先
+func newTestCachedStore(size int, ttl time.Duration) (*CachedSessionStore, *fakeSessionStore) {
+	backend := newFakeSessionStore()
+	backend.users["alice"] = User{UserID: "alice", Name: "Alice"}
+	backend.users["bob"] = User{UserID: "bob", Name: "Bob"}
+	backend.sessions["a1"] = Session{SessionID: "a1", UserID: "alice"}
+	backend.sessions["a2"] = Session{SessionID: "a2", UserID: "alice"}
+	backend.sessions["b1"] = Session{SessionID: "b1", UserID: "bob"}
+
+	return NewCachedSessionStore(backend, size, ttl), backend
+}
+
+func TestCachedSessionStoreCaches(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		session, err := store.GetSession("a1")
+		if err != nil || session.UserID != "alice" {
+			t.Fatalf("unexpected session: %+v (%v)", session, err)
+		}
+
+		// 返した値を書き換えても覚えている値は変わらない
+		session.UserID = "mallory"
+
+		user, result := store.GetUser("alice")
+		if result.Error != nil || !result.IsExists || user.Name != "Alice" {
+			t.Fatalf("unexpected user: %+v (%v)", user, result.Error)
+		}
+		user.Name = "Mallory"
+	}
+
+	if backend.calls["session:a1"] != 1 || backend.calls["user:alice"] != 1 {
+		t.Fatalf("expected one fetch each, got %v", backend.calls)
+	}
+
+	// 見つからない時は覚えない
+	for i := 0; i < 2; i++ {
+		if _, err := store.GetSession("missing"); err == nil {
+			t.Fatal("expected not found")
+		}
+		if _, result := store.GetUser("missing"); result.IsExists {
+			t.Fatal("expected not found")
+		}
+	}
+	if backend.calls["session:missing"] != 2 || backend.calls["user:missing"] != 2 {
+		t.Fatalf("not found must not be cached, got %v", backend.calls)
+	}
+}
+
+func TestCachedSessionStoreLRU(t *testing.T) {
+	store, backend := newTestCachedStore(2, time.Minute)
+
+	store.GetSession("a1")
+	store.GetSession("a2")
+
+	// a1 を使ったので a2 が一番古くなる
+	store.GetSession("a1")
+	store.GetSession("b1")
+
+	store.GetSession("a1")
+	store.GetSession("a2")
+
+	if backend.calls["session:a1"] != 1 {
+		t.Fatalf("recently used entry must stay cached, got %v", backend.calls)
+	}
+	if backend.calls["session:a2"] != 2 {
+		t.Fatalf("least recently used entry must be evicted, got %v", backend.calls)
+	}
+}
+
+func TestCachedSessionStoreTTL(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Millisecond*20)
+
+	store.GetSession("a1")
+	store.GetUser("alice")
+	store.IsAccessTokenRevoked("jti-1")
+
+	time.Sleep(time.Millisecond * 40)
+
+	store.GetSession("a1")
+	store.GetUser("alice")
+	store.IsAccessTokenRevoked("jti-1")
+
+	if backend.calls["session:a1"] != 2 || backend.calls["user:alice"] != 2 || backend.calls["jti:jti-1"] != 2 {
+		t.Fatalf("expired entries must be fetched again, got %v", backend.calls)
+	}
+}
+
+func TestCachedSessionStoreInvalidate(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Minute)
+
+	fill := func() {
+		for _, sessionID := range []string{"a1", "a2", "b1"} {
+			store.GetSession(sessionID)
+		}
+		store.GetUser("alice")
+		store.GetUser("bob")
+		store.GetUserRevokedAt("alice", 0)
+		store.GetUserRevokedAt("bob", 0)
+	}
+
+	fill()
+
+	// セッションだけ捨てる
+	store.InvalidateSessions("a1")
+	fill()
+
+	if backend.calls["session:a1"] != 2 || backend.calls["session:a2"] != 1 || backend.calls["user:alice"] != 1 {
+		t.Fatalf("only a1 must be fetched again, got %v", backend.calls)
+	}
+
+	// ユーザーとそのセッションと失効の日時を捨てる
+	backend.revokedAt["alice"] = 100
+	store.InvalidateUser("alice")
+	fill()
+
+	if backend.calls["session:a1"] != 3 || backend.calls["session:a2"] != 2 || backend.calls["user:alice"] != 2 || backend.calls["revokedAt:alice"] != 2 {
+		t.Fatalf("alice's entries must be fetched again, got %v", backend.calls)
+	}
+	if backend.calls["session:b1"] != 1 || backend.calls["user:bob"] != 1 || backend.calls["revokedAt:bob"] != 1 {
+		t.Fatalf("bob's entries must stay cached, got %v", backend.calls)
+	}
+	if revokedAt, _ := store.GetUserRevokedAt("alice", 0); revokedAt != 100 {
+		t.Fatalf("expected new revokedAt, got %d", revokedAt)
+	}
+}
+
+func TestCachedSessionStoreAccessTokenRevocation(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Minute)
+
+	// 失効していない結果も覚える
+	for i := 0; i < 2; i++ {
+		if revoked, err := store.IsAccessTokenRevoked("jti-1"); err != nil || revoked {
+			t.Fatalf("expected not revoked, got %v (%v)", revoked, err)
+		}
+	}
+	if backend.calls["jti:jti-1"] != 1 {
+		t.Fatalf("expected one fetch, got %v", backend.calls)
+	}
+
+	// 失効させた時は捨てる
+	backend.revokedTokens["jti-1"] = true
+	store.InvalidateAccessToken("jti-1")
+
+	if revoked, _ := store.IsAccessTokenRevoked("jti-1"); !revoked {
+		t.Fatal("expected revoked after invalidation")
+	}
+}
+
+func TestCachedSessionStoreInvalidateDuringFetch(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Minute)
+
+	// 取得している間に捨てられた時は古い値を覚えない
+	backend.onFetch = func() {
+		backend.onFetch = nil
+		store.InvalidateSessions("a1")
+	}
+	store.GetSession("a1")
+	store.GetSession("a1")
+
+	if backend.calls["session:a1"] != 2 {
+		t.Fatalf("value fetched before invalidation must not be cached, got %v", backend.calls)
+	}
+}
+
+func TestInvalidateRevocations(t *testing.T) {
+	store, backend := newTestCachedStore(10, time.Minute)
+
+	store.GetSession("a1")
+	store.GetSession("b1")
+	store.GetUser("alice")
+	store.GetUser("bob")
+	store.IsAccessTokenRevoked("jti-1")
+	store.IsAccessTokenRevoked("jti-2")
+
+	// 他のプロセスで記録された失効
+	InvalidateRevocations(store,
+		[]TokenRevocation{{UserID: "alice"}, {UserID: "bob", SessionID: "b1"}},
+		[]RevokedAccessToken{{JTI: "jti-1"}},
+	)
+
+	store.GetSession("a1")
+	store.GetSession("b1")
+	store.GetUser("alice")
+	store.GetUser("bob")
+	store.IsAccessTokenRevoked("jti-1")
+	store.IsAccessTokenRevoked("jti-2")
+
+	expected := map[string]int{
+		"session:a1": 2, // ユーザーの失効
+		"session:b1": 2, // セッションの失効
+		"user:alice": 2,
+		"user:bob":   1, // セッションの失効ではユーザーは捨てない
+		"jti:jti-1":  2,
+		"jti:jti-2":  1,
+	}
+	for key, count := range expected {
+		if backend.calls[key] != count {
+			t.Fatalf("%s: expected %d fetches, got %d", key, count, backend.calls[key])
+		}
+	}
+}
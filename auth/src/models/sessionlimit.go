@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 同時ログイン数の上限を超えた時の動作
+const (
+	SessionLimitReject      = "reject"       // 新しいログインを拒否する
+	SessionLimitEvictOldest = "evict_oldest" // 一番古いセッションを終了させる
+)
+
+// 同時ログイン数の上限 (ラベル名が空の時は全てのユーザー)
+type SessionLimit struct {
+	ID          uint   `gorm:"primarykey"`                    // ID
+	LabelName   string `gorm:"type:varchar(255);uniqueIndex"` // 対象のラベル
+	MaxSessions int    // 同時に持てるセッションの数
+	Policy      string `gorm:"type:varchar(32)"` // 上限を超えた時の動作
+	CreatedAt   int64  `gorm:"autoCreateTime"`   // 作成日
+	UpdatedAt   int64  `gorm:"autoUpdateTime"`   // 更新日
+}
+
+// 上限一覧を取得
+func GetSessionLimits() ([]SessionLimit, error) {
+	var limits []SessionLimit
+
+	// 取得する
+	err := dbconn.Order("label_name").Find(&limits).Error
+	return limits, err
+}
+
+// ラベルごとの上限を取得 (空のラベル名は全てのユーザー)
+func GetSessionLimitsFor(labelNames ...string) ([]SessionLimit, error) {
+	var limits []SessionLimit
+
+	// 取得する
+	err := dbconn.Where("label_name IN ?", labelNames).Find(&limits).Error
+	return limits, err
+}
+
+func GetSessionLimit(id uint) (*SessionLimit, GetResult) {
+	var limit SessionLimit
+
+	// 取得する
+	err := dbconn.Where(&SessionLimit{ID: id}).First(&limit).Error
+
+	return &limit, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+func CreateSessionLimit(limit *SessionLimit) error {
+	return dbconn.Create(limit).Error
+}
+
+func UpdateSessionLimit(limit *SessionLimit) error {
+	return dbconn.Save(limit).Error
+}
+
+func DeleteSessionLimit(id uint) error {
+	return dbconn.Delete(&SessionLimit{}, id).Error
+}
+
+// ラベル名の変更に追従する
+func RenameSessionLimitLabel(oldName string, newName string) error {
+	return dbconn.Model(&SessionLimit{}).Where(&SessionLimit{LabelName: oldName}).Update("label_name", newName).Error
+}
+
+// 削除したラベルの上限を削除する
+func DeleteLabelSessionLimit(labelName string) error {
+	return dbconn.Where(&SessionLimit{LabelName: labelName}).Delete(&SessionLimit{}).Error
+}
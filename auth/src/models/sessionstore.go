@@ -0,0 +1,72 @@
+package models
+
+import "sync"
+
+// 認証のたびに引くセッションとユーザーと失効の取得先
+// 書き込みはこれまで通りデータベースに行い、変更した時に Invalidate で捨てさせる
+type SessionStore interface {
+	GetSession(sessionID string) (*Session, error)
+	GetUser(userID string) (*User, GetResult)
+
+	// アクセストークンを失効させたか
+	IsAccessTokenRevoked(jti string) (bool, error)
+
+	// ユーザーの全てのトークンを最後に失効させた日時 (ない時は 0)
+	GetUserRevokedAt(userID string, since int64) (int64, error)
+
+	// セッションを捨てる
+	InvalidateSessions(sessionIDs ...string)
+
+	// ユーザーとそのユーザーのセッションと失効の日時を全て捨てる
+	InvalidateUser(userID string)
+
+	// アクセストークンの失効を捨てる
+	InvalidateAccessToken(jti string)
+}
+
+var (
+	// 既定はデータベースを直接引く
+	sessionStore      SessionStore = gormSessionStore{}
+	sessionStoreMutex sync.RWMutex
+)
+
+// 取得先を差し替える
+func SetSessionStore(store SessionStore) {
+	sessionStoreMutex.Lock()
+	defer sessionStoreMutex.Unlock()
+
+	sessionStore = store
+}
+
+// 今の取得先
+func Sessions() SessionStore {
+	sessionStoreMutex.RLock()
+	defer sessionStoreMutex.RUnlock()
+
+	return sessionStore
+}
+
+// データベースを直接引く取得先 (捨てるものはない)
+type gormSessionStore struct{}
+
+func (gormSessionStore) GetSession(sessionID string) (*Session, error) {
+	return GetSession(sessionID)
+}
+
+func (gormSessionStore) GetUser(userID string) (*User, GetResult) {
+	return GetUser(userID)
+}
+
+func (gormSessionStore) IsAccessTokenRevoked(jti string) (bool, error) {
+	return IsAccessTokenRevoked(jti)
+}
+
+func (gormSessionStore) GetUserRevokedAt(userID string, since int64) (int64, error) {
+	return GetUserRevokedAt(userID, since)
+}
+
+func (gormSessionStore) InvalidateSessions(sessionIDs ...string) {}
+
+func (gormSessionStore) InvalidateUser(userID string) {}
+
+func (gormSessionStore) InvalidateAccessToken(jti string) {}
@@ -0,0 +1,64 @@
+package models
+
+import "gorm.io/gorm"
+
+// JWT の署名鍵
+type SigningKey struct {
+	KeyID      string `gorm:"type:varchar(255);primaryKey"` // 鍵ID (JWK Thumbprint)
+	PrivateKey string `gorm:"type:text"`                    // Ed25519 秘密鍵 (PKCS#8 PEM, 暗号化している時は enc:v1: から始まる)
+	IsActive   int    `gorm:"default:0;index"`              // 署名に使う鍵か
+	RetiredAt  int64  `gorm:"default:0"`                    // 署名に使わなくなった日時 (0 は現役)
+	CreatedAt  int64  `gorm:"autoCreateTime"`               // 作成日
+}
+
+// 署名鍵一覧を取得 (新しい順)
+func GetSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+
+	// 取得する
+	err := dbconn.Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
+
+// 署名鍵を取得
+func GetSigningKey(keyID string) (*SigningKey, error) {
+	var key SigningKey
+
+	// 取得する
+	err := dbconn.Where(&SigningKey{KeyID: keyID}).First(&key).Error
+	return &key, err
+}
+
+// 署名鍵を登録する
+func CreateSigningKey(key *SigningKey) error {
+	return dbconn.Create(key).Error
+}
+
+// 秘密鍵を書き換える (暗号化し直す時)
+func UpdateSigningKeyPrivateKey(keyID string, privateKey string) error {
+	return dbconn.Model(&SigningKey{}).Where("key_id = ?", keyID).Update("private_key", privateKey).Error
+}
+
+// 現役の鍵を退役させて新しい鍵を登録する
+func RotateSigningKey(key *SigningKey, now int64) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		// 現役の鍵を退役させる
+		err := tx.Model(&SigningKey{}).
+			Where("is_active = 1").
+			Updates(map[string]interface{}{"is_active": 0, "retired_at": now}).Error
+
+		// エラー処理
+		if err != nil {
+			return err
+		}
+
+		// 新しい鍵を登録
+		key.IsActive = 1
+		return tx.Create(key).Error
+	})
+}
+
+// 退役してから時間が経った鍵を削除する
+func DeleteRetiredSigningKeys(before int64) error {
+	return dbconn.Where("is_active = 0 AND retired_at > 0 AND retired_at < ?", before).Delete(&SigningKey{}).Error
+}
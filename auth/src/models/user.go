@@ -7,21 +7,47 @@ import (
 )
 
 type User struct {
-	UserID       string       `gorm:"type:varchar(255);primaryKey"`                             // ユーザーID
-	Name         string       `gorm:"type:varchar(255)"`                                        // ユーザー名
-	Email        string       `gorm:"type:varchar(255);uniqueIndex:idx_users_email,length:255"` // メールアドレス
-	ProvCode     ProviderCode `gorm:"type:varchar(255);index:idx_prov_code,length:255"`         // 認証プロバイダコード
-	ProvUID      string       `gorm:"type:varchar(255);index:idx_prov_uid,length:255"`          // 認証プロバイダUID
-	PasswordHash string       `gorm:"default:''"`                                               // ハッシュ化されたパスワード
-	CreatedAt    int64        `gorm:"autoCreateTime"`                                           // ユーザー作成日
-	Sessions     []Session    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`            // ユーザーが持つセッション
-	IsBanned     int          `gorm:"default:0"`                                                // ユーザーの禁止状態
-	IsSystem     int          `gorm:"default:0"`                                                // システムユーザーかどうか
-	Labels       []Label      `gorm:"many2many:user_labels;constraint:OnDelete:CASCADE"`        // ユーザーのラベル
-	UpdatedAt    int64        `gorm:"autoUpdateTime"`                                           // ユーザー更新日
+	UserID          string               `gorm:"type:varchar(255);primaryKey"`                             // ユーザーID
+	Name            string               `gorm:"type:varchar(255)"`                                        // ユーザー名
+	Email           string               `gorm:"type:varchar(255);uniqueIndex:idx_users_email,length:255"` // メールアドレス
+	ProvCode        ProviderCode         `gorm:"type:varchar(255);index:idx_prov_code,length:255"`         // 登録時の認証プロバイダコード (ログインには Identities を使う)
+	ProvUID         string               `gorm:"type:varchar(255);index:idx_prov_uid,length:255"`          // 登録時の認証プロバイダUID
+	PasswordHash    string               `gorm:"default:''"`                                               // ハッシュ化されたパスワード
+	CreatedAt       int64                `gorm:"autoCreateTime"`                                           // ユーザー作成日
+	Sessions        []Session            `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`            // ユーザーが持つセッション
+	IsBanned        int                  `gorm:"default:0"`                                                // ユーザーの禁止状態
+	IsSystem        int                  `gorm:"default:0"`                                                // システムユーザーかどうか
+	Labels          []Label              `gorm:"many2many:user_labels;constraint:OnDelete:CASCADE"`        // ユーザーのラベル
+	Passkeys        []WebauthnCredential `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`            // ユーザーのパスキー
+	Identities      []Identity           `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`            // 連携している外部プロバイダのアカウント
+	UpdatedAt       int64                `gorm:"autoUpdateTime"`                                           // ユーザー更新日
+	EmailVerifiedAt int64                `gorm:"default:0"`                                                // メールアドレス確認日 (0 は未確認)
+	TotpSecret      string               `gorm:"default:''"`                                               // TOTP シークレット (base32)
+	TotpEnabled     int                  `gorm:"default:0"`                                                // TOTP が有効か
+	TotpLastStep    int64                `gorm:"default:0"`                                                // 最後に使用した TOTP ステップ (再利用防止)
+	MfaFailures     int                  `gorm:"default:0"`                                                // 二要素認証の連続失敗回数
+	MfaLockedUntil  int64                `gorm:"default:0"`                                                // 二要素認証をロックする期限
 }
 
 func CreateUser(user *User, ProviderCode ProviderCode) error {
+	return createUser(dbconn, user, ProviderCode)
+}
+
+// ユーザーと連携を作成する (どちらかに失敗した時はどちらも作成しない)
+func CreateUserWithIdentity(user *User, providerCode ProviderCode, identity *Identity) error {
+	return dbconn.Transaction(func(tx *gorm.DB) error {
+		// ユーザーを作成する
+		if err := createUser(tx, user, providerCode); err != nil {
+			return err
+		}
+
+		// 連携を作成する
+		identity.UserID = user.UserID
+		return tx.Create(identity).Error
+	})
+}
+
+func createUser(tx *gorm.DB, user *User, ProviderCode ProviderCode) error {
 	// プロバイダを取得する
 	provider, err := GetProvider(ProviderCode)
 
@@ -34,7 +60,7 @@ func CreateUser(user *User, ProviderCode ProviderCode) error {
 	user.ProvCode = ProviderCode
 
 	// ユーザを作成する
-	err = dbconn.Create(user).Error
+	err = tx.Create(user).Error
 
 	// エラー処理
 	if err != nil {
@@ -42,7 +68,7 @@ func CreateUser(user *User, ProviderCode ProviderCode) error {
 	}
 
 	// プロバイダにユーザーを追加する
-	err = dbconn.Model(provider).Association("Users").Append(user)
+	err = tx.Model(provider).Association("Users").Append(user)
 
 	// エラー処理
 	if err != nil {
@@ -96,7 +122,10 @@ func GetAllUsers() ([]User, error) {
 // ユーザーを更新する
 func UpdateUser(user *User) error {
 	// 更新する
-	return dbconn.Save(user).Error
+	err := dbconn.Save(user).Error
+	Sessions().InvalidateUser(user.UserID)
+
+	return err
 }
 
 // ユーザーを削除する
@@ -109,7 +138,10 @@ func DeleteUser(userid string) error {
 		return result.Error
 	}
 
-	return dbconn.Unscoped().Delete(user).Error
+	err := dbconn.Unscoped().Delete(user).Error
+	Sessions().InvalidateUser(userid)
+
+	return err
 }
 
 //ユーザーを検索する
@@ -123,4 +155,52 @@ func SearchUserByEmail(email string) ([]User, error) {
 	var users []User
 	err := dbconn.Where("email LIKE ?", "%"+email+"%").Find(&users).Error
 	return users, err
-}
\ No newline at end of file
+}
+
+// TOTP のステップを使用済みにする (既に同じか新しいステップが使われている時は false)
+func UseTotpStep(userID string, step int64) (bool, error) {
+	// 古いステップの時だけ更新する
+	result := dbconn.Model(&User{}).
+		Where("user_id = ? AND totp_last_step < ?", userID, step).
+		Updates(map[string]interface{}{
+			"totp_last_step": step,
+			"mfa_failures":   0,
+		})
+	Sessions().InvalidateUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// 二要素認証の失敗回数を増やして現在の回数を返す
+func IncrementMfaFailures(userID string) (int, error) {
+	// 増やす
+	err := dbconn.Model(&User{}).
+		Where("user_id = ?", userID).
+		Update("mfa_failures", gorm.Expr("mfa_failures + 1")).Error
+	Sessions().InvalidateUser(userID)
+
+	// エラー処理
+	if err != nil {
+		return 0, err
+	}
+
+	// 取得する
+	user, result := GetUser(userID)
+	return user.MfaFailures, result.Error
+}
+
+// 二要素認証をロックする (失敗回数は戻す)
+func LockMfa(userID string, until int64) error {
+	err := dbconn.Model(&User{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"mfa_failures":     0,
+		"mfa_locked_until": until,
+	}).Error
+	Sessions().InvalidateUser(userID)
+
+	return err
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type WebauthnCredential struct {
+	CredentialID string `gorm:"type:varchar(255);primaryKey"` // クレデンシャルID (base64url)
+	UserID       string `gorm:"type:varchar(255);index"`      // ユーザーID
+	Name         string `gorm:"type:varchar(255)"`            // 表示名
+	Data         string `gorm:"type:text"`                    // webauthn.Credential の JSON
+	CreatedAt    int64  `gorm:"autoCreateTime"`               // 登録日
+	LastUsedAt   int64  `gorm:"default:0"`                    // 最終使用日
+}
+
+func CreateWebauthnCredential(credential *WebauthnCredential) error {
+	return dbconn.Create(credential).Error
+}
+
+// クレデンシャルを取得
+func GetWebauthnCredential(credentialID string) (*WebauthnCredential, GetResult) {
+	var credential WebauthnCredential
+
+	// 取得する
+	err := dbconn.Where(&WebauthnCredential{CredentialID: credentialID}).First(&credential).Error
+
+	return &credential, GetResult{
+		Error:    err,
+		IsExists: !errors.Is(err, gorm.ErrRecordNotFound),
+	}
+}
+
+// ユーザーのクレデンシャル一覧を取得
+func (usr *User) GetWebauthnCredentials() ([]WebauthnCredential, error) {
+	var credentials []WebauthnCredential
+
+	// 取得する
+	err := dbconn.Where(&WebauthnCredential{UserID: usr.UserID}).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+func UpdateWebauthnCredential(credential *WebauthnCredential) error {
+	return dbconn.Save(credential).Error
+}
+
+// ユーザーのクレデンシャルを削除 (削除した時は true)
+func (usr *User) DeleteWebauthnCredential(credentialID string) (bool, error) {
+	// 削除する
+	result := dbconn.Where(&WebauthnCredential{UserID: usr.UserID, CredentialID: credentialID}).Delete(&WebauthnCredential{})
+	return result.RowsAffected > 0, result.Error
+}
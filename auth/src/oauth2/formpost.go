@@ -0,0 +1,86 @@
+package oauth2
+
+import (
+	"auth/utils"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ここから response_mode=form_post の一時保存
+// クロスサイトの POST では SameSite=Lax のクッキーが送られないので、応答を保存してハンドルだけを GET のコールバックに渡す
+// (認可コードを URL に載せないため)
+
+const (
+	// 保存しておく時間
+	formPostExpiry = time.Minute
+)
+
+type formPost struct {
+	providerName string
+	values       url.Values
+	expires      time.Time
+}
+
+type formPostStore struct {
+	mutex sync.Mutex
+	posts map[string]formPost
+}
+
+var formPosts = &formPostStore{posts: map[string]formPost{}}
+
+// 保存してハンドルを返す
+func (store *formPostStore) Put(providerName string, values url.Values) string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+
+	// 期限切れを削除する
+	for handle, value := range store.posts {
+		if value.expires.Before(now) {
+			delete(store.posts, handle)
+		}
+	}
+
+	handle := utils.GenID()
+	store.posts[handle] = formPost{
+		providerName: providerName,
+		values:       values,
+		expires:      now.Add(formPostExpiry),
+	}
+
+	return handle
+}
+
+// 取り出す (一度しか取り出せない)
+func (store *formPostStore) Take(handle string, providerName string) (url.Values, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	value, ok := store.posts[handle]
+	if !ok {
+		return nil, false
+	}
+
+	delete(store.posts, handle)
+
+	// 期限切れ、プロバイダ違いの時
+	if value.expires.Before(time.Now()) || value.providerName != providerName {
+		return nil, false
+	}
+
+	return value.values, true
+}
+
+// form_post の応答を保存してハンドルを返す
+func SaveFormPost(providerName string, values url.Values) string {
+	return formPosts.Put(providerName, values)
+}
+
+// 保存した form_post の応答を取り出す
+func TakeFormPost(handle string, providerName string) (url.Values, bool) {
+	return formPosts.Take(handle, providerName)
+}
+
+// ここまで
@@ -0,0 +1,47 @@
+package oauth2
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFormPostStore(t *testing.T) {
+	store := &formPostStore{posts: map[string]formPost{}}
+	values := url.Values{"code": {"abc"}, "state": {"xyz"}}
+
+	// 取り出せる
+	handle := store.Put("apple", values)
+	got, ok := store.Take(handle, "apple")
+	if !ok || got.Get("code") != "abc" || got.Get("state") != "xyz" {
+		t.Fatalf("Take() = %v, %v", got, ok)
+	}
+
+	// 二度目は取り出せない
+	if _, ok := store.Take(handle, "apple"); ok {
+		t.Fatal("handle was accepted twice")
+	}
+
+	// 知らないハンドル
+	if _, ok := store.Take("unknown", "apple"); ok {
+		t.Fatal("unknown handle was accepted")
+	}
+
+	// プロバイダ違い (使えなくなる)
+	handle = store.Put("apple", values)
+	if _, ok := store.Take(handle, "google"); ok {
+		t.Fatal("handle was accepted for another provider")
+	}
+	if _, ok := store.Take(handle, "apple"); ok {
+		t.Fatal("handle was accepted after a provider mismatch")
+	}
+
+	// 期限切れ
+	handle = store.Put("apple", values)
+	expired := store.posts[handle]
+	expired.expires = time.Now().Add(-time.Second)
+	store.posts[handle] = expired
+	if _, ok := store.Take(handle, "apple"); ok {
+		t.Fatal("expired handle was accepted")
+	}
+}
@@ -2,7 +2,6 @@ package oauth2
 
 import (
 	"auth/logger"
-	"auth/models"
 	"auth/utils"
 	"context"
 	"encoding/base64"
@@ -11,22 +10,20 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/discord"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/google"
-	"github.com/markbates/goth/providers/microsoftonline"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
 func InitGothic() {
-
+	// 組み込みのプロバイダを登録する
+	registerProviders()
 }
 
 type OauthArgs struct {
 	ProviderName string // プロバイダー名
 	IsMobile     bool   // モバイルかどうか
 	IsPopup      bool   // パップアップかどうか
+	LinkTicket   string // アカウント連携チケット (連携時のみ)
 }
 
 // 認証を開始するメソッド
@@ -59,8 +56,6 @@ func StartOauth(ctx echo.Context, args OauthArgs) error {
 	// base64 エンコード
 	paylaod := base64.StdEncoding.EncodeToString(argsbin)
 
-	logger.Println(paylaod)
-
 	// クッキー
 	ctx.SetCookie(&http.Cookie{
 		Name:     "goth",
@@ -82,6 +77,7 @@ type OauthResponse struct {
 	User goth.User
 	IsMobile bool
 	IsPopup bool
+	LinkTicket string
 }
 
 func CallbackOauth(ctx echo.Context, providerName string) (OauthResponse, error) {
@@ -123,7 +119,7 @@ func CallbackOauth(ctx echo.Context, providerName string) (OauthResponse, error)
 		return OauthResponse{}, err
 	}
 
-	return OauthResponse{User: user, IsMobile: args.IsMobile, IsPopup: args.IsPopup}, nil
+	return OauthResponse{User: user, IsMobile: args.IsMobile, IsPopup: args.IsPopup, LinkTicket: args.LinkTicket}, nil
 }
 
 // コンテキストを設定
@@ -134,69 +130,15 @@ func contextWithProviderName(ctx echo.Context, providerName string) *http.Reques
 func UseProviders() {
 	logger.Println("プロバイダを更新する")
 
-	// 認証プロバイダー
-	providers := []goth.Provider{}
-
-	// モデルから取得
-	// Google
-	gprovider, err := models.GetProvider(models.Google)
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
-
-	// エラー処理 と 有効かどうか
-	if err == nil && gprovider.IsEnabled == 1 {
-		// 認証プロバイダーに追加
-		providers = append(providers, google.New(gprovider.ClientID, gprovider.ClientSecret, gprovider.CallbackURL,"profile","email"))
-	}	
-
-	// github
-	githubProvider, err := models.GetProvider(models.Github)
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
-
-	// エラー処理 と 有効かどうか
-	if err == nil && githubProvider.IsEnabled == 1 {
-		// 認証プロバイダーに追加
-		providers = append(providers, github.New(githubProvider.ClientID, githubProvider.ClientSecret, githubProvider.CallbackURL,"read:user","user:email"))
-	}
-
-	// microsoft
-	microsoftProvider, err := models.GetProvider(models.Microsoft)
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
-
-	// エラー処理 と 有効かどうか
-	if err == nil && microsoftProvider.IsEnabled == 1 {
-		// 認証プロバイダーに追加
-		providers = append(providers, microsoftonline.New(microsoftProvider.ClientID, microsoftProvider.ClientSecret, microsoftProvider.CallbackURL,"openid","email","profile","offline_access"))
-	}
-
-	// discord
-	discordProvider, err := models.GetProvider(models.Discord)
-
-	// エラー処理
-	if err != nil {
-		logger.PrintErr(err)
-	}
+	// 組み込みのプロバイダ
+	providers := registryProviders()
 
-	// エラー処理 と 有効かどうか
-	if err == nil && discordProvider.IsEnabled == 1 {
-		// 認証プロバイダーに追加
-		providers = append(providers, discord.New(discordProvider.ClientID, discordProvider.ClientSecret, discordProvider.CallbackURL,"email","identify"))
-	}
+	// ダッシュボードから登録された OIDC プロバイダ
+	providers = append(providers, oidcProviders()...)
 
 	// プロバイダをリセット
 	goth.ClearProviders()
 
-	// 認証プロバイダーを設定
+	// 認証プロバイダーを設定
 	goth.UseProviders(providers...)
 }
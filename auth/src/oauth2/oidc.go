@@ -0,0 +1,264 @@
+package oauth2
+
+import (
+	"auth/logger"
+	"auth/models"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// デフォルトで要求するスコープ
+var DefaultOidcScopes = []string{"openid", "profile", "email"}
+
+// クレームの割り当て (空の項目はデフォルトのクレームを使う)
+type ClaimMapping struct {
+	UserID        []string `json:"userId,omitempty"`        // ユーザーID (デフォルト: sub)
+	Name          []string `json:"name,omitempty"`          // 名前 (デフォルト: name)
+	NickName      []string `json:"nickName,omitempty"`      // ニックネーム (デフォルト: nickname, preferred_username)
+	Email         []string `json:"email,omitempty"`         // メールアドレス (デフォルト: email)
+	EmailVerified []string `json:"emailVerified,omitempty"` // メールアドレス確認済み (デフォルト: email_verified)
+	AvatarURL     []string `json:"avatarUrl,omitempty"`     // アバターURL (デフォルト: picture)
+	FirstName     []string `json:"firstName,omitempty"`     // 名 (デフォルト: given_name)
+	LastName      []string `json:"lastName,omitempty"`      // 姓 (デフォルト: family_name)
+}
+
+// クレームの割り当てを読み込む
+func ParseClaimMapping(raw string) (ClaimMapping, error) {
+	mapping := ClaimMapping{}
+
+	// 空の時
+	if strings.TrimSpace(raw) == "" {
+		return mapping, nil
+	}
+
+	err := json.Unmarshal([]byte(raw), &mapping)
+	return mapping, err
+}
+
+// 要求するスコープを分割する
+func ParseScopes(raw string) []string {
+	scopes := strings.Fields(raw)
+
+	// 空の時
+	if len(scopes) == 0 {
+		return DefaultOidcScopes
+	}
+
+	return scopes
+}
+
+// discovery の結果をキャッシュする (設定が変わるまで再取得しない)
+type oidcCacheEntry struct {
+	fingerprint string
+	provider    *openidConnect.Provider
+}
+
+var (
+	oidcCacheMutex sync.Mutex
+	oidcCache      = map[models.ProviderCode]oidcCacheEntry{}
+)
+
+// 設定の指紋
+func oidcFingerprint(provider models.Provider) string {
+	return strings.Join([]string{
+		provider.IssuerURL,
+		provider.ClientID,
+		provider.ClientSecret,
+		provider.CallbackURL,
+		provider.Scopes,
+		provider.ClaimMapping,
+	}, "\x00")
+}
+
+// discovery の URL
+func DiscoveryURL(issuerURL string) string {
+	return strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+}
+
+// OIDC プロバイダを生成する
+func NewOidcProvider(provider models.Provider) (*openidConnect.Provider, error) {
+	// クレームの割り当てを読み込む
+	mapping, err := ParseClaimMapping(provider.ClaimMapping)
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	// discovery を行う
+	oidcProvider, err := openidConnect.NewNamed(
+		string(provider.ProviderCode),
+		provider.ClientID,
+		provider.ClientSecret,
+		provider.CallbackURL,
+		DiscoveryURL(provider.IssuerURL),
+		ParseScopes(provider.Scopes)...,
+	)
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	// goth に登録する名前をプロバイダコードにする
+	oidcProvider.SetName(string(provider.ProviderCode))
+
+	// クレームの割り当てを設定
+	if len(mapping.UserID) > 0 {
+		oidcProvider.UserIdClaims = mapping.UserID
+	}
+	if len(mapping.Name) > 0 {
+		oidcProvider.NameClaims = mapping.Name
+	}
+	if len(mapping.NickName) > 0 {
+		oidcProvider.NickNameClaims = mapping.NickName
+	}
+	if len(mapping.Email) > 0 {
+		oidcProvider.EmailClaims = mapping.Email
+	}
+	if len(mapping.AvatarURL) > 0 {
+		oidcProvider.AvatarURLClaims = mapping.AvatarURL
+	}
+	if len(mapping.FirstName) > 0 {
+		oidcProvider.FirstNameClaims = mapping.FirstName
+	}
+	if len(mapping.LastName) > 0 {
+		oidcProvider.LastNameClaims = mapping.LastName
+	}
+
+	return oidcProvider, nil
+}
+
+// キャッシュから OIDC プロバイダを取得する
+func cachedOidcProvider(provider models.Provider) (*openidConnect.Provider, error) {
+	oidcCacheMutex.Lock()
+	defer oidcCacheMutex.Unlock()
+
+	// 設定が変わっていない時
+	fingerprint := oidcFingerprint(provider)
+	if entry, ok := oidcCache[provider.ProviderCode]; ok && entry.fingerprint == fingerprint {
+		return entry.provider, nil
+	}
+
+	// 生成する
+	oidcProvider, err := NewOidcProvider(provider)
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	// キャッシュする
+	oidcCache[provider.ProviderCode] = oidcCacheEntry{
+		fingerprint: fingerprint,
+		provider:    oidcProvider,
+	}
+
+	return oidcProvider, nil
+}
+
+// キャッシュを削除する
+func ForgetOidcProvider(providerCode models.ProviderCode) {
+	oidcCacheMutex.Lock()
+	defer oidcCacheMutex.Unlock()
+
+	delete(oidcCache, providerCode)
+}
+
+// 有効な OIDC プロバイダ一覧を生成する
+func oidcProviders() []goth.Provider {
+	providers := []goth.Provider{}
+
+	// モデルから取得
+	oidcModels, err := models.GetOidcProviders()
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return providers
+	}
+
+	for _, provider := range oidcModels {
+		// 無効な時
+		if provider.IsEnabled != 1 {
+			continue
+		}
+
+		// 生成する
+		oidcProvider, err := cachedOidcProvider(provider)
+
+		// エラー処理 (他のプロバイダは使えるようにする)
+		if err != nil {
+			logger.PrintErr(err)
+			continue
+		}
+
+		providers = append(providers, oidcProvider)
+	}
+
+	return providers
+}
+
+// メールアドレスがプロバイダで確認済みかどうか
+func IsEmailVerified(providerCode string, user goth.User) bool {
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.ProviderCode(providerCode))
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return false
+	}
+
+	return emailVerifiedFor(*provider, user)
+}
+
+// プロバイダの種類ごとに確認済みかを判定する (分からない時は未確認)
+func emailVerifiedFor(provider models.Provider, user goth.User) bool {
+	switch provider.Kind {
+	case models.KindOidc:
+		// クレームの割り当てを読み込む
+		mapping, err := ParseClaimMapping(provider.ClaimMapping)
+
+		// エラー処理
+		if err != nil {
+			logger.PrintErr(err)
+			return false
+		}
+
+		claims := mapping.EmailVerified
+		if len(claims) == 0 {
+			claims = []string{"email_verified"}
+		}
+
+		return rawDataIsTrue(user.RawData, claims)
+	case models.KindBuiltin:
+		// 確認済みを返すと定義したプロバイダのみ
+		spec, ok := LookupProvider(provider.ProviderCode)
+		if !ok {
+			return false
+		}
+
+		return rawDataIsTrue(user.RawData, spec.EmailVerified)
+	}
+
+	return false
+}
+
+// RawData の値が true か (先頭から見つかったものを使う)
+func rawDataIsTrue(raw map[string]interface{}, keys []string) bool {
+	for _, key := range keys {
+		switch value := raw[key].(type) {
+		case bool:
+			return value
+		case string:
+			return value == "true"
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package oauth2
+
+import (
+	"auth/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/markbates/goth"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testAuthCode     = "test-code"
+)
+
+// テスト用の OpenID Connect 発行者
+func newFakeIssuer(t *testing.T, idClaims jwt.MapClaims, userInfo map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	writeJSON := func(writer http.ResponseWriter, value interface{}) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(value)
+	}
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil || request.PostForm.Get("code") != testAuthCode {
+			writer.WriteHeader(http.StatusBadRequest)
+			writeJSON(writer, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		// goth は署名を検証しない (トークンエンドポイントから直接受け取るため)
+		claims := jwt.MapClaims{
+			"iss": server.URL,
+			"aud": testClientID,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for key, value := range idClaims {
+			claims[key] = value
+		}
+
+		idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("issuer-key"))
+		if err != nil {
+			t.Error(err)
+		}
+
+		writeJSON(writer, map[string]interface{}{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+
+	mux.HandleFunc("/userinfo", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer test-access-token" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeJSON(writer, userInfo)
+	})
+
+	return server
+}
+
+// テスト用のプロバイダ設定
+func fakeIssuerProvider(issuerURL string, claimMapping string) models.Provider {
+	return models.Provider{
+		ProviderCode: "test-oidc",
+		Kind:         models.KindOidc,
+		IssuerURL:    issuerURL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		CallbackURL:  "http://localhost/callback",
+		ClaimMapping: claimMapping,
+	}
+}
+
+// ログインを最後まで進める
+func loginWithFakeIssuer(t *testing.T, provider goth.Provider, code string) (goth.User, error) {
+	t.Helper()
+
+	session, err := provider.BeginAuth("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Authorize(provider, url.Values{"code": {code}}); err != nil {
+		return goth.User{}, err
+	}
+
+	return provider.FetchUser(session)
+}
+
+func TestOidcDiscovery(t *testing.T) {
+	server := newFakeIssuer(t, jwt.MapClaims{}, map[string]interface{}{})
+
+	oidcProvider, err := NewOidcProvider(fakeIssuerProvider(server.URL+"/", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oidcProvider.Name() != "test-oidc" {
+		t.Fatalf("unexpected name: %s", oidcProvider.Name())
+	}
+	if oidcProvider.OpenIDConfig.TokenEndpoint != server.URL+"/token" {
+		t.Fatalf("unexpected token endpoint: %s", oidcProvider.OpenIDConfig.TokenEndpoint)
+	}
+
+	// 認可 URL に client_id とスコープが入る
+	session, err := oidcProvider.BeginAuth("state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Query().Get("client_id") != testClientID || parsed.Query().Get("scope") != "openid profile email" {
+		t.Fatalf("unexpected auth url: %s", authURL)
+	}
+}
+
+func TestOidcDiscoveryFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := NewOidcProvider(fakeIssuerProvider(server.URL, "")); err == nil {
+		t.Fatal("expected discovery error")
+	}
+}
+
+func TestOidcLogin(t *testing.T) {
+	server := newFakeIssuer(t,
+		jwt.MapClaims{"sub": "user-1", "mail": "alice@example.com", "mail_verified": true},
+		map[string]interface{}{"sub": "user-1", "name": "Alice", "picture": "https://example.com/alice.png"},
+	)
+
+	provider := fakeIssuerProvider(server.URL, `{"email":["mail"],"emailVerified":["mail_verified"]}`)
+	oidcProvider, err := NewOidcProvider(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := loginWithFakeIssuer(t, oidcProvider, testAuthCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.UserID != "user-1" || user.Email != "alice@example.com" || user.Name != "Alice" || user.AvatarURL != "https://example.com/alice.png" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+
+	// 割り当てたクレームで確認済みを判定する
+	if !emailVerifiedFor(provider, user) {
+		t.Fatal("email should be verified through the mapped claim")
+	}
+
+	// 割り当てがない時は email_verified を見る (ないので未確認)
+	if emailVerifiedFor(fakeIssuerProvider(server.URL, ""), user) {
+		t.Fatal("email must not be verified without email_verified")
+	}
+
+	// 間違ったコードの時
+	if _, err := loginWithFakeIssuer(t, oidcProvider, "wrong-code"); err == nil {
+		t.Fatal("expected token exchange error")
+	}
+}
+
+func TestEmailVerifiedDefaultsToFalse(t *testing.T) {
+	user := goth.User{RawData: map[string]interface{}{"email_verified": true, "verified": true}}
+
+	// OIDC 以外は確認済みとして扱わない
+	for _, kind := range []models.ProviderKind{models.KindSaml, "unknown"} {
+		if emailVerifiedFor(models.Provider{ProviderCode: "x", Kind: kind}, user) {
+			t.Fatalf("kind %q must default to unverified", kind)
+		}
+	}
+
+	// 文字列の "true" も確認済み
+	oidc := models.Provider{Kind: models.KindOidc}
+	if !emailVerifiedFor(oidc, goth.User{RawData: map[string]interface{}{"email_verified": "true"}}) {
+		t.Fatal(`"true" should be treated as verified`)
+	}
+	if emailVerifiedFor(oidc, goth.User{RawData: map[string]interface{}{"email_verified": false}}) {
+		t.Fatal("false must be unverified")
+	}
+}
@@ -0,0 +1,297 @@
+package oauth2
+
+import (
+	"auth/logger"
+	"auth/models"
+	"os"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/amazon"
+	"github.com/markbates/goth/providers/apple"
+	"github.com/markbates/goth/providers/bitbucket"
+	"github.com/markbates/goth/providers/discord"
+	"github.com/markbates/goth/providers/facebook"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/line"
+	"github.com/markbates/goth/providers/microsoftonline"
+	"github.com/markbates/goth/providers/slack"
+	"github.com/markbates/goth/providers/twitch"
+)
+
+// goth.User から値を取り出す
+type UserField func(user goth.User) string
+
+var (
+	FieldName      UserField = func(user goth.User) string { return user.Name }
+	FieldNickName  UserField = func(user goth.User) string { return user.NickName }
+	FieldEmail     UserField = func(user goth.User) string { return user.Email }
+	FieldAvatarURL UserField = func(user goth.User) string { return user.AvatarURL }
+
+	// 名と姓をつなげる
+	FieldFullName UserField = func(user goth.User) string {
+		result := user.FirstName
+
+		if user.LastName != "" {
+			if result != "" {
+				result += " "
+			}
+
+			result += user.LastName
+		}
+
+		return result
+	}
+)
+
+// 登録されていない時の割り当て
+var (
+	defaultNameFields   = []UserField{FieldName, FieldNickName, FieldFullName}
+	defaultEmailFields  = []UserField{FieldEmail}
+	defaultAvatarFields = []UserField{FieldAvatarURL}
+)
+
+// プロバイダの定義
+type ProviderSpec struct {
+	Code          models.ProviderCode                                                        // プロバイダコード (URL に使う)
+	Name          string                                                                     // 表示名
+	EnvPrefix     string                                                                     // 初期値を読む環境変数の接頭辞 (<EnvPrefix>ClientID など)
+	DefaultScopes []string                                                                   // 設定が空の時に要求するスコープ
+	New           func(clientID, secret, callbackURL string, scopes ...string) goth.Provider // プロバイダを生成する
+	NameFields    []UserField                                                                // 名前に使う項目 (先頭から空でないものを使う)
+	EmailFields   []UserField                                                                // メールアドレスに使う項目
+	AvatarFields  []UserField                                                                // アバターURLに使う項目
+	EmailVerified []string                                                                   // メールアドレス確認済みを表す RawData のキー (空のプロバイダは未確認として扱う)
+}
+
+// 組み込みのプロバイダ一覧 (ここに追加すると /api/providers/oauth で有効化できる)
+var registry = []ProviderSpec{
+	{
+		Code:          models.Google,
+		Name:          "Google",
+		EnvPrefix:     "Google",
+		DefaultScopes: []string{"profile", "email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return google.New(clientID, secret, callbackURL, scopes...)
+		},
+		// userinfo v2 の項目
+		EmailVerified: []string{"verified_email", "email_verified"},
+	},
+	{
+		Code:          models.Github,
+		Name:          "GitHub",
+		EnvPrefix:     "Github",
+		DefaultScopes: []string{"read:user", "user:email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return github.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Discord,
+		Name:          "Discord",
+		EnvPrefix:     "Discord",
+		DefaultScopes: []string{"email", "identify"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return discord.New(clientID, secret, callbackURL, scopes...)
+		},
+		// Discord はメールアドレスを確認したかを verified で返す
+		EmailVerified: []string{"verified"},
+	},
+	{
+		Code:          models.Microsoft,
+		Name:          "Microsoft",
+		EnvPrefix:     "Microsoft",
+		DefaultScopes: []string{"openid", "email", "profile", "offline_access"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return microsoftonline.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Gitlab,
+		Name:          "GitLab",
+		EnvPrefix:     "Gitlab",
+		DefaultScopes: []string{"read_user"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return gitlab.New(clientID, secret, callbackURL, scopes...)
+		},
+		NameFields: []UserField{FieldName, FieldNickName},
+	},
+	{
+		Code:          models.Slack,
+		Name:          "Slack",
+		EnvPrefix:     "Slack",
+		DefaultScopes: []string{"users:read", "users:read.email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return slack.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Twitch,
+		Name:          "Twitch",
+		EnvPrefix:     "Twitch",
+		DefaultScopes: []string{"user:read:email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return twitch.New(clientID, secret, callbackURL, scopes...)
+		},
+		// Twitch は表示名が NickName に入る
+		NameFields: []UserField{FieldNickName, FieldName},
+	},
+	{
+		Code:          models.Line,
+		Name:          "LINE",
+		EnvPrefix:     "Line",
+		DefaultScopes: []string{"openid", "profile", "email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return line.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Apple,
+		Name:          "Apple",
+		EnvPrefix:     "Apple",
+		DefaultScopes: []string{apple.ScopeName, apple.ScopeEmail},
+		// ClientSecret には apple.MakeSecret で生成した JWT を設定する
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return apple.New(clientID, secret, callbackURL, nil, scopes...)
+		},
+		// Apple は名前を初回しか返さないのでメールアドレスで補う
+		NameFields: []UserField{FieldName, FieldFullName, FieldEmail},
+	},
+	{
+		Code:          models.Facebook,
+		Name:          "Facebook",
+		EnvPrefix:     "Facebook",
+		DefaultScopes: []string{"email", "public_profile"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return facebook.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Bitbucket,
+		Name:          "Bitbucket",
+		EnvPrefix:     "Bitbucket",
+		DefaultScopes: []string{"account", "email"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return bitbucket.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+	{
+		Code:          models.Amazon,
+		Name:          "Amazon",
+		EnvPrefix:     "Amazon",
+		DefaultScopes: []string{"profile"},
+		New: func(clientID, secret, callbackURL string, scopes ...string) goth.Provider {
+			return amazon.New(clientID, secret, callbackURL, scopes...)
+		},
+	},
+}
+
+// 組み込みのプロバイダ一覧を取得
+func Registry() []ProviderSpec {
+	return registry
+}
+
+// プロバイダの定義を取得
+func LookupProvider(code models.ProviderCode) (ProviderSpec, bool) {
+	for _, spec := range registry {
+		if spec.Code == code {
+			return spec, true
+		}
+	}
+
+	return ProviderSpec{}, false
+}
+
+// 組み込みのプロバイダをデータベースに登録する
+func registerProviders() {
+	for _, spec := range registry {
+		// 既に存在する時 (管理画面の設定を優先する)
+		_, err := models.GetProvider(spec.Code)
+		if err == nil {
+			continue
+		}
+
+		// 作成する
+		err = models.CreateProvider(&models.Provider{
+			ProviderName: spec.Name,
+			ClientID:     os.Getenv(spec.EnvPrefix + "ClientID"),
+			ClientSecret: os.Getenv(spec.EnvPrefix + "ClientSecret"),
+			CallbackURL:  os.Getenv(spec.EnvPrefix + "Callback"),
+			ProviderCode: spec.Code,
+			IsEnabled:    0,
+			Users:        []models.User{},
+		})
+
+		// エラー処理
+		if err != nil {
+			logger.PrintErr(err)
+		}
+	}
+}
+
+// 有効な組み込みのプロバイダ一覧を生成する
+func registryProviders() []goth.Provider {
+	providers := []goth.Provider{}
+
+	for _, spec := range registry {
+		// モデルから取得
+		provider, err := models.GetProvider(spec.Code)
+
+		// エラー処理
+		if err != nil {
+			logger.PrintErr(err)
+			continue
+		}
+
+		// 無効な時
+		if provider.IsEnabled != 1 {
+			continue
+		}
+
+		// スコープ (設定が空の時はデフォルト)
+		scopes := spec.DefaultScopes
+		if provider.Scopes != "" {
+			scopes = ParseScopes(provider.Scopes)
+		}
+
+		// 認証プロバイダーに追加
+		providers = append(providers, spec.New(provider.ClientID, provider.ClientSecret, provider.CallbackURL, scopes...))
+	}
+
+	return providers
+}
+
+// プロバイダから取得したプロフィール
+type Profile struct {
+	Name      string // 名前
+	Email     string // メールアドレス
+	AvatarURL string // アバターURL
+}
+
+// 先頭から空でない値を返す
+func pickField(user goth.User, fields []UserField, defaults []UserField) string {
+	if len(fields) == 0 {
+		fields = defaults
+	}
+
+	for _, field := range fields {
+		if value := field(user); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// goth.User からプロフィールを取得する
+func GetProfile(code string, user goth.User) Profile {
+	// 登録されていない時 (OIDC など) はデフォルトの割り当て
+	spec, _ := LookupProvider(models.ProviderCode(code))
+
+	return Profile{
+		Name:      pickField(user, spec.NameFields, defaultNameFields),
+		Email:     pickField(user, spec.EmailFields, defaultEmailFields),
+		AvatarURL: pickField(user, spec.AvatarFields, defaultAvatarFields),
+	}
+}
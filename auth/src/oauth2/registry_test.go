@@ -0,0 +1,38 @@
+package oauth2
+
+import (
+	"auth/models"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+func TestBuiltinEmailVerified(t *testing.T) {
+	verified := goth.User{RawData: map[string]interface{}{"verified_email": true, "email_verified": true, "verified": true}}
+
+	// 確認済みを返すプロバイダ
+	for _, code := range []models.ProviderCode{models.Google, models.Discord} {
+		if !emailVerifiedFor(models.Provider{ProviderCode: code}, verified) {
+			t.Fatalf("%s should report a verified email", code)
+		}
+		if emailVerifiedFor(models.Provider{ProviderCode: code}, goth.User{}) {
+			t.Fatalf("%s without the flag must be unverified", code)
+		}
+	}
+
+	// 確認済みを保証しないプロバイダ
+	for _, spec := range Registry() {
+		if len(spec.EmailVerified) > 0 {
+			continue
+		}
+
+		if emailVerifiedFor(models.Provider{ProviderCode: spec.Code}, verified) {
+			t.Fatalf("%s must be treated as unverified", spec.Code)
+		}
+	}
+
+	// 登録されていないプロバイダ
+	if emailVerifiedFor(models.Provider{ProviderCode: "unknown"}, verified) {
+		t.Fatal("unknown provider must be unverified")
+	}
+}
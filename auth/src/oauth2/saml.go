@@ -0,0 +1,505 @@
+package oauth2
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/labstack/echo/v4"
+	"github.com/markbates/goth"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// AuthnRequest の有効期限
+	samlRequestExpiry = 10 * time.Minute
+
+	// SP 証明書の有効期限
+	samlCertificateLifetime = 10 * 365 * 24 * time.Hour
+)
+
+var (
+	ErrSamlProviderNotFound = errors.New("saml provider not found")
+	ErrSamlRequestNotFound  = errors.New("saml request not found or expired")
+)
+
+// SAML の属性の割り当て (空の項目はデフォルトの属性を使う)
+type SamlAttributes struct {
+	UserID    []string `json:"UserID,omitempty"`    // ユーザーID (デフォルト: NameID)
+	Name      []string `json:"Name,omitempty"`      // 名前
+	Email     []string `json:"Email,omitempty"`     // メールアドレス
+	FirstName []string `json:"FirstName,omitempty"` // 名
+	LastName  []string `json:"LastName,omitempty"`  // 姓
+}
+
+// デフォルトの属性 (Name と FriendlyName のどちらでも一致する)
+var defaultSamlAttributes = SamlAttributes{
+	Name: []string{
+		"displayName", "name",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name",
+	},
+	Email: []string{
+		"email", "mail", "emailAddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	},
+	FirstName: []string{
+		"givenName", "firstName",
+		"urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+	},
+	LastName: []string{
+		"sn", "surname", "lastName",
+		"urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+	},
+}
+
+// SAML プロバイダの設定 (models.Provider.Config に保存する)
+type SamlConfig struct {
+	IdPMetadataURL    string         `json:"IdPMetadataURL"`    // IdP のメタデータ URL
+	IdPMetadataXML    string         `json:"IdPMetadataXML"`    // IdP のメタデータ (URL の時は取得した結果)
+	EntityID          string         `json:"EntityID"`          // SP のエンティティID (空の時はメタデータ URL)
+	Attributes        SamlAttributes `json:"Attributes"`        // 属性の割り当て
+	AllowIDPInitiated bool           `json:"AllowIDPInitiated"` // IdP 起点のログインを許可するか
+	SignRequests      bool           `json:"SignRequests"`      // AuthnRequest に署名するか
+	SPKey             string         `json:"SPKey"`             // SP の秘密鍵 (PEM)
+	SPCertificate     string         `json:"SPCertificate"`     // SP の証明書 (PEM)
+}
+
+// 設定を読み込む
+func ParseSamlConfig(raw string) (SamlConfig, error) {
+	config := SamlConfig{}
+
+	// 空の時
+	if strings.TrimSpace(raw) == "" {
+		return config, nil
+	}
+
+	err := json.Unmarshal([]byte(raw), &config)
+	return config, err
+}
+
+// SP の URL
+func SamlMetadataURL(publicURL string, providerCode models.ProviderCode) string {
+	return publicURL + "/saml/" + url.PathEscape(string(providerCode)) + "/metadata"
+}
+
+func SamlAcsURL(publicURL string, providerCode models.ProviderCode) string {
+	return publicURL + "/saml/" + url.PathEscape(string(providerCode)) + "/acs"
+}
+
+// SP の鍵と自己署名証明書を生成する (返却値: 秘密鍵 PEM, 証明書 PEM)
+func NewSamlKeyPair(commonName string) (string, string, error) {
+	// 鍵を生成
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	// シリアル番号
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	// 証明書を生成
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(samlCertificateLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return string(keyPEM), string(certPEM), nil
+}
+
+// IdP のメタデータを取得する
+func FetchIdpMetadata(metadataURL string) (string, error) {
+	// URL を検証
+	parsed, err := url.Parse(metadataURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", errors.New("IdPMetadataURL must be an http(s) URL")
+	}
+
+	// タイムアウト付きで取得
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	descriptor, err := samlsp.FetchMetadata(ctx, http.DefaultClient, *parsed)
+	if err != nil {
+		return "", err
+	}
+
+	// 保存用に変換
+	metadata, err := xml.Marshal(descriptor)
+	return string(metadata), err
+}
+
+// SP を生成する
+func NewSamlServiceProvider(provider models.Provider, publicURL string) (*saml.ServiceProvider, SamlConfig, error) {
+	// 設定を読み込む
+	config, err := ParseSamlConfig(provider.Config)
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	// IdP のメタデータ
+	idpMetadata, err := samlsp.ParseMetadata([]byte(config.IdPMetadataXML))
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	// SP の鍵
+	keyPair, err := tls.X509KeyPair([]byte(config.SPCertificate), []byte(config.SPKey))
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	// URL
+	metadataURL, err := url.Parse(SamlMetadataURL(publicURL, provider.ProviderCode))
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	acsURL, err := url.Parse(SamlAcsURL(publicURL, provider.ProviderCode))
+	if err != nil {
+		return nil, SamlConfig{}, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          config.EntityID,
+		Key:               keyPair.PrivateKey.(*rsa.PrivateKey),
+		Certificate:       certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		AllowIDPInitiated: config.AllowIDPInitiated,
+	}
+
+	// 署名する時
+	if config.SignRequests {
+		sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+	}
+
+	return sp, config, nil
+}
+
+// SAML プロバイダを取得する (有効なもののみ)
+func getSamlProvider(providerCode string) (*models.Provider, error) {
+	provider, err := models.GetProvider(models.ProviderCode(providerCode))
+
+	// 存在しない, SAML でない, 無効の時
+	if err != nil || provider.Kind != models.KindSaml || provider.IsEnabled != 1 {
+		return nil, ErrSamlProviderNotFound
+	}
+
+	return provider, nil
+}
+
+// SP のメタデータを生成する
+func SamlMetadata(providerCode string, publicURL string) ([]byte, error) {
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.ProviderCode(providerCode))
+	if err != nil || provider.Kind != models.KindSaml {
+		return nil, ErrSamlProviderNotFound
+	}
+
+	// SP を生成
+	sp, _, err := NewSamlServiceProvider(*provider, publicURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// ここから AuthnRequest の保存
+// ACS はクロスサイトの POST なので SameSite=Lax のクッキーが使えない
+// RelayState をキーにしてサーバー側に保存する
+type samlRequest struct {
+	providerCode string
+	requestID    string
+	args         OauthArgs
+	expires      time.Time
+}
+
+type samlRequestStore struct {
+	mutex    sync.Mutex
+	requests map[string]samlRequest
+}
+
+var samlRequests = &samlRequestStore{requests: map[string]samlRequest{}}
+
+// 保存して RelayState を返す
+func (store *samlRequestStore) Put(providerCode string, requestID string, args OauthArgs) string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+
+	// 期限切れを削除する
+	for id, value := range store.requests {
+		if value.expires.Before(now) {
+			delete(store.requests, id)
+		}
+	}
+
+	relayState := utils.GenID()
+	store.requests[relayState] = samlRequest{
+		providerCode: providerCode,
+		requestID:    requestID,
+		args:         args,
+		expires:      now.Add(samlRequestExpiry),
+	}
+
+	return relayState
+}
+
+// 取り出す (一度しか取り出せない)
+func (store *samlRequestStore) Take(relayState string, providerCode string) (samlRequest, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	value, ok := store.requests[relayState]
+	if !ok {
+		return samlRequest{}, false
+	}
+
+	delete(store.requests, relayState)
+
+	// 期限切れ、プロバイダ違いの時
+	if value.expires.Before(time.Now()) || value.providerCode != providerCode {
+		return samlRequest{}, false
+	}
+
+	return value, true
+}
+
+// ここまで
+
+// SAML 認証を開始する (IdP が対応していれば Redirect, それ以外は POST バインディング)
+func StartSaml(ctx echo.Context, publicURL string, args OauthArgs) error {
+	// プロバイダを取得
+	provider, err := getSamlProvider(args.ProviderName)
+
+	// エラー処理
+	if err != nil {
+		return utils.ErrorScreen(ctx, http.StatusBadRequest, utils.GenID(), err, args.IsPopup)
+	}
+
+	// SP を生成
+	sp, _, err := NewSamlServiceProvider(*provider, publicURL)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return utils.ErrorScreen(ctx, http.StatusInternalServerError, utils.GenID(), err, args.IsPopup)
+	}
+
+	// バインディングを選ぶ
+	binding := saml.HTTPRedirectBinding
+	location := sp.GetSSOBindingLocation(binding)
+	if location == "" {
+		binding = saml.HTTPPostBinding
+		location = sp.GetSSOBindingLocation(binding)
+	}
+
+	// AuthnRequest を生成
+	request, err := sp.MakeAuthenticationRequest(location, binding, saml.HTTPPostBinding)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return utils.ErrorScreen(ctx, http.StatusInternalServerError, utils.GenID(), err, args.IsPopup)
+	}
+
+	// リクエストを保存
+	relayState := samlRequests.Put(string(provider.ProviderCode), request.ID, args)
+
+	// POST バインディングの時
+	if binding == saml.HTTPPostBinding {
+		page := "<!DOCTYPE html><html><head><meta charset=\"UTF-8\"></head><body>" + string(request.Post(relayState)) + "</body></html>"
+		return ctx.HTMLBlob(http.StatusOK, []byte(page))
+	}
+
+	// Redirect バインディング
+	redirectURL, err := request.Redirect(relayState, sp)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return utils.ErrorScreen(ctx, http.StatusInternalServerError, utils.GenID(), err, args.IsPopup)
+	}
+
+	return ctx.Redirect(http.StatusFound, redirectURL.String())
+}
+
+// 属性の値を取得する
+func samlAttribute(assertion *saml.Assertion, names []string, defaults []string) string {
+	if len(names) == 0 {
+		names = defaults
+	}
+
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attribute := range statement.Attributes {
+				// Name か FriendlyName が一致する時
+				if attribute.Name != name && attribute.FriendlyName != name {
+					continue
+				}
+
+				for _, value := range attribute.Values {
+					if value.Value != "" {
+						return value.Value
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// アサーションを goth.User に変換する (Oauth と同じユーザー作成に渡すため)
+func samlUser(providerCode string, assertion *saml.Assertion, config SamlConfig) goth.User {
+	user := goth.User{
+		Provider:  providerCode,
+		Name:      samlAttribute(assertion, config.Attributes.Name, defaultSamlAttributes.Name),
+		Email:     samlAttribute(assertion, config.Attributes.Email, defaultSamlAttributes.Email),
+		FirstName: samlAttribute(assertion, config.Attributes.FirstName, defaultSamlAttributes.FirstName),
+		LastName:  samlAttribute(assertion, config.Attributes.LastName, defaultSamlAttributes.LastName),
+		RawData:   map[string]interface{}{},
+	}
+
+	// NameID
+	nameID := ""
+	nameIDFormat := ""
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		nameID = assertion.Subject.NameID.Value
+		nameIDFormat = assertion.Subject.NameID.Format
+	}
+
+	// ユーザーID
+	user.UserID = nameID
+	if len(config.Attributes.UserID) > 0 {
+		user.UserID = samlAttribute(assertion, config.Attributes.UserID, nil)
+	}
+
+	// メールアドレス形式の NameID
+	if user.Email == "" && nameIDFormat == string(saml.EmailAddressNameIDFormat) {
+		user.Email = nameID
+	}
+
+	// 属性をそのまま残す
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := []string{}
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+			user.RawData[attribute.Name] = values
+		}
+	}
+
+	return user
+}
+
+// 応答が対応してよい AuthnRequest の ID (保存したリクエストがない時は IdP 起点を許可している時のみ)
+func samlRequestIDs(pending samlRequest, found bool, config SamlConfig) ([]string, error) {
+	if found {
+		return []string{pending.requestID}, nil
+	}
+
+	// IdP 起点を許可していない時
+	if !config.AllowIDPInitiated {
+		return nil, ErrSamlRequestNotFound
+	}
+
+	return []string{}, nil
+}
+
+// ACS でアサーションを検証する
+func CallbackSaml(ctx echo.Context, publicURL string, providerCode string) (OauthResponse, error) {
+	request := ctx.Request()
+
+	// フォームを読み込む
+	if err := request.ParseForm(); err != nil {
+		return OauthResponse{}, err
+	}
+
+	// 保存したリクエストを取得
+	pending, found := samlRequests.Take(request.PostForm.Get("RelayState"), providerCode)
+	response := OauthResponse{IsMobile: pending.args.IsMobile, IsPopup: pending.args.IsPopup, LinkTicket: pending.args.LinkTicket}
+
+	// プロバイダを取得
+	provider, err := getSamlProvider(providerCode)
+	if err != nil {
+		return response, err
+	}
+
+	// SP を生成
+	sp, config, err := NewSamlServiceProvider(*provider, publicURL)
+	if err != nil {
+		return response, err
+	}
+
+	// 対応するリクエスト
+	possibleRequestIDs, err := samlRequestIDs(pending, found, config)
+	if err != nil {
+		return response, err
+	}
+
+	// アサーションを検証
+	assertion, err := sp.ParseResponse(request, possibleRequestIDs)
+
+	// エラー処理
+	if err != nil {
+		// 詳しい理由はログにのみ出す
+		var invalidResponse *saml.InvalidResponseError
+		if errors.As(err, &invalidResponse) {
+			logger.PrintErr(invalidResponse.PrivateErr)
+		}
+
+		return response, err
+	}
+
+	response.User = samlUser(providerCode, assertion, config)
+	return response, nil
+}
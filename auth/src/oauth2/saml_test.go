@@ -0,0 +1,173 @@
+package oauth2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+func TestSamlRequestStore(t *testing.T) {
+	store := &samlRequestStore{requests: map[string]samlRequest{}}
+	args := OauthArgs{ProviderName: "okta", IsPopup: true, LinkTicket: "ticket"}
+
+	// 保存したリクエストを取り出せる
+	relayState := store.Put("okta", "id-1", args)
+	pending, ok := store.Take(relayState, "okta")
+	if !ok || pending.requestID != "id-1" || pending.args != args {
+		t.Fatalf("Take() = %+v, %v", pending, ok)
+	}
+
+	// 二度目は取り出せない
+	if _, ok := store.Take(relayState, "okta"); ok {
+		t.Fatal("RelayState was accepted twice")
+	}
+
+	// 知らない RelayState
+	if _, ok := store.Take("unknown", "okta"); ok {
+		t.Fatal("unknown RelayState was accepted")
+	}
+
+	// 他のプロバイダの RelayState (使えなくなる)
+	relayState = store.Put("okta", "id-2", args)
+	if _, ok := store.Take(relayState, "azure"); ok {
+		t.Fatal("RelayState was accepted for another provider")
+	}
+	if _, ok := store.Take(relayState, "okta"); ok {
+		t.Fatal("RelayState was accepted after a provider mismatch")
+	}
+
+	// 期限切れ
+	relayState = store.Put("okta", "id-3", args)
+	expired := store.requests[relayState]
+	expired.expires = time.Now().Add(-time.Second)
+	store.requests[relayState] = expired
+	if _, ok := store.Take(relayState, "okta"); ok {
+		t.Fatal("expired RelayState was accepted")
+	}
+
+	// 期限切れは次の保存で削除される
+	store.requests[relayState] = expired
+	store.Put("okta", "id-4", args)
+	if _, exists := store.requests[relayState]; exists {
+		t.Fatal("expired request was not swept")
+	}
+}
+
+func TestSamlRequestIDs(t *testing.T) {
+	pending := samlRequest{providerCode: "okta", requestID: "id-1"}
+
+	// SP 起点
+	ids, err := samlRequestIDs(pending, true, SamlConfig{})
+	if err != nil || len(ids) != 1 || ids[0] != "id-1" {
+		t.Fatalf("samlRequestIDs() = %v, %v", ids, err)
+	}
+
+	// IdP 起点を許可していない時
+	if _, err := samlRequestIDs(samlRequest{}, false, SamlConfig{AllowIDPInitiated: false}); !errors.Is(err, ErrSamlRequestNotFound) {
+		t.Fatalf("expected ErrSamlRequestNotFound, got %v", err)
+	}
+
+	// IdP 起点を許可している時
+	ids, err = samlRequestIDs(samlRequest{}, false, SamlConfig{AllowIDPInitiated: true})
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("samlRequestIDs() = %v, %v", ids, err)
+	}
+}
+
+// テスト用のアサーション
+func testAssertion(nameID string, format saml.NameIDFormat, attributes ...saml.Attribute) *saml.Assertion {
+	return &saml.Assertion{
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Value: nameID, Format: string(format)},
+		},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: attributes}},
+	}
+}
+
+func testAttribute(name string, friendlyName string, values ...string) saml.Attribute {
+	attribute := saml.Attribute{Name: name, FriendlyName: friendlyName}
+	for _, value := range values {
+		attribute.Values = append(attribute.Values, saml.AttributeValue{Value: value})
+	}
+
+	return attribute
+}
+
+func TestSamlUserDefaultAttributes(t *testing.T) {
+	assertion := testAssertion("user-1", saml.UnspecifiedNameIDFormat,
+		testAttribute("urn:oid:2.16.840.1.113730.3.1.241", "displayName", "Alice Liddell"),
+		testAttribute("urn:oid:0.9.2342.19200300.100.1.3", "", "", "alice@example.com"),
+		testAttribute("http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname", "", "Alice"),
+		testAttribute("x-surname", "sn", "Liddell"),
+	)
+
+	user := samlUser("okta", assertion, SamlConfig{})
+
+	if user.Provider != "okta" || user.UserID != "user-1" {
+		t.Fatalf("unexpected provider or user id: %+v", user)
+	}
+	if user.Name != "Alice Liddell" || user.FirstName != "Alice" || user.LastName != "Liddell" {
+		t.Fatalf("unexpected names: %+v", user)
+	}
+
+	// 空の値は飛ばす
+	if user.Email != "alice@example.com" {
+		t.Fatalf("Email = %q", user.Email)
+	}
+
+	// 属性はそのまま残す
+	values, _ := user.RawData["x-surname"].([]string)
+	if len(values) != 1 || values[0] != "Liddell" {
+		t.Fatalf("RawData = %v", user.RawData)
+	}
+}
+
+func TestSamlUserConfiguredAttributes(t *testing.T) {
+	assertion := testAssertion("transient-id", saml.TransientNameIDFormat,
+		testAttribute("employeeNumber", "", "E1234"),
+		testAttribute("corpMail", "", "bob@example.com"),
+		testAttribute("email", "", "ignored@example.com"),
+	)
+
+	config := SamlConfig{Attributes: SamlAttributes{
+		UserID: []string{"employeeNumber"},
+		Email:  []string{"corpMail"},
+	}}
+	user := samlUser("okta", assertion, config)
+
+	// 割り当てた属性を NameID やデフォルトより優先する
+	if user.UserID != "E1234" {
+		t.Fatalf("UserID = %q, want E1234", user.UserID)
+	}
+	if user.Email != "bob@example.com" {
+		t.Fatalf("Email = %q, want bob@example.com", user.Email)
+	}
+
+	// 割り当てた属性がない時は空にする (NameID に戻さない)
+	user = samlUser("okta", testAssertion("transient-id", saml.TransientNameIDFormat), config)
+	if user.UserID != "" {
+		t.Fatalf("UserID = %q, want empty", user.UserID)
+	}
+}
+
+func TestSamlUserEmailNameID(t *testing.T) {
+	// メールアドレス形式の NameID は属性がない時にメールアドレスに使う
+	user := samlUser("okta", testAssertion("carol@example.com", saml.EmailAddressNameIDFormat), SamlConfig{})
+	if user.UserID != "carol@example.com" || user.Email != "carol@example.com" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+
+	// 他の形式の NameID はメールアドレスに使わない
+	user = samlUser("okta", testAssertion("carol@example.com", saml.PersistentNameIDFormat), SamlConfig{})
+	if user.Email != "" {
+		t.Fatalf("Email = %q, want empty", user.Email)
+	}
+
+	// NameID がない時
+	user = samlUser("okta", &saml.Assertion{}, SamlConfig{})
+	if user.UserID != "" || user.Email != "" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+}
@@ -0,0 +1,944 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/oauth2"
+	"auth/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// 認可コードの有効期限
+	authorizationCodeExpiry = time.Minute * 5
+
+	// PKCE で受け付ける方式
+	pkceMethodS256 = "S256"
+)
+
+var (
+	// クライアントが見つからない時のエラー
+	ErrOauthClientNotFound = errors.New("oauth client not found")
+
+	// リダイレクトURIが登録されていない時のエラー
+	ErrRedirectURIMismatch = errors.New("redirect_uri is not registered for this client")
+
+	// スコープの形式 (RFC 6749 3.3)
+	scopeTokenPattern = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+
+	// PKCE のチャレンジと検証コードの形式 (RFC 7636 4.1)
+	pkceVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	pkceChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// RFC 6749 のエラー
+type OauthError struct {
+	Status      int    // HTTP ステータス (トークンエンドポイント)
+	Code        string // エラーコード (invalid_request など)
+	Description string // 説明
+	RedirectURL string // クライアントにリダイレクトして返す時の URL (認可エンドポイント)
+}
+
+func (err *OauthError) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+func newOauthError(status int, code string, description string) *OauthError {
+	return &OauthError{Status: status, Code: code, Description: description}
+}
+
+// ランダムな値を生成する (base64url)
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// シークレットやコードのハッシュ (十分に長いランダム値なので bcrypt は使わない)
+func hashSecret(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// スペース区切りのスコープを分割する (重複は取り除く)
+func splitScope(raw string) []string {
+	scopes := []string{}
+
+	for _, scope := range strings.Fields(raw) {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// ここからクライアント管理
+type OauthClient struct {
+	ClientID     string   `json:"ClientID"`               // クライアントID (読み取り専用)
+	ClientName   string   `json:"ClientName"`             // 同意画面に表示する名前
+	ClientSecret string   `json:"ClientSecret,omitempty"` // クライアントシークレット (作成時と再生成時だけ返す)
+	RedirectURIs []string `json:"RedirectURIs"`           // 登録するリダイレクトURI
+	Scopes       []string `json:"Scopes"`                 // 許可するスコープ
+	IsPublic     bool     `json:"IsPublic"`               // 公開クライアントか (SPA やネイティブアプリ)
+	SkipConsent  bool     `json:"SkipConsent"`            // 同意画面を表示しないか
+	CreatedAt    string   `json:"CreatedAt"`              // 作成日 (読み取り専用)
+}
+
+// モデルから変換する (シークレットは返さない)
+func toOauthClient(client models.OauthClient) OauthClient {
+	return OauthClient{
+		ClientID:     client.ClientID,
+		ClientName:   client.ClientName,
+		RedirectURIs: strings.Fields(client.RedirectURIs),
+		Scopes:       splitScope(client.Scopes),
+		IsPublic:     client.IsPublic == 1,
+		SkipConsent:  client.SkipConsent == 1,
+		CreatedAt:    FormatUnixTimestampToString(client.CreatedAt, time.RFC3339),
+	}
+}
+
+// リダイレクトURIを検証する
+func validateRedirectURI(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme == "" {
+		return errors.New("redirect URI must be an absolute URI: " + raw)
+	}
+
+	// フラグメントは使えない (RFC 6749 3.1.2)
+	if parsed.Fragment != "" || strings.Contains(raw, "#") {
+		return errors.New("redirect URI must not contain a fragment: " + raw)
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host == "" {
+			return errors.New("redirect URI must have a host: " + raw)
+		}
+	case "http":
+		// http はループバックだけ許可する (ネイティブアプリ用)
+		host := parsed.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return errors.New("http redirect URI is only allowed for loopback hosts: " + raw)
+		}
+	case "javascript", "data", "file":
+		return errors.New("redirect URI scheme is not allowed: " + raw)
+	}
+
+	return nil
+}
+
+// 入力を検証してモデルに反映する
+func (args OauthClient) applyTo(client *models.OauthClient) error {
+	// 値を検証する
+	if strings.TrimSpace(args.ClientName) == "" {
+		return errors.New("ClientName is required")
+	}
+
+	if len(args.RedirectURIs) == 0 {
+		return errors.New("RedirectURIs is required")
+	}
+
+	for _, redirectURI := range args.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return err
+		}
+	}
+
+	for _, scope := range args.Scopes {
+		if !scopeTokenPattern.MatchString(scope) {
+			return errors.New("invalid scope: " + scope)
+		}
+	}
+
+	client.ClientName = strings.TrimSpace(args.ClientName)
+	client.RedirectURIs = strings.Join(args.RedirectURIs, "\n")
+	client.Scopes = strings.Join(splitScope(strings.Join(args.Scopes, " ")), " ")
+	client.SkipConsent = utils.BoolToInt(args.SkipConsent)
+
+	return nil
+}
+
+// クライアントを取得する
+func getOauthClient(clientID string) (*models.OauthClient, error) {
+	client, result := models.GetOauthClient(clientID)
+
+	// エラー処理
+	if !result.IsExists {
+		return nil, ErrOauthClientNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return client, nil
+}
+
+// クライアント一覧を取得
+func GetOauthClients() ([]OauthClient, error) {
+	// データベースから取得
+	clients, err := models.GetOauthClients()
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	returnClients := []OauthClient{}
+	for _, client := range clients {
+		returnClients = append(returnClients, toOauthClient(client))
+	}
+
+	return returnClients, nil
+}
+
+// クライアントを作成 (シークレットはこの時だけ返す)
+func CreateOauthClient(args OauthClient) (OauthClient, error) {
+	client := models.OauthClient{
+		ClientID: utils.GenID(),
+		IsPublic: utils.BoolToInt(args.IsPublic),
+	}
+
+	// 入力を反映
+	if err := args.applyTo(&client); err != nil {
+		return OauthClient{}, err
+	}
+
+	// シークレットを生成 (公開クライアントは持たない)
+	secret := ""
+	if !args.IsPublic {
+		generated, err := randomToken(32)
+		if err != nil {
+			return OauthClient{}, err
+		}
+
+		secret = generated
+		client.SecretHash = hashSecret(secret)
+	}
+
+	// 作成する
+	if err := models.CreateOauthClient(&client); err != nil {
+		return OauthClient{}, err
+	}
+
+	returnClient := toOauthClient(client)
+	returnClient.ClientSecret = secret
+
+	return returnClient, nil
+}
+
+// クライアントを更新 (公開クライアントかどうかは変えない)
+func UpdateOauthClient(clientID string, args OauthClient) (OauthClient, error) {
+	// 存在するか確認
+	client, err := getOauthClient(clientID)
+
+	// エラー処理
+	if err != nil {
+		return OauthClient{}, err
+	}
+
+	// 入力を反映
+	if err := args.applyTo(client); err != nil {
+		return OauthClient{}, err
+	}
+
+	// 更新する
+	if err := models.UpdateOauthClient(client); err != nil {
+		return OauthClient{}, err
+	}
+
+	return toOauthClient(*client), nil
+}
+
+// シークレットを再生成する
+func RegenerateOauthClientSecret(clientID string) (OauthClient, error) {
+	// 存在するか確認
+	client, err := getOauthClient(clientID)
+
+	// エラー処理
+	if err != nil {
+		return OauthClient{}, err
+	}
+
+	// 公開クライアントの時
+	if client.IsPublic == 1 {
+		return OauthClient{}, errors.New("public clients do not have a secret")
+	}
+
+	// 生成する
+	secret, err := randomToken(32)
+	if err != nil {
+		return OauthClient{}, err
+	}
+
+	client.SecretHash = hashSecret(secret)
+
+	// 更新する
+	if err := models.UpdateOauthClient(client); err != nil {
+		return OauthClient{}, err
+	}
+
+	returnClient := toOauthClient(*client)
+	returnClient.ClientSecret = secret
+
+	return returnClient, nil
+}
+
+// クライアントを削除
+func DeleteOauthClient(clientID string) error {
+	// 存在するか確認
+	if _, err := getOauthClient(clientID); err != nil {
+		return err
+	}
+
+	return models.DeleteOauthClient(clientID)
+}
+
+// ここまで
+
+// ここから認可エンドポイント
+type AuthorizeArgs struct {
+	ResponseType        string `json:"response_type" query:"response_type"`
+	ClientID            string `json:"client_id" query:"client_id"`
+	RedirectURI         string `json:"redirect_uri" query:"redirect_uri"`
+	Scope               string `json:"scope" query:"scope"`
+	State               string `json:"state" query:"state"`
+	CodeChallenge       string `json:"code_challenge" query:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" query:"code_challenge_method"`
+	Nonce               string `json:"nonce" query:"nonce"`
+}
+
+// 検証済みの認可リクエスト
+type authorizeRequest struct {
+	client      *models.OauthClient
+	redirectURI string   // リダイレクト先
+	scopes      []string // 要求されたスコープ
+	args        AuthorizeArgs
+}
+
+// クライアントにリダイレクトする URL を生成する
+func (request authorizeRequest) redirectURL(params url.Values) string {
+	// state を返す
+	if request.args.State != "" {
+		params.Set("state", request.args.State)
+	}
+
+	// 既にクエリがある時は追加する
+	separator := "?"
+	if strings.Contains(request.redirectURI, "?") {
+		separator = "&"
+	}
+
+	return request.redirectURI + separator + params.Encode()
+}
+
+// エラーをクライアントに返す
+func (request authorizeRequest) redirectError(code string, description string) *OauthError {
+	err := newOauthError(http.StatusFound, code, description)
+	err.RedirectURL = request.redirectURL(url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+
+	return err
+}
+
+// 認可リクエストを検証する
+// クライアントとリダイレクトURIが正しくない時はリダイレクトしない
+func checkAuthorizeRequest(args AuthorizeArgs) (authorizeRequest, error) {
+	// クライアントを取得
+	client, err := getOauthClient(args.ClientID)
+	if err != nil {
+		return authorizeRequest{}, err
+	}
+
+	// リダイレクトURIを検証 (完全一致)
+	registered := strings.Fields(client.RedirectURIs)
+	redirectURI := args.RedirectURI
+
+	if redirectURI == "" {
+		// 1つだけ登録されている時は省略できる
+		if len(registered) != 1 {
+			return authorizeRequest{}, ErrRedirectURIMismatch
+		}
+
+		redirectURI = registered[0]
+	} else if !slices.Contains(registered, redirectURI) {
+		return authorizeRequest{}, ErrRedirectURIMismatch
+	}
+
+	request := authorizeRequest{
+		client:      client,
+		redirectURI: redirectURI,
+		args:        args,
+	}
+
+	// ここからはクライアントにエラーを返す
+	if args.ResponseType != "code" {
+		return request, request.redirectError("unsupported_response_type", "response_type must be code")
+	}
+
+	// PKCE は全てのクライアントで必須
+	if description := checkCodeChallenge(args.CodeChallenge, args.CodeChallengeMethod); description != "" {
+		return request, request.redirectError("invalid_request", description)
+	}
+
+	// スコープ
+	scopes, err := resolveScopes(client, args.Scope)
+	if err != nil {
+		return request, request.redirectError("invalid_scope", err.Error())
+	}
+
+	request.scopes = scopes
+
+	return request, nil
+}
+
+// PKCE のチャレンジを検証する (問題がある時はエラーの説明を返す)
+func checkCodeChallenge(challenge string, method string) string {
+	if challenge == "" {
+		return "code_challenge is required"
+	}
+
+	if method != pkceMethodS256 {
+		return "code_challenge_method must be S256"
+	}
+
+	if !pkceChallengePattern.MatchString(challenge) {
+		return "code_challenge is malformed"
+	}
+
+	return ""
+}
+
+// 要求されたスコープを検証する (省略した時はクライアントに許可された全て)
+func resolveScopes(client *models.OauthClient, raw string) ([]string, error) {
+	scopes := splitScope(raw)
+
+	if len(scopes) == 0 {
+		scopes = splitScope(client.Scopes)
+	}
+
+	// OpenID Connect の標準スコープは常に許可する
+	allowed := splitScope(client.Scopes + " " + strings.Join(openidScopes, " "))
+
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, errors.New("scope is not allowed for this client: " + scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// 同意画面に表示するログイン方法
+type LoginMethod struct {
+	Name string // 表示名
+	URL  string // ログインを開始する URL
+}
+
+// パスワードでログインする方法
+type PasswordLogin struct {
+	Name  string // 表示名
+	URL   string // ログインする URL
+	Field string // ユーザー名の JSON キー
+}
+
+// 同意画面の表示内容
+type AuthorizePage struct {
+	ClientName     string
+	Scopes         []string
+	LoginMethods   []LoginMethod
+	PasswordLogins []PasswordLogin
+}
+
+// 有効なログイン方法を取得する
+func getLoginMethods() ([]LoginMethod, []PasswordLogin) {
+	methods := []LoginMethod{}
+	passwords := []PasswordLogin{}
+
+	// 組み込みのプロバイダ
+	for _, spec := range oauth2.Registry() {
+		provider, err := models.GetProvider(spec.Code)
+		if err != nil || provider.IsEnabled != 1 {
+			continue
+		}
+
+		methods = append(methods, LoginMethod{Name: provider.ProviderName, URL: PublicURL + "/oauth/" + string(spec.Code)})
+	}
+
+	// OIDC と SAML
+	for _, kind := range []models.ProviderKind{models.KindOidc, models.KindSaml} {
+		providers, err := models.GetProvidersByKind(kind)
+		if err != nil {
+			logger.PrintErr(err)
+			continue
+		}
+
+		for _, provider := range providers {
+			if provider.IsEnabled != 1 {
+				continue
+			}
+
+			path := "/oauth/"
+			if kind == models.KindSaml {
+				path = "/saml/"
+			}
+
+			methods = append(methods, LoginMethod{Name: provider.ProviderName, URL: PublicURL + path + string(provider.ProviderCode)})
+		}
+	}
+
+	// basic と ldap
+	if provider, err := models.GetProvider(models.Basic); err == nil && provider.IsEnabled == 1 {
+		passwords = append(passwords, PasswordLogin{Name: "メールアドレス", URL: PublicURL + "/basic/login", Field: "email"})
+	}
+
+	if provider, err := models.GetProvider(models.Ldap); err == nil && provider.IsEnabled == 1 {
+		passwords = append(passwords, PasswordLogin{Name: provider.ProviderName, URL: PublicURL + "/ldap/login", Field: "username"})
+	}
+
+	return methods, passwords
+}
+
+// 認可リクエストを検証して同意画面の内容を返す
+func BeginAuthorize(args AuthorizeArgs) (AuthorizePage, error) {
+	// 検証する
+	request, err := checkAuthorizeRequest(args)
+	if err != nil {
+		return AuthorizePage{}, err
+	}
+
+	// ログイン方法
+	methods, passwords := getLoginMethods()
+
+	return AuthorizePage{
+		ClientName:     request.client.ClientName,
+		Scopes:         request.scopes,
+		LoginMethods:   methods,
+		PasswordLogins: passwords,
+	}, nil
+}
+
+type AuthorizeDecisionArgs struct {
+	AuthorizeArgs
+	Decision  string `json:"decision"` // allow / deny (空の時は同意済みか確認する)
+	UserID    string `json:"-"`
+	SessionID string `json:"-"`
+}
+
+type AuthorizeResult struct {
+	RedirectURL     string   `json:"redirect,omitempty"`        // クライアントに戻る URL
+	ConsentRequired bool     `json:"consentRequired,omitempty"` // 同意が必要か
+	UserName        string   `json:"userName,omitempty"`        // ログイン中のユーザー名
+	Scopes          []string `json:"scopes,omitempty"`          // 同意するスコープ
+}
+
+// 同意済みか確認する
+func hasConsent(userID string, client *models.OauthClient, scopes []string) (bool, error) {
+	// 同意画面を表示しないクライアント
+	if client.SkipConsent == 1 {
+		return true, nil
+	}
+
+	consent, result := models.GetOauthConsent(userID, client.ClientID)
+
+	// 同意していない時
+	if !result.IsExists {
+		return false, nil
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	// 全てのスコープに同意しているか
+	granted := splitScope(consent.Scope)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// 同意を保存する (以前の同意に追加する)
+func saveConsent(userID string, clientID string, scopes []string) error {
+	granted := scopes
+
+	// 以前の同意
+	consent, result := models.GetOauthConsent(userID, clientID)
+	if result.Error == nil {
+		granted = splitScope(consent.Scope + " " + strings.Join(scopes, " "))
+	}
+
+	return models.SaveOauthConsent(&models.OauthConsent{
+		UserID:   userID,
+		ClientID: clientID,
+		Scope:    strings.Join(granted, " "),
+	})
+}
+
+// 認可コードを発行する
+func issueAuthorizationCode(request authorizeRequest, userID string, sessionID string) (string, error) {
+	// コードを生成
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	// 保存する
+	err = models.CreateAuthorizationCode(&models.AuthorizationCode{
+		CodeHash:            hashSecret(code),
+		ClientID:            request.client.ClientID,
+		UserID:              userID,
+		SessionID:           sessionID,
+		RedirectURI:         request.args.RedirectURI,
+		Scope:               strings.Join(request.scopes, " "),
+		CodeChallenge:       request.args.CodeChallenge,
+		CodeChallengeMethod: request.args.CodeChallengeMethod,
+		Nonce:               request.args.Nonce,
+		ExpiresAt:           now.Add(authorizationCodeExpiry).Unix(),
+	})
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	// 期限切れのコードを掃除する
+	if err := models.DeleteExpiredAuthorizationCodes(now.Unix()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	return code, nil
+}
+
+// ログイン中のユーザーの判断を処理する
+func Authorize(args AuthorizeDecisionArgs) (AuthorizeResult, error) {
+	// 検証する
+	request, err := checkAuthorizeRequest(args.AuthorizeArgs)
+	if err != nil {
+		return AuthorizeResult{}, err
+	}
+
+	// 拒否した時
+	if args.Decision == "deny" {
+		return AuthorizeResult{RedirectURL: request.redirectError("access_denied", "the user denied the request").RedirectURL}, nil
+	}
+
+	// 同意した時
+	if args.Decision == "allow" {
+		if err := saveConsent(args.UserID, request.client.ClientID, request.scopes); err != nil {
+			return AuthorizeResult{}, err
+		}
+	} else {
+		// 同意済みか確認する
+		consented, err := hasConsent(args.UserID, request.client, request.scopes)
+		if err != nil {
+			return AuthorizeResult{}, err
+		}
+
+		// 同意画面を表示する
+		if !consented {
+			user, result := models.GetUser(args.UserID)
+			if result.Error != nil {
+				return AuthorizeResult{}, result.Error
+			}
+
+			return AuthorizeResult{ConsentRequired: true, UserName: user.Name, Scopes: request.scopes}, nil
+		}
+	}
+
+	// 認可コードを発行
+	code, err := issueAuthorizationCode(request, args.UserID, args.SessionID)
+	if err != nil {
+		return AuthorizeResult{}, err
+	}
+
+	return AuthorizeResult{RedirectURL: request.redirectURL(url.Values{"code": {code}})}, nil
+}
+
+// ここまで
+
+// ここからトークンエンドポイント
+type TokenArgs struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Scope        string `form:"scope"` // 更新時にスコープを狭める
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // openid スコープの時
+}
+
+// クライアントを認証する
+func authenticateClient(clientID string, clientSecret string) (*models.OauthClient, error) {
+	invalidClient := newOauthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+
+	// クライアントを取得
+	client, err := getOauthClient(clientID)
+	if errors.Is(err, ErrOauthClientNotFound) {
+		return nil, invalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 公開クライアントはシークレットを持たない
+	if client.IsPublic == 1 {
+		if clientSecret != "" {
+			return nil, invalidClient
+		}
+
+		return client, nil
+	}
+
+	// シークレットを検証
+	if clientSecret == "" || subtle.ConstantTimeCompare([]byte(hashSecret(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalidClient
+	}
+
+	return client, nil
+}
+
+// PKCE の検証コードを確認する
+func verifyCodeChallenge(code *models.AuthorizationCode, verifier string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) || code.CodeChallengeMethod != pkceMethodS256 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) == 1
+}
+
+// トークンを発行する
+func ExchangeToken(args TokenArgs) (TokenResponse, error) {
+	// 対応しているグラント
+	switch args.GrantType {
+	case "authorization_code":
+		return exchangeAuthorizationCode(args)
+	case "refresh_token":
+		return exchangeRefreshToken(args)
+	case deviceCodeGrantType:
+		return exchangeDeviceCode(args)
+	case "client_credentials":
+		return exchangeClientCredentials(args)
+	}
+
+	return TokenResponse{}, newOauthError(http.StatusBadRequest, "unsupported_grant_type", "grant_type is not supported")
+}
+
+// 認可コードをアクセストークンに交換する
+func exchangeAuthorizationCode(args TokenArgs) (TokenResponse, error) {
+	if args.Code == "" || args.CodeVerifier == "" {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+	}
+
+	// クライアントを認証
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	invalidGrant := newOauthError(http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+
+	// 認可コードを取得
+	code, result := models.GetAuthorizationCode(hashSecret(args.Code))
+	if !result.IsExists {
+		return TokenResponse{}, invalidGrant
+	}
+	if result.Error != nil {
+		return TokenResponse{}, result.Error
+	}
+
+	// 他のクライアントのコード
+	if code.ClientID != client.ClientID {
+		return TokenResponse{}, invalidGrant
+	}
+
+	// 使用済みにする (検証に失敗してもコードは使えなくなる)
+	used, err := models.UseAuthorizationCode(code.CodeHash, utils.NowTime())
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if !used {
+		return TokenResponse{}, invalidGrant
+	}
+
+	// 認可リクエストと同じリダイレクトURIか
+	if args.RedirectURI != code.RedirectURI {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+	}
+
+	// PKCE を検証
+	if !verifyCodeChallenge(code, args.CodeVerifier) {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+	}
+
+	// 認可したセッションが終了している時
+	session, err := getActiveSession(code.SessionID)
+	if err != nil {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", "the session that authorized this code has ended")
+	}
+
+	// BANされている時
+	user, uresult := models.GetUser(code.UserID)
+	if uresult.Error != nil || user.IsBanned == 1 {
+		return TokenResponse{}, invalidGrant
+	}
+
+	return issueTokenResponse(session, client.ClientID, code.Scope, code.Nonce)
+}
+
+// 認可したセッションに紐づけてトークンを発行する
+func issueTokenResponse(session *models.Session, clientID string, scope string, nonce string) (TokenResponse, error) {
+	// アクセストークンを発行
+	token, err := issueAccessToken(session.UserID, session.SessionID, clientID, scope)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// リフレッシュトークンを発行
+	refreshToken, _, err := issueRefreshToken(refreshTokenArgs{
+		SessionID: session.SessionID,
+		UserID:    session.UserID,
+		ClientID:  clientID,
+		Scope:     scope,
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	response := TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokenExpiry.Seconds()),
+		Scope:        scope,
+		RefreshToken: refreshToken,
+	}
+
+	// ID トークンを発行
+	scopes := splitScope(scope)
+	if slices.Contains(scopes, "openid") {
+		idToken, err := IDTokenJwt(IDTokenClaim{
+			UserID:   session.UserID,
+			ClientID: clientID,
+			Nonce:    nonce,
+			AuthTime: session.CreatedAt,
+			Scopes:   scopes,
+		})
+		if err != nil {
+			return TokenResponse{}, err
+		}
+
+		response.IDToken = idToken
+	}
+
+	return response, nil
+}
+
+// リフレッシュトークンをローテーションしてアクセストークンを発行する
+func exchangeRefreshToken(args TokenArgs) (TokenResponse, error) {
+	if args.RefreshToken == "" {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_request", "refresh_token is required")
+	}
+
+	// クライアントを認証
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// 検証する (失敗した時にトークンを使用済みにしないよう、ローテーションは最後にする)
+	record, session, err := checkRefreshToken(args.RefreshToken, client.ClientID)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", err.Error())
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// スコープを狭める時 (元のスコープを超えられない)
+	scope, ok := narrowScope(record.Scope, args.Scope)
+	if !ok {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_scope", "scope exceeds the original grant")
+	}
+
+	// BANされている時
+	user, uresult := models.GetUser(record.UserID)
+	if uresult.Error != nil || user.IsBanned == 1 {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", "refresh token is invalid or expired")
+	}
+
+	// ローテーションする
+	refreshToken, err := useRefreshToken(record, session)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", err.Error())
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// アクセストークンを発行
+	token, err := issueAccessToken(record.UserID, record.SessionID, client.ClientID, scope)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokenExpiry.Seconds()),
+		Scope:        scope,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// 要求されたスコープに狭める (空の時は元のまま、元のスコープを超える時は false)
+func narrowScope(granted string, requested string) (string, bool) {
+	if strings.TrimSpace(requested) == "" {
+		return granted, true
+	}
+
+	grantedScopes := splitScope(granted)
+	requestedScopes := splitScope(requested)
+	for _, scope := range requestedScopes {
+		if !slices.Contains(grantedScopes, scope) {
+			return "", false
+		}
+	}
+
+	return strings.Join(requestedScopes, " "), true
+}
+
+// ここまで
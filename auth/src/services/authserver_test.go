@@ -0,0 +1,293 @@
+package services
+
+import (
+	"auth/models"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// RFC 7636 Appendix B の例
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+const testRedirectURI = "https://client.example.com/callback"
+
+func TestCheckCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		wantError bool
+	}{
+		{"valid", testCodeChallenge, pkceMethodS256, false},
+		{"missing challenge", "", pkceMethodS256, true},
+		{"missing method", testCodeChallenge, "", true},
+		{"plain method", testCodeChallenge, "plain", true},
+		{"too short", testCodeChallenge[:42], pkceMethodS256, true},
+		{"too long", testCodeChallenge + "A", pkceMethodS256, true},
+		{"padding", testCodeChallenge[:42] + "=", pkceMethodS256, true},
+		{"standard base64", strings.Replace(testCodeChallenge, "-", "+", 1), pkceMethodS256, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			description := checkCodeChallenge(test.challenge, test.method)
+			if (description != "") != test.wantError {
+				t.Fatalf("checkCodeChallenge() = %q, want error %v", description, test.wantError)
+			}
+		})
+	}
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	code := &models.AuthorizationCode{CodeChallenge: testCodeChallenge, CodeChallengeMethod: pkceMethodS256}
+
+	// RFC 7636 の例
+	if !verifyCodeChallenge(code, testCodeVerifier) {
+		t.Fatal("RFC 7636 verifier was rejected")
+	}
+
+	// 違う検証コード
+	other := strings.Repeat("a", 43)
+	if verifyCodeChallenge(code, other) {
+		t.Fatal("mismatched verifier was accepted")
+	}
+
+	// チャレンジそのものを検証コードにした時 (plain と同じ)
+	if verifyCodeChallenge(code, testCodeChallenge) {
+		t.Fatal("challenge was accepted as its own verifier")
+	}
+
+	// 形式が正しくない検証コード
+	for _, verifier := range []string{"", strings.Repeat("a", 42), strings.Repeat("a", 129), testCodeVerifier[:42] + "!"} {
+		if verifyCodeChallenge(code, verifier) {
+			t.Fatalf("malformed verifier %q was accepted", verifier)
+		}
+	}
+
+	// S256 以外のコード
+	plain := &models.AuthorizationCode{CodeChallenge: testCodeVerifier, CodeChallengeMethod: "plain"}
+	if verifyCodeChallenge(plain, testCodeVerifier) {
+		t.Fatal("plain challenge was accepted")
+	}
+}
+
+// テスト用のクライアントを作る (終了時に削除する)
+func createTestClient(t *testing.T) OauthClient {
+	t.Helper()
+
+	client, err := CreateOauthClient(OauthClient{
+		ClientName:   "test client",
+		RedirectURIs: []string{testRedirectURI, testRedirectURI + "/other"},
+		Scopes:       []string{"profile"},
+		SkipConsent:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DeleteOauthClient(client.ClientID) })
+
+	return client
+}
+
+// 認可コードを発行する
+func authorizeTestCode(t *testing.T, client OauthClient, session *models.Session, verifier string) string {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(verifier))
+	result, err := Authorize(AuthorizeDecisionArgs{
+		AuthorizeArgs: AuthorizeArgs{
+			ResponseType:        "code",
+			ClientID:            client.ClientID,
+			RedirectURI:         testRedirectURI,
+			Scope:               "openid profile",
+			State:               "state",
+			CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+			CodeChallengeMethod: pkceMethodS256,
+		},
+		Decision:  "allow",
+		UserID:    session.UserID,
+		SessionID: session.SessionID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirect, err := url.Parse(result.RedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := redirect.Query().Get("code")
+	if code == "" || redirect.Query().Get("state") != "state" {
+		t.Fatalf("unexpected redirect: %s", result.RedirectURL)
+	}
+
+	return code
+}
+
+// OAuth のエラーコードを確認する
+func expectOauthError(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var oauthErr *OauthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+// 認可コードのテストの準備
+func setupAuthorizationCodeTest(t *testing.T) (OauthClient, *models.Session) {
+	t.Helper()
+
+	requireTestDB(t)
+	useTestSigningKey(t)
+	user := createTestUser(t)
+
+	return createTestClient(t), createTestSession(t, user)
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	args := TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	}
+
+	response, err := ExchangeToken(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" || response.IDToken == "" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	// 二度目は使えない
+	_, err = ExchangeToken(args)
+	expectOauthError(t, err, "invalid_grant")
+}
+
+func TestAuthorizationCodeVerifierMismatch(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	args := TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: strings.Repeat("a", 43),
+	}
+
+	_, err := ExchangeToken(args)
+	expectOauthError(t, err, "invalid_grant")
+
+	// 失敗したコードは正しい検証コードでも使えない
+	args.CodeVerifier = testCodeVerifier
+	_, err = ExchangeToken(args)
+	expectOauthError(t, err, "invalid_grant")
+}
+
+func TestAuthorizationCodeRedirectURIMismatch(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	// 登録されているが認可リクエストとは違う URI
+	_, err := ExchangeToken(TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI + "/other",
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	})
+	expectOauthError(t, err, "invalid_grant")
+}
+
+func TestAuthorizationCodeOtherClient(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+	other := createTestClient(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	// 他のクライアントでは使えない
+	_, err := ExchangeToken(TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     other.ClientID,
+		ClientSecret: other.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	})
+	expectOauthError(t, err, "invalid_grant")
+
+	// 他のクライアントが試してもコードは使えなくならない
+	_, err = ExchangeToken(TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizationCodeEndedSession(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	// セッションを終了する
+	if err := revokeSession(session.SessionID, RevocationLogout); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ExchangeToken(TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	})
+	expectOauthError(t, err, "invalid_grant")
+}
+
+func TestAuthorizeRejectsBadCodeChallenge(t *testing.T) {
+	client, session := setupAuthorizationCodeTest(t)
+
+	for _, challenge := range []string{"", "short", testCodeChallenge + "="} {
+		_, err := Authorize(AuthorizeDecisionArgs{
+			AuthorizeArgs: AuthorizeArgs{
+				ResponseType:        "code",
+				ClientID:            client.ClientID,
+				RedirectURI:         testRedirectURI,
+				CodeChallenge:       challenge,
+				CodeChallengeMethod: pkceMethodS256,
+			},
+			Decision:  "allow",
+			UserID:    session.UserID,
+			SessionID: session.SessionID,
+		})
+
+		// クライアントにエラーを返す
+		var oauthErr *OauthError
+		if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_request" || !strings.HasPrefix(oauthErr.RedirectURL, testRedirectURI+"?") {
+			t.Fatalf("challenge %q: expected invalid_request redirect, got %v", challenge, err)
+		}
+	}
+}
@@ -1,43 +1,176 @@
 package services
 
 import (
+	"auth/logger"
 	"auth/models"
 	"auth/structs"
 	"auth/utils"
 	"errors"
 	"net/http"
+	"net/mail"
+	"strings"
+)
+
+const (
+	// パスワードの最小文字数
+	minPasswordLength = 8
+
+	// パスワードの最大文字数 (bcrypt は 72 バイトまで)
+	maxPasswordLength = 72
+)
+
+var (
+	// メールアドレスかパスワードが違う時のエラー
+	ErrInvalidCredentials = errors.New("invalid email or password")
+
+	// ユーザーが存在しない時に比較するハッシュ
+	dummyPasswordHash, _ = utils.HashPassword(utils.GenID())
 )
 
 type CreateBasicUserArgs struct {
-	Name     string // ユーザー名
-	Email    string // メールアドレス
-	Password string // パスワード
+	Name      string // ユーザー名
+	Email     string // メールアドレス
+	Password  string // パスワード
+	RemoteIP  string // リモートIP
+	UserAgent string // ユーザーエージェント
 }
 
-// 一般ユーザーを作成する (返却値: トークン, HttpResult)
-func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
+// basic プロバイダが有効か確認する (有効な時は Success が true)
+func checkBasicProvider() structs.HttpResult {
 	// プロバイダを取得
 	provider, err := models.GetProvider(models.Basic)
 
 	// エラー処理
 	if err != nil {
-		return "", structs.HttpResult{
-			Code: http.StatusInternalServerError,
+		return structs.HttpResult{
+			Code:    http.StatusInternalServerError,
 			Message: "failed to get provider",
 			Error:   err,
 			Success: false,
 		}
 	}
 
+	// 無効の時
 	if provider.IsEnabled == 0 {
-		return "", structs.HttpResult{
-			Code: http.StatusUnauthorized,
+		return structs.HttpResult{
+			Code:    http.StatusForbidden,
 			Message: "provider is disabled",
 			Error:   errors.New("provider is disabled"),
 			Success: false,
 		}
 	}
 
+	return structs.HttpResult{
+		Code:    http.StatusOK,
+		Message: "success",
+		Error:   nil,
+		Success: true,
+	}
+}
+
+// メールアドレスを検証して正規化する
+func normalizeEmail(email string) (string, error) {
+	// 前後の空白を削除
+	email = strings.TrimSpace(email)
+
+	// 形式を検証する
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errors.New("invalid email address")
+	}
+
+	return email, nil
+}
+
+// パスワードを検証する
+func validatePassword(password string) error {
+	// 短すぎる時
+	if len(password) < minPasswordLength {
+		return errors.New("password is too short")
+	}
+
+	// 長すぎる時
+	if len(password) > maxPasswordLength {
+		return errors.New("password is too long")
+	}
+
+	return nil
+}
+
+// 入力エラーを返す
+func badRequestResult(err error) structs.HttpResult {
+	return structs.HttpResult{
+		Code:    http.StatusBadRequest,
+		Message: err.Error(),
+		Error:   err,
+		Success: false,
+	}
+}
+
+// セッション作成時のエラーを HttpResult に変換する
+func sessionErrorResult(err error) structs.HttpResult {
+	// メールアドレスが確認されていない時
+	if errors.Is(err, ErrEmailNotVerified) {
+		return structs.HttpResult{
+			Code:    http.StatusForbidden,
+			Message: "email not verified",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// BANされている時
+	if errors.Is(err, ErrUserBanned) {
+		return structs.HttpResult{
+			Code:    http.StatusForbidden,
+			Message: "user is banned",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// 同時ログイン数の上限に達している時
+	if errors.Is(err, ErrSessionLimitReached) {
+		return structs.HttpResult{
+			Code:    http.StatusConflict,
+			Message: err.Error(),
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	return structs.HttpResult{
+		Code:    http.StatusInternalServerError,
+		Message: "failed to create session",
+		Error:   err,
+		Success: false,
+	}
+}
+
+// 一般ユーザーを作成する (返却値: トークン, HttpResult)
+func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
+	// プロバイダを確認する
+	if presult := checkBasicProvider(); !presult.Success {
+		return "", presult
+	}
+
+	// 名前を検証する
+	args.Name = strings.TrimSpace(args.Name)
+	if args.Name == "" {
+		return "", badRequestResult(errors.New("name is required"))
+	}
+
+	// メールアドレスを検証する
+	email, err := normalizeEmail(args.Email)
+	if err != nil {
+		return "", badRequestResult(err)
+	}
+
+	// パスワードを検証する
+	if err := validatePassword(args.Password); err != nil {
+		return "", badRequestResult(err)
+	}
+
 	// UUID を生成
 	uid := utils.GenID()
 
@@ -45,15 +178,25 @@ func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
 	now := utils.NowTime()
 
 	// ユーザーを取得する
-	_, result := models.GetUserByEmail(args.Email)
+	_, result := models.GetUserByEmail(email)
 
 	// エラー処理
 	if result.IsExists {
 		// 存在するとき
+		if result.Error == nil {
+			return "", structs.HttpResult{
+				Code:    http.StatusConflict,
+				Message: "user already exists",
+				Error:   errors.New("user already exists"),
+				Success: false,
+			}
+		}
+
+		// 取得に失敗した時
 		return "", structs.HttpResult{
-			Code: http.StatusConflict,
-			Message: "user already exists",
-			Error:   err,
+			Code:    http.StatusInternalServerError,
+			Message: "failed to get user",
+			Error:   result.Error,
 			Success: false,
 		}
 	}
@@ -64,7 +207,7 @@ func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
 	// エラー処理
 	if err != nil {
 		return "", structs.HttpResult{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "failed to hash password",
 			Error:   err,
 			Success: false,
@@ -72,45 +215,66 @@ func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
 	}
 
 	// ユーザーを作成する
-	err = models.CreateUser(&models.User{
+	user := &models.User{
 		UserID:       uid,
 		Name:         args.Name,
-		Email:        args.Email,
+		Email:        email,
 		ProvCode:     "",
 		ProvUID:      "",
 		PasswordHash: hashed,
 		CreatedAt:    now,
-	}, models.Basic)
+	}
+	err = models.CreateUser(user, models.Basic)
 
 	// エラー処理
 	if err != nil {
 		return "", structs.HttpResult{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "failed to create user",
 			Error:   err,
 			Success: false,
 		}
 	}
 
-	// セッションを作成する
-	token, err := NewSession(SessionArgs{
-		UserID:   uid,
-		RemoteIP: "",
-		UserAgent: "",
-	})
+	// 確認メールを送信する
+	if err := SendVerificationEmail(user); err != nil {
+		logger.PrintErr(err)
+	}
 
-	// エラー処理
+	// メールアドレスの確認が必要な時はセッションを作成しない
+	required, err := isEmailVerificationRequired(user)
 	if err != nil {
 		return "", structs.HttpResult{
-			Code: http.StatusInternalServerError,
-			Message: "failed to create session",
+			Code:    http.StatusInternalServerError,
+			Message: "failed to get provider",
 			Error:   err,
 			Success: false,
 		}
 	}
 
+	if required {
+		return "", structs.HttpResult{
+			Code:    http.StatusAccepted,
+			Message: "verification required",
+			Error:   nil,
+			Success: true,
+		}
+	}
+
+	// セッションを作成する
+	token, err := NewSession(SessionArgs{
+		UserID:    uid,
+		RemoteIP:  args.RemoteIP,
+		UserAgent: args.UserAgent,
+	})
+
+	// エラー処理
+	if err != nil {
+		return "", sessionErrorResult(err)
+	}
+
 	return token, structs.HttpResult{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "user created",
 		Error:   nil,
 		Success: true,
@@ -118,88 +282,74 @@ func CreateBasicUser(args CreateBasicUserArgs) (string, structs.HttpResult) {
 }
 
 type LoginBasicUserArgs struct {
-	Email    string // メールアドレス
-	Password string // パスワード
+	Email     string // メールアドレス
+	Password  string // パスワード
+	RemoteIP  string // リモートIP
+	UserAgent string // ユーザーエージェント
 }
 
-// ログインしてトークンを返す (返却値: トークン, HttpResult)
-func LoginBasicUser(args LoginBasicUserArgs) (string,structs.HttpResult) {
-	// プロバイダを取得
-	provider, err := models.GetProvider(models.Basic)
-
-	// エラー処理
-	if err != nil {
-		return "",structs.HttpResult{
-			Code: http.StatusInternalServerError,
-			Message: "failed to get provider",
-			Error:   err,
-			Success: false,
-		}
+// ログインしてトークンを返す (返却値: ログイン結果, HttpResult)
+func LoginBasicUser(args LoginBasicUserArgs) (LoginResult, structs.HttpResult) {
+	// プロバイダを確認する
+	if presult := checkBasicProvider(); !presult.Success {
+		return LoginResult{}, presult
 	}
 
-	if provider.IsEnabled == 0 {
-		return "",structs.HttpResult{
-			Code: http.StatusUnauthorized,
-			Message: "provider is disabled",
-			Error:   errors.New("provider is disabled"),
-			Success: false,
-		}
+	// 認証失敗時の結果
+	invalidResult := structs.HttpResult{
+		Code:    http.StatusUnauthorized,
+		Message: ErrInvalidCredentials.Error(),
+		Error:   ErrInvalidCredentials,
+		Success: false,
 	}
 
 	// ユーザーを取得する
-	user, result := models.GetUserByEmail(args.Email)
+	user, result := models.GetUserByEmail(strings.TrimSpace(args.Email))
+
+	// 存在しない時
+	if !result.IsExists {
+		// タイミングで存在を判別されないようにハッシュを計算する
+		utils.CheckPasswordHash(args.Password, dummyPasswordHash)
+		return LoginResult{}, invalidResult
+	}
 
 	// エラー処理
 	if result.Error != nil {
-		return "",structs.HttpResult{
-			Code: http.StatusInternalServerError,
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusInternalServerError,
 			Message: "failed to get user",
-			Error:   err,
+			Error:   result.Error,
 			Success: false,
 		}
 	}
 
 	// プロバイダをチェックする
-	if user.ProvCode != models.Basic {
+	if user.ProvCode != models.Basic || user.PasswordHash == "" {
 		// basic 以外の場合はエラーを返す
-		return "",structs.HttpResult{
-			Code: http.StatusBadRequest,
-			Message: "invalid provider",
-			Error:   errors.New("invalid provider"),
-			Success: false,
-		}
+		utils.CheckPasswordHash(args.Password, dummyPasswordHash)
+		return LoginResult{}, invalidResult
 	}
 
-	// パスワードをチェックする
+	// パスワードをチェックする
 	if !utils.CheckPasswordHash(args.Password, user.PasswordHash) {
-		// パスワードが一致しない場合はエラーを返す
-		return "",structs.HttpResult{
-			Code: http.StatusBadRequest,
-			Message: "invalid password",
-			Error:   errors.New("invalid password"),
-			Success: false,
-		}
+		// パスワードが一致しない場合はエラーを返す
+		return LoginResult{}, invalidResult
 	}
 
-	// セッションを作成する
-	token, err := NewSession(SessionArgs{
+	// セッションを作成する (二要素認証が有効な時は待機トークン)
+	login, err := StartSession(SessionArgs{
 		UserID:    user.UserID,
-		RemoteIP:  "",
-		UserAgent: "",
+		RemoteIP:  args.RemoteIP,
+		UserAgent: args.UserAgent,
 	})
 
 	// エラー処理
 	if err != nil {
-		return "",structs.HttpResult{
-			Code: http.StatusInternalServerError,
-			Message: "failed to create session",
-			Error:   err,
-			Success: false,
-		}
+		return LoginResult{}, sessionErrorResult(err)
 	}
 
-	return token,structs.HttpResult{
-		Code: http.StatusOK,
+	return login, structs.HttpResult{
+		Code:    http.StatusOK,
 		Message: "success",
 		Error:   nil,
 		Success: true,
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"auth/models"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// クレームの値の取得元
+	claimSourceName      = "name"      // ユーザー名
+	claimSourceEmail     = "email"     // メールアドレス
+	claimSourceAvatar    = "avatar"    // アイコンの URL
+	claimSourceAudience  = "audience"  // 発行先のクライアントID
+	claimSourceIssuer    = "issuer"    // 発行者
+	claimSourceAttribute = "attribute" // ユーザーの任意の属性
+
+	// 全てのトークンに適用する設定の対象
+	claimAudienceAll = "*"
+)
+
+var (
+	// 設定が見つからない時のエラー
+	ErrClaimMappingNotFound = errors.New("claim mapping not found")
+
+	// ユーザーが見つからない時のエラー
+	ErrUserNotFound = errors.New("user not found")
+
+	// 取得元の一覧
+	claimSources = []string{claimSourceName, claimSourceEmail, claimSourceAvatar, claimSourceAudience, claimSourceIssuer, claimSourceAttribute}
+
+	// 上書きさせないクレーム (検証やラベルに使う)
+	reservedClaims = []string{"iss", "sub", "aud", "exp", "iat", "nbf", "jti", "sid", "scope", "labels", "userID", "provCode", "provUid", "principalType"}
+)
+
+// ここからクレームの設定
+type ClaimMapping struct {
+	ID        uint   `json:"ID"`        // ID (読み取り専用)
+	Audience  string `json:"Audience"`  // 対象 (クライアントID, 空はファーストパーティ, * は全て)
+	ClaimName string `json:"ClaimName"` // クレーム名
+	Source    string `json:"Source"`    // 値の取得元
+	Attribute string `json:"Attribute"` // Source が attribute の時の属性名
+	CreatedAt string `json:"CreatedAt"` // 作成日 (読み取り専用)
+}
+
+func toClaimMapping(mapping models.ClaimMapping) ClaimMapping {
+	return ClaimMapping{
+		ID:        mapping.ID,
+		Audience:  mapping.Audience,
+		ClaimName: mapping.ClaimName,
+		Source:    mapping.Source,
+		Attribute: mapping.Attribute,
+		CreatedAt: FormatUnixTimestampToString(mapping.CreatedAt, time.RFC3339),
+	}
+}
+
+// 入力を検証してモデルに反映する
+func (args ClaimMapping) applyTo(mapping *models.ClaimMapping) error {
+	name := strings.TrimSpace(args.ClaimName)
+	if name == "" {
+		return errors.New("ClaimName is required")
+	}
+
+	// 予約されたクレーム
+	if slices.Contains(reservedClaims, name) {
+		return errors.New("ClaimName is reserved: " + name)
+	}
+
+	// 取得元
+	if !slices.Contains(claimSources, args.Source) {
+		return errors.New("Source must be one of " + strings.Join(claimSources, ", "))
+	}
+
+	if args.Source == claimSourceAttribute && strings.TrimSpace(args.Attribute) == "" {
+		return errors.New("Attribute is required when Source is attribute")
+	}
+
+	// 対象のクライアントが存在するか
+	audience := strings.TrimSpace(args.Audience)
+	if audience != "" && audience != claimAudienceAll {
+		if _, err := getOauthClient(audience); err != nil {
+			return err
+		}
+	}
+
+	mapping.Audience = audience
+	mapping.ClaimName = name
+	mapping.Source = args.Source
+	mapping.Attribute = ""
+	if args.Source == claimSourceAttribute {
+		mapping.Attribute = strings.TrimSpace(args.Attribute)
+	}
+
+	return nil
+}
+
+// 設定一覧を取得
+func GetClaimMappings() ([]ClaimMapping, error) {
+	mappings, err := models.GetClaimMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ClaimMapping{}
+	for _, mapping := range mappings {
+		results = append(results, toClaimMapping(mapping))
+	}
+
+	return results, nil
+}
+
+// 設定を作成
+func CreateClaimMapping(args ClaimMapping) (ClaimMapping, error) {
+	mapping := models.ClaimMapping{}
+
+	// 入力を反映
+	if err := args.applyTo(&mapping); err != nil {
+		return ClaimMapping{}, err
+	}
+
+	// 作成する
+	if err := models.CreateClaimMapping(&mapping); err != nil {
+		return ClaimMapping{}, err
+	}
+
+	return toClaimMapping(mapping), nil
+}
+
+// 設定を更新
+func UpdateClaimMapping(id uint, args ClaimMapping) (ClaimMapping, error) {
+	// 取得する
+	mapping, result := models.GetClaimMapping(id)
+	if !result.IsExists {
+		return ClaimMapping{}, ErrClaimMappingNotFound
+	}
+	if result.Error != nil {
+		return ClaimMapping{}, result.Error
+	}
+
+	// 入力を反映
+	if err := args.applyTo(mapping); err != nil {
+		return ClaimMapping{}, err
+	}
+
+	// 更新する
+	if err := models.UpdateClaimMapping(mapping); err != nil {
+		return ClaimMapping{}, err
+	}
+
+	return toClaimMapping(*mapping), nil
+}
+
+// 設定を削除
+func DeleteClaimMapping(id uint) error {
+	// 存在するか確認
+	_, result := models.GetClaimMapping(id)
+	if !result.IsExists {
+		return ErrClaimMappingNotFound
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return models.DeleteClaimMapping(id)
+}
+
+// ここまで
+
+// ここからユーザーの属性
+// ユーザーの属性を取得
+func GetUserAttributes(userID string) (map[string]string, error) {
+	// ユーザーが存在するか
+	_, result := models.GetUser(userID)
+	if !result.IsExists {
+		return nil, ErrUserNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	attributes, err := models.GetUserAttributes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, attribute := range attributes {
+		values[attribute.Key] = attribute.Value
+	}
+
+	return values, nil
+}
+
+// ユーザーの属性を置き換える
+func UpdateUserAttributes(userID string, values map[string]string) (map[string]string, error) {
+	// ユーザーが存在するか
+	_, result := models.GetUser(userID)
+	if !result.IsExists {
+		return nil, ErrUserNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	attributes := []models.UserAttribute{}
+	for key, value := range values {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, errors.New("attribute name must not be empty")
+		}
+
+		attributes = append(attributes, models.UserAttribute{UserID: userID, Key: key, Value: value})
+	}
+
+	// 保存する
+	if err := models.ReplaceUserAttributes(userID, attributes); err != nil {
+		return nil, err
+	}
+
+	return GetUserAttributes(userID)
+}
+
+// ここまで
+
+// ここからトークンへの追加
+// 設定に従ってユーザーのクレームを作る (audience が空の時はファーストパーティ)
+func mappedClaims(user *models.User, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	// 設定を取得
+	mappings, err := models.GetClaimMappingsFor(audience, claimAudienceAll)
+	if err != nil {
+		return nil, err
+	}
+
+	// 対象を指定した設定を優先する
+	slices.SortStableFunc(mappings, func(a, b models.ClaimMapping) int {
+		if a.Audience == b.Audience {
+			return 0
+		}
+		if a.Audience == claimAudienceAll {
+			return -1
+		}
+		return 1
+	})
+
+	var attributes map[string]string
+
+	for _, mapping := range mappings {
+		var value string
+
+		switch mapping.Source {
+		case claimSourceName:
+			value = user.Name
+		case claimSourceEmail:
+			value = user.Email
+		case claimSourceAvatar:
+			value = PublicURL + "/icon/" + user.UserID
+		case claimSourceAudience:
+			value = audience
+		case claimSourceIssuer:
+			value = PublicURL
+		case claimSourceAttribute:
+			// 必要になった時だけ読み込む
+			if attributes == nil {
+				values, err := models.GetUserAttributes(user.UserID)
+				if err != nil {
+					return nil, err
+				}
+
+				attributes = map[string]string{}
+				for _, attribute := range values {
+					attributes[attribute.Key] = attribute.Value
+				}
+			}
+
+			value = attributes[mapping.Attribute]
+		}
+
+		// 値がない時は入れない
+		if value == "" {
+			delete(claims, mapping.ClaimName)
+			continue
+		}
+
+		claims[mapping.ClaimName] = value
+	}
+
+	return claims, nil
+}
+
+// ここまで
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// デバイスコードのグラントタイプ (RFC 8628 3.4)
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// デバイスコードの有効期限
+	deviceCodeExpiry = time.Minute * 10
+
+	// ポーリング間隔の初期値と slow_down で増やす秒数
+	devicePollInterval  = 5
+	deviceSlowDownDelta = 5
+
+	// ユーザーコードの文字 (読み間違えにくい子音だけ, RFC 8628 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+var (
+	// ユーザーコードが無効な時のエラー
+	ErrInvalidUserCode = errors.New("invalid or expired user code")
+
+	// ユーザーコードの総当たりを防ぐ制限 (ユーザーごと、IP ごと)
+	deviceUserLimiter = newRateLimiter(20, 15*time.Minute)
+	deviceIPLimiter   = newRateLimiter(20, 15*time.Minute)
+)
+
+// ここからデバイス認可リクエスト
+type DeviceAuthorizationArgs struct {
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Scope        string `form:"scope"`
+}
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// ユーザーコードを生成する
+func genUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	limit := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// 入力されたユーザーコードを揃える (区切りと小文字を許す)
+func normalizeUserCode(raw string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(raw))
+}
+
+// 表示用のユーザーコード (XXXX-XXXX)
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// デバイスコードとユーザーコードを発行する
+func DeviceAuthorization(args DeviceAuthorizationArgs) (DeviceAuthorizationResponse, error) {
+	// クライアントを認証
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return DeviceAuthorizationResponse{}, err
+	}
+
+	// スコープを検証
+	scopes, err := resolveScopes(client, args.Scope)
+	if err != nil {
+		return DeviceAuthorizationResponse{}, newOauthError(http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+
+	// デバイスコードを生成
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		return DeviceAuthorizationResponse{}, err
+	}
+
+	now := time.Now()
+
+	// 期限切れのコードを掃除する
+	if err := models.DeleteExpiredDeviceCodes(now.Unix()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// ユーザーコードが重複した時は作り直す
+	var userCode string
+	for range 3 {
+		userCode, err = genUserCode()
+		if err != nil {
+			return DeviceAuthorizationResponse{}, err
+		}
+
+		err = models.CreateDeviceCode(&models.DeviceCode{
+			DeviceCodeHash: hashSecret(deviceCode),
+			UserCode:       userCode,
+			ClientID:       client.ClientID,
+			Scope:          strings.Join(scopes, " "),
+			Status:         models.DevicePending,
+			Interval:       devicePollInterval,
+			ExpiresAt:      now.Add(deviceCodeExpiry).Unix(),
+		})
+		if err == nil {
+			break
+		}
+	}
+
+	// エラー処理
+	if err != nil {
+		return DeviceAuthorizationResponse{}, err
+	}
+
+	verificationURI := PublicURL + "/oauth2/device"
+
+	return DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               int64(deviceCodeExpiry.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+// ここまで
+
+// ここから確認画面
+// 確認画面の表示内容
+type DevicePage struct {
+	UserCode       string
+	LoginMethods   []LoginMethod
+	PasswordLogins []PasswordLogin
+}
+
+// 確認画面の内容を返す
+func BeginDeviceVerification(userCode string) DevicePage {
+	// ログイン方法
+	methods, passwords := getLoginMethods()
+
+	return DevicePage{
+		UserCode:       userCode,
+		LoginMethods:   methods,
+		PasswordLogins: passwords,
+	}
+}
+
+type DeviceDecisionArgs struct {
+	UserCode  string `json:"user_code"`
+	Decision  string `json:"decision"` // allow / deny (空の時は内容を確認する)
+	UserID    string `json:"-"`
+	SessionID string `json:"-"`
+	RemoteIP  string `json:"-"`
+}
+
+type DeviceDecisionResult struct {
+	ClientName string   `json:"clientName"`         // 要求しているクライアント
+	Scopes     []string `json:"scopes"`             // 要求されたスコープ
+	UserName   string   `json:"userName,omitempty"` // ログイン中のユーザー名
+	Status     string   `json:"status"`             // pending / approved / denied
+}
+
+// ログイン中のユーザーがユーザーコードを承認する
+func DecideDeviceCode(args DeviceDecisionArgs) (DeviceDecisionResult, error) {
+	// 制限する (確認と承認のどちらも数える)
+	if !deviceUserLimiter.Allow(args.UserID) || !deviceIPLimiter.Allow(args.RemoteIP) {
+		return DeviceDecisionResult{}, ErrTooManyRequests
+	}
+
+	userCode := normalizeUserCode(args.UserCode)
+	now := time.Now().Unix()
+
+	// コードを取得
+	code, result := models.GetDeviceCodeByUserCode(userCode)
+	if !result.IsExists {
+		return DeviceDecisionResult{}, ErrInvalidUserCode
+	}
+	if result.Error != nil {
+		return DeviceDecisionResult{}, result.Error
+	}
+
+	// 期限切れや処理済みの時
+	if code.Status != models.DevicePending || now > code.ExpiresAt {
+		return DeviceDecisionResult{}, ErrInvalidUserCode
+	}
+
+	// クライアントを取得
+	client, err := getOauthClient(code.ClientID)
+	if err != nil {
+		return DeviceDecisionResult{}, err
+	}
+
+	response := DeviceDecisionResult{
+		ClientName: client.ClientName,
+		Scopes:     splitScope(code.Scope),
+		Status:     string(models.DevicePending),
+	}
+
+	var status models.DeviceCodeStatus
+	switch args.Decision {
+	case "allow":
+		status = models.DeviceApproved
+	case "deny":
+		status = models.DeviceDenied
+	default:
+		// 内容を確認する
+		user, result := models.GetUser(args.UserID)
+		if result.Error != nil {
+			return DeviceDecisionResult{}, result.Error
+		}
+
+		response.UserName = user.Name
+		return response, nil
+	}
+
+	// 承認または拒否する
+	decided, err := models.DecideDeviceCode(userCode, status, args.UserID, args.SessionID, now)
+	if err != nil {
+		return DeviceDecisionResult{}, err
+	}
+
+	// 同時に処理された時
+	if !decided {
+		return DeviceDecisionResult{}, ErrInvalidUserCode
+	}
+
+	response.Status = string(status)
+	return response, nil
+}
+
+// ここまで
+
+// ここからポーリング
+// デバイスコードをトークンに交換する
+func exchangeDeviceCode(args TokenArgs) (TokenResponse, error) {
+	if args.DeviceCode == "" {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_request", "device_code is required")
+	}
+
+	// クライアントを認証
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	invalidGrant := newOauthError(http.StatusBadRequest, "invalid_grant", "device code is invalid")
+
+	// コードを取得
+	code, result := models.GetDeviceCode(hashSecret(args.DeviceCode))
+	if !result.IsExists {
+		return TokenResponse{}, invalidGrant
+	}
+	if result.Error != nil {
+		return TokenResponse{}, result.Error
+	}
+
+	// 他のクライアントのコード
+	if code.ClientID != client.ClientID {
+		return TokenResponse{}, invalidGrant
+	}
+
+	now := time.Now().Unix()
+
+	// 期限切れの時
+	if now > code.ExpiresAt {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "expired_token", "device code has expired")
+	}
+
+	switch code.Status {
+	case models.DevicePending:
+		// 間隔を守らずにポーリングした時は間隔を広げる
+		interval := code.Interval
+		pollErr := newOauthError(http.StatusBadRequest, "authorization_pending", "the user has not yet approved the request")
+
+		if code.LastPolledAt != 0 && now-code.LastPolledAt < code.Interval {
+			interval += deviceSlowDownDelta
+			pollErr = newOauthError(http.StatusBadRequest, "slow_down", "polling too frequently")
+		}
+
+		if err := models.PollDeviceCode(code.DeviceCodeHash, interval, now); err != nil {
+			return TokenResponse{}, err
+		}
+
+		return TokenResponse{}, pollErr
+	case models.DeviceDenied:
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "access_denied", "the user denied the request")
+	case models.DeviceApproved:
+		// 承認済みの時はトークンを発行する
+	default:
+		return TokenResponse{}, invalidGrant
+	}
+
+	// 発行済みにする
+	used, err := models.UseDeviceCode(code.DeviceCodeHash)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if !used {
+		return TokenResponse{}, invalidGrant
+	}
+
+	// 承認したセッションが終了している時
+	session, err := getActiveSession(code.SessionID)
+	if err != nil {
+		return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_grant", "the session that approved this code has ended")
+	}
+
+	// BANされている時
+	user, uresult := models.GetUser(code.UserID)
+	if uresult.Error != nil || user.IsBanned == 1 {
+		return TokenResponse{}, invalidGrant
+	}
+
+	return issueTokenResponse(session, client.ClientID, code.Scope, "")
+}
+
+// ここまで
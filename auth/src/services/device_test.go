@@ -0,0 +1,171 @@
+package services
+
+import (
+	"auth/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecideDeviceCodeRateLimit(t *testing.T) {
+	// 上限まで使った後は DB を見ずに拒否する
+	for range 20 {
+		deviceUserLimiter.Allow("limited-user")
+	}
+
+	_, err := DecideDeviceCode(DeviceDecisionArgs{UserCode: "BCDF-GHJK", UserID: "limited-user", RemoteIP: "192.0.2.1"})
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("expected ErrTooManyRequests for the user, got %v", err)
+	}
+
+	for range 20 {
+		deviceIPLimiter.Allow("192.0.2.2")
+	}
+
+	_, err = DecideDeviceCode(DeviceDecisionArgs{UserCode: "BCDF-GHJK", UserID: "other-user", RemoteIP: "192.0.2.2"})
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("expected ErrTooManyRequests for the IP, got %v", err)
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	code, err := genUserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 区切りと小文字を許す
+	formatted := formatUserCode(code)
+	if len(formatted) != userCodeLength+1 || normalizeUserCode(formatted) != code {
+		t.Fatalf("formatted %q does not normalize back to %q", formatted, code)
+	}
+	if normalizeUserCode("bcdf ghjk") != "BCDFGHJK" {
+		t.Fatal("lowercase code with a space was not normalized")
+	}
+}
+
+// デバイスコードのテストの準備
+func setupDeviceTest(t *testing.T) (OauthClient, *models.Session, DeviceAuthorizationResponse) {
+	t.Helper()
+
+	client, session := setupAuthorizationCodeTest(t)
+
+	response, err := DeviceAuthorization(DeviceAuthorizationArgs{
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Scope:        "openid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, session, response
+}
+
+// デバイスコードでトークンを要求する
+func pollDeviceCode(client OauthClient, deviceCode string) (TokenResponse, error) {
+	return ExchangeToken(TokenArgs{
+		GrantType:    deviceCodeGrantType,
+		DeviceCode:   deviceCode,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+	})
+}
+
+// ユーザーが判断する
+func decideTestDeviceCode(t *testing.T, session *models.Session, userCode string, decision string) {
+	t.Helper()
+
+	_, err := DecideDeviceCode(DeviceDecisionArgs{
+		UserCode:  userCode,
+		Decision:  decision,
+		UserID:    session.UserID,
+		SessionID: session.SessionID,
+		RemoteIP:  "198.51.100.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceCodePending(t *testing.T) {
+	client, _, device := setupDeviceTest(t)
+
+	// 承認待ち
+	_, err := pollDeviceCode(client, device.DeviceCode)
+	expectOauthError(t, err, "authorization_pending")
+
+	// 間隔を守らない時は間隔を広げる
+	_, err = pollDeviceCode(client, device.DeviceCode)
+	expectOauthError(t, err, "slow_down")
+
+	code, result := models.GetDeviceCode(hashSecret(device.DeviceCode))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if code.Interval != devicePollInterval+deviceSlowDownDelta {
+		t.Fatalf("Interval = %d, want %d", code.Interval, devicePollInterval+deviceSlowDownDelta)
+	}
+}
+
+func TestDeviceCodeDenied(t *testing.T) {
+	client, session, device := setupDeviceTest(t)
+	decideTestDeviceCode(t, session, device.UserCode, "deny")
+
+	_, err := pollDeviceCode(client, device.DeviceCode)
+	expectOauthError(t, err, "access_denied")
+}
+
+func TestDeviceCodeApprovedOnce(t *testing.T) {
+	client, session, device := setupDeviceTest(t)
+	decideTestDeviceCode(t, session, device.UserCode, "allow")
+
+	// 承認したセッションに紐づけて発行する
+	response, err := pollDeviceCode(client, device.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" || response.Scope != "openid" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	// 二度目は発行しない
+	_, err = pollDeviceCode(client, device.DeviceCode)
+	expectOauthError(t, err, "invalid_grant")
+
+	// 処理済みのユーザーコードは使えない
+	_, err = DecideDeviceCode(DeviceDecisionArgs{UserCode: device.UserCode, Decision: "allow", UserID: session.UserID, SessionID: session.SessionID, RemoteIP: "198.51.100.1"})
+	if !errors.Is(err, ErrInvalidUserCode) {
+		t.Fatalf("expected ErrInvalidUserCode, got %v", err)
+	}
+}
+
+func TestDeviceCodeExpired(t *testing.T) {
+	client, _ := setupAuthorizationCodeTest(t)
+
+	// 期限切れのコード
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCode, err := genUserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.CreateDeviceCode(&models.DeviceCode{
+		DeviceCodeHash: hashSecret(deviceCode),
+		UserCode:       userCode,
+		ClientID:       client.ClientID,
+		Scope:          "openid",
+		Status:         models.DevicePending,
+		Interval:       devicePollInterval,
+		ExpiresAt:      time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pollDeviceCode(client, deviceCode)
+	expectOauthError(t, err, "expired_token")
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"auth/models"
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"time"
+)
+
+const (
+	// 連携チケットの有効期限
+	linkTicketExpiry = time.Minute * 10
+)
+
+var (
+	// 連携が見つからない時のエラー
+	ErrIdentityNotFound = errors.New("identity not found")
+
+	// 他のユーザーに連携されている時のエラー
+	ErrIdentityInUse = errors.New("this account is already linked to another user")
+
+	// 最後のログイン手段を削除しようとした時のエラー
+	ErrLastLoginMethod = errors.New("cannot unlink the last login method")
+
+	// 連携チケットを発行したブラウザ以外から使われた時のエラー
+	ErrLinkTicketMismatch = errors.New("link ticket was issued to another browser")
+)
+
+type IdentityInfo struct {
+	ID         uint   `json:"id"`
+	Provider   string `json:"provider"`
+	ProviderID string `json:"providerId"`
+	Email      string `json:"email"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+func toIdentityInfo(identity models.Identity) IdentityInfo {
+	return IdentityInfo{
+		ID:         identity.ID,
+		Provider:   string(identity.ProvCode),
+		ProviderID: identity.ProvUID,
+		Email:      identity.Email,
+		CreatedAt:  FormatUnixTimestampToString(identity.CreatedAt, time.RFC3339),
+	}
+}
+
+// 連携一覧を取得する
+func GetIdentities(userID string) ([]IdentityInfo, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// 連携を取得
+	identities, err := user.GetIdentities()
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []IdentityInfo{}
+	for _, identity := range identities {
+		infos = append(infos, toIdentityInfo(identity))
+	}
+
+	return infos, nil
+}
+
+type LinkStart struct {
+	URL     string // ポップアップで開く URL
+	Binding string // 要求したブラウザのクッキーに保存する値
+}
+
+// 連携を開始する URL を返す
+func BeginLinkIdentity(userID string, provider string) (LinkStart, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return LinkStart{}, result.Error
+	}
+
+	// 他のブラウザでチケットを使わせないための値
+	binding, err := randomToken(32)
+
+	// エラー処理
+	if err != nil {
+		return LinkStart{}, err
+	}
+
+	// チケットを発行する
+	ticket, err := issueOneTimeToken(user, models.PurposeLinkIdentity, linkTicketExpiry, binding)
+
+	// エラー処理
+	if err != nil {
+		return LinkStart{}, err
+	}
+
+	// SAML の時
+	startPath := "/oauth/"
+	if providerModel, err := models.GetProvider(models.ProviderCode(provider)); err == nil && providerModel.Kind == models.KindSaml {
+		startPath = "/saml/"
+	}
+
+	return LinkStart{
+		URL:     PublicURL + startPath + url.PathEscape(provider) + "?popup=1&link=" + url.QueryEscape(ticket),
+		Binding: binding,
+	}, nil
+}
+
+// 連携チケットが発行したブラウザから使われているか確認する (使用済みにはしない)
+// 他人に自分のチケットを開かせて、その人のアカウントを自分に連携させないようにする
+func CheckLinkTicket(ticket string, binding string) error {
+	// トークンを検証
+	claims, err := parseOneTimeClaims(ticket, models.PurposeLinkIdentity)
+	if err != nil {
+		return err
+	}
+
+	if !isLinkBindingValid(claims, binding) {
+		return ErrLinkTicketMismatch
+	}
+
+	return nil
+}
+
+// チケットに結び付けた値とブラウザの値が一致するか
+func isLinkBindingValid(claims *oneTimeClaims, binding string) bool {
+	if claims.Binding == "" || binding == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(claims.Binding), []byte(hashSecret(binding))) == 1
+}
+
+type LinkIdentityArgs struct {
+	LinkTicket     string // 連携チケット
+	ProviderCode   string // 認証プロバイダコード
+	ProviderUserID string // 認証プロバイダユーザーID
+	Email          string // メールアドレス
+}
+
+// Oauth の結果をチケットのユーザーに連携する
+func LinkIdentity(args LinkIdentityArgs) error {
+	// プロバイダのユーザーIDがない時
+	if args.ProviderUserID == "" {
+		return errors.New("プロバイダのユーザーIDの取得に失敗しました")
+	}
+
+	// チケットを使用する
+	user, err := ConsumeOneTimeToken(args.LinkTicket, models.PurposeLinkIdentity)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 連携を取得する
+	identity, result := models.GetIdentity(models.ProviderCode(args.ProviderCode), args.ProviderUserID)
+
+	// 既に連携されている時
+	if result.IsExists {
+		// エラー処理
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// 同じユーザーの時は何もしない
+		if identity.UserID == user.UserID {
+			return nil
+		}
+
+		return ErrIdentityInUse
+	}
+
+	// 連携を追加する
+	return user.AddIdentity(&models.Identity{
+		ProvCode: models.ProviderCode(args.ProviderCode),
+		ProvUID:  args.ProviderUserID,
+		Email:    args.Email,
+	})
+}
+
+// 連携を解除する
+func UnlinkIdentity(userID string, identityID uint) error {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 連携を取得
+	identities, err := user.GetIdentities()
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 対象が存在するか
+	found := false
+	for _, identity := range identities {
+		if identity.ID == identityID {
+			found = true
+		}
+	}
+
+	if !found {
+		return ErrIdentityNotFound
+	}
+
+	// パスキーを取得
+	passkeys, err := user.GetWebauthnCredentials()
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 他にログイン手段がない時
+	if len(identities) <= 1 && user.PasswordHash == "" && len(passkeys) == 0 {
+		return ErrLastLoginMethod
+	}
+
+	// 削除する
+	_, err = user.DeleteIdentity(identityID)
+	return err
+}
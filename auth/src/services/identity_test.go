@@ -0,0 +1,48 @@
+package services
+
+import (
+	"auth/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckLinkTicket(t *testing.T) {
+	user := &models.User{UserID: "user-1", Email: "alice@example.com"}
+	expiresAt := time.Now().Add(linkTicketExpiry)
+
+	ticket, err := signOneTimeToken("token-1", user, models.PurposeLinkIdentity, expiresAt, "browser-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// チケットを要求したブラウザ
+	if err := CheckLinkTicket(ticket, "browser-a"); err != nil {
+		t.Fatalf("CheckLinkTicket() from the same browser = %v", err)
+	}
+
+	// 他のブラウザ、クッキーがない時
+	for _, binding := range []string{"browser-b", ""} {
+		if err := CheckLinkTicket(ticket, binding); !errors.Is(err, ErrLinkTicketMismatch) {
+			t.Fatalf("CheckLinkTicket(%q) = %v, want ErrLinkTicketMismatch", binding, err)
+		}
+	}
+
+	// ブラウザに結び付けていないチケット
+	unbound, err := signOneTimeToken("token-2", user, models.PurposeLinkIdentity, expiresAt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLinkTicket(unbound, "browser-a"); !errors.Is(err, ErrLinkTicketMismatch) {
+		t.Fatalf("CheckLinkTicket() with an unbound ticket = %v, want ErrLinkTicketMismatch", err)
+	}
+
+	// 連携以外の用途のトークン
+	other, err := signOneTimeToken("token-3", user, models.PurposeVerifyEmail, expiresAt, "browser-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckLinkTicket(other, "browser-a"); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("CheckLinkTicket() with another purpose = %v, want ErrInvalidOneTimeToken", err)
+	}
+}
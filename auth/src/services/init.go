@@ -12,17 +12,37 @@ import (
 var (
 	// トークンシークレット
 	TokenSecret = "secret"
+
+	// 外部から見た認証サーバーの URL (メールのリンクなどに使う)
+	PublicURL = "https://localhost:8370/auth"
 )
 
 func Init() {
 	// 環境変数からトークンシークレットを取得
 	TokenSecret = os.Getenv("TOKEN_SECRET")
 
+	// 環境変数から公開 URL を取得
+	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
+		PublicURL = strings.TrimSuffix(publicURL, "/")
+	}
+
 	// 環境変数から秘密鍵を取得
 	certString := os.Getenv("JWT_PRIVATE_KEY")
 
-	// 秘密鍵を初期化
-	initJwt(certString)
+	// 秘密鍵を初期化 (データベースに保存する鍵は SIGNING_KEY_ENCRYPTION_KEY で暗号化する)
+	initJwt(certString, os.Getenv("SIGNING_KEY_ENCRYPTION_KEY"))
+
+	// WebAuthn を初期化
+	initWebauthn()
+
+	// セッションの取得先
+	initSessionStore()
+
+	// 失効の取り込み (キャッシュを捨て、gRPC の購読者に配信する)
+	initRevocationSync()
+
+	// セッションの有効期限
+	initSessionExpiry()
 
 	// 画像一覧を取得
 	filepath.Walk(IconDir, func(path string, info fs.FileInfo, err error) error {
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ここからイントロスペクション (RFC 7662)
+type IntrospectArgs struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Sid       string `json:"sid,omitempty"` // 発行元のセッション
+}
+
+// セッションとユーザーが有効か (ログアウトや BAN を反映する)
+func isSessionActive(sessionID string, userID string) (*models.User, bool) {
+	// セッションが終了している時
+	if sessionID != "" {
+		session, err := getActiveSession(sessionID)
+		if err != nil || session.UserID != userID {
+			return nil, false
+		}
+	}
+
+	// BANされている時
+	user, result := models.GetUser(userID)
+	if result.Error != nil || user.IsBanned == 1 {
+		return nil, false
+	}
+
+	return user, true
+}
+
+// アクセストークンを調べる
+func introspectAccessToken(tokenString string) (IntrospectResponse, bool) {
+	// 署名と期限、失効を検証
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
+		return IntrospectResponse{}, false
+	}
+
+	userID, _ := claims["sub"].(string)
+	sessionID, _ := claims["sid"].(string)
+
+	// 名前 (サービスアカウントは無効化や削除を反映する)
+	var username string
+	if claims["principalType"] == principalServiceAccount {
+		account, ok := isServiceAccountActive(userID)
+		if !ok {
+			return IntrospectResponse{}, false
+		}
+
+		username = account.Name
+	} else {
+		user, ok := isSessionActive(sessionID, userID)
+		if !ok {
+			return IntrospectResponse{}, false
+		}
+
+		username = user.Name
+	}
+
+	exp, _ := claims.GetExpirationTime()
+	iat, _ := claims.GetIssuedAt()
+	aud, _ := claims["aud"].(string)
+	scope, _ := claims["scope"].(string)
+	jti, _ := claims["jti"].(string)
+
+	response := IntrospectResponse{
+		Active:    true,
+		Scope:     scope,
+		ClientID:  aud,
+		Username:  username,
+		TokenType: "Bearer",
+		Sub:       userID,
+		Aud:       aud,
+		Iss:       PublicURL,
+		Jti:       jti,
+		Sid:       sessionID,
+	}
+
+	if exp != nil {
+		response.Exp = exp.Unix()
+	}
+	if iat != nil {
+		response.Iat = iat.Unix()
+	}
+
+	return response, true
+}
+
+// リフレッシュトークンを調べる (発行先のクライアントにだけ答える)
+func introspectRefreshToken(tokenString string, clientID string) (IntrospectResponse, bool) {
+	if !strings.HasPrefix(tokenString, refreshTokenPrefix) {
+		return IntrospectResponse{}, false
+	}
+
+	// トークンを取得
+	record, result := models.GetRefreshToken(hashSecret(tokenString))
+	if !result.IsExists || result.Error != nil || record.ClientID != clientID {
+		return IntrospectResponse{}, false
+	}
+
+	// 使用済み、失効、期限切れの時
+	now := time.Now().Unix()
+	if record.UsedAt != 0 || record.RevokedAt != 0 || now > record.ExpiresAt || now > record.IdleExpiresAt {
+		return IntrospectResponse{}, false
+	}
+
+	user, ok := isSessionActive(record.SessionID, record.UserID)
+	if !ok {
+		return IntrospectResponse{}, false
+	}
+
+	return IntrospectResponse{
+		Active:    true,
+		Scope:     record.Scope,
+		ClientID:  record.ClientID,
+		Username:  user.Name,
+		TokenType: "refresh_token",
+		Exp:       min(record.ExpiresAt, record.IdleExpiresAt),
+		Iat:       record.CreatedAt,
+		Sub:       record.UserID,
+		Iss:       PublicURL,
+		Sid:       record.SessionID,
+	}, true
+}
+
+// トークンが有効か調べる (無効な時は active=false だけを返す)
+func Introspect(args IntrospectArgs) (IntrospectResponse, error) {
+	if args.Token == "" {
+		return IntrospectResponse{}, newOauthError(http.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	// クライアントを認証 (リソースサーバーはシークレットを持つ)
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return IntrospectResponse{}, err
+	}
+	if client.IsPublic == 1 {
+		return IntrospectResponse{}, newOauthError(http.StatusUnauthorized, "invalid_client", "public clients cannot introspect tokens")
+	}
+
+	// リフレッシュトークンの時
+	if strings.HasPrefix(args.Token, refreshTokenPrefix) {
+		if response, ok := introspectRefreshToken(args.Token, client.ClientID); ok {
+			return response, nil
+		}
+
+		return IntrospectResponse{Active: false}, nil
+	}
+
+	// アクセストークンの時
+	if response, ok := introspectAccessToken(args.Token); ok {
+		return response, nil
+	}
+
+	return IntrospectResponse{Active: false}, nil
+}
+
+// ここまで
+
+// ここから失効 (RFC 7009)
+type RevokeArgs struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// リフレッシュトークンを失効させる
+func revokeRefreshToken(tokenString string, clientID string) error {
+	// トークンを取得
+	record, result := models.GetRefreshToken(hashSecret(tokenString))
+	if !result.IsExists {
+		return nil
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 他のクライアントやファーストパーティのトークンは失効させない
+	if !isRevocableBy(record.ClientID, clientID) {
+		return nil
+	}
+
+	return models.RevokeRefreshTokenFamily(record.FamilyID, utils.NowTime())
+}
+
+// クライアントが失効させてよいトークンか (自分に発行されたものだけ)
+// ファーストパーティのトークン (発行先なし) はクライアントからは終了させない
+func isRevocableBy(tokenClientID string, clientID string) bool {
+	return tokenClientID != "" && tokenClientID == clientID
+}
+
+// アクセストークンを jti で失効させる
+func revokeAccessToken(tokenString string, clientID string) error {
+	// 期限切れや無効なトークンは何もしない
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
+		return nil
+	}
+
+	// 他のクライアントやファーストパーティのトークンは失効させない
+	if aud, _ := claims["aud"].(string); !isRevocableBy(aud, clientID) {
+		return nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if jti == "" || err != nil || exp == nil {
+		return nil
+	}
+
+	// 期限切れのものを削除する
+	if err := models.DeleteExpiredRevokedAccessTokens(utils.NowTime()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	return models.RevokeAccessToken(jti, exp.Unix())
+}
+
+// トークンを失効させる (知らないトークンも成功として扱う)
+func Revoke(args RevokeArgs) error {
+	if args.Token == "" {
+		return newOauthError(http.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	// クライアントを認証
+	client, err := authenticateClient(args.ClientID, args.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	// リフレッシュトークンの時
+	if strings.HasPrefix(args.Token, refreshTokenPrefix) {
+		return revokeRefreshToken(args.Token, client.ClientID)
+	}
+
+	// 以前のセッショントークンはファーストパーティなので何もしない
+	if sessionID, err := ValidateSessionToken(args.Token); err == nil && sessionID != "" {
+		return nil
+	}
+
+	// アクセストークンの時
+	return revokeAccessToken(args.Token, client.ClientID)
+}
+
+// ここまで
@@ -0,0 +1,46 @@
+package services
+
+import "testing"
+
+func TestIsRevocableBy(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokenClientID string
+		clientID      string
+		want          bool
+	}{
+		{"own token", "client-1", "client-1", true},
+		{"other client", "client-2", "client-1", false},
+		{"first party", "", "client-1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRevocableBy(test.tokenClientID, test.clientID); got != test.want {
+				t.Fatalf("isRevocableBy() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRevokeIgnoresFirstPartyTokens(t *testing.T) {
+	requireTestDB(t)
+	user := createTestUser(t)
+	client := createTestClient(t)
+
+	// ファーストパーティのセッション
+	refreshToken, err := NewSession(SessionArgs{UserID: user.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// クライアントが失効を要求しても成功として扱い、何もしない
+	err = Revoke(RevokeArgs{Token: refreshToken, ClientID: client.ClientID, ClientSecret: client.ClientSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isRefreshTokenUnused(t, refreshToken) {
+		t.Fatal("first-party refresh token was revoked by a client")
+	}
+}
@@ -3,78 +3,129 @@ package services
 import (
 	"auth/logger"
 	"auth/models"
+	"auth/utils"
 	"crypto/ed25519"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
-	// 秘密鍵
-	JwtPrivateKey ed25519.PrivateKey
-
-	// 公開鍵
-	JwtPublicKey ed25519.PublicKey
-)
-
-func initJwt(certString string) {
+// PEM 形式の Ed25519 秘密鍵を読み込む
+func parsePrivateKeyPem(certString string) (ed25519.PrivateKey, error) {
 	// PEMブロックの解析
 	// PEM形式の秘密鍵を解析
 	block, _ := pem.Decode([]byte(certString))
 	if block == nil {
-		logger.PrintErr("PEMデータの解析に失敗しました")
-		return
+		return nil, errors.New("PEMデータの解析に失敗しました")
 	}
 
 	// 秘密鍵のパース
 	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		logger.PrintErr("秘密鍵のパースに失敗しました: %v", err)
-		return
+		return nil, err
 	}
 
 	// Ed25519秘密鍵の型アサーション
 	edPrivateKey, ok := privateKey.(ed25519.PrivateKey)
 	if !ok {
-		logger.PrintErr("キーはEd25519秘密鍵ではありません")
+		return nil, errors.New("キーはEd25519秘密鍵ではありません")
+	}
+
+	return edPrivateKey, nil
+}
+
+// Ed25519 秘密鍵を PEM 形式にする
+func encodePrivateKeyPem(privateKey ed25519.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func initJwt(certString string, encryptionKey string) {
+	// 秘密鍵を保存する時の暗号
+	keyCipher, err := newSigningKeyCipher(encryptionKey)
+	if err != nil {
+		logger.PrintErr("署名鍵の暗号化の初期化に失敗しました: %v", err)
 		return
 	}
+	signingKeyCipher = keyCipher
 
-	// 秘密鍵を保存
-	JwtPrivateKey = edPrivateKey
+	if signingKeyCipher == nil {
+		logger.Println("SIGNING_KEY_ENCRYPTION_KEY が設定されていないため、署名鍵を暗号化せずに保存します")
+	} else if err := encryptStoredSigningKeys(); err != nil {
+		logger.PrintErr("署名鍵の暗号化に失敗しました: %v", err)
+	}
+
+	// 環境変数の鍵を鍵束に取り込む (以前の設定からの移行)
+	if certString != "" {
+		if err := importSigningKey(certString); err != nil {
+			logger.PrintErr("秘密鍵の読み込みに失敗しました: %v", err)
+		}
+	}
 
-	// 秘密鍵の情報を表示
-	logger.Println("Ed25519秘密鍵の読み込みに成功しました\n")
-	logger.Println("秘密鍵の長さ: %d バイト\n", len(edPrivateKey))
+	// 鍵束を読み込む
+	if err := jwtKeyring.load(); err != nil {
+		logger.PrintErr(err)
+		return
+	}
 
-	// 対応する公開鍵を取得 (秘密鍵の後半32バイトが公開鍵)
-	publicKey := edPrivateKey.Public().(ed25519.PublicKey)
-	logger.Println("対応する公開鍵の長さ: %d バイト\n", len(publicKey))
-	logger.Println("対応する公開鍵の値: %v\n", publicKey)
+	// 署名に使う鍵がない時は生成する
+	if _, err := jwtKeyring.signer(); err != nil {
+		if _, err := RotateSigningKey(); err != nil {
+			logger.PrintErr(err)
+			return
+		}
+	}
 
-	// 公開鍵を保存
-	JwtPublicKey = publicKey
+	logger.Println("署名鍵を読み込みました")
 }
 
 const (
 	tokenExpiry = time.Minute * 10
+
+	// アクセストークンの typ ヘッダ (RFC 9068)
+	accessTokenType = "at+jwt"
 )
 
 type AccessTokenClaim struct {
-	UserID   string   // ユーザーID
-	Labels   []string // ラベル
-	ProvCode models.ProviderCode
-	ProvUid  string
+	UserID    string   // ユーザーID
+	SessionID string   // 発行元のセッション
+	Labels    []string // ラベル
+	ProvCode  models.ProviderCode
+	ProvUid   string
+	Audience  string // 発行先のクライアントID (認証サーバーから発行した時)
+	Scope     string // 認可されたスコープ (スペース区切り)
+
+	// 人間以外のアカウントか (空の時は user)
+	PrincipalType string
+
+	// 設定で追加するクレーム (予約されたクレームは上書きしない)
+	Extra jwt.MapClaims
 }
 
 func AccessTokenJwt(args AccessTokenClaim) (string, error) {
+	now := time.Now()
+
 	// Create a new token object, specifying signing method and the claims
 	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+	claims := jwt.MapClaims{
+		// 発行者
+		"iss": PublicURL,
+		// ユーザーID (標準クレーム)
+		"sub": args.UserID,
+		// 発行日時
+		"iat": now.Unix(),
+		// トークンID (失効に使う)
+		"jti": utils.GenID(),
 		// 有効期限
-		"exp": time.Now().Add(tokenExpiry).Unix(),
+		"exp": now.Add(tokenExpiry).Unix(),
 		// ラベル
 		"labels": args.Labels,
 		// ユーザーID
@@ -83,10 +134,115 @@ func AccessTokenJwt(args AccessTokenClaim) (string, error) {
 		"provCode": args.ProvCode,
 		// プロバイダUID
 		"provUid": args.ProvUid,
-	})
+		// アカウントの種類 (user / service_account)
+		"principalType": principalUser,
+	}
+
+	// サービスアカウントの時
+	if args.PrincipalType != "" {
+		claims["principalType"] = args.PrincipalType
+	}
+
+	// 発行元のセッション
+	if args.SessionID != "" {
+		claims["sid"] = args.SessionID
+	}
+
+	// クライアントに発行する時
+	if args.Audience != "" {
+		claims["aud"] = args.Audience
+	}
+
+	// スコープが決まっている時
+	if args.Audience != "" || args.Scope != "" {
+		claims["scope"] = args.Scope
+	}
+
+	// 追加のクレーム
+	for name, value := range args.Extra {
+		if _, exists := claims[name]; exists || slices.Contains(reservedClaims, name) {
+			continue
+		}
+
+		claims[name] = value
+	}
+
+	return signJwt(claims, accessTokenType)
+}
+
+// 現役の鍵で署名する (kid を付ける)
+func signJwt(claims jwt.MapClaims, tokenType string) (string, error) {
+	// 署名に使う鍵を取得
+	key, err := jwtKeyring.signer()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.keyID
+
+	if tokenType != "" {
+		token.Header["typ"] = tokenType
+	}
 
 	// Sign and get the complete encoded token as a string using the secret
-	tokenString, err := token.SignedString(JwtPrivateKey)
+	tokenString, err := token.SignedString(key.privateKey)
 
 	return tokenString, err
 }
+
+// kid から検証に使う公開鍵を選ぶ
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+	return jwtKeyring.publicKey(keyID)
+}
+
+// アクセストークンを検証してクレームを返す
+func parseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	// トークンを検証
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// ID トークンなどは受け付けない
+		if token.Header["typ"] != accessTokenType {
+			return nil, errors.New("not an access token")
+		}
+
+		return verificationKey(token)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithExpirationRequired(), jwt.WithIssuer(PublicURL))
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	// クレームを取得
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid claims")
+	}
+
+	// 失効させたトークン
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := models.Sessions().IsAccessTokenRevoked(jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errors.New("access token has been revoked")
+		}
+	}
+
+	// ユーザーのトークンを一括で失効させた時
+	userID, _ := claims["userID"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	if userID != "" {
+		valid, err := isIssuedAfterRevocation(userID, int64(issuedAt))
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, errors.New("access token has been revoked")
+		}
+	}
+
+	return claims, nil
+}
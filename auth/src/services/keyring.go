@@ -0,0 +1,447 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// 退役した鍵を検証用に残す期間 (発行したトークンが全て期限切れになるまで)
+	retiredKeyRetention = tokenExpiry + time.Minute*5
+
+	// 他のインスタンスのローテーションを反映する間隔
+	keyringRefreshInterval = time.Minute
+
+	// 知らない kid の時に読み込み直す最短間隔
+	keyringMissInterval = time.Second * 10
+)
+
+var (
+	// 署名に使う鍵がない時のエラー
+	ErrNoSigningKey = errors.New("no active signing key")
+
+	// kid に対応する鍵がない時のエラー
+	ErrUnknownKeyID = errors.New("unknown key id")
+
+	// 暗号化された鍵を復号できない時のエラー
+	ErrSigningKeyEncryption = errors.New("cannot decrypt signing key")
+)
+
+// 暗号化して保存した秘密鍵の接頭辞
+const encryptedKeyPrefix = "enc:v1:"
+
+// 秘密鍵を保存する時の暗号 (SIGNING_KEY_ENCRYPTION_KEY がない時は nil で平文のまま)
+var signingKeyCipher cipher.AEAD
+
+// 読み込んだ署名鍵
+type signingKey struct {
+	keyID      string
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	createdAt  int64
+	retiredAt  int64
+}
+
+// 署名鍵の束 (現役の鍵 1 つと検証用の鍵)
+type keyring struct {
+	mutex    sync.RWMutex
+	loading  sync.Mutex // 読み込みを同時に 1 つにする
+	active   *signingKey
+	keys     []*signingKey // 新しい順
+	loadedAt time.Time
+}
+
+var jwtKeyring = &keyring{}
+
+// データベースから読み込む
+func (ring *keyring) load() error {
+	ring.loading.Lock()
+	defer ring.loading.Unlock()
+
+	return ring.loadLocked()
+}
+
+// 読み込む (loading を持っている時に呼ぶ)
+func (ring *keyring) loadLocked() error {
+	now := time.Now()
+
+	// 期限の過ぎた鍵を削除する
+	if err := models.DeleteRetiredSigningKeys(now.Add(-retiredKeyRetention).Unix()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// 取得する
+	records, err := models.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	var active *signingKey
+	keys := []*signingKey{}
+
+	for _, record := range records {
+		pemString, err := openPrivateKey(record.PrivateKey)
+		if err != nil {
+			logger.PrintErr("署名鍵 %s の復号に失敗しました: %v", record.KeyID, err)
+			continue
+		}
+
+		privateKey, err := parsePrivateKeyPem(pemString)
+		if err != nil {
+			logger.PrintErr("署名鍵 %s の読み込みに失敗しました: %v", record.KeyID, err)
+			continue
+		}
+
+		key := &signingKey{
+			keyID:      record.KeyID,
+			privateKey: privateKey,
+			publicKey:  privateKey.Public().(ed25519.PublicKey),
+			createdAt:  record.CreatedAt,
+			retiredAt:  record.RetiredAt,
+		}
+		keys = append(keys, key)
+
+		// 現役の鍵が複数ある時は一番新しいものを使う
+		if record.IsActive == 1 && active == nil {
+			active = key
+		}
+	}
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	ring.active = active
+	ring.keys = keys
+	ring.loadedAt = now
+
+	return nil
+}
+
+// 読み込んでから interval 以上経ったか
+func (ring *keyring) isStale(interval time.Duration) bool {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	return time.Since(ring.loadedAt) > interval
+}
+
+// 古くなっていたら読み込み直す
+func (ring *keyring) refresh(interval time.Duration) {
+	if !ring.isStale(interval) {
+		return
+	}
+
+	// 同時に古いと判断したリクエストは 1 つだけが読み込み、残りはそれを待つ
+	ring.loading.Lock()
+	defer ring.loading.Unlock()
+
+	// 待っている間に読み込まれた時
+	if !ring.isStale(interval) {
+		return
+	}
+
+	if err := ring.loadLocked(); err != nil {
+		logger.PrintErr(err)
+	}
+}
+
+// 署名に使う鍵を取得
+func (ring *keyring) signer() (*signingKey, error) {
+	ring.refresh(keyringRefreshInterval)
+
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	if ring.active == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return ring.active, nil
+}
+
+// kid から公開鍵を探す
+func (ring *keyring) find(keyID string) (ed25519.PublicKey, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	// kid がない時は現役の鍵 (kid を付ける前に発行したトークン)
+	if keyID == "" {
+		if ring.active == nil {
+			return nil, false
+		}
+
+		return ring.active.publicKey, true
+	}
+
+	for _, key := range ring.keys {
+		if key.keyID == keyID {
+			return key.publicKey, true
+		}
+	}
+
+	return nil, false
+}
+
+// 検証に使う公開鍵を取得
+func (ring *keyring) publicKey(keyID string) (ed25519.PublicKey, error) {
+	ring.refresh(keyringRefreshInterval)
+
+	if key, ok := ring.find(keyID); ok {
+		return key, nil
+	}
+
+	// 他のインスタンスがローテーションした直後かもしれない
+	ring.refresh(keyringMissInterval)
+
+	if key, ok := ring.find(keyID); ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKeyID
+}
+
+// 公開する鍵一覧
+func (ring *keyring) publicKeys() []*signingKey {
+	ring.refresh(keyringRefreshInterval)
+
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	return ring.keys
+}
+
+// 環境変数の鍵を取り込む (既にある時は何もしない)
+func importSigningKey(certString string) error {
+	// 読み込む
+	privateKey, err := parsePrivateKeyPem(certString)
+	if err != nil {
+		return err
+	}
+
+	keyID := jwkThumbprint(privateKey.Public().(ed25519.PublicKey))
+
+	// 既に取り込んでいる時
+	_, err = models.GetSigningKey(keyID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// 現役の鍵がなければ現役にする
+	isActive := 1
+	if records, err := models.GetSigningKeys(); err == nil {
+		for _, record := range records {
+			if record.IsActive == 1 {
+				isActive = 0
+			}
+		}
+	}
+
+	// 退役済みとして取り込む時は今から保持期間だけ残す
+	retiredAt := int64(0)
+	if isActive == 0 {
+		retiredAt = time.Now().Unix()
+	}
+
+	// 暗号化する
+	stored, err := sealPrivateKey(certString)
+	if err != nil {
+		return err
+	}
+
+	return models.CreateSigningKey(&models.SigningKey{
+		KeyID:      keyID,
+		PrivateKey: stored,
+		IsActive:   isActive,
+		RetiredAt:  retiredAt,
+	})
+}
+
+// ここから秘密鍵の暗号化
+// 環境変数の文字列から暗号を作る (空の時は nil)
+func newSigningKeyCipher(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, nil
+	}
+
+	// 任意の長さの文字列を使えるように SHA-256 で 32 バイトにする
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// 保存する形式にする (暗号がない時は平文のまま)
+func sealPrivateKey(pemString string) (string, error) {
+	if signingKeyCipher == nil {
+		return pemString, nil
+	}
+
+	nonce := make([]byte, signingKeyCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := signingKeyCipher.Seal(nonce, nonce, []byte(pemString), nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 保存した形式から PEM に戻す (平文で保存した鍵はそのまま返す)
+func openPrivateKey(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		return stored, nil
+	}
+
+	// 暗号化されているのに鍵がない時
+	if signingKeyCipher == nil {
+		return "", ErrSigningKeyEncryption
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil || len(sealed) < signingKeyCipher.NonceSize() {
+		return "", ErrSigningKeyEncryption
+	}
+
+	nonce, ciphertext := sealed[:signingKeyCipher.NonceSize()], sealed[signingKeyCipher.NonceSize():]
+	plain, err := signingKeyCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrSigningKeyEncryption
+	}
+
+	return string(plain), nil
+}
+
+// 平文で保存されている鍵を暗号化し直す (暗号化を有効にする前の鍵の移行)
+func encryptStoredSigningKeys() error {
+	if signingKeyCipher == nil {
+		return nil
+	}
+
+	records, err := models.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		// 暗号化済みの時
+		if strings.HasPrefix(record.PrivateKey, encryptedKeyPrefix) {
+			continue
+		}
+
+		stored, err := sealPrivateKey(record.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		if err := models.UpdateSigningKeyPrivateKey(record.KeyID, stored); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ここまで
+
+// ここから管理用
+type SigningKeyInfo struct {
+	KeyID     string `json:"kid"`
+	IsActive  bool   `json:"isActive"`
+	CreatedAt string `json:"createdAt"`
+	RetiredAt string `json:"retiredAt,omitempty"`
+}
+
+func toSigningKeyInfo(key *signingKey, active *signingKey) SigningKeyInfo {
+	info := SigningKeyInfo{
+		KeyID:     key.keyID,
+		IsActive:  key == active,
+		CreatedAt: FormatUnixTimestampToString(key.createdAt, time.RFC3339),
+	}
+
+	if key.retiredAt != 0 {
+		info.RetiredAt = FormatUnixTimestampToString(key.retiredAt, time.RFC3339)
+	}
+
+	return info
+}
+
+// 署名鍵一覧を取得
+func GetSigningKeys() ([]SigningKeyInfo, error) {
+	// 最新の状態を読み込む
+	if err := jwtKeyring.load(); err != nil {
+		return nil, err
+	}
+
+	jwtKeyring.mutex.RLock()
+	defer jwtKeyring.mutex.RUnlock()
+
+	infos := []SigningKeyInfo{}
+	for _, key := range jwtKeyring.keys {
+		infos = append(infos, toSigningKeyInfo(key, jwtKeyring.active))
+	}
+
+	return infos, nil
+}
+
+// 新しい鍵を生成して現役にする (古い鍵は検証用に残す)
+func RotateSigningKey() (SigningKeyInfo, error) {
+	// 鍵を生成
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SigningKeyInfo{}, err
+	}
+
+	pemString, err := encodePrivateKeyPem(privateKey)
+	if err != nil {
+		return SigningKeyInfo{}, err
+	}
+
+	// 暗号化する
+	stored, err := sealPrivateKey(pemString)
+	if err != nil {
+		return SigningKeyInfo{}, err
+	}
+
+	// 登録する
+	record := models.SigningKey{
+		KeyID:      jwkThumbprint(publicKey),
+		PrivateKey: stored,
+	}
+
+	if err := models.RotateSigningKey(&record, time.Now().Unix()); err != nil {
+		return SigningKeyInfo{}, err
+	}
+
+	// 読み込み直す
+	if err := jwtKeyring.load(); err != nil {
+		return SigningKeyInfo{}, err
+	}
+
+	logger.Println("署名鍵をローテーションしました: " + record.KeyID)
+
+	return SigningKeyInfo{
+		KeyID:     record.KeyID,
+		IsActive:  true,
+		CreatedAt: FormatUnixTimestampToString(record.CreatedAt, time.RFC3339),
+	}, nil
+}
+
+// ここまで
@@ -70,6 +70,11 @@ func DeleteLabel(args DeleteLabelArgs) error {
 		return err
 	}
 
+	// 同時ログイン数の上限を削除する
+	if err := models.DeleteLabelSessionLimit(label.Name); err != nil {
+		return err
+	}
+
 	// ラベルを削除する
 	return models.DeleteLabel(label)
 }
@@ -87,6 +92,13 @@ func UpdateLabel(args LabelUpdateArgs) error {
 		return err
 	}
 
+	// 同時ログイン数の上限をラベル名に追従させる
+	if label.Name != args.Name && args.Name != "" {
+		if err := models.RenameSessionLimitLabel(label.Name, args.Name); err != nil {
+			return err
+		}
+	}
+
 	// ラベルを更新する
 	label.Name = args.Name
 	label.Color = args.Color
@@ -0,0 +1,627 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/structs"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// LDAP サーバーとの通信のタイムアウト
+	ldapTimeout = 10 * time.Second
+)
+
+var (
+	// ユーザー名ごとのログイン試行回数 (15分に10回まで)
+	ldapLoginLimiter = newRateLimiter(10, 15*time.Minute)
+
+	// ユーザー名かパスワードが違う時のエラー
+	ErrInvalidLdapCredentials = errors.New("invalid username or password")
+)
+
+// LDAP の属性の割り当て
+type LdapAttributes struct {
+	UserID string `json:"UserID"` // 変わらない ID (OpenLDAP: entryUUID, AD: objectGUID)
+	Name   string `json:"Name"`   // 名前 (cn, displayName など)
+	Email  string `json:"Email"`  // メールアドレス (mail)
+	Groups string `json:"Groups"` // 所属グループ (memberOf)
+}
+
+// LDAP の設定
+type LdapConfig struct {
+	URL                string            `json:"URL"`                // ldap://host:389 または ldaps://host:636
+	StartTLS           bool              `json:"StartTLS"`           // ldap:// の時に StartTLS を使うか
+	InsecureSkipVerify bool              `json:"InsecureSkipVerify"` // 証明書を検証しないか (テスト用)
+	BindDN             string            `json:"BindDN"`             // 検索に使うアカウント (空の時は匿名)
+	BindPassword       string            `json:"BindPassword"`       // 検索に使うアカウントのパスワード
+	SearchBase         string            `json:"SearchBase"`         // ユーザーを検索するベース DN
+	UserFilter         string            `json:"UserFilter"`         // ユーザーの検索フィルタ (%s がユーザー名に置き換わる)
+	GroupSearchBase    string            `json:"GroupSearchBase"`    // グループを検索するベース DN (空の時は Groups 属性を使う)
+	GroupFilter        string            `json:"GroupFilter"`        // グループの検索フィルタ (%s がユーザーの DN に置き換わる)
+	Attributes         LdapAttributes    `json:"Attributes"`         // 属性の割り当て
+	GroupLabels        map[string]string `json:"GroupLabels"`        // グループ DN とラベル名の割り当て
+	TrustEmail         bool              `json:"TrustEmail"`         // ディレクトリのメールアドレスを確認済みとして扱うか (管理者がメールアドレスを管理している時のみ)
+}
+
+// デフォルト値を設定する
+func (config *LdapConfig) setDefaults() {
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+
+	if config.GroupFilter == "" {
+		config.GroupFilter = "(member=%s)"
+	}
+
+	if config.Attributes.UserID == "" {
+		config.Attributes.UserID = "entryUUID"
+	}
+
+	if config.Attributes.Name == "" {
+		config.Attributes.Name = "cn"
+	}
+
+	if config.Attributes.Email == "" {
+		config.Attributes.Email = "mail"
+	}
+
+	if config.Attributes.Groups == "" {
+		config.Attributes.Groups = "memberOf"
+	}
+
+	if config.GroupLabels == nil {
+		config.GroupLabels = map[string]string{}
+	}
+}
+
+// 設定を検証する
+func (config LdapConfig) validate() error {
+	// URL を検証
+	serverURL, err := url.Parse(config.URL)
+	if err != nil || (serverURL.Scheme != "ldap" && serverURL.Scheme != "ldaps") || serverURL.Host == "" {
+		return errors.New("URL must be an ldap:// or ldaps:// URL")
+	}
+
+	if config.SearchBase == "" {
+		return errors.New("SearchBase is required")
+	}
+
+	if !strings.Contains(config.UserFilter, "%s") {
+		return errors.New("UserFilter must contain %s")
+	}
+
+	if config.GroupSearchBase != "" && !strings.Contains(config.GroupFilter, "%s") {
+		return errors.New("GroupFilter must contain %s")
+	}
+
+	// ラベルが存在するか確認
+	for group, labelName := range config.GroupLabels {
+		if _, err := models.GetLabel(labelName); err != nil {
+			return errors.New("label not found for group " + group + ": " + labelName)
+		}
+	}
+
+	return nil
+}
+
+// 設定を読み込む
+func parseLdapConfig(raw string) (LdapConfig, error) {
+	config := LdapConfig{}
+
+	// 空でない時
+	if strings.TrimSpace(raw) != "" {
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			return LdapConfig{}, err
+		}
+	}
+
+	config.setDefaults()
+	return config, nil
+}
+
+// LDAP サーバーに接続する
+func dialLdap(config LdapConfig) (*ldap.Conn, error) {
+	// TLS の設定
+	serverURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	// 接続する
+	conn, err := ldap.DialURL(
+		config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(ldapTimeout)
+
+	// StartTLS
+	if config.StartTLS && serverURL.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// 検索用のアカウントで bind する
+func bindLdapService(conn *ldap.Conn, config LdapConfig) error {
+	// 匿名の時
+	if config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+
+	return conn.Bind(config.BindDN, config.BindPassword)
+}
+
+// 属性の値を文字列で取得する (バイナリの時は base64url)
+func ldapAttributeString(entry *ldap.Entry, attribute string) string {
+	// DN の時
+	if strings.EqualFold(attribute, "dn") {
+		return entry.DN
+	}
+
+	raw := entry.GetRawAttributeValue(attribute)
+
+	// 文字列の時
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	// objectGUID など
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ディレクトリのユーザー
+type ldapUser struct {
+	DN     string   // ユーザーの DN
+	UserID string   // 変わらない ID
+	Name   string   // 名前
+	Email  string   // メールアドレス
+	Groups []string // 所属グループの DN
+}
+
+// ユーザーを検索してパスワードを確認する
+func authenticateLdap(config LdapConfig, username string, password string) (ldapUser, error) {
+	// 空のパスワードは未認証 bind になるので拒否する
+	if username == "" || password == "" {
+		return ldapUser{}, ErrInvalidLdapCredentials
+	}
+
+	// 接続する
+	conn, err := dialLdap(config)
+	if err != nil {
+		return ldapUser{}, err
+	}
+	defer conn.Close()
+
+	// 検索用のアカウントで bind
+	if err := bindLdapService(conn, config); err != nil {
+		return ldapUser{}, err
+	}
+
+	// ユーザーを検索
+	attributes := []string{config.Attributes.UserID, config.Attributes.Name, config.Attributes.Email, config.Attributes.Groups}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		config.SearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		strings.ReplaceAll(config.UserFilter, "%s", ldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	))
+
+	// エラー処理 (見つからない時もサイズ超過の時も認証失敗にする)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return ldapUser{}, err
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return ldapUser{}, ErrInvalidLdapCredentials
+	}
+
+	entry := result.Entries[0]
+
+	// ユーザーとして bind してパスワードを確認する
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ldapUser{}, ErrInvalidLdapCredentials
+		}
+		return ldapUser{}, err
+	}
+
+	user := ldapUser{
+		DN:     entry.DN,
+		UserID: ldapAttributeString(entry, config.Attributes.UserID),
+		Name:   entry.GetAttributeValue(config.Attributes.Name),
+		Email:  strings.TrimSpace(entry.GetAttributeValue(config.Attributes.Email)),
+		Groups: entry.GetAttributeValues(config.Attributes.Groups),
+	}
+
+	// ID がない時は DN を使う
+	if user.UserID == "" {
+		user.UserID = strings.ToLower(entry.DN)
+	}
+
+	// 名前がない時はユーザー名を使う
+	if user.Name == "" {
+		user.Name = username
+	}
+
+	// グループを検索する時 (memberOf がないサーバー)
+	if config.GroupSearchBase != "" && len(config.GroupLabels) > 0 {
+		// 検索用のアカウントに戻す
+		if err := bindLdapService(conn, config); err != nil {
+			return ldapUser{}, err
+		}
+
+		groups, err := conn.Search(ldap.NewSearchRequest(
+			config.GroupSearchBase,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false,
+			strings.ReplaceAll(config.GroupFilter, "%s", ldap.EscapeFilter(entry.DN)),
+			[]string{"1.1"},
+			nil,
+		))
+
+		// エラー処理
+		if err != nil {
+			return ldapUser{}, err
+		}
+
+		user.Groups = []string{}
+		for _, group := range groups.Entries {
+			user.Groups = append(user.Groups, group.DN)
+		}
+	}
+
+	return user, nil
+}
+
+// 所属グループからラベルを同期する (割り当てのあるラベルのみ変更する)
+func syncLdapLabels(user *models.User, config LdapConfig, groups []string) error {
+	// 所属しているグループ (DN は大文字小文字を区別しない)
+	memberOf := map[string]bool{}
+	for _, group := range groups {
+		memberOf[strings.ToLower(group)] = true
+	}
+
+	// ラベルごとに所属しているか
+	assign := map[string]bool{}
+	for group, labelName := range config.GroupLabels {
+		assign[labelName] = assign[labelName] || memberOf[strings.ToLower(group)]
+	}
+
+	// 現在のラベル
+	current, err := user.GetLabelNames()
+	if err != nil {
+		return err
+	}
+
+	hasLabel := map[string]bool{}
+	for _, labelName := range current {
+		hasLabel[labelName] = true
+	}
+
+	for labelName, member := range assign {
+		switch {
+		case member && !hasLabel[labelName]:
+			err = user.AddLabel(labelName)
+		case !member && hasLabel[labelName]:
+			err = user.RemoveLabel(labelName)
+		}
+
+		// エラー処理
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ディレクトリの値でユーザーを更新する
+func updateLdapUser(userID string, config LdapConfig, entry ldapUser) error {
+	// ユーザーを取得する
+	user, result := models.GetUser(userID)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 名前とメールアドレスを更新する
+	if user.Name != entry.Name || (entry.Email != "" && user.Email != entry.Email) {
+		user.Name = entry.Name
+
+		// 他のユーザーが使っていない時のみメールアドレスを更新する
+		if entry.Email != "" && user.Email != entry.Email {
+			if _, exists := models.GetUserByEmail(entry.Email); !exists.IsExists {
+				user.Email = entry.Email
+
+				// ディレクトリを信頼しない時は確認し直させる
+				if !config.TrustEmail {
+					user.EmailVerifiedAt = 0
+				}
+			}
+		}
+
+		if err := models.UpdateUser(user); err != nil {
+			return err
+		}
+	}
+
+	// ラベルを同期する
+	if len(config.GroupLabels) > 0 {
+		return syncLdapLabels(user, config, entry.Groups)
+	}
+
+	return nil
+}
+
+type LoginLdapUserArgs struct {
+	Username  string // ユーザー名
+	Password  string // パスワード
+	RemoteIP  string // リモートIP
+	UserAgent string // ユーザーエージェント
+}
+
+// LDAP でログインする
+func LoginLdapUser(args LoginLdapUserArgs) (LoginResult, structs.HttpResult) {
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.Ldap)
+
+	// エラー処理
+	if err != nil {
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusInternalServerError,
+			Message: "failed to get provider",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// 無効の時
+	if provider.IsEnabled == 0 {
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusForbidden,
+			Message: "provider is disabled",
+			Error:   errors.New("provider is disabled"),
+			Success: false,
+		}
+	}
+
+	// 試行回数を制限する (RemoteIP は信頼するプロキシの X-Forwarded-For のみから取る)
+	username := strings.TrimSpace(args.Username)
+	if !ldapLoginLimiter.Allow(strings.ToLower(username) + "|" + args.RemoteIP) {
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusTooManyRequests,
+			Message: ErrTooManyRequests.Error(),
+			Error:   ErrTooManyRequests,
+			Success: false,
+		}
+	}
+
+	// 設定を読み込む
+	config, err := parseLdapConfig(provider.Config)
+
+	// エラー処理
+	if err != nil {
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusInternalServerError,
+			Message: "invalid ldap config",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// 認証する
+	entry, err := authenticateLdap(config, username, args.Password)
+
+	// エラー処理
+	if err != nil {
+		// 認証失敗の時
+		if errors.Is(err, ErrInvalidLdapCredentials) {
+			return LoginResult{}, structs.HttpResult{
+				Code:    http.StatusUnauthorized,
+				Message: err.Error(),
+				Error:   err,
+				Success: false,
+			}
+		}
+
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusBadGateway,
+			Message: "failed to connect to ldap server",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// ユーザーを取得または作成する (管理者が信頼すると設定した時のみメールアドレスを確認済みにする)
+	userID, err := provisionExternalUser(OauthUserArgs{
+		Name:           entry.Name,
+		Email:          entry.Email,
+		ProviderCode:   string(models.Ldap),
+		ProviderUserID: entry.UserID,
+		RemoteIP:       args.RemoteIP,
+		UserAgent:      args.UserAgent,
+		EmailVerified:  config.TrustEmail,
+	})
+
+	// エラー処理
+	if err != nil {
+		// 既存のアカウントと連携が必要な時
+		if errors.Is(err, ErrIdentityLinkRequired) {
+			return LoginResult{}, structs.HttpResult{
+				Code:    http.StatusConflict,
+				Message: err.Error(),
+				Error:   err,
+				Success: false,
+			}
+		}
+
+		return LoginResult{}, structs.HttpResult{
+			Code:    http.StatusInternalServerError,
+			Message: "failed to provision user",
+			Error:   err,
+			Success: false,
+		}
+	}
+
+	// ディレクトリの値で更新する (失敗してもログインは続ける)
+	if err := updateLdapUser(userID, config, entry); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// セッションを作成する (二要素認証が有効な時は待機トークン)
+	login, err := StartSession(SessionArgs{
+		UserID:    userID,
+		RemoteIP:  args.RemoteIP,
+		UserAgent: args.UserAgent,
+	})
+
+	// エラー処理
+	if err != nil {
+		return LoginResult{}, sessionErrorResult(err)
+	}
+
+	return login, structs.HttpResult{
+		Code:    http.StatusOK,
+		Message: "success",
+		Error:   nil,
+		Success: true,
+	}
+}
+
+// ここから LDAP プロバイダの管理
+type LdapProvider struct {
+	ProviderCode string     `json:"ProviderCode"`
+	ProviderName string     `json:"ProviderName"`
+	IsEnabled    int        `json:"IsEnabled"`
+	Config       LdapConfig `json:"Config"`
+}
+
+// LDAP プロバイダを取得
+func GetLdapProvider() (LdapProvider, error) {
+	// データベースから取得
+	provider, err := models.GetProvider(models.Ldap)
+
+	// エラー処理
+	if err != nil {
+		return LdapProvider{}, err
+	}
+
+	// 設定を読み込む
+	config, err := parseLdapConfig(provider.Config)
+
+	// エラー処理
+	if err != nil {
+		return LdapProvider{}, err
+	}
+
+	return LdapProvider{
+		ProviderCode: string(provider.ProviderCode),
+		ProviderName: provider.ProviderName,
+		IsEnabled:    provider.IsEnabled,
+		Config:       config,
+	}, nil
+}
+
+// LDAP プロバイダ更新
+type UpdateLdapProviderArgs struct {
+	IsEnabled int        `json:"IsEnabled"` // 有効状態 (0 or 1)
+	Config    LdapConfig `json:"Config"`    // 設定
+}
+
+func UpdateLdapProvider(args UpdateLdapProviderArgs) error {
+	// 値を検証する
+	if args.IsEnabled != 0 && args.IsEnabled != 1 {
+		return errors.New("IsEnabled must be 0 or 1")
+	}
+
+	args.Config.setDefaults()
+
+	// 有効にする時は設定を検証する
+	if args.IsEnabled == 1 {
+		if err := args.Config.validate(); err != nil {
+			return err
+		}
+	}
+
+	// 設定を変換
+	config, err := json.Marshal(args.Config)
+	if err != nil {
+		return err
+	}
+
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.Ldap)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// データを更新する
+	provider.IsEnabled = args.IsEnabled
+	provider.Config = string(config)
+
+	// プロバイダを更新
+	return models.UpdateOauthProvider(*provider)
+}
+
+// LDAP サーバーに接続できるか確認する
+func TestLdapProvider(config LdapConfig) error {
+	config.setDefaults()
+
+	// 設定を検証する
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	// 接続する
+	conn, err := dialLdap(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 検索用のアカウントで bind
+	if err := bindLdapService(conn, config); err != nil {
+		return err
+	}
+
+	// ベース DN が存在するか確認
+	_, err = conn.Search(ldap.NewSearchRequest(
+		config.SearchBase,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(ldapTimeout.Seconds()), false,
+		"(objectClass=*)",
+		[]string{"dn"},
+		nil,
+	))
+
+	return err
+}
+
+// ここまで
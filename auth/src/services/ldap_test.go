@@ -0,0 +1,271 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// テスト用のディレクトリのエントリ
+type fakeLdapEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// 単純な bind と search だけに答えるプロセス内の LDAP サーバー
+type fakeLdapServer struct {
+	listener net.Listener
+	entries  []fakeLdapEntry
+}
+
+func newFakeLdapServer(t *testing.T, entries []fakeLdapEntry) *fakeLdapServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeLdapServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (server *fakeLdapServer) URL() string {
+	return "ldap://" + server.listener.Addr().String()
+}
+
+func (server *fakeLdapServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			conn.Write(fakeLdapMessage(messageID, fakeLdapResult(ldap.ApplicationBindResponse, server.bind(dn, password))))
+
+		case ldap.ApplicationSearchRequest:
+			base, _ := request.Children[0].Value.(string)
+			filter, err := ldap.DecompileFilter(request.Children[6])
+			if err != nil {
+				conn.Write(fakeLdapMessage(messageID, fakeLdapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)))
+				continue
+			}
+
+			for _, entry := range server.search(base, filter) {
+				conn.Write(fakeLdapMessage(messageID, fakeLdapSearchEntry(entry)))
+			}
+			conn.Write(fakeLdapMessage(messageID, fakeLdapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)))
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// bind の結果コード
+func (server *fakeLdapServer) bind(dn string, password string) uint16 {
+	// 匿名
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	for _, entry := range server.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password != "" && entry.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// (attr=value) と (attr=*) の形のフィルタだけを扱う
+func (server *fakeLdapServer) search(base string, filter string) []fakeLdapEntry {
+	attribute, value, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(filter, "("), ")"), "=")
+	if !ok {
+		return nil
+	}
+
+	results := []fakeLdapEntry{}
+	for _, entry := range server.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.dn), strings.ToLower(base)) {
+			continue
+		}
+
+		for _, candidate := range entry.attributes[attribute] {
+			if value == "*" || strings.EqualFold(candidate, value) {
+				results = append(results, entry)
+				break
+			}
+		}
+	}
+
+	return results
+}
+
+func fakeLdapMessage(messageID interface{}, operation *ber.Packet) []byte {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	envelope.AppendChild(operation)
+
+	return envelope.Bytes()
+}
+
+func fakeLdapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	return result
+}
+
+func fakeLdapSearchEntry(entry fakeLdapEntry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+
+	return result
+}
+
+// テスト用のディレクトリ
+func testLdapDirectory(t *testing.T) *fakeLdapServer {
+	return newFakeLdapServer(t, []fakeLdapEntry{
+		{
+			dn:       "cn=admin,dc=example,dc=com",
+			password: "admin-secret",
+		},
+		{
+			dn:       "uid=alice,ou=people,dc=example,dc=com",
+			password: "alice-secret",
+			attributes: map[string][]string{
+				"uid":       {"alice"},
+				"cn":        {"Alice"},
+				"mail":      {"alice@example.com"},
+				"entryUUID": {"0b6e5c9a-0000-4000-8000-000000000001"},
+				"memberOf":  {"cn=admins,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn: "cn=developers,ou=groups,dc=example,dc=com",
+			attributes: map[string][]string{
+				"member": {"uid=alice,ou=people,dc=example,dc=com"},
+			},
+		},
+	})
+}
+
+func testLdapConfig(serverURL string) LdapConfig {
+	config := LdapConfig{
+		URL:          serverURL,
+		BindDN:       "cn=admin,dc=example,dc=com",
+		BindPassword: "admin-secret",
+		SearchBase:   "ou=people,dc=example,dc=com",
+	}
+	config.setDefaults()
+
+	return config
+}
+
+func TestAuthenticateLdap(t *testing.T) {
+	server := testLdapDirectory(t)
+	config := testLdapConfig(server.URL())
+
+	user, err := authenticateLdap(config, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.DN != "uid=alice,ou=people,dc=example,dc=com" || user.UserID != "0b6e5c9a-0000-4000-8000-000000000001" ||
+		user.Name != "Alice" || user.Email != "alice@example.com" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	if len(user.Groups) != 1 || user.Groups[0] != "cn=admins,ou=groups,dc=example,dc=com" {
+		t.Fatalf("unexpected groups: %v", user.Groups)
+	}
+}
+
+func TestAuthenticateLdapRejects(t *testing.T) {
+	server := testLdapDirectory(t)
+	config := testLdapConfig(server.URL())
+
+	cases := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"unknown user", "bob", "alice-secret"},
+		{"empty password", "alice", ""},
+		{"filter injection", "*", "alice-secret"},
+	}
+
+	for _, c := range cases {
+		if _, err := authenticateLdap(config, c.username, c.password); !errors.Is(err, ErrInvalidLdapCredentials) {
+			t.Fatalf("%s: expected ErrInvalidLdapCredentials, got %v", c.name, err)
+		}
+	}
+
+	// 検索用のアカウントが違う時は認証失敗ではなくエラーにする
+	config.BindPassword = "wrong"
+	if _, err := authenticateLdap(config, "alice", "alice-secret"); err == nil || errors.Is(err, ErrInvalidLdapCredentials) {
+		t.Fatalf("expected service bind error, got %v", err)
+	}
+}
+
+func TestAuthenticateLdapGroupSearch(t *testing.T) {
+	server := testLdapDirectory(t)
+	config := testLdapConfig(server.URL())
+	config.GroupSearchBase = "ou=groups,dc=example,dc=com"
+	config.GroupLabels = map[string]string{"cn=developers,ou=groups,dc=example,dc=com": "developer"}
+
+	user, err := authenticateLdap(config, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// memberOf ではなく検索した結果を使う
+	if len(user.Groups) != 1 || user.Groups[0] != "cn=developers,ou=groups,dc=example,dc=com" {
+		t.Fatalf("unexpected groups: %v", user.Groups)
+	}
+}
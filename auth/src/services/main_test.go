@@ -0,0 +1,128 @@
+package services
+
+import (
+	"auth/mailer"
+	"auth/models"
+	"auth/utils"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+// データベースを使うテストの準備 (TEST_DB_DSN がない時はスキップする)
+func requireTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	testDBOnce.Do(func() {
+		os.Setenv("DB_DSN", dsn)
+		TokenSecret = "test-secret"
+		testDBErr = models.Init()
+	})
+
+	if testDBErr != nil {
+		t.Fatal(testDBErr)
+	}
+}
+
+// メールをメモリに送るようにする
+func useMemoryMailer(t *testing.T) *mailer.MemorySender {
+	t.Helper()
+
+	previous := mailer.GetSender()
+	sender := mailer.NewMemorySender()
+	mailer.SetSender(sender)
+	t.Cleanup(func() { mailer.SetSender(previous) })
+
+	return sender
+}
+
+// テスト用の basic ユーザーを作る (終了時に削除する)
+func createTestUser(t *testing.T) *models.User {
+	t.Helper()
+
+	userID := utils.GenID()
+	hashed, err := utils.HashPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{
+		UserID:       userID,
+		Name:         "test",
+		Email:        userID + "@example.com",
+		PasswordHash: hashed,
+		CreatedAt:    utils.NowTime(),
+	}
+	if err := models.CreateUser(user, models.Basic); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { models.DeleteUser(userID) })
+
+	return user
+}
+
+// テスト用のセッションを作る
+func createTestSession(t *testing.T, user *models.User) *models.Session {
+	t.Helper()
+
+	now := time.Now()
+	session := &models.Session{
+		SessionID:  utils.GenID(),
+		UserID:     user.UserID,
+		ExpiresAt:  now.Add(SessionLifetime).Unix(),
+		LastSeenAt: now.Unix(),
+	}
+
+	_, err := models.CreateUserSession(session, func(sessions []models.Session) ([]string, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+// テスト用の署名鍵を使う (終了時に戻す)
+func useTestSigningKey(t *testing.T) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &signingKey{
+		keyID:      "test-" + utils.GenID(),
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		createdAt:  time.Now().Unix(),
+	}
+
+	jwtKeyring.mutex.Lock()
+	previousActive, previousKeys, previousLoadedAt := jwtKeyring.active, jwtKeyring.keys, jwtKeyring.loadedAt
+	jwtKeyring.active = key
+	jwtKeyring.keys = []*signingKey{key}
+	jwtKeyring.loadedAt = time.Now().Add(time.Hour)
+	jwtKeyring.mutex.Unlock()
+
+	t.Cleanup(func() {
+		jwtKeyring.mutex.Lock()
+		defer jwtKeyring.mutex.Unlock()
+
+		jwtKeyring.active, jwtKeyring.keys, jwtKeyring.loadedAt = previousActive, previousKeys, previousLoadedAt
+	})
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	// 二要素認証待機トークンの有効期限
+	mfaChallengeExpiry = time.Minute * 5
+
+	// 二要素認証の最大試行回数
+	mfaMaxAttempts = 5
+
+	// ユーザーごとの連続失敗の上限 (待機トークンを取り直しても数える)
+	mfaMaxFailures = 10
+
+	// 上限に達した時にロックする期間
+	mfaLockDuration = time.Minute * 15
+)
+
+var (
+	// コードが違う時のエラー
+	ErrInvalidTotpCode = errors.New("invalid code")
+
+	// TOTP が有効な時のエラー
+	ErrTotpAlreadyEnabled = errors.New("totp is already enabled")
+
+	// TOTP が無効な時のエラー
+	ErrTotpNotEnabled = errors.New("totp is not enabled")
+
+	// 失敗が続いてロックされている時のエラー
+	ErrMfaLocked = errors.New("too many failed attempts, try again later")
+)
+
+// ログイン結果
+type LoginResult struct {
+	Token       string `json:"token"`       // リフレッシュトークン
+	MfaRequired bool   `json:"mfaRequired"` // 二要素認証が必要か
+	MfaToken    string `json:"mfaToken"`    // 二要素認証待機トークン
+}
+
+// 一要素目の認証が完了した時に呼ぶ
+// 二要素認証が有効な時は待機トークンを、無効な時はリフレッシュトークンを返す
+func StartSession(args SessionArgs) (LoginResult, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(args.UserID)
+
+	// エラー処理
+	if result.Error != nil {
+		return LoginResult{}, result.Error
+	}
+
+	// 二要素認証が無効な時
+	if user.TotpEnabled == 0 {
+		// セッションを作成する
+		token, err := NewSession(args)
+		return LoginResult{Token: token}, err
+	}
+
+	// BANされている時は待機トークンを発行しない
+	if user.IsBanned == 1 {
+		return LoginResult{}, ErrUserBanned
+	}
+
+	// 同時ログイン数の上限で拒否される時も発行しない
+	if err := checkSessionLimit(user); err != nil {
+		return LoginResult{}, err
+	}
+
+	// 以前の待機トークンを無効にする (取り直して試行回数を増やせないように)
+	if err := models.RevokeOneTimeTokens(user.UserID, models.PurposeMfa, utils.NowTime()); err != nil {
+		return LoginResult{}, err
+	}
+
+	// 待機トークンを発行する
+	mfaToken, err := IssueOneTimeToken(user, models.PurposeMfa, mfaChallengeExpiry)
+
+	// エラー処理
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	return LoginResult{MfaRequired: true, MfaToken: mfaToken}, nil
+}
+
+type VerifyMfaArgs struct {
+	MfaToken  string // 二要素認証待機トークン
+	Code      string // TOTP コード
+	RemoteIP  string // リモートIP
+	UserAgent string // ユーザーエージェント
+}
+
+// 待機トークンとコードをリフレッシュトークンに交換する
+func VerifyMfa(args VerifyMfaArgs) (string, error) {
+	// トークンを検証
+	user, record, err := parseOneTimeToken(args.MfaToken, models.PurposeMfa)
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	// コードを検証する
+	if err := verifyTotpCode(user, args.Code); err != nil {
+		// 失敗回数を増やす
+		attempts, aerr := models.IncrementOneTimeTokenAttempts(record.TokenID)
+
+		// 上限に達した時はトークンを無効にする
+		if aerr == nil && attempts >= mfaMaxAttempts {
+			models.UseOneTimeToken(record.TokenID, utils.NowTime())
+		}
+
+		return "", err
+	}
+
+	// 使用済みにする
+	ok, err := models.UseOneTimeToken(record.TokenID, utils.NowTime())
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	// 同時に使用された時
+	if !ok {
+		return "", ErrInvalidOneTimeToken
+	}
+
+	// セッションを作成する
+	return NewSession(SessionArgs{
+		UserID:    user.UserID,
+		RemoteIP:  args.RemoteIP,
+		UserAgent: args.UserAgent,
+	})
+}
+
+// TOTP コードを検証して使用済みのステップを記録する
+func verifyTotpCode(user *models.User, code string) error {
+	// シークレットがない時
+	if user.TotpSecret == "" {
+		return ErrTotpNotEnabled
+	}
+
+	// ロックされている時
+	now := time.Now()
+	if user.MfaLockedUntil > now.Unix() {
+		return ErrMfaLocked
+	}
+
+	// コードを検証する
+	step, ok := utils.ValidateTotp(user.TotpSecret, code, now)
+
+	// ステップを記録する (同時に同じコードが使われた時は片方だけ成功する)
+	if ok {
+		used, err := models.UseTotpStep(user.UserID, step)
+		if err != nil {
+			return err
+		}
+
+		if used {
+			user.TotpLastStep = step
+			user.MfaFailures = 0
+			return nil
+		}
+	}
+
+	// 一致しない、もしくは使用済みのコードの時は失敗回数を増やす
+	failures, err := models.IncrementMfaFailures(user.UserID)
+	if err != nil {
+		return err
+	}
+
+	// 上限に達した時はロックする
+	if failures >= mfaMaxFailures {
+		if err := models.LockMfa(user.UserID, now.Add(mfaLockDuration).Unix()); err != nil {
+			return err
+		}
+
+		return ErrMfaLocked
+	}
+
+	return ErrInvalidTotpCode
+}
+
+// ここから TOTP の登録
+type TotpSetup struct {
+	Secret string `json:"secret"` // base32 シークレット
+	URI    string `json:"uri"`    // otpauth:// URI (QR コード用)
+}
+
+// TOTP のシークレットを生成する (確認するまで有効にならない)
+func SetupTotp(userID string) (TotpSetup, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return TotpSetup{}, result.Error
+	}
+
+	// 既に有効な時
+	if user.TotpEnabled == 1 {
+		return TotpSetup{}, ErrTotpAlreadyEnabled
+	}
+
+	// シークレットを生成
+	secret, err := utils.GenTotpSecret()
+
+	// エラー処理
+	if err != nil {
+		return TotpSetup{}, err
+	}
+
+	// 保存する
+	user.TotpSecret = secret
+	user.TotpLastStep = 0
+	if err := models.UpdateUser(user); err != nil {
+		return TotpSetup{}, err
+	}
+
+	// 発行者名
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "AuthBase"
+	}
+
+	return TotpSetup{
+		Secret: secret,
+		URI:    utils.TotpURI(issuer, user.Email, secret),
+	}, nil
+}
+
+// コードを確認して TOTP を有効にする
+func ConfirmTotp(userID string, code string) error {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 既に有効な時
+	if user.TotpEnabled == 1 {
+		return ErrTotpAlreadyEnabled
+	}
+
+	// コードを検証する
+	if err := verifyTotpCode(user, code); err != nil {
+		return err
+	}
+
+	// 有効にする
+	user.TotpEnabled = 1
+	return models.UpdateUser(user)
+}
+
+// コードを確認して TOTP を無効にする
+func DisableTotp(userID string, code string) error {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 無効な時
+	if user.TotpEnabled == 0 {
+		return ErrTotpNotEnabled
+	}
+
+	// コードを検証する
+	if err := verifyTotpCode(user, code); err != nil {
+		return err
+	}
+
+	// 無効にする
+	user.TotpEnabled = 0
+	user.TotpSecret = ""
+	user.TotpLastStep = 0
+	return models.UpdateUser(user)
+}
+
+// 管理者が TOTP をリセットする (端末を失くした時など)
+func ResetMfa(userID string) error {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if !result.IsExists {
+		return ErrUserNotFound
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 無効にする
+	user.TotpEnabled = 0
+	user.TotpSecret = ""
+	user.TotpLastStep = 0
+	user.MfaFailures = 0
+	user.MfaLockedUntil = 0
+	if err := models.UpdateUser(user); err != nil {
+		return err
+	}
+
+	// 認証情報が変わったのでセッションと発行済みのトークンを失効させる
+	return RevokeUserTokens(userID, RevocationMfaReset)
+}
+
+// ここまで
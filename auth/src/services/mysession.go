@@ -0,0 +1,90 @@
+package services
+
+import (
+	"auth/models"
+	"errors"
+	"time"
+)
+
+var (
+	// セッションが見つからない時のエラー
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// ユーザー自身に見せるセッション
+type MySession struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`  // desktop / mobile / tablet / bot / unknown
+	Browser    string `json:"browser"` // ブラウザ名とバージョン
+	OS         string `json:"os"`
+	UserAgent  string `json:"userAgent"`
+	IPAddress  string `json:"ipAddress"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+	Current    bool   `json:"current"` // このリクエストのセッションか
+}
+
+// ユーザーの有効なセッション一覧を取得
+func GetMySessions(userID string, currentSessionID string) ([]MySession, error) {
+	sessions, err := models.GetUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	results := []MySession{}
+
+	for _, session := range sessions {
+		// 期限切れのセッションは見せない (削除は定期処理に任せる)
+		expiresAt := sessionExpiresAt(&session)
+		if now > expiresAt {
+			continue
+		}
+
+		agent := parseUserAgent(session.UserAgent)
+
+		results = append(results, MySession{
+			ID:         session.SessionID,
+			Device:     agent.Device,
+			Browser:    agent.Browser,
+			OS:         agent.OS,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.RemoteIP,
+			CreatedAt:  session.CreatedAt * 1000,
+			LastSeenAt: session.LastSeenAt * 1000,
+			ExpiresAt:  expiresAt * 1000,
+			Current:    session.SessionID == currentSessionID,
+		})
+	}
+
+	return results, nil
+}
+
+// ユーザーのセッションを終了する
+func RevokeMySession(userID string, sessionID string) error {
+	// 他のユーザーのセッションは終了させない
+	session, err := models.GetSession(sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	return RevokeSessionTokens(userID, sessionID, RevocationLogout)
+}
+
+// 今のセッション以外を全て終了する (終了した数を返す)
+func RevokeOtherSessions(userID string, currentSessionID string) (int64, error) {
+	sessionIDs, err := models.DeleteOtherUserSessions(userID, currentSessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	// 終了したセッションのトークンを失効させる
+	for _, sessionID := range sessionIDs {
+		if err := recordRevocation(userID, sessionID, RevocationLogout); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(sessionIDs)), nil
+}
@@ -6,23 +6,59 @@ import (
 	"errors"
 )
 
+var (
+	// 同じメールアドレスのユーザーに自動で連携できない時のエラー
+	ErrIdentityLinkRequired = errors.New("このメールアドレスは既に登録されています。ログインしてからアカウントを連携してください")
+)
+
 type OauthUserArgs struct {
-	Name           string // ユーザー名
-	Email          string // メールアドレス
+	Name           string // ユーザー名
+	Email          string // メールアドレス
 	ProviderCode   string // 認証プロバイダコード
 	ProviderUserID string // 認証プロバイダユーザーID
 	RemoteIP       string // IPアドレス
 	UserAgent      string // User-Agent
 	AvaterURL      string // アバターURL
+	EmailVerified  bool   // プロバイダがメールアドレスを確認済みか
 }
 
 // Oauthユーザーを作成する
-func LoginOauthUser(args OauthUserArgs) (string, error) {
-	// UUID を生成
-	uid := utils.GenID()
+func LoginOauthUser(args OauthUserArgs) (LoginResult, error) {
+	// ユーザーを取得または作成する
+	userID, err := provisionExternalUser(args)
 
-	// 現在時刻を取得
-	now := utils.NowTime()
+	// エラー処理
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	// セッションを追加する (二要素認証が有効な時は待機トークン)
+	return StartSession(SessionArgs{
+		UserID:    userID,
+		RemoteIP:  args.RemoteIP,
+		UserAgent: args.UserAgent,
+	})
+}
+
+// 外部プロバイダのユーザーを取得または作成する (返却値: ユーザーID)
+func provisionExternalUser(args OauthUserArgs) (string, error) {
+	// プロバイダのユーザーIDがない時
+	if args.ProviderUserID == "" {
+		return "", errors.New("プロバイダのユーザーIDの取得に失敗しました")
+	}
+
+	// 連携を取得する
+	identity, iresult := models.GetIdentity(models.ProviderCode(args.ProviderCode), args.ProviderUserID)
+
+	// 連携済みの時
+	if iresult.IsExists {
+		// エラー処理
+		if iresult.Error != nil {
+			return "", iresult.Error
+		}
+
+		return identity.UserID, nil
+	}
 
 	// メールアドレスがない時
 	if args.Email == "" {
@@ -30,38 +66,49 @@ func LoginOauthUser(args OauthUserArgs) (string, error) {
 	}
 
 	// ユーザーを取得する
-	user, result := models.GetUserByEmail(args.Email)
+	_, result := models.GetUserByEmail(args.Email)
 
-	// 存在する時
+	// 存在する時は自動で連携しない (ログインしてから /me/identities/link で連携させる)
+	// プロバイダによってはメールアドレスを確認していないため、他人のアカウントを乗っ取られないようにする
 	if result.IsExists {
-		// ユーザーが取得できた時
-
-		// プロバイダが同じかどうか
-		if user.ProvUID != args.ProviderUserID {
-			return "", errors.New("同一プロバイダのユーザーが見つかりません")
+		// エラー処理
+		if result.Error != nil {
+			return "", result.Error
 		}
 
-		// セッションを追加する
-		token, err := NewSession(SessionArgs{
-			UserID:    user.UserID,
-			RemoteIP:  args.RemoteIP,
-			UserAgent: args.UserAgent,
-		})
-
-		return token, err
+		return "", ErrIdentityLinkRequired
 	}
 
 	// 存在しない時
 
+	// UUID を生成
+	uid := utils.GenID()
+
+	// 現在時刻を取得
+	now := utils.NowTime()
+
+	// プロバイダが確認したメールアドレスの時のみ確認済みにする
+	var emailVerifiedAt int64
+	if args.EmailVerified {
+		emailVerifiedAt = now
+	}
+
 	// ユーザーを作成する
-	err := models.CreateUser(&models.User{
-		UserID:       uid,
-		Name:         args.Name,
-		Email:        args.Email,
-		PasswordHash: "",
-		ProvUID:      args.ProviderUserID,
-		CreatedAt:    now,
-	}, models.ProviderCode(args.ProviderCode))
+	newUser := &models.User{
+		UserID:          uid,
+		Name:            args.Name,
+		Email:           args.Email,
+		PasswordHash:    "",
+		ProvUID:         args.ProviderUserID,
+		CreatedAt:       now,
+		EmailVerifiedAt: emailVerifiedAt,
+	}
+	// 連携も同時に作成する (連携のないユーザーが残らないように)
+	err := models.CreateUserWithIdentity(newUser, models.ProviderCode(args.ProviderCode), &models.Identity{
+		ProvCode: models.ProviderCode(args.ProviderCode),
+		ProvUID:  args.ProviderUserID,
+		Email:    args.Email,
+	})
 
 	// エラー処理
 	if err != nil {
@@ -71,7 +118,7 @@ func LoginOauthUser(args OauthUserArgs) (string, error) {
 	// 画像を保存する (10mb まで)
 	if args.AvaterURL != "" {
 		// 画像を保存
-		err = ProcessImageFromURL(IconDir + "/" + uid + ".png", args.AvaterURL, MaxImageSize, 10)
+		err = ProcessImageFromURL(IconDir+"/"+uid+".png", args.AvaterURL, MaxImageSize, 10)
 
 		// エラー処理
 		if err != nil {
@@ -79,17 +126,5 @@ func LoginOauthUser(args OauthUserArgs) (string, error) {
 		}
 	}
 
-	// トークンを生成
-	token, err := NewSession(SessionArgs{
-		UserID:    uid,
-		RemoteIP:  args.RemoteIP,
-		UserAgent: args.UserAgent,
-	})
-
-	// エラー処理
-	if err != nil {
-		return "", err
-	}
-
-	return token, nil
+	return uid, nil
 }
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"testing"
+)
+
+func TestProvisionExternalUserDoesNotAutoLink(t *testing.T) {
+	requireTestDB(t)
+	user := createTestUser(t)
+
+	// 確認済みのアカウントでも同じメールアドレスでは連携しない
+	user.EmailVerifiedAt = utils.NowTime()
+	if err := models.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	args := OauthUserArgs{
+		Name:           "attacker",
+		Email:          user.Email,
+		ProviderCode:   string(models.Basic),
+		ProviderUserID: utils.GenID(),
+		EmailVerified:  true,
+	}
+
+	if _, err := provisionExternalUser(args); !errors.Is(err, ErrIdentityLinkRequired) {
+		t.Fatalf("expected ErrIdentityLinkRequired, got %v", err)
+	}
+
+	// 連携済みの時はそのユーザーになる
+	if err := user.AddIdentity(&models.Identity{
+		ProvCode: models.ProviderCode(args.ProviderCode),
+		ProvUID:  args.ProviderUserID,
+		Email:    args.Email,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := provisionExternalUser(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.UserID {
+		t.Fatalf("expected %s, got %s", user.UserID, userID)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// トークンが無効な時のエラー
+	ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+)
+
+// 署名付きのワンタイムトークンを発行する
+func IssueOneTimeToken(user *models.User, purpose models.TokenPurpose, ttl time.Duration) (string, error) {
+	return issueOneTimeToken(user, purpose, ttl, "")
+}
+
+// ワンタイムトークンを発行する (binding がある時は同じ値を持つブラウザでしか使えない)
+func issueOneTimeToken(user *models.User, purpose models.TokenPurpose, ttl time.Duration, binding string) (string, error) {
+	// トークンID を生成
+	tokenID := utils.GenID()
+
+	// 有効期限
+	expiresAt := time.Now().Add(ttl)
+
+	// データベースに保存する
+	err := models.CreateOneTimeToken(&models.OneTimeToken{
+		TokenID:   tokenID,
+		UserID:    user.UserID,
+		Purpose:   purpose,
+		ExpiresAt: expiresAt.Unix(),
+	})
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	return signOneTimeToken(tokenID, user, purpose, expiresAt, binding)
+}
+
+// ワンタイムトークンのクレーム
+type oneTimeClaims struct {
+	TokenID string
+	UserID  string
+	Purpose models.TokenPurpose
+	Email   string
+	Binding string // ブラウザに結び付けた値のハッシュ
+}
+
+// ワンタイムトークンに署名する
+func signOneTimeToken(tokenID string, user *models.User, purpose models.TokenPurpose, expiresAt time.Time, binding string) (string, error) {
+	claims := jwt.MapClaims{
+		"jti":     tokenID,
+		"sub":     user.UserID,
+		"purpose": string(purpose),
+		// メールアドレスが変わった時に無効にする
+		"email": user.Email,
+		"exp":   expiresAt.Unix(),
+	}
+
+	// ブラウザに結び付ける時はハッシュだけを入れる
+	if binding != "" {
+		claims["bnd"] = hashSecret(binding)
+	}
+
+	// トークンを生成
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+
+	// トークンに署名
+	return token.SignedString([]byte(TokenSecret))
+}
+
+// ワンタイムトークンの署名と用途を検証してクレームを取り出す
+func parseOneTimeClaims(tokenString string, purpose models.TokenPurpose) (*oneTimeClaims, error) {
+	// トークンを検証
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(TokenSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}), jwt.WithExpirationRequired())
+
+	// エラー処理
+	if err != nil {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	// クレームを取得
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	claims := &oneTimeClaims{}
+	claims.TokenID, _ = mapClaims["jti"].(string)
+	claims.UserID, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Binding, _ = mapClaims["bnd"].(string)
+	tokenPurpose, _ := mapClaims["purpose"].(string)
+	claims.Purpose = models.TokenPurpose(tokenPurpose)
+
+	// 用途が違う時
+	if claims.Purpose != purpose || claims.TokenID == "" || claims.UserID == "" {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	return claims, nil
+}
+
+// 保存されたトークンがクレームに対して有効か
+func isOneTimeRecordValid(record *models.OneTimeToken, claims *oneTimeClaims, now int64) bool {
+	return record.UsedAt == 0 && record.ExpiresAt > now && record.UserID == claims.UserID && record.Purpose == claims.Purpose
+}
+
+// ワンタイムトークンを検証する (使用済みにはしない)
+func parseOneTimeToken(tokenString string, purpose models.TokenPurpose) (*models.User, *models.OneTimeToken, error) {
+	// トークンを検証
+	claims, err := parseOneTimeClaims(tokenString, purpose)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// データベースから取得する
+	record, result := models.GetOneTimeToken(claims.TokenID)
+	if result.Error != nil {
+		return nil, nil, ErrInvalidOneTimeToken
+	}
+
+	// 使用済み、期限切れ、ユーザー違いの時
+	if !isOneTimeRecordValid(record, claims, utils.NowTime()) {
+		return nil, nil, ErrInvalidOneTimeToken
+	}
+
+	// ユーザーを取得する
+	user, uresult := models.GetUser(claims.UserID)
+	if uresult.Error != nil {
+		return nil, nil, ErrInvalidOneTimeToken
+	}
+
+	// メールアドレスが変わっている時
+	if user.Email != claims.Email {
+		return nil, nil, ErrInvalidOneTimeToken
+	}
+
+	return user, record, nil
+}
+
+// ワンタイムトークンを使用する
+func ConsumeOneTimeToken(tokenString string, purpose models.TokenPurpose) (*models.User, error) {
+	// トークンを検証
+	user, record, err := parseOneTimeToken(tokenString, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	// 使用済みにする
+	ok, err := models.UseOneTimeToken(record.TokenID, utils.NowTime())
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	// 同時に使用された時
+	if !ok {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	return user, nil
+}
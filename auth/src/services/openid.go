@@ -0,0 +1,228 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// ID トークンの有効期限
+	idTokenExpiry = time.Minute * 10
+)
+
+var (
+	// OpenID Connect の標準スコープ (クライアントの設定に関係なく要求できる)
+	openidScopes = []string{"openid", "profile", "email"}
+
+	// アクセストークンが無効な時のエラー
+	ErrInvalidAccessToken = errors.New("invalid access token")
+
+	// openid スコープがないトークンの時のエラー
+	ErrInsufficientScope = errors.New("access token does not have the openid scope")
+)
+
+// ここから鍵の公開
+type Jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JwkSet struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// JWK Thumbprint を計算する (RFC 7638, RFC 8037)
+func jwkThumbprint(publicKey ed25519.PublicKey) string {
+	// 必須メンバーを辞書順に並べる
+	canonical := `{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(publicKey) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// 署名の検証に使う公開鍵一覧 (退役した鍵も含む)
+func GetJwks() JwkSet {
+	keys := []Jwk{}
+
+	for _, key := range jwtKeyring.publicKeys() {
+		keys = append(keys, Jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.publicKey),
+			Kid: key.keyID,
+			Use: "sig",
+			Alg: jwt.SigningMethodEdDSA.Alg(),
+		})
+	}
+
+	return JwkSet{Keys: keys}
+}
+
+// ここまで
+
+// ここからディスカバリ
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// ディスカバリドキュメントを生成する
+func GetOpenIDConfiguration() OpenIDConfiguration {
+	return OpenIDConfiguration{
+		Issuer:                            PublicURL,
+		AuthorizationEndpoint:             PublicURL + "/oauth2/authorize",
+		TokenEndpoint:                     PublicURL + "/oauth2/token",
+		UserinfoEndpoint:                  PublicURL + "/userinfo",
+		IntrospectionEndpoint:             PublicURL + "/oauth2/introspect",
+		RevocationEndpoint:                PublicURL + "/oauth2/revoke",
+		DeviceAuthorizationEndpoint:       PublicURL + "/oauth2/device_authorization",
+		JwksURI:                           PublicURL + "/.well-known/jwks.json",
+		ScopesSupported:                   openidScopes,
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", deviceCodeGrantType, "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodEdDSA.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "name", "picture", "email", "email_verified"},
+	}
+}
+
+// ここまで
+
+// ここから ID トークンとユーザー情報
+// スコープに応じたユーザーのクレーム
+func userClaims(userID string, scopes []string) (jwt.MapClaims, error) {
+	// ユーザーを取得
+	info, err := GetMe(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{
+		"sub": info.UserID,
+	}
+
+	// プロフィール
+	if slices.Contains(scopes, "profile") {
+		claims["name"] = info.Name
+		claims["picture"] = PublicURL + "/icon/" + info.UserID
+	}
+
+	// メールアドレス
+	if slices.Contains(scopes, "email") && info.Email != "" {
+		claims["email"] = info.Email
+		claims["email_verified"] = info.EmailVerified
+	}
+
+	return claims, nil
+}
+
+type IDTokenClaim struct {
+	UserID   string   // ユーザーID
+	ClientID string   // 発行先のクライアントID
+	Nonce    string   // 認可リクエストの nonce
+	AuthTime int64    // ログインした日時
+	Scopes   []string // 認可されたスコープ
+}
+
+// ID トークンを発行する
+func IDTokenJwt(args IDTokenClaim) (string, error) {
+	// ユーザーのクレーム
+	claims, err := userClaims(args.UserID, args.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims["iss"] = PublicURL
+	claims["aud"] = args.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenExpiry).Unix()
+	claims["auth_time"] = args.AuthTime
+
+	if args.Nonce != "" {
+		claims["nonce"] = args.Nonce
+	}
+
+	return signJwt(claims, "")
+}
+
+// アクセストークンからユーザー情報を取得する
+func GetUserinfo(tokenString string) (jwt.MapClaims, error) {
+	// トークンを検証
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// 返せるスコープ
+	scopes, err := userinfoScopes(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// 発行元のセッションが終了している時
+	if sessionID, _ := claims["sid"].(string); sessionID != "" {
+		if _, err := getActiveSession(sessionID); err != nil {
+			return nil, ErrInvalidAccessToken
+		}
+	}
+
+	userID, _ := claims["sub"].(string)
+	return userClaims(userID, scopes)
+}
+
+// トークンのクレームからユーザー情報として返せるスコープを決める
+func userinfoScopes(claims jwt.MapClaims) ([]string, error) {
+	// サービスアカウントにはユーザー情報がない
+	if claims["principalType"] == principalServiceAccount {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if userID, _ := claims["sub"].(string); userID == "" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// 自分のトークンは標準スコープを全て返す
+	if _, ok := claims["aud"]; !ok {
+		return openidScopes, nil
+	}
+
+	// クライアントに発行したトークンはスコープで制限する (openid が必要)
+	scope, _ := claims["scope"].(string)
+	scopes := splitScope(scope)
+	if !slices.Contains(scopes, "openid") {
+		return nil, ErrInsufficientScope
+	}
+
+	return scopes, nil
+}
+
+// ここまで
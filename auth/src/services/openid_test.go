@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestUserinfoScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "first party token",
+			claims: jwt.MapClaims{"sub": "user-1", "principalType": principalUser},
+			want:   openidScopes,
+		},
+		{
+			name:   "client token with openid",
+			claims: jwt.MapClaims{"sub": "user-1", "aud": "client-1", "scope": "openid email"},
+			want:   []string{"openid", "email"},
+		},
+		{
+			name:    "client token without openid",
+			claims:  jwt.MapClaims{"sub": "user-1", "aud": "client-1", "scope": "profile email"},
+			wantErr: ErrInsufficientScope,
+		},
+		{
+			name:    "client token without scope",
+			claims:  jwt.MapClaims{"sub": "user-1", "aud": "client-1"},
+			wantErr: ErrInsufficientScope,
+		},
+		{
+			name:    "service account",
+			claims:  jwt.MapClaims{"sub": "account-1", "principalType": principalServiceAccount, "scope": "openid"},
+			wantErr: ErrInvalidAccessToken,
+		},
+		{
+			name:    "no subject",
+			claims:  jwt.MapClaims{"aud": "client-1", "scope": "openid"},
+			wantErr: ErrInvalidAccessToken,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopes, err := userinfoScopes(test.claims)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("userinfoScopes() error = %v, want %v", err, test.wantErr)
+			}
+			if !slices.Equal(scopes, test.want) {
+				t.Fatalf("userinfoScopes() = %v, want %v", scopes, test.want)
+			}
+		})
+	}
+}
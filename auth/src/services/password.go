@@ -0,0 +1,160 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/mailer"
+	"auth/models"
+	"auth/utils"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// パスワードリセットトークンの有効期限
+	resetPasswordExpiry = time.Minute * 30
+)
+
+var (
+	// IP ごとのメール送信リクエスト制限
+	mailRequestIPLimiter = newRateLimiter(10, time.Hour)
+
+	// メールアドレスごとのメール送信制限
+	mailRequestEmailLimiter = newRateLimiter(3, time.Hour)
+)
+
+// メールを送信するリクエストを制限する
+// IP の上限を超えた時はエラーを返し、メールアドレスの上限を超えた時は false を返す
+// (メールアドレスの上限は応答を変えないため、存在の確認には使えない)
+func throttleMailRequest(email string, remoteIP string) (bool, error) {
+	// IP ごとの制限
+	if !mailRequestIPLimiter.Allow(remoteIP) {
+		return false, ErrTooManyRequests
+	}
+
+	// メールアドレスごとの制限 (IP を変えても回数は減らない)
+	return mailRequestEmailLimiter.Allow(strings.ToLower(email)), nil
+}
+
+// パスワードリセットを要求する (ユーザーの存在は返さない)
+func RequestPasswordReset(email string, remoteIP string) error {
+	email = strings.TrimSpace(email)
+
+	// 制限する
+	allowed, err := throttleMailRequest(email, remoteIP)
+	if err != nil {
+		return err
+	}
+
+	if !allowed {
+		return nil
+	}
+
+	// 応答時間で存在を判別されないように非同期で送る
+	go sendPasswordResetEmail(email)
+
+	return nil
+}
+
+// パスワードリセットメールを送信する
+func sendPasswordResetEmail(email string) {
+	// ユーザーを取得する
+	user, result := models.GetUserByEmail(email)
+
+	// 存在しない時
+	if result.Error != nil {
+		return
+	}
+
+	// basic 以外の時
+	if user.ProvCode != models.Basic {
+		return
+	}
+
+	// 以前のトークンを無効にする
+	err := models.RevokeOneTimeTokens(user.UserID, models.PurposeResetPassword, utils.NowTime())
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return
+	}
+
+	// トークンを発行する
+	token, err := IssueOneTimeToken(user, models.PurposeResetPassword, resetPasswordExpiry)
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+		return
+	}
+
+	// リセット用 URL
+	link := PublicURL + "/basic/password/reset?token=" + url.QueryEscape(token)
+
+	// 送信する
+	err = mailer.Send(mailer.Mail{
+		To:      user.Email,
+		Subject: "パスワードの再設定",
+		Body: user.Name + " 様\r\n\r\n" +
+			"以下のリンクからパスワードを再設定してください。\r\n" +
+			link + "\r\n\r\n" +
+			"このリンクの有効期限は 30 分です。\r\n" +
+			"心当たりがない場合はこのメールを破棄してください。\r\n",
+	})
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr(err)
+	}
+}
+
+type ResetPasswordArgs struct {
+	Token    string `json:"token"`    // リセットトークン
+	Password string `json:"password"` // 新しいパスワード
+}
+
+// パスワードを再設定する
+func ResetPassword(args ResetPasswordArgs) error {
+	// パスワードを検証する
+	if err := validatePassword(args.Password); err != nil {
+		return err
+	}
+
+	// トークンを使用する
+	user, err := ConsumeOneTimeToken(args.Token, models.PurposeResetPassword)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// パスワードをハッシュ化する
+	hashed, err := utils.HashPassword(args.Password)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// パスワードを更新する
+	user.PasswordHash = hashed
+
+	// メールを受け取れたので確認済みにする
+	if user.EmailVerifiedAt == 0 {
+		user.EmailVerifiedAt = utils.NowTime()
+	}
+
+	// ユーザーを更新する
+	if err := models.UpdateUser(user); err != nil {
+		return err
+	}
+
+	// 残りのリセットトークンを無効にする
+	if err := models.RevokeOneTimeTokens(user.UserID, models.PurposeResetPassword, utils.NowTime()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// 全てのセッションと発行済みのトークンを失効させる
+	return RevokeUserTokens(user.UserID, RevocationPasswordReset)
+}
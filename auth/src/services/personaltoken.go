@@ -0,0 +1,255 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// パーソナルアクセストークンの接頭辞
+	personalTokenPrefix = "pat_"
+
+	// 有効期限の上限
+	personalTokenMaxExpiry = time.Hour * 24 * 365
+
+	// 最終使用日時を更新する間隔 (リクエストごとに書き込まない)
+	personalTokenTouchInterval = time.Minute
+)
+
+var (
+	// トークンが無効な時のエラー
+	ErrInvalidPersonalToken = errors.New("invalid personal access token")
+
+	// トークンが見つからない時のエラー
+	ErrPersonalTokenNotFound = errors.New("personal access token not found")
+
+	// パーソナルアクセストークンでは操作できない時のエラー
+	ErrPersonalTokenNotAllowed = errors.New("personal access tokens cannot be used for this operation")
+
+	// トークンにスコープがない時のエラー
+	ErrPersonalTokenScope = errors.New("personal access token does not have the required scope")
+)
+
+// パーソナルアクセストークンか
+func IsPersonalToken(tokenString string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(tokenString, "Bearer "), personalTokenPrefix)
+}
+
+// ここから管理
+type PersonalTokenArgs struct {
+	Name          string   `json:"name"`          // 用途が分かる名前
+	Scopes        []string `json:"scopes"`        // 使えるラベル (ユーザーが持っているものだけ)
+	ExpiresInDays int      `json:"expiresInDays"` // 有効期間 (日)
+}
+
+type PersonalToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	Token      string   `json:"token,omitempty"` // 作成時だけ返す
+	ExpiresAt  int64    `json:"expiresAt"`
+	LastUsedAt int64    `json:"lastUsedAt"`
+	CreatedAt  int64    `json:"createdAt"`
+}
+
+func toPersonalToken(record models.PersonalAccessToken) PersonalToken {
+	return PersonalToken{
+		ID:         record.TokenID,
+		Name:       record.Name,
+		Scopes:     splitScope(record.Scopes),
+		ExpiresAt:  record.ExpiresAt * 1000,
+		LastUsedAt: record.LastUsedAt * 1000,
+		CreatedAt:  record.CreatedAt * 1000,
+	}
+}
+
+// パーソナルアクセストークンを作成する (トークンはこの時だけ返す)
+func CreatePersonalToken(userID string, args PersonalTokenArgs) (PersonalToken, error) {
+	name := strings.TrimSpace(args.Name)
+	if name == "" {
+		return PersonalToken{}, errors.New("name is required")
+	}
+
+	// 有効期限
+	expiry := time.Hour * 24 * time.Duration(args.ExpiresInDays)
+	if args.ExpiresInDays <= 0 || expiry > personalTokenMaxExpiry {
+		return PersonalToken{}, errors.New("expiresInDays must be between 1 and 365")
+	}
+
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+	if result.Error != nil {
+		return PersonalToken{}, result.Error
+	}
+
+	// ユーザーが持っているラベルだけ許可する
+	labels, err := user.GetLabelNames()
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	scopes := splitScope(strings.Join(args.Scopes, " "))
+	for _, scope := range scopes {
+		if !slices.Contains(labels, scope) {
+			return PersonalToken{}, errors.New("label is not assigned to the user: " + scope)
+		}
+	}
+
+	// トークンを生成
+	random, err := randomToken(32)
+	if err != nil {
+		return PersonalToken{}, err
+	}
+
+	token := personalTokenPrefix + random
+
+	record := models.PersonalAccessToken{
+		TokenID:   utils.GenID(),
+		TokenHash: hashSecret(token),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: time.Now().Add(expiry).Unix(),
+	}
+
+	// 保存する
+	if err := models.CreatePersonalAccessToken(&record); err != nil {
+		return PersonalToken{}, err
+	}
+
+	response := toPersonalToken(record)
+	response.Token = token
+
+	return response, nil
+}
+
+// ユーザーのトークン一覧を取得
+func GetPersonalTokens(userID string) ([]PersonalToken, error) {
+	records, err := models.GetUserPersonalAccessTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []PersonalToken{}
+	for _, record := range records {
+		tokens = append(tokens, toPersonalToken(record))
+	}
+
+	return tokens, nil
+}
+
+// トークンを失効させる
+func DeletePersonalToken(userID string, tokenID string) error {
+	deleted, err := models.DeletePersonalAccessToken(userID, tokenID)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ErrPersonalTokenNotFound
+	}
+
+	return nil
+}
+
+// ここまで
+
+// ここから認証
+// トークンを検証する
+func AuthenticatePersonalToken(tokenString string) (*models.PersonalAccessToken, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	if !strings.HasPrefix(tokenString, personalTokenPrefix) {
+		return nil, ErrInvalidPersonalToken
+	}
+
+	// トークンを取得
+	record, result := models.GetPersonalAccessToken(hashSecret(tokenString))
+	if !result.IsExists {
+		return nil, ErrInvalidPersonalToken
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// 期限切れの時
+	now := time.Now().Unix()
+	if now > record.ExpiresAt {
+		return nil, ErrInvalidPersonalToken
+	}
+
+	// 使用日時を記録
+	if now-record.LastUsedAt > int64(personalTokenTouchInterval.Seconds()) {
+		if err := models.TouchPersonalAccessToken(record.TokenID, now); err != nil {
+			logger.PrintErr(err)
+		}
+	}
+
+	return record, nil
+}
+
+// トークンで使えるラベル (トークンとユーザーの両方にあるものだけ)
+func PersonalTokenLabels(record *models.PersonalAccessToken, user *models.User) ([]string, error) {
+	current, err := user.GetLabelNames()
+	if err != nil {
+		return nil, err
+	}
+
+	return labelsInScope(current, record.Scopes), nil
+}
+
+// トークンをアクセストークンに交換する (ラベルはトークンとユーザーの両方にあるものだけ)
+func exchangePersonalToken(tokenString string) (RefreshResult, error) {
+	// トークンを検証
+	record, err := AuthenticatePersonalToken(tokenString)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	// ユーザーを取得
+	user, result := models.GetUser(record.UserID)
+	if result.Error != nil {
+		return RefreshResult{}, result.Error
+	}
+
+	// BANされている時
+	if user.IsBanned == 1 {
+		return RefreshResult{}, ErrUserBanned
+	}
+
+	// 今も持っているラベルに絞る
+	labels, err := PersonalTokenLabels(record, user)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	// 設定されたクレームを作る
+	extra, err := mappedClaims(user, "")
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	// アクセストークンを発行
+	token, err := AccessTokenJwt(AccessTokenClaim{
+		UserID:   user.UserID,
+		Labels:   labels,
+		ProvCode: user.ProvCode,
+		ProvUid:  user.ProvUID,
+		Scope:    strings.Join(labels, " "),
+		Extra:    extra,
+	})
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	return RefreshResult{
+		Token:     token,
+		ExpiresIn: int64(tokenExpiry.Seconds()),
+	}, nil
+}
+
+// ここまで
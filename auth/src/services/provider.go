@@ -2,15 +2,26 @@ package services
 
 import (
 	"auth/models"
+	"auth/oauth2"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
 type OauthProvider struct {
-	ProviderCode string `json:"ProviderCode"`
-	ProviderName string `json:"ProviderName"`
-	ClientID     string `json:"ClientID"`
-	ClientSecret string `json:"ClientSecret"`
-	CallbackURL  string `json:"CallbackURL"`
-	IsEnabled    int    `json:"IsEnabled"` // JSONの数値に合わせてint型
+	ProviderCode  string   `json:"ProviderCode"`
+	ProviderName  string   `json:"ProviderName"`
+	ClientID      string   `json:"ClientID"`
+	ClientSecret  string   `json:"ClientSecret"`
+	CallbackURL   string   `json:"CallbackURL"`
+	IsEnabled     int      `json:"IsEnabled"`     // JSONの数値に合わせてint型
+	Scopes        []string `json:"Scopes"`        // 空の時は DefaultScopes を使う
+	DefaultScopes []string `json:"DefaultScopes"` // 登録されたデフォルトのスコープ (読み取り専用)
 }
 
 // Oauth プロバイダ一覧を取得
@@ -18,18 +29,29 @@ func GetOauthProviders() []OauthProvider {
 	// 返すデータ
 	returnProviders := []OauthProvider{}
 
+	// 登録されたプロバイダコード
+	codes := []models.ProviderCode{}
+	for _, spec := range oauth2.Registry() {
+		codes = append(codes, spec.Code)
+	}
+
 	// データベースから取得
-	providers := models.GetOauthProviders()
+	providers := models.GetOauthProviders(codes)
 
 	for _, provider := range providers {
+		// 登録されたデフォルトのスコープ
+		spec, _ := oauth2.LookupProvider(provider.ProviderCode)
+
 		// データを返す
 		returnProviders = append(returnProviders, OauthProvider{
-			ProviderCode: string(provider.ProviderCode),
-			ProviderName: provider.ProviderName,
-			ClientID:     provider.ClientID,
-			ClientSecret: provider.ClientSecret,
-			CallbackURL:  provider.CallbackURL,
-			IsEnabled:    provider.IsEnabled,
+			ProviderCode:  string(provider.ProviderCode),
+			ProviderName:  provider.ProviderName,
+			ClientID:      provider.ClientID,
+			ClientSecret:  provider.ClientSecret,
+			CallbackURL:   provider.CallbackURL,
+			IsEnabled:     provider.IsEnabled,
+			Scopes:        strings.Fields(provider.Scopes),
+			DefaultScopes: spec.DefaultScopes,
 		})
 	}
 
@@ -39,6 +61,16 @@ func GetOauthProviders() []OauthProvider {
 // 更新する
 func UpdateOauthProviders(providers []OauthProvider) error {
 	for _, provider := range providers {
+		// 登録されていないプロバイダの時
+		if _, ok := oauth2.LookupProvider(models.ProviderCode(provider.ProviderCode)); !ok {
+			return fmt.Errorf("unknown oauth provider: %s", provider.ProviderCode)
+		}
+
+		// 値を検証する
+		if provider.IsEnabled != 0 && provider.IsEnabled != 1 {
+			return errors.New("IsEnabled must be 0 or 1")
+		}
+
 		// プロバイダを取得
 		getProvider, err := models.GetProvider(models.ProviderCode(provider.ProviderCode))
 
@@ -52,6 +84,7 @@ func UpdateOauthProviders(providers []OauthProvider) error {
 		getProvider.ClientID = provider.ClientID
 		getProvider.ClientSecret = provider.ClientSecret
 		getProvider.IsEnabled = provider.IsEnabled
+		getProvider.Scopes = strings.Join(provider.Scopes, " ")
 
 		// プロバイダを更新
 		err = models.UpdateOauthProvider(*getProvider)
@@ -65,11 +98,332 @@ func UpdateOauthProviders(providers []OauthProvider) error {
 	return nil
 }
 
+// ここから Basic プロバイダ
+type BasicProvider struct {
+	ProviderCode             string `json:"ProviderCode"`
+	ProviderName             string `json:"ProviderName"`
+	IsEnabled                int    `json:"IsEnabled"`
+	RequireEmailVerification int    `json:"RequireEmailVerification"`
+}
+
+// Basic プロバイダを取得
+func GetBasicProvider() (BasicProvider, error) {
+	// データベースから取得
+	provider, err := models.GetProvider(models.Basic)
+
+	// エラー処理
+	if err != nil {
+		return BasicProvider{}, err
+	}
+
+	return BasicProvider{
+		ProviderCode: string(provider.ProviderCode),
+		ProviderName: provider.ProviderName,
+		IsEnabled:    provider.IsEnabled,
+		RequireEmailVerification: provider.RequireEmailVerification,
+	}, nil
+}
+
 // Basic プロバイダ更新
 type UpdateBasicProviderArgs struct {
-	
+	IsEnabled                int `json:"IsEnabled"`                // 有効状態 (0 or 1)
+	RequireEmailVerification int `json:"RequireEmailVerification"` // メールアドレス確認を必須にするか (0 or 1)
+}
+
+func UpdateBasicProvider(args UpdateBasicProviderArgs) error {
+	// 値を検証する
+	if args.IsEnabled != 0 && args.IsEnabled != 1 {
+		return errors.New("IsEnabled must be 0 or 1")
+	}
+
+	if args.RequireEmailVerification != 0 && args.RequireEmailVerification != 1 {
+		return errors.New("RequireEmailVerification must be 0 or 1")
+	}
+
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.Basic)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// データを更新する
+	provider.IsEnabled = args.IsEnabled
+	provider.RequireEmailVerification = args.RequireEmailVerification
+
+	// プロバイダを更新
+	return models.UpdateOauthProvider(*provider)
+}
+
+// ここまで
+
+// ここから OIDC プロバイダ
+var (
+	// プロバイダコードに使える文字 (URL のパスに使う)
+	providerCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,62}$`)
+
+	ErrProviderNotFound = errors.New("provider not found")
+	ErrProviderExists   = errors.New("provider already exists")
+	ErrProviderInUse    = errors.New("provider is used by users, disable it instead")
+)
+
+type OidcProvider struct {
+	ProviderCode string              `json:"ProviderCode"`
+	ProviderName string              `json:"ProviderName"`
+	IssuerURL    string              `json:"IssuerURL"`
+	ClientID     string              `json:"ClientID"`
+	ClientSecret string              `json:"ClientSecret"`
+	CallbackURL  string              `json:"CallbackURL"`  // 空の時は PublicURL から生成する
+	Scopes       []string            `json:"Scopes"`       // 空の時は openid profile email
+	ClaimMapping oauth2.ClaimMapping `json:"ClaimMapping"` // 空の項目は標準のクレームを使う
+	IsEnabled    int                 `json:"IsEnabled"`
+}
+
+// モデルから変換する
+func toOidcProvider(provider models.Provider) OidcProvider {
+	// クレームの割り当てを読み込む (壊れている時は空にする)
+	mapping, _ := oauth2.ParseClaimMapping(provider.ClaimMapping)
+
+	return OidcProvider{
+		ProviderCode: string(provider.ProviderCode),
+		ProviderName: provider.ProviderName,
+		IssuerURL:    provider.IssuerURL,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		CallbackURL:  provider.CallbackURL,
+		Scopes:       oauth2.ParseScopes(provider.Scopes),
+		ClaimMapping: mapping,
+		IsEnabled:    provider.IsEnabled,
+	}
+}
+
+// 入力を検証してモデルに変換する
+func (args OidcProvider) toModel() (models.Provider, error) {
+	// 値を検証する
+	if strings.TrimSpace(args.ProviderName) == "" {
+		return models.Provider{}, errors.New("ProviderName is required")
+	}
+
+	if args.ClientID == "" {
+		return models.Provider{}, errors.New("ClientID is required")
+	}
+
+	if args.IsEnabled != 0 && args.IsEnabled != 1 {
+		return models.Provider{}, errors.New("IsEnabled must be 0 or 1")
+	}
+
+	issuer, err := url.Parse(args.IssuerURL)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return models.Provider{}, errors.New("IssuerURL must be an http(s) URL")
+	}
+
+	// コールバックURL
+	callbackURL := args.CallbackURL
+	if callbackURL == "" {
+		callbackURL = PublicURL + "/oauth/" + args.ProviderCode + "/callback"
+	}
+
+	// クレームの割り当て
+	mapping, err := json.Marshal(args.ClaimMapping)
+	if err != nil {
+		return models.Provider{}, err
+	}
+
+	return models.Provider{
+		ProviderName: strings.TrimSpace(args.ProviderName),
+		ClientID:     args.ClientID,
+		ClientSecret: args.ClientSecret,
+		CallbackURL:  callbackURL,
+		ProviderCode: models.ProviderCode(args.ProviderCode),
+		IsEnabled:    args.IsEnabled,
+		Kind:         models.KindOidc,
+		IssuerURL:    strings.TrimSuffix(args.IssuerURL, "/"),
+		Scopes:       strings.Join(args.Scopes, " "),
+		ClaimMapping: string(mapping),
+		Users:        []models.User{},
+	}, nil
+}
+
+// discovery できるか確認する
+func checkOidcDiscovery(provider models.Provider) error {
+	_, err := oauth2.NewOidcProvider(provider)
+
+	// エラー処理
+	if err != nil {
+		return fmt.Errorf("openid configuration discovery failed: %w", err)
+	}
+
+	return nil
+}
+
+// 種類を指定してプロバイダを取得する
+func getProviderOfKind(providerCode string, kind models.ProviderKind) (*models.Provider, error) {
+	provider, err := models.GetProvider(models.ProviderCode(providerCode))
+
+	// エラー処理
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProviderNotFound
+		}
+		return nil, err
+	}
+
+	// 種類が違う時
+	if provider.Kind != kind {
+		return nil, ErrProviderNotFound
+	}
+
+	return provider, nil
+}
+
+// 新しいプロバイダコードを検証する
+func checkNewProviderCode(providerCode string) error {
+	// 形式を検証
+	if !providerCodePattern.MatchString(providerCode) {
+		return errors.New("ProviderCode must match " + providerCodePattern.String())
+	}
+
+	// 既に存在する時
+	_, err := models.GetProvider(models.ProviderCode(providerCode))
+	if err == nil {
+		return ErrProviderExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return nil
 }
 
-func UpdateBasicProvider() {
+// OIDC プロバイダ一覧を取得
+func GetOidcProviders() ([]OidcProvider, error) {
+	// データベースから取得
+	providers, err := models.GetOidcProviders()
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	returnProviders := []OidcProvider{}
+	for _, provider := range providers {
+		returnProviders = append(returnProviders, toOidcProvider(provider))
+	}
+
+	return returnProviders, nil
+}
+
+// OIDC プロバイダを作成
+func CreateOidcProvider(args OidcProvider) (OidcProvider, error) {
+	// プロバイダコードを検証
+	if err := checkNewProviderCode(args.ProviderCode); err != nil {
+		return OidcProvider{}, err
+	}
+
+	// モデルに変換
+	provider, err := args.toModel()
+
+	// エラー処理
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// discovery を確認する
+	err = checkOidcDiscovery(provider)
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// 作成する
+	err = models.CreateProvider(&provider)
+
+	// エラー処理
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	return toOidcProvider(provider), nil
+}
+
+// OIDC プロバイダを更新
+func UpdateOidcProvider(providerCode string, args OidcProvider) (OidcProvider, error) {
+	// 存在するか確認
+	_, err := getProviderOfKind(providerCode, models.KindOidc)
+
+	// エラー処理
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// プロバイダコードは変更できない
+	args.ProviderCode = providerCode
+
+	// モデルに変換
+	provider, err := args.toModel()
+
+	// エラー処理
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// discovery を確認する
+	err = checkOidcDiscovery(provider)
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// 更新する
+	err = models.UpdateProviderByCode(provider.ProviderCode, provider)
+
+	// エラー処理
+	if err != nil {
+		return OidcProvider{}, err
+	}
+
+	// キャッシュを削除
+	oauth2.ForgetOidcProvider(provider.ProviderCode)
+
+	return toOidcProvider(provider), nil
+}
+
+// OIDC プロバイダを削除
+func DeleteOidcProvider(providerCode string) error {
+	// 存在するか確認
+	provider, err := getProviderOfKind(providerCode, models.KindOidc)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 削除する
+	err = deleteProvider(provider)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// キャッシュを削除
+	oauth2.ForgetOidcProvider(provider.ProviderCode)
+
+	return nil
+}
+
+// プロバイダを削除する (ユーザーがいる時は削除しない)
+func deleteProvider(provider *models.Provider) error {
+	// ユーザーがいる時は削除しない
+	count, err := models.CountProviderUsers(provider.ProviderCode)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrProviderInUse
+	}
+
+	// 削除する
+	return models.DeleteProvider(provider.ProviderCode)
+}
 
-}
\ No newline at end of file
+// ここまで
\ No newline at end of file
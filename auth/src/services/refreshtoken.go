@@ -0,0 +1,295 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// リフレッシュトークンの接頭辞 (セッショントークンと見分ける)
+	refreshTokenPrefix = "rt_"
+
+	// ログインしてから再ログインが必要になるまでの期間
+	refreshTokenExpiry = time.Hour * 24 * 30
+
+	// 使われないまま失効するまでの期間
+	refreshTokenIdleExpiry = time.Hour * 24 * 7
+
+	// ローテーション直後の再使用を盗用とみなさない猶予 (複数タブの同時更新)
+	refreshTokenReuseGrace = time.Second * 10
+)
+
+var (
+	// リフレッシュトークンが無効な時のエラー
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ローテーション済みのトークンが再使用された時のエラー
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+type refreshTokenArgs struct {
+	SessionID string // 紐づくセッション
+	UserID    string // ユーザーID
+	ClientID  string // 発行先のクライアント (空はファーストパーティ)
+	Scope     string // 認可されたスコープ
+	FamilyID  string // 引き継ぐ系列 (空の時は新しい系列)
+	ExpiresAt int64  // 系列の絶対的な有効期限
+}
+
+// リフレッシュトークンを発行する
+func issueRefreshToken(args refreshTokenArgs) (string, *models.RefreshToken, error) {
+	// トークンを生成
+	random, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := refreshTokenPrefix + random
+	now := time.Now()
+
+	// 新しい系列の時
+	if args.FamilyID == "" {
+		args.FamilyID = utils.GenID()
+	}
+	if args.ExpiresAt == 0 {
+		args.ExpiresAt = now.Add(refreshTokenExpiry).Unix()
+	}
+
+	// アイドル期限は絶対期限を超えない
+	idleExpiresAt := min(now.Add(refreshTokenIdleExpiry).Unix(), args.ExpiresAt)
+
+	record := models.RefreshToken{
+		TokenHash:     hashSecret(token),
+		FamilyID:      args.FamilyID,
+		SessionID:     args.SessionID,
+		UserID:        args.UserID,
+		ClientID:      args.ClientID,
+		Scope:         args.Scope,
+		ExpiresAt:     args.ExpiresAt,
+		IdleExpiresAt: idleExpiresAt,
+	}
+
+	// 保存する
+	if err := models.CreateRefreshToken(&record); err != nil {
+		return "", nil, err
+	}
+
+	return token, &record, nil
+}
+
+// 再使用を検知した時は系列ごと失効させる
+func revokeRefreshTokenFamily(record *models.RefreshToken) {
+	logger.Println("リフレッシュトークンの再使用を検知しました: " + record.FamilyID)
+
+	if err := models.RevokeRefreshTokenFamily(record.FamilyID, utils.NowTime()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// ファーストパーティの時はセッションも終了させ、発行済みのアクセストークンを失効させる
+	if record.ClientID == "" {
+		if err := RevokeSessionTokens(record.UserID, record.SessionID, RevocationTokenReuse); err != nil {
+			logger.PrintErr(err)
+		}
+	}
+}
+
+// リフレッシュトークンをローテーションする (新しいトークンと元のトークンを返す)
+func rotateRefreshToken(tokenString string, clientID string) (string, *models.RefreshToken, *models.Session, error) {
+	// 検証する
+	record, session, err := checkRefreshToken(tokenString, clientID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// ローテーションする
+	token, err := useRefreshToken(record, session)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return token, record, session, nil
+}
+
+// ローテーション済みのトークンの再使用が同時の更新による猶予内か
+func isWithinReuseGrace(usedAt int64, now int64) bool {
+	return now-usedAt <= int64(refreshTokenReuseGrace.Seconds())
+}
+
+// リフレッシュトークンを検証する (使用済みにはしない)
+func checkRefreshToken(tokenString string, clientID string) (*models.RefreshToken, *models.Session, error) {
+	if !strings.HasPrefix(tokenString, refreshTokenPrefix) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// トークンを取得
+	record, result := models.GetRefreshToken(hashSecret(tokenString))
+	if !result.IsExists {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	// 他のクライアントのトークン
+	if record.ClientID != clientID {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// 失効している時
+	if record.RevokedAt != 0 {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now().Unix()
+
+	// ローテーション済みのトークンが使われた時
+	if record.UsedAt != 0 {
+		// 同時に更新した時は盗用とみなさない
+		if isWithinReuseGrace(record.UsedAt, now) {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+
+		revokeRefreshTokenFamily(record)
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	// 期限切れの時
+	if now > record.ExpiresAt || now > record.IdleExpiresAt {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	// セッションが終了している時
+	session, err := getActiveSession(record.SessionID)
+	if err != nil {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	return record, session, nil
+}
+
+// 検証済みのトークンを使用済みにして同じ系列の新しいトークンを発行する
+func useRefreshToken(record *models.RefreshToken, session *models.Session) (string, error) {
+	// 使用済みにする
+	used, err := models.UseRefreshToken(record.TokenHash, utils.NowTime())
+	if err != nil {
+		return "", err
+	}
+
+	// 同時に使用された時
+	if !used {
+		return "", ErrInvalidRefreshToken
+	}
+
+	// 同じ系列で新しいトークンを発行する
+	token, _, err := issueRefreshToken(refreshTokenArgs{
+		SessionID: record.SessionID,
+		UserID:    record.UserID,
+		ClientID:  record.ClientID,
+		Scope:     record.Scope,
+		FamilyID:  record.FamilyID,
+		ExpiresAt: record.ExpiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 使われた日時を記録
+	touchSession(session)
+
+	return token, nil
+}
+
+// 以前のセッショントークンをリフレッシュトークンに移行する (一度だけ)
+func migrateSessionToken(tokenString string) (string, *models.Session, error) {
+	// トークンを検証
+	sessionID, err := ValidateSessionToken(tokenString)
+	if err != nil || sessionID == "" {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	// セッションを取得
+	session, err := getActiveSession(sessionID)
+	if err != nil {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	// 既に移行している時は受け付けない
+	exists, err := models.HasSessionRefreshTokens(sessionID)
+	if err != nil {
+		return "", nil, err
+	}
+	if exists {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	// セッションの有効期限を引き継ぐ
+	expiresAt := session.ExpiresAt
+
+	// 発行する
+	token, _, err := issueRefreshToken(refreshTokenArgs{
+		SessionID: session.SessionID,
+		UserID:    session.UserID,
+		ExpiresAt: expiresAt,
+	})
+
+	return token, session, err
+}
+
+// ファーストパーティの更新結果
+type RefreshResult struct {
+	Token        string `json:"token"`        // アクセストークン
+	RefreshToken string `json:"refreshToken"` // 新しいリフレッシュトークン (パーソナルアクセストークンの時は空)
+	ExpiresIn    int64  `json:"expiresIn"`    // アクセストークンの有効期間 (秒)
+}
+
+// リフレッシュトークンをアクセストークンに交換する
+func RefreshAccessToken(tokenString string) (RefreshResult, error) {
+	// パーソナルアクセストークンの時
+	if IsPersonalToken(tokenString) {
+		return exchangePersonalToken(tokenString)
+	}
+
+	var (
+		refreshToken string
+		session      *models.Session
+		err          error
+	)
+
+	if strings.HasPrefix(tokenString, refreshTokenPrefix) {
+		// ローテーションする
+		refreshToken, _, session, err = rotateRefreshToken(tokenString, "")
+	} else {
+		// 以前のセッショントークンの時
+		refreshToken, session, err = migrateSessionToken(tokenString)
+	}
+
+	// エラー処理
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	// BANされている時
+	user, result := models.GetUser(session.UserID)
+	if result.Error != nil {
+		return RefreshResult{}, result.Error
+	}
+	if user.IsBanned == 1 {
+		return RefreshResult{}, ErrUserBanned
+	}
+
+	// アクセストークンを発行
+	token, err := issueAccessToken(session.UserID, session.SessionID, "", "")
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	return RefreshResult{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(tokenExpiry.Seconds()),
+	}, nil
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"auth/models"
+	"auth/utils"
+	"testing"
+	"time"
+)
+
+func TestIsWithinReuseGrace(t *testing.T) {
+	const now = int64(1_000_000)
+	grace := int64(refreshTokenReuseGrace.Seconds())
+
+	tests := []struct {
+		name   string
+		usedAt int64
+		want   bool
+	}{
+		{"same second", now, true},
+		{"inside grace", now - grace + 1, true},
+		{"grace boundary", now - grace, true},
+		{"after grace", now - grace - 1, false},
+		{"long ago", now - 3600, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isWithinReuseGrace(test.usedAt, now); got != test.want {
+				t.Fatalf("isWithinReuseGrace() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNarrowScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		granted   string
+		requested string
+		want      string
+		ok        bool
+	}{
+		{"not requested", "openid profile", "", "openid profile", true},
+		{"blank request", "openid profile", "  ", "openid profile", true},
+		{"subset", "openid profile email", "email openid", "email openid", true},
+		{"duplicates", "openid profile", "openid openid", "openid", true},
+		{"same", "openid profile", "openid profile", "openid profile", true},
+		{"exceeds", "openid profile", "openid admin", "", false},
+		{"nothing granted", "", "openid", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, ok := narrowScope(test.granted, test.requested)
+			if scope != test.want || ok != test.ok {
+				t.Fatalf("narrowScope() = %q, %v, want %q, %v", scope, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+// 認可コードを交換してクライアントのリフレッシュトークンを取得する
+func issueTestClientTokens(t *testing.T) (OauthClient, *models.Session, string) {
+	t.Helper()
+
+	client, session := setupAuthorizationCodeTest(t)
+	code := authorizeTestCode(t, client, session, testCodeVerifier)
+
+	response, err := ExchangeToken(TokenArgs{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: testCodeVerifier,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, session, response.RefreshToken
+}
+
+// リフレッシュトークンで更新する
+func refreshTestToken(client OauthClient, refreshToken string, scope string) (TokenResponse, error) {
+	return ExchangeToken(TokenArgs{
+		GrantType:    "refresh_token",
+		RefreshToken: refreshToken,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		Scope:        scope,
+	})
+}
+
+// リフレッシュトークンが未使用か
+func isRefreshTokenUnused(t *testing.T, refreshToken string) bool {
+	t.Helper()
+
+	record, result := models.GetRefreshToken(hashSecret(refreshToken))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	return record.UsedAt == 0 && record.RevokedAt == 0
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	client, _, first := issueTestClientTokens(t)
+
+	// ローテーションする
+	response, err := refreshTestToken(client, first, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := response.RefreshToken
+	if second == "" || second == first || response.Scope != "openid profile" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	// 猶予内の再使用は拒否するが系列は失効させない (複数タブの同時更新)
+	_, err = refreshTestToken(client, first, "")
+	expectOauthError(t, err, "invalid_grant")
+
+	if !isRefreshTokenUnused(t, second) {
+		t.Fatal("rotated token was revoked by a reuse inside the grace window")
+	}
+
+	// 新しいトークンは使える
+	if _, err := refreshTestToken(client, second, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	client, session, current := issueTestClientTokens(t)
+
+	currentRecord, result := models.GetRefreshToken(hashSecret(current))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	// 猶予を過ぎてから再使用された同じ系列のトークン
+	now := time.Now()
+	stolen := refreshTokenPrefix + utils.GenID()
+	err := models.CreateRefreshToken(&models.RefreshToken{
+		TokenHash:     hashSecret(stolen),
+		FamilyID:      currentRecord.FamilyID,
+		SessionID:     session.SessionID,
+		UserID:        session.UserID,
+		ClientID:      client.ClientID,
+		Scope:         currentRecord.Scope,
+		ExpiresAt:     currentRecord.ExpiresAt,
+		IdleExpiresAt: currentRecord.IdleExpiresAt,
+		UsedAt:        now.Add(-refreshTokenReuseGrace - time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = refreshTestToken(client, stolen, "")
+	expectOauthError(t, err, "invalid_grant")
+
+	// 系列の他のトークンも使えなくなる
+	_, err = refreshTestToken(client, current, "")
+	expectOauthError(t, err, "invalid_grant")
+}
+
+func TestRefreshTokenScopeNarrowing(t *testing.T) {
+	client, _, refreshToken := issueTestClientTokens(t)
+
+	// 元のスコープを超える時はローテーションしない
+	_, err := refreshTestToken(client, refreshToken, "openid admin")
+	expectOauthError(t, err, "invalid_scope")
+
+	if !isRefreshTokenUnused(t, refreshToken) {
+		t.Fatal("refresh token was rotated by a request with an invalid scope")
+	}
+
+	// 狭める
+	response, err := refreshTestToken(client, refreshToken, "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Scope != "openid" {
+		t.Fatalf("Scope = %q, want openid", response.Scope)
+	}
+}
+
+func TestRefreshTokenBannedUser(t *testing.T) {
+	client, session, refreshToken := issueTestClientTokens(t)
+
+	user, result := models.GetUser(session.UserID)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	user.IsBanned = 1
+	if err := models.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	// BAN されたユーザーのトークンはローテーションしない
+	_, err := refreshTestToken(client, refreshToken, "")
+	expectOauthError(t, err, "invalid_grant")
+
+	if !isRefreshTokenUnused(t, refreshToken) {
+		t.Fatal("refresh token was rotated for a banned user")
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// トークンを失効させる理由
+type RevocationReason string
+
+const (
+	RevocationBan           RevocationReason = "ban"            // BAN
+	RevocationPasswordReset RevocationReason = "password_reset" // パスワードの再設定
+	RevocationMfaReset      RevocationReason = "mfa_reset"      // 管理者による二要素認証のリセット
+	RevocationAdminLogout   RevocationReason = "admin_logout"   // 管理者による強制ログアウト
+	RevocationDelete        RevocationReason = "delete"         // ユーザーの削除
+	RevocationLogout        RevocationReason = "logout"         // ユーザー自身によるセッションの終了
+	RevocationSessionLimit  RevocationReason = "session_limit"  // 同時ログイン数の上限を超えた
+	RevocationTokenReuse    RevocationReason = "token_reuse"    // リフレッシュトークンの再使用 (盗まれた可能性がある)
+
+	// 購読者ごとに溜められる通知の数 (溢れた時は接続を切って取り直させる)
+	revocationBufferSize = 64
+)
+
+// 失効の購読者
+type revocationHub struct {
+	mutex       sync.Mutex
+	subscribers map[chan models.TokenRevocation]struct{}
+}
+
+var (
+	revocations = &revocationHub{
+		subscribers: map[chan models.TokenRevocation]struct{}{},
+	}
+
+	// 記録した失効を取り込む間隔 (SESSION_CACHE_SYNC_INTERVAL)
+	// 他のプロセスで記録した失効がキャッシュと購読者に届くまでの最大時間
+	SessionCacheSyncInterval = time.Second * 2
+
+	// 同じプロセスで記録した時にすぐ取り込ませる
+	revocationSyncWake = make(chan struct{}, 1)
+)
+
+// 失効を購読する (返した関数で解除する)
+// 通知が溢れた時はチャネルを閉じるので、購読し直して取りこぼしを取得すること
+func SubscribeRevocations() (<-chan models.TokenRevocation, func()) {
+	ch := make(chan models.TokenRevocation, revocationBufferSize)
+
+	revocations.mutex.Lock()
+	revocations.subscribers[ch] = struct{}{}
+	revocations.mutex.Unlock()
+
+	return ch, func() {
+		revocations.mutex.Lock()
+		defer revocations.mutex.Unlock()
+
+		if _, ok := revocations.subscribers[ch]; ok {
+			delete(revocations.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// 購読者に配信する
+func (hub *revocationHub) publish(revocation models.TokenRevocation) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for ch := range hub.subscribers {
+		select {
+		case ch <- revocation:
+		default:
+			// 受け取りが遅い購読者は切る
+			logger.Println("失効の通知が溢れたため購読を解除します")
+			delete(hub.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// 配信し直す範囲の失効を取得 (since が 0 の時はアクセストークンの有効期間分)
+func GetRevocationsSince(since int64) ([]models.TokenRevocation, error) {
+	oldest := time.Now().Add(-tokenExpiry).Unix()
+	if since < oldest {
+		since = oldest
+	}
+
+	return models.GetTokenRevocationsSince(since)
+}
+
+// 取り込んだ失効の位置
+type revocationCursor struct {
+	lastID    uint  // 最後に取り込んだ一括失効の ID
+	tokenFrom int64 // 次に取り込むアクセストークンの失効の日時 (同じ秒は重ねて取り込む)
+}
+
+// 記録済みの失効の後から取り込む (取得できない時は残っている失効を全て取り込む)
+func newRevocationCursor() *revocationCursor {
+	lastID, err := models.GetLatestTokenRevocationID()
+	if err != nil {
+		logger.PrintErr(err)
+	}
+
+	return &revocationCursor{lastID: lastID, tokenFrom: time.Now().Unix()}
+}
+
+// 前回より後に記録された失効を取得先に反映し、購読者に配信する
+func (cursor *revocationCursor) sync(store models.SessionStore, hub *revocationHub) error {
+	// ユーザーとセッションの一括失効
+	records, err := models.GetTokenRevocationsAfter(cursor.lastID)
+	if err != nil {
+		return err
+	}
+
+	// アクセストークンの失効
+	tokens, err := models.GetRevokedAccessTokensSince(cursor.tokenFrom)
+	if err != nil {
+		return err
+	}
+
+	models.InvalidateRevocations(store, records, tokens)
+
+	// 位置を進める (ID は一度しか通らないので重ねて配信しない)
+	for _, revocation := range records {
+		hub.publish(revocation)
+		cursor.lastID = max(cursor.lastID, revocation.ID)
+	}
+	for _, token := range tokens {
+		cursor.tokenFrom = max(cursor.tokenFrom, token.CreatedAt)
+	}
+
+	return nil
+}
+
+// 失効の取り込みを始める (キャッシュを使わない時も購読者への配信に使う)
+func initRevocationSync() {
+	SessionCacheSyncInterval = durationFromEnv("SESSION_CACHE_SYNC_INTERVAL", SessionCacheSyncInterval)
+
+	go syncRevocations(newRevocationCursor())
+}
+
+// 失効を定期的に、もしくは同じプロセスで記録した時に取り込む
+func syncRevocations(cursor *revocationCursor) {
+	ticker := time.NewTicker(SessionCacheSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-revocationSyncWake:
+		}
+
+		if err := cursor.sync(models.Sessions(), revocations); err != nil {
+			logger.PrintErr(err)
+		}
+	}
+}
+
+// 取り込みを起こす (既に起こしている時は何もしない)
+func wakeRevocationSync() {
+	select {
+	case revocationSyncWake <- struct{}{}:
+	default:
+	}
+}
+
+// 失効を記録して配信する
+func recordRevocation(userID string, sessionID string, reason RevocationReason) error {
+	now := time.Now()
+
+	revocation := models.TokenRevocation{
+		UserID:    userID,
+		SessionID: sessionID,
+		Reason:    string(reason),
+		RevokedAt: now.Unix(),
+	}
+
+	// 保存する
+	if err := models.CreateTokenRevocation(&revocation); err != nil {
+		return err
+	}
+
+	// 配信する (どのプロセスで記録したものも取り込みから一度だけ配信する)
+	wakeRevocationSync()
+
+	// 発行済みのトークンが全て期限切れになった失効を削除する
+	if err := models.DeleteExpiredTokenRevocations(now.Add(-tokenExpiry).Unix()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	return nil
+}
+
+// ユーザーの全てのセッションとパーソナルアクセストークンを削除し、発行済みのトークンを失効させる
+func RevokeUserTokens(userID string, reason RevocationReason) error {
+	// セッションとリフレッシュトークンを削除
+	if err := models.DeleteUserSessions(userID); err != nil {
+		return err
+	}
+
+	// パーソナルアクセストークンも削除する (認証情報を変えた後に盗まれたトークンを残さない)
+	if err := models.DeleteUserPersonalAccessTokens(userID); err != nil {
+		return err
+	}
+
+	return recordRevocation(userID, "", reason)
+}
+
+// セッションを削除し、そのセッションのトークンを失効させる
+func RevokeSessionTokens(userID string, sessionID string, reason RevocationReason) error {
+	// セッションとリフレッシュトークンを削除
+	if err := models.DeleteSession(sessionID); err != nil {
+		return err
+	}
+
+	return recordRevocation(userID, sessionID, reason)
+}
+
+// セッションIDだけ分かる時にセッションを失効させる (既にない時は何もしない)
+func revokeSession(sessionID string, reason RevocationReason) error {
+	session, err := models.Sessions().GetSession(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return RevokeSessionTokens(session.UserID, sessionID, reason)
+}
+
+// ユーザーの全てのトークンを失効させた後に発行されたか
+// iat は秒単位なので、失効と同じ秒に発行されたものも拒否する (ログインし直してもらう)
+func isIssuedAfterRevocation(userID string, issuedAt int64) (bool, error) {
+	revokedAt, err := models.Sessions().GetUserRevokedAt(userID, time.Now().Add(-tokenExpiry).Unix())
+	if err != nil {
+		return false, err
+	}
+
+	return revokedAt == 0 || issuedAt > revokedAt, nil
+}
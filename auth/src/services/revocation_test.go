@@ -0,0 +1,68 @@
+package services
+
+import (
+	"auth/models"
+	"testing"
+	"time"
+)
+
+func TestWakeRevocationSync(t *testing.T) {
+	// 起こしている間に何度呼んでも止まらない
+	wakeRevocationSync()
+	wakeRevocationSync()
+
+	select {
+	case <-revocationSyncWake:
+	default:
+		t.Fatal("sync was not woken")
+	}
+}
+
+func TestRevocationCursorPublishesOnce(t *testing.T) {
+	requireTestDB(t)
+
+	cursor := newRevocationCursor()
+	subscription, unsubscribe := SubscribeRevocations()
+	defer unsubscribe()
+
+	user := createTestUser(t)
+
+	// このプロセスで記録した失効
+	if err := recordRevocation(user.UserID, "", RevocationBan); err != nil {
+		t.Fatal(err)
+	}
+
+	// 他のプロセスで記録した失効
+	err := models.CreateTokenRevocation(&models.TokenRevocation{
+		UserID:    user.UserID,
+		SessionID: "other-instance-session",
+		Reason:    string(RevocationLogout),
+		RevokedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 取り込むまでは配信しない
+	if len(subscription) != 0 {
+		t.Fatalf("published before sync: %d", len(subscription))
+	}
+
+	// 二回取り込んでも一度だけ配信する
+	for range 2 {
+		if err := cursor.sync(models.Sessions(), revocations); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []models.TokenRevocation
+	for len(subscription) > 0 {
+		if revocation := <-subscription; revocation.UserID == user.UserID {
+			received = append(received, revocation)
+		}
+	}
+
+	if len(received) != 2 || received[0].Reason != string(RevocationBan) || received[1].SessionID != "other-instance-session" {
+		t.Fatalf("unexpected revocations: %+v", received)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"auth/models"
+	"auth/oauth2"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/crewjam/saml/samlsp"
+)
+
+// ここから SAML プロバイダ
+type SamlProvider struct {
+	ProviderCode      string                `json:"ProviderCode"`
+	ProviderName      string                `json:"ProviderName"`
+	IsEnabled         int                   `json:"IsEnabled"`
+	IdPMetadataURL    string                `json:"IdPMetadataURL"`    // IdP のメタデータ URL (XML とどちらか)
+	IdPMetadataXML    string                `json:"IdPMetadataXML"`    // IdP のメタデータ
+	EntityID          string                `json:"EntityID"`          // SP のエンティティID (空の時はメタデータ URL)
+	Attributes        oauth2.SamlAttributes `json:"Attributes"`        // 属性の割り当て
+	AllowIDPInitiated bool                  `json:"AllowIDPInitiated"` // IdP 起点のログインを許可するか
+	SignRequests      bool                  `json:"SignRequests"`      // AuthnRequest に署名するか
+	MetadataURL       string                `json:"MetadataURL"`       // SP のメタデータ URL (読み取り専用)
+	AcsURL            string                `json:"AcsURL"`            // SP の ACS URL (読み取り専用)
+	SPCertificate     string                `json:"SPCertificate"`     // SP の証明書 (読み取り専用)
+}
+
+// モデルから変換する (秘密鍵は返さない)
+func toSamlProvider(provider models.Provider) SamlProvider {
+	// 設定を読み込む (壊れている時は空にする)
+	config, _ := oauth2.ParseSamlConfig(provider.Config)
+
+	return SamlProvider{
+		ProviderCode:      string(provider.ProviderCode),
+		ProviderName:      provider.ProviderName,
+		IsEnabled:         provider.IsEnabled,
+		IdPMetadataURL:    config.IdPMetadataURL,
+		IdPMetadataXML:    config.IdPMetadataXML,
+		EntityID:          config.EntityID,
+		Attributes:        config.Attributes,
+		AllowIDPInitiated: config.AllowIDPInitiated,
+		SignRequests:      config.SignRequests,
+		MetadataURL:       oauth2.SamlMetadataURL(PublicURL, provider.ProviderCode),
+		AcsURL:            oauth2.SamlAcsURL(PublicURL, provider.ProviderCode),
+		SPCertificate:     config.SPCertificate,
+	}
+}
+
+// 入力を検証して設定に反映する
+func (args SamlProvider) applyTo(config *oauth2.SamlConfig) error {
+	// 値を検証する
+	if strings.TrimSpace(args.ProviderName) == "" {
+		return errors.New("ProviderName is required")
+	}
+
+	if args.IsEnabled != 0 && args.IsEnabled != 1 {
+		return errors.New("IsEnabled must be 0 or 1")
+	}
+
+	// IdP のメタデータ
+	metadata := strings.TrimSpace(args.IdPMetadataXML)
+	if args.IdPMetadataURL != "" {
+		// URL から取得する
+		fetched, err := oauth2.FetchIdpMetadata(args.IdPMetadataURL)
+		if err != nil {
+			return errors.New("failed to fetch IdP metadata: " + err.Error())
+		}
+
+		metadata = fetched
+	}
+
+	if metadata == "" {
+		return errors.New("IdPMetadataURL or IdPMetadataXML is required")
+	}
+
+	// メタデータを検証
+	if _, err := samlsp.ParseMetadata([]byte(metadata)); err != nil {
+		return errors.New("invalid IdP metadata: " + err.Error())
+	}
+
+	config.IdPMetadataURL = args.IdPMetadataURL
+	config.IdPMetadataXML = metadata
+	config.EntityID = strings.TrimSpace(args.EntityID)
+	config.Attributes = args.Attributes
+	config.AllowIDPInitiated = args.AllowIDPInitiated
+	config.SignRequests = args.SignRequests
+
+	return nil
+}
+
+// SAML プロバイダ一覧を取得
+func GetSamlProviders() ([]SamlProvider, error) {
+	// データベースから取得
+	providers, err := models.GetProvidersByKind(models.KindSaml)
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	returnProviders := []SamlProvider{}
+	for _, provider := range providers {
+		returnProviders = append(returnProviders, toSamlProvider(provider))
+	}
+
+	return returnProviders, nil
+}
+
+// SAML プロバイダを作成
+func CreateSamlProvider(args SamlProvider) (SamlProvider, error) {
+	// プロバイダコードを検証
+	if err := checkNewProviderCode(args.ProviderCode); err != nil {
+		return SamlProvider{}, err
+	}
+
+	// 設定を作成
+	config := oauth2.SamlConfig{}
+	if err := args.applyTo(&config); err != nil {
+		return SamlProvider{}, err
+	}
+
+	// SP の鍵を生成
+	key, certificate, err := oauth2.NewSamlKeyPair(oauth2.SamlMetadataURL(PublicURL, models.ProviderCode(args.ProviderCode)))
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	config.SPKey = key
+	config.SPCertificate = certificate
+
+	// 設定を変換
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	provider := models.Provider{
+		ProviderName: strings.TrimSpace(args.ProviderName),
+		CallbackURL:  oauth2.SamlAcsURL(PublicURL, models.ProviderCode(args.ProviderCode)),
+		ProviderCode: models.ProviderCode(args.ProviderCode),
+		IsEnabled:    args.IsEnabled,
+		Kind:         models.KindSaml,
+		Config:       string(rawConfig),
+		Users:        []models.User{},
+	}
+
+	// 作成する
+	err = models.CreateProvider(&provider)
+
+	// エラー処理
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	return toSamlProvider(provider), nil
+}
+
+// SAML プロバイダを更新 (SP の鍵は変えない)
+func UpdateSamlProvider(providerCode string, args SamlProvider) (SamlProvider, error) {
+	// 存在するか確認
+	provider, err := getProviderOfKind(providerCode, models.KindSaml)
+
+	// エラー処理
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	// 設定を読み込む
+	config, err := oauth2.ParseSamlConfig(provider.Config)
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	// 設定を更新
+	if err := args.applyTo(&config); err != nil {
+		return SamlProvider{}, err
+	}
+
+	// 設定を変換
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	provider.ProviderName = strings.TrimSpace(args.ProviderName)
+	provider.IsEnabled = args.IsEnabled
+	provider.Config = string(rawConfig)
+
+	// 更新する
+	err = models.UpdateProviderByCode(provider.ProviderCode, *provider)
+
+	// エラー処理
+	if err != nil {
+		return SamlProvider{}, err
+	}
+
+	return toSamlProvider(*provider), nil
+}
+
+// SAML プロバイダを削除
+func DeleteSamlProvider(providerCode string) error {
+	// 存在するか確認
+	provider, err := getProviderOfKind(providerCode, models.KindSaml)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	return deleteProvider(provider)
+}
+
+// ここまで
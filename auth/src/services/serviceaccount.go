@@ -0,0 +1,290 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// アクセストークンの principalType クレーム
+	principalUser           = "user"
+	principalServiceAccount = "service_account"
+)
+
+var (
+	// サービスアカウントが見つからない時のエラー
+	ErrServiceAccountNotFound = errors.New("service account not found")
+)
+
+// ここからサービスアカウントの管理
+type ServiceAccount struct {
+	AccountID    string   `json:"AccountID"`              // アカウントID (client_id, 読み取り専用)
+	Name         string   `json:"Name"`                   // 表示名
+	Description  string   `json:"Description"`            // 説明
+	Labels       []string `json:"Labels"`                 // スコープとして使うラベル
+	IsEnabled    *bool    `json:"IsEnabled"`              // 有効か (省略した時は作成時は有効、更新時は変更しない)
+	ClientSecret string   `json:"ClientSecret,omitempty"` // クライアントシークレット (作成時と再生成時だけ返す)
+	LastUsedAt   string   `json:"LastUsedAt,omitempty"`   // 最後にトークンを発行した日時 (読み取り専用)
+	CreatedAt    string   `json:"CreatedAt"`              // 作成日 (読み取り専用)
+}
+
+func toServiceAccount(account models.ServiceAccount) ServiceAccount {
+	labels := []string{}
+	for _, label := range account.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	enabled := account.IsEnabled == 1
+	result := ServiceAccount{
+		AccountID:   account.AccountID,
+		Name:        account.Name,
+		Description: account.Description,
+		Labels:      labels,
+		IsEnabled:   &enabled,
+		CreatedAt:   FormatUnixTimestampToString(account.CreatedAt, time.RFC3339),
+	}
+
+	if account.LastUsedAt != 0 {
+		result.LastUsedAt = FormatUnixTimestampToString(account.LastUsedAt, time.RFC3339)
+	}
+
+	return result
+}
+
+// 入力をモデルに反映する
+func (args ServiceAccount) applyTo(account *models.ServiceAccount) error {
+	if strings.TrimSpace(args.Name) == "" {
+		return errors.New("Name is required")
+	}
+
+	// ラベルを取得 (存在するものだけ)
+	labels := []models.Label{}
+	for _, name := range args.Labels {
+		label, err := models.GetLabel(name)
+		if err != nil {
+			return errors.New("label not found: " + name)
+		}
+
+		labels = append(labels, *label)
+	}
+
+	account.Name = args.Name
+	account.Description = args.Description
+	account.Labels = labels
+	if args.IsEnabled != nil {
+		account.IsEnabled = 0
+		if *args.IsEnabled {
+			account.IsEnabled = 1
+		}
+	}
+
+	return nil
+}
+
+// サービスアカウントを取得
+func getServiceAccount(accountID string) (*models.ServiceAccount, error) {
+	account, result := models.GetServiceAccount(accountID)
+
+	// 見つからない時
+	if !result.IsExists {
+		return nil, ErrServiceAccountNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return account, nil
+}
+
+// サービスアカウント一覧を取得
+func GetServiceAccounts() ([]ServiceAccount, error) {
+	accounts, err := models.GetServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ServiceAccount{}
+	for _, account := range accounts {
+		results = append(results, toServiceAccount(account))
+	}
+
+	return results, nil
+}
+
+// サービスアカウントを作成 (シークレットはこの時だけ返す)
+func CreateServiceAccount(args ServiceAccount) (ServiceAccount, error) {
+	account := models.ServiceAccount{
+		AccountID: utils.GenID(),
+		IsEnabled: 1,
+	}
+
+	// 入力を反映
+	if err := args.applyTo(&account); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	// シークレットを生成
+	secret, err := randomToken(32)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	account.SecretHash = hashSecret(secret)
+
+	// 作成する
+	if err := models.CreateServiceAccount(&account); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	result := toServiceAccount(account)
+	result.ClientSecret = secret
+
+	return result, nil
+}
+
+// サービスアカウントを更新
+func UpdateServiceAccount(accountID string, args ServiceAccount) (ServiceAccount, error) {
+	account, err := getServiceAccount(accountID)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	// 入力を反映
+	if err := args.applyTo(account); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	// 更新する
+	if err := models.UpdateServiceAccount(account); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	return toServiceAccount(*account), nil
+}
+
+// シークレットを再生成する (以前のシークレットは使えなくなる)
+func RegenerateServiceAccountSecret(accountID string) (ServiceAccount, error) {
+	account, err := getServiceAccount(accountID)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	// シークレットを生成
+	secret, err := randomToken(32)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	account.SecretHash = hashSecret(secret)
+
+	// 更新する
+	if err := models.UpdateServiceAccount(account); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	result := toServiceAccount(*account)
+	result.ClientSecret = secret
+
+	return result, nil
+}
+
+// サービスアカウントを削除
+func DeleteServiceAccount(accountID string) error {
+	// 存在するか確認
+	if _, err := getServiceAccount(accountID); err != nil {
+		return err
+	}
+
+	return models.DeleteServiceAccount(accountID)
+}
+
+// ここまで
+
+// ここからクライアントクレデンシャル
+// サービスアカウントが有効か (無効化や削除を反映する)
+func isServiceAccountActive(accountID string) (*models.ServiceAccount, bool) {
+	account, err := getServiceAccount(accountID)
+	if err != nil || account.IsEnabled != 1 {
+		return nil, false
+	}
+
+	return account, true
+}
+
+// サービスアカウントのアクセストークンを発行する (ラベルをスコープとして使う)
+func exchangeClientCredentials(args TokenArgs) (TokenResponse, error) {
+	invalidClient := newOauthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+
+	// サービスアカウントを取得
+	account, err := getServiceAccount(args.ClientID)
+	if errors.Is(err, ErrServiceAccountNotFound) {
+		return TokenResponse{}, invalidClient
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// シークレットを検証
+	if args.ClientSecret == "" || subtle.ConstantTimeCompare([]byte(hashSecret(args.ClientSecret)), []byte(account.SecretHash)) != 1 {
+		return TokenResponse{}, invalidClient
+	}
+
+	// 無効化されている時
+	if account.IsEnabled != 1 {
+		return TokenResponse{}, invalidClient
+	}
+
+	// 持っているラベル
+	granted := []string{}
+	for _, label := range account.Labels {
+		granted = append(granted, label.Name)
+	}
+
+	// スコープ (省略した時は全てのラベル)
+	scopes := splitScope(args.Scope)
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return TokenResponse{}, newOauthError(http.StatusBadRequest, "invalid_scope", "scope is not granted to this service account: "+scope)
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+
+	// アクセストークンを発行
+	token, err := AccessTokenJwt(AccessTokenClaim{
+		UserID:        account.AccountID,
+		Labels:        scopes,
+		ProvCode:      models.ServiceAccountCode,
+		ProvUid:       account.AccountID,
+		Scope:         scope,
+		PrincipalType: principalServiceAccount,
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	// 使用日時を記録
+	if err := models.TouchServiceAccount(account.AccountID, utils.NowTime()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	return TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(tokenExpiry.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// ここまで
@@ -4,34 +4,24 @@ import (
 	"auth/models"
 	"auth/utils"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	// BANされている時のエラー
+	ErrUserBanned = errors.New("Your account has been banned")
+)
+
 type SessionArgs struct {
 	UserID    string // ユーザーID
 	RemoteIP  string // リモートIP
 	UserAgent string // ユーザーエージェント
 }
 
-func GenSessionToken(SessionID string) (string, error) {
-	// トークンを生成
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-		"SessionID": SessionID,
-	})
-
-	// トークンに署名
-	signedToken, err := token.SignedString([]byte(TokenSecret))
-
-	// エラー処理
-	if err != nil {
-		return "", err
-	}
-
-	return signedToken, nil
-}
-
-// トークンを検証
+// 以前のセッショントークン (HS512) を検証する
 func ValidateSessionToken(tokenString string) (string, error) {
 	// トークンを検証
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -46,13 +36,14 @@ func ValidateSessionToken(tokenString string) (string, error) {
 
 	// トークンを検証
 	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		return claims["SessionID"].(string), nil
+		sessionID, _ := claims["SessionID"].(string)
+		return sessionID, nil
 	}
 
 	return "", nil
 }
 
-// セッションを作成してトークンを返す
+// セッションを作成してリフレッシュトークンを返す
 func NewSession(args SessionArgs) (string, error) {
 	// ユーザーIDを取得
 	user, result := models.GetUser(args.UserID)
@@ -64,54 +55,91 @@ func NewSession(args SessionArgs) (string, error) {
 
 	// BANされている時
 	if user.IsBanned == 1 {
-		return "", errors.New("Your account has been banned")
+		return "", ErrUserBanned
+	}
+
+	// メールアドレスの確認が必要な時
+	required, err := isEmailVerificationRequired(user)
+	if err != nil {
+		return "", err
+	}
+
+	if required {
+		return "", ErrEmailNotVerified
 	}
 
 	// セッションIDを生成
 	SessionID := utils.GenID()
+	now := time.Now()
 
 	// セッションを作成
 	session := models.Session{
-		SessionID: SessionID,
-		UserID:    args.UserID,
-		RemoteIP:  args.RemoteIP,
-		UserAgent: args.UserAgent,
+		SessionID:  SessionID,
+		UserID:     args.UserID,
+		RemoteIP:   args.RemoteIP,
+		UserAgent:  args.UserAgent,
+		ExpiresAt:  now.Add(SessionLifetime).Unix(),
+		LastSeenAt: now.Unix(),
 	}
 
-	// セッションを追加
-	if err := user.NewSession(&session); err != nil {
+	// 同時ログイン数の上限を確認してセッションを追加 (古いセッションを終了させることもある)
+	if err := createLimitedSession(user, &session); err != nil {
 		return "", err
 	}
 
-	// トークンを生成
-	token, err := GenSessionToken(SessionID)
+	// リフレッシュトークンを発行 (新しい系列)
+	token, _, err := issueRefreshToken(refreshTokenArgs{
+		SessionID: SessionID,
+		UserID:    args.UserID,
+		ExpiresAt: session.ExpiresAt,
+	})
 
 	return token, err
 }
 
+// アクセストークンからセッションを取得する
 func GetSession(tokenString string) (*models.Session, error) {
 	// トークンを検証
-	SessionID, err := ValidateSessionToken(tokenString)
+	claims, err := parseAccessToken(strings.TrimPrefix(tokenString, "Bearer "))
 
 	// エラー処理
 	if err != nil {
 		return nil, err
 	}
 
-	// セッションを取得
-	return models.GetSession(SessionID)
+	// クライアントに発行したトークンは受け付けない
+	if _, ok := claims["aud"]; ok {
+		return nil, ErrInvalidAccessToken
+	}
+
+	SessionID, _ := claims["sid"].(string)
+	if SessionID == "" {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// セッションを取得 (期限切れの時はエラー)
+	session, err := getActiveSession(SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 使われた日時を記録
+	touchSession(session)
+
+	return session, nil
 }
 
 // ここからセッション一覧
 // Session represents a user session.
 type Session struct {
-	ID        string `json:"id"`
-	UserID    string `json:"userId"`
-	IPAddress string `json:"ipAddress"`
-	UserAgent string `json:"userAgent"`
-	CreatedAt int64  `json:"createdAt"`
-	ExpiresAt int64  `json:"expiresAt"` // 最終アクティブから有効期限に変更
-	IsActive  bool   `json:"isActive"`
+	ID         string `json:"id"`
+	UserID     string `json:"userId"`
+	IPAddress  string `json:"ipAddress"`
+	UserAgent  string `json:"userAgent"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastSeenAt int64  `json:"lastSeenAt"`
+	ExpiresAt  int64  `json:"expiresAt"` // 絶対期限とアイドル期限の早い方
+	IsActive   bool   `json:"isActive"`
 }
 
 func GetAllSessions() ([]Session, error) {
@@ -125,17 +153,21 @@ func GetAllSessions() ([]Session, error) {
 
 	// 返すデータ
 	returnSessions := make([]Session, len(sessions))
+	now := time.Now().Unix()
 
 	// セッションを回す
 	for i, session := range sessions {
+		expiresAt := sessionExpiresAt(&session)
+
 		returnSessions[i] = Session{
-			ID:        session.SessionID,
-			UserID:    session.UserID,
-			IPAddress: session.RemoteIP,
-			UserAgent: session.UserAgent,
-			CreatedAt: session.CreatedAt * 1000,
-			ExpiresAt: session.CreatedAt * 1000 + 1000*60*60*24*30,
-			IsActive:  true,
+			ID:         session.SessionID,
+			UserID:     session.UserID,
+			IPAddress:  session.RemoteIP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt * 1000,
+			LastSeenAt: session.LastSeenAt * 1000,
+			ExpiresAt:  expiresAt * 1000,
+			IsActive:   now <= expiresAt,
 		}
 	}
 
@@ -146,6 +178,12 @@ func GetAllSessions() ([]Session, error) {
 
 // ここからセッション削除
 func DeleteSession(SessionID string) error {
-	// サービスを呼び出す
-	return models.DeleteSession(SessionID)
+	// セッションを取得
+	session, err := models.GetSession(SessionID)
+	if err != nil {
+		return err
+	}
+
+	// 削除して発行済みのトークンを失効させる
+	return RevokeSessionTokens(session.UserID, session.SessionID, RevocationAdminLogout)
 }
\ No newline at end of file
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	// 最終使用日時を更新する間隔 (リクエストごとに書き込まない)
+	sessionTouchInterval = time.Minute
+
+	// 期限切れのセッションを削除する間隔
+	sessionReapInterval = time.Minute * 10
+)
+
+var (
+	// ログインしてから再ログインが必要になるまでの期間 (SESSION_LIFETIME)
+	SessionLifetime = time.Hour * 24 * 30
+
+	// 使われないまま終了するまでの期間 (SESSION_IDLE_TIMEOUT)
+	SessionIdleTimeout = time.Hour * 24 * 7
+
+	// セッションの期限が切れている時のエラー
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// 環境変数から期間を読み込む (不正な値の時は既定値)
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		logger.PrintErr(name+" が不正なため既定値を使います", value)
+		return fallback
+	}
+
+	return duration
+}
+
+func initSessionExpiry() {
+	SessionLifetime = durationFromEnv("SESSION_LIFETIME", SessionLifetime)
+	SessionIdleTimeout = durationFromEnv("SESSION_IDLE_TIMEOUT", SessionIdleTimeout)
+
+	// 以前のセッションに期限を設定する
+	if err := models.BackfillSessionExpiry(int64(SessionLifetime.Seconds()), time.Now().Unix()); err != nil {
+		logger.PrintErr(err)
+	}
+
+	// 期限切れのセッションを定期的に削除する
+	go reapExpiredSessions()
+}
+
+// 期限切れのセッションとリフレッシュトークンを削除し続ける
+func reapExpiredSessions() {
+	ticker := time.NewTicker(sessionReapInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+
+		deleted, err := models.DeleteExpiredSessions(now.Unix(), now.Add(-SessionIdleTimeout).Unix())
+		if err != nil {
+			logger.PrintErr(err)
+		} else if deleted > 0 {
+			logger.Println("期限切れのセッションを削除しました", deleted)
+		}
+
+		// 期限切れのリフレッシュトークンを削除する (リフレッシュのたびに行うと重いため)
+		if err := models.DeleteExpiredRefreshTokens(now.Unix()); err != nil {
+			logger.PrintErr(err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// セッションの期限 (絶対期限とアイドル期限の早い方)
+func sessionExpiresAt(session *models.Session) int64 {
+	return min(session.ExpiresAt, session.LastSeenAt+int64(SessionIdleTimeout.Seconds()))
+}
+
+// 有効なセッションを取得する (期限切れの時は削除する)
+func getActiveSession(sessionID string) (*models.Session, error) {
+	session, err := models.Sessions().GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 期限切れの時
+	if time.Now().Unix() > sessionExpiresAt(session) {
+		if err := models.DeleteSession(sessionID); err != nil {
+			logger.PrintErr(err)
+		}
+
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+// セッションが使われたことを記録する (アイドル期限を延ばす)
+func touchSession(session *models.Session) {
+	now := time.Now().Unix()
+	if now-session.LastSeenAt <= int64(sessionTouchInterval.Seconds()) {
+		return
+	}
+
+	if err := models.TouchSession(session.SessionID, now); err != nil {
+		logger.PrintErr(err)
+		return
+	}
+
+	session.LastSeenAt = now
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"testing"
+	"time"
+)
+
+// アイドル期限を固定する (終了時に戻す)
+func useSessionIdleTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	previous := SessionIdleTimeout
+	SessionIdleTimeout = timeout
+	t.Cleanup(func() { SessionIdleTimeout = previous })
+}
+
+func TestSessionExpiresAt(t *testing.T) {
+	useSessionIdleTimeout(t, time.Hour)
+
+	now := time.Now().Unix()
+	idle := int64(time.Hour.Seconds())
+
+	cases := []struct {
+		name       string
+		expiresAt  int64
+		lastSeenAt int64
+		expected   int64
+	}{
+		// 使われ続けていても絶対期限で終わる
+		{"absolute first", now + 60, now, now + 60},
+		// 絶対期限の前でも使われないと終わる
+		{"idle first", now + idle*24, now - 60, now - 60 + idle},
+		{"same", now + idle, now, now + idle},
+	}
+
+	for _, c := range cases {
+		session := &models.Session{ExpiresAt: c.expiresAt, LastSeenAt: c.lastSeenAt}
+		if expiresAt := sessionExpiresAt(session); expiresAt != c.expected {
+			t.Fatalf("%s: expected %d, got %d", c.name, c.expected, expiresAt)
+		}
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", time.Hour},
+		{"30m", time.Minute * 30},
+		// 不正な値は既定値
+		{"soon", time.Hour},
+		{"0s", time.Hour},
+		{"-1h", time.Hour},
+	}
+
+	for _, c := range cases {
+		t.Setenv("TEST_SESSION_DURATION", c.value)
+		if duration := durationFromEnv("TEST_SESSION_DURATION", time.Hour); duration != c.expected {
+			t.Fatalf("%q: expected %v, got %v", c.value, c.expected, duration)
+		}
+	}
+}
+
+// 期限を指定してセッションを作る
+func createTestSessionAt(t *testing.T, user *models.User, expiresAt int64, lastSeenAt int64) *models.Session {
+	t.Helper()
+
+	session := &models.Session{
+		SessionID:  utils.GenID(),
+		UserID:     user.UserID,
+		ExpiresAt:  expiresAt,
+		LastSeenAt: lastSeenAt,
+	}
+
+	_, err := models.CreateUserSession(session, func(sessions []models.Session) ([]string, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+func TestGetActiveSessionExpiry(t *testing.T) {
+	requireTestDB(t)
+	useSessionIdleTimeout(t, time.Hour)
+
+	user := createTestUser(t)
+	now := time.Now()
+
+	live := createTestSessionAt(t, user, now.Add(time.Hour*24).Unix(), now.Unix())
+	idle := createTestSessionAt(t, user, now.Add(time.Hour*24).Unix(), now.Add(-time.Hour*2).Unix())
+	absolute := createTestSessionAt(t, user, now.Add(-time.Minute).Unix(), now.Unix())
+
+	if _, err := getActiveSession(live.SessionID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 期限切れの時は削除する
+	for _, session := range []*models.Session{idle, absolute} {
+		if _, err := getActiveSession(session.SessionID); !errors.Is(err, ErrSessionExpired) {
+			t.Fatalf("expected ErrSessionExpired, got %v", err)
+		}
+		if _, err := models.GetSession(session.SessionID); err == nil {
+			t.Fatal("expired session was not deleted")
+		}
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	requireTestDB(t)
+
+	user := createTestUser(t)
+	now := time.Now()
+	idleBefore := now.Add(-time.Hour).Unix()
+
+	live := createTestSessionAt(t, user, now.Add(time.Hour*24).Unix(), now.Unix())
+	idle := createTestSessionAt(t, user, now.Add(time.Hour*24).Unix(), now.Add(-time.Hour*2).Unix())
+	absolute := createTestSessionAt(t, user, now.Add(-time.Minute).Unix(), now.Unix())
+
+	deleted, err := models.DeleteExpiredSessions(now.Unix(), idleBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 他のテストが残したものも消えるので下限だけ確認する
+	if deleted < 2 {
+		t.Fatalf("expected at least 2 deleted sessions, got %d", deleted)
+	}
+
+	if _, err := models.GetSession(live.SessionID); err != nil {
+		t.Fatalf("live session was deleted: %v", err)
+	}
+	for _, session := range []*models.Session{idle, absolute} {
+		if _, err := models.GetSession(session.SessionID); err == nil {
+			t.Fatalf("expired session %s was not deleted", session.SessionID)
+		}
+	}
+}
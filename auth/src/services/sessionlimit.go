@@ -0,0 +1,252 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// 同時ログイン数の上限に達している時のエラー
+	ErrSessionLimitReached = errors.New("too many active sessions")
+
+	// 上限の設定が見つからない時のエラー
+	ErrSessionLimitNotFound = errors.New("session limit not found")
+
+	// 上限を超えた時の動作の一覧
+	sessionLimitPolicies = []string{models.SessionLimitReject, models.SessionLimitEvictOldest}
+)
+
+// ここから上限の設定
+type SessionLimit struct {
+	ID          uint   `json:"ID"`          // ID (読み取り専用)
+	LabelName   string `json:"LabelName"`   // 対象のラベル (空は全てのユーザー)
+	MaxSessions int    `json:"MaxSessions"` // 同時に持てるセッションの数
+	Policy      string `json:"Policy"`      // reject / evict_oldest
+	CreatedAt   string `json:"CreatedAt"`   // 作成日 (読み取り専用)
+	UpdatedAt   string `json:"UpdatedAt"`   // 更新日 (読み取り専用)
+}
+
+func toSessionLimit(limit models.SessionLimit) SessionLimit {
+	return SessionLimit{
+		ID:          limit.ID,
+		LabelName:   limit.LabelName,
+		MaxSessions: limit.MaxSessions,
+		Policy:      limit.Policy,
+		CreatedAt:   FormatUnixTimestampToString(limit.CreatedAt, time.RFC3339),
+		UpdatedAt:   FormatUnixTimestampToString(limit.UpdatedAt, time.RFC3339),
+	}
+}
+
+// 入力を検証してモデルに反映する
+func (args SessionLimit) applyTo(limit *models.SessionLimit) error {
+	if args.MaxSessions < 1 {
+		return errors.New("MaxSessions must be at least 1")
+	}
+
+	// 既定は新しいログインを拒否する
+	if args.Policy == "" {
+		args.Policy = models.SessionLimitReject
+	}
+	if !slices.Contains(sessionLimitPolicies, args.Policy) {
+		return errors.New("Policy must be one of " + strings.Join(sessionLimitPolicies, ", "))
+	}
+
+	// ラベルが存在するか
+	labelName := strings.TrimSpace(args.LabelName)
+	if labelName != "" {
+		if _, err := models.GetLabel(labelName); err != nil {
+			return errors.New("label not found: " + labelName)
+		}
+	}
+
+	limit.LabelName = labelName
+	limit.MaxSessions = args.MaxSessions
+	limit.Policy = args.Policy
+
+	return nil
+}
+
+// 上限一覧を取得
+func GetSessionLimits() ([]SessionLimit, error) {
+	limits, err := models.GetSessionLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SessionLimit{}
+	for _, limit := range limits {
+		results = append(results, toSessionLimit(limit))
+	}
+
+	return results, nil
+}
+
+// 上限を作成
+func CreateSessionLimit(args SessionLimit) (SessionLimit, error) {
+	limit := models.SessionLimit{}
+
+	// 入力を反映
+	if err := args.applyTo(&limit); err != nil {
+		return SessionLimit{}, err
+	}
+
+	// 作成する
+	if err := models.CreateSessionLimit(&limit); err != nil {
+		return SessionLimit{}, err
+	}
+
+	return toSessionLimit(limit), nil
+}
+
+// 上限を更新
+func UpdateSessionLimit(id uint, args SessionLimit) (SessionLimit, error) {
+	// 取得する
+	limit, result := models.GetSessionLimit(id)
+	if !result.IsExists {
+		return SessionLimit{}, ErrSessionLimitNotFound
+	}
+	if result.Error != nil {
+		return SessionLimit{}, result.Error
+	}
+
+	// 入力を反映
+	if err := args.applyTo(limit); err != nil {
+		return SessionLimit{}, err
+	}
+
+	// 更新する
+	if err := models.UpdateSessionLimit(limit); err != nil {
+		return SessionLimit{}, err
+	}
+
+	return toSessionLimit(*limit), nil
+}
+
+// 上限を削除
+func DeleteSessionLimit(id uint) error {
+	// 存在するか確認
+	_, result := models.GetSessionLimit(id)
+	if !result.IsExists {
+		return ErrSessionLimitNotFound
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return models.DeleteSessionLimit(id)
+}
+
+// ここまで
+
+// ここから上限の適用
+// ユーザーに適用する上限 (複数ある時は一番厳しいもの, ない時は nil)
+func userSessionLimit(user *models.User) (*models.SessionLimit, error) {
+	labels, err := user.GetLabelNames()
+	if err != nil {
+		return nil, err
+	}
+
+	// 全てのユーザーの上限とラベルの上限
+	limits, err := models.GetSessionLimitsFor(append(labels, "")...)
+	if err != nil {
+		return nil, err
+	}
+
+	var strictest *models.SessionLimit
+	for i := range limits {
+		if strictest == nil || limits[i].MaxSessions < strictest.MaxSessions {
+			strictest = &limits[i]
+		}
+	}
+
+	return strictest, nil
+}
+
+// 新しいセッションを作れるか確認する (二要素認証の前など、まだセッションを作らない時)
+func checkSessionLimit(user *models.User) error {
+	limit, err := userSessionLimit(user)
+	if err != nil || limit == nil {
+		return err
+	}
+
+	sessions, err := models.GetUserSessions(user.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = sessionsToEvict(limit, sessions, time.Now().Unix())
+	return err
+}
+
+// 新しいセッションを追加するために終了させるセッション (拒否する時は ErrSessionLimitReached)
+func sessionsToEvict(limit *models.SessionLimit, sessions []models.Session, now int64) ([]string, error) {
+	if limit == nil {
+		return nil, nil
+	}
+
+	// 有効なセッションを古い順に並べる
+	active := []models.Session{}
+	for _, session := range sessions {
+		if now <= sessionExpiresAt(&session) {
+			active = append(active, session)
+		}
+	}
+
+	slices.SortFunc(active, func(a, b models.Session) int {
+		return int(a.CreatedAt - b.CreatedAt)
+	})
+
+	// 新しいセッションを含めて上限以内の時
+	excess := len(active) + 1 - limit.MaxSessions
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	// 拒否する時
+	if limit.Policy != models.SessionLimitEvictOldest {
+		return nil, ErrSessionLimitReached
+	}
+
+	// 古いセッションから終了させる
+	sessionIDs := []string{}
+	for _, session := range active[:excess] {
+		sessionIDs = append(sessionIDs, session.SessionID)
+	}
+
+	return sessionIDs, nil
+}
+
+// 同時ログイン数の上限を確認してセッションを作成する
+// 確認と作成はユーザーの行をロックして行う (同時にログインしても上限を超えない)
+func createLimitedSession(user *models.User, session *models.Session) error {
+	limit, err := userSessionLimit(user)
+	if err != nil {
+		return err
+	}
+
+	// 作成する
+	now := time.Now().Unix()
+	evicted, err := models.CreateUserSession(session, func(sessions []models.Session) ([]string, error) {
+		return sessionsToEvict(limit, sessions, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 終了させたセッションのトークンを失効させる
+	for _, sessionID := range evicted {
+		logger.Println("同時ログイン数の上限を超えたためセッションを終了します", sessionID)
+
+		if err := recordRevocation(user.UserID, sessionID, RevocationSessionLimit); err != nil {
+			logger.PrintErr(err)
+		}
+	}
+
+	return nil
+}
+
+// ここまで
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"auth/models"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSessionsToEvict(t *testing.T) {
+	now := time.Now().Unix()
+
+	// 作成日の違う有効なセッション
+	session := func(sessionID string, createdAt int64) models.Session {
+		return models.Session{
+			SessionID:  sessionID,
+			CreatedAt:  createdAt,
+			ExpiresAt:  now + 3600,
+			LastSeenAt: now,
+		}
+	}
+
+	sessions := []models.Session{
+		session("newest", now-10),
+		session("oldest", now-30),
+		session("middle", now-20),
+		// 期限切れは数えない
+		{SessionID: "expired", CreatedAt: now - 40, ExpiresAt: now - 1, LastSeenAt: now - 1},
+	}
+
+	reject := &models.SessionLimit{MaxSessions: 3, Policy: models.SessionLimitReject}
+	evict := &models.SessionLimit{MaxSessions: 2, Policy: models.SessionLimitEvictOldest}
+
+	// 上限がない時
+	if ids, err := sessionsToEvict(nil, sessions, now); err != nil || len(ids) != 0 {
+		t.Fatalf("no limit: %v %v", ids, err)
+	}
+
+	// 上限以内の時
+	if ids, err := sessionsToEvict(&models.SessionLimit{MaxSessions: 4, Policy: models.SessionLimitReject}, sessions, now); err != nil || len(ids) != 0 {
+		t.Fatalf("within limit: %v %v", ids, err)
+	}
+
+	// 拒否する時
+	if _, err := sessionsToEvict(reject, sessions, now); !errors.Is(err, ErrSessionLimitReached) {
+		t.Fatalf("expected ErrSessionLimitReached, got %v", err)
+	}
+
+	// 古いものから終了させる時
+	ids, err := sessionsToEvict(evict, sessions, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{"oldest", "middle"}) {
+		t.Fatalf("unexpected evicted sessions: %v", ids)
+	}
+
+	// 1 つだけ持てる時
+	ids, err = sessionsToEvict(&models.SessionLimit{MaxSessions: 1, Policy: models.SessionLimitEvictOldest}, sessions[:1], now)
+	if err != nil || !slices.Equal(ids, []string{"newest"}) {
+		t.Fatalf("trial limit: %v %v", ids, err)
+	}
+}
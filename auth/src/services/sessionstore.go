@@ -0,0 +1,50 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// データベースを直接引く (既定)
+	sessionStoreDatabase = "database"
+
+	// プロセス内の LRU に覚える
+	sessionStoreMemory = "memory"
+)
+
+var (
+	// LRU に覚えるセッションとユーザーの最大数 (SESSION_CACHE_SIZE)
+	SessionCacheSize = 10000
+
+	// LRU に覚えておく期間 (SESSION_CACHE_TTL, 他のプロセスでの失効以外の変更が見えるまでの最大時間)
+	SessionCacheTTL = time.Second * 30
+)
+
+// セッションの取得先を設定する (SESSION_STORE)
+func initSessionStore() {
+	switch store := os.Getenv("SESSION_STORE"); store {
+	case "", sessionStoreDatabase:
+		return
+	case sessionStoreMemory:
+	default:
+		logger.PrintErr("SESSION_STORE が不正なためデータベースを使います", store)
+		return
+	}
+
+	if value := os.Getenv("SESSION_CACHE_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			logger.PrintErr("SESSION_CACHE_SIZE が不正なため既定値を使います", value)
+		} else {
+			SessionCacheSize = size
+		}
+	}
+	SessionCacheTTL = durationFromEnv("SESSION_CACHE_TTL", SessionCacheTTL)
+
+	// 他のプロセスで失効させたものは initRevocationSync で捨てる
+	models.SetSessionStore(models.NewCachedSessionStore(models.Sessions(), SessionCacheSize, SessionCacheTTL))
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// リクエストが多すぎる時のエラー
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// 直近のウィンドウ内の回数を制限する (キーごとにリクエスト時刻を記録するスライディングログ)
+type rateLimiter struct {
+	mutex  sync.Mutex
+	limit  int                    // ウィンドウ内の最大回数
+	window time.Duration          // ウィンドウの長さ
+	hits   map[string][]time.Time // キーごとのリクエスト時刻
+	calls  int                    // 掃除用のカウンタ
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   map[string][]time.Time{},
+	}
+}
+
+// 許可するかどうか (許可した時は回数に数える)
+func (limiter *rateLimiter) Allow(key string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+
+	// 定期的に古いキーを削除する
+	limiter.calls++
+	if limiter.calls%1000 == 0 {
+		limiter.sweep(now)
+	}
+
+	// ウィンドウ内のリクエストだけ残す
+	hits := limiter.prune(limiter.hits[key], now)
+
+	// 上限に達している時
+	if len(hits) >= limiter.limit {
+		limiter.hits[key] = hits
+		return false
+	}
+
+	limiter.hits[key] = append(hits, now)
+	return true
+}
+
+// ウィンドウ外の時刻を削除する
+func (limiter *rateLimiter) prune(hits []time.Time, now time.Time) []time.Time {
+	kept := hits[:0]
+	for _, hit := range hits {
+		if now.Sub(hit) < limiter.window {
+			kept = append(kept, hit)
+		}
+	}
+
+	return kept
+}
+
+// 全てのキーを掃除する
+func (limiter *rateLimiter) sweep(now time.Time) {
+	for key, hits := range limiter.hits {
+		hits = limiter.prune(hits, now)
+		if len(hits) == 0 {
+			delete(limiter.hits, key)
+			continue
+		}
+
+		limiter.hits[key] = hits
+	}
+}
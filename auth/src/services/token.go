@@ -1,8 +1,13 @@
 package services
 
-import "auth/models"
+import (
+	"auth/models"
+	"slices"
+)
 
-func GetAccessToken(userID string) (string, error) {
+// ユーザーのアクセストークンを発行する (audience が空の時はクライアントを指定しない)
+// ラベルは audience が空の時は全て、クライアントの時は許可されたスコープにあるものだけ
+func issueAccessToken(userID string, sessionID string, audience string, scope string) (string, error) {
 	// ユーザーを取得
 	user, result := models.GetUser(userID)
 
@@ -11,8 +16,47 @@ func GetAccessToken(userID string) (string, error) {
 		return "", result.Error
 	}
 
+	// ラベルを取得
+	current, err := user.GetLabelNames()
+	if err != nil {
+		return "", err
+	}
+
+	// クライアントに発行する時は許可されたスコープにあるラベルだけ入れる
+	labels := current
+	if audience != "" {
+		labels = labelsInScope(current, scope)
+	}
+
+	// 設定されたクレームを作る
+	extra, err := mappedClaims(user, audience)
+	if err != nil {
+		return "", err
+	}
+
 	// トークンを生成
-	token, err := AccessTokenJwt(AccessTokenClaim{UserID: userID, Labels: []string{}, ProvCode: user.ProvCode, ProvUid: user.ProvUID})
+	token, err := AccessTokenJwt(AccessTokenClaim{
+		UserID:    userID,
+		SessionID: sessionID,
+		Labels:    labels,
+		ProvCode:  user.ProvCode,
+		ProvUid:   user.ProvUID,
+		Audience:  audience,
+		Scope:     scope,
+		Extra:     extra,
+	})
 
 	return token, err
 }
+
+// スコープに含まれるラベルだけに絞る
+func labelsInScope(labels []string, scope string) []string {
+	result := []string{}
+	for _, name := range splitScope(scope) {
+		if slices.Contains(labels, name) {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
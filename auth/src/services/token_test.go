@@ -0,0 +1,29 @@
+package services
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLabelsInScope(t *testing.T) {
+	labels := []string{"admin", "developer", "viewer"}
+
+	cases := []struct {
+		scope    string
+		expected []string
+	}{
+		// OpenID Connect のスコープだけの時はラベルを入れない
+		{"openid profile email", []string{}},
+		{"", []string{}},
+		{"openid developer", []string{"developer"}},
+		{"viewer developer viewer", []string{"viewer", "developer"}},
+		// 持っていないラベルは入れない
+		{"owner admin", []string{"admin"}},
+	}
+
+	for _, c := range cases {
+		if got := labelsInScope(labels, c.scope); !slices.Equal(got, c.expected) {
+			t.Fatalf("scope %q: expected %v, got %v", c.scope, c.expected, got)
+		}
+	}
+}
@@ -30,11 +30,13 @@ func GetInfo(userid string) (GetUserInfo, error) {
 }
 
 type UserInfo struct {
-	UserID   string `json:"user_id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	ProvCode string `json:"prov_code"`
-	ProvUid  string `json:"prov_uid"`
+	UserID        string `json:"user_id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	ProvCode      string `json:"prov_code"`
+	ProvUid       string `json:"prov_uid"`
+	TotpEnabled   bool   `json:"totp_enabled"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func GetMe(userid string) (UserInfo, error) {
@@ -47,11 +49,13 @@ func GetMe(userid string) (UserInfo, error) {
 	}
 
 	return UserInfo{
-		UserID:   user.UserID,
-		Name:     user.Name,
-		Email:    user.Email,
-		ProvCode: string(user.ProvCode),
-		ProvUid:  user.ProvUID,
+		UserID:        user.UserID,
+		Name:          user.Name,
+		Email:         user.Email,
+		ProvCode:      string(user.ProvCode),
+		ProvUid:       user.ProvUID,
+		TotpEnabled:   user.TotpEnabled == 1,
+		EmailVerified: user.EmailVerifiedAt != 0,
 	}, nil
 }
 
@@ -134,6 +138,26 @@ func DeleteUser(userid string) error {
 		return err
 	}
 
+	// クライアントへの同意を削除する
+	if err := models.DeleteUserOauthConsents(userid); err != nil {
+		return err
+	}
+
+	// パーソナルアクセストークンを削除する
+	if err := models.DeleteUserPersonalAccessTokens(userid); err != nil {
+		return err
+	}
+
+	// ユーザーの属性を削除する
+	if err := models.DeleteUserAttributes(userid); err != nil {
+		return err
+	}
+
+	// セッションと発行済みのトークンを失効させる
+	if err := RevokeUserTokens(userid, RevocationDelete); err != nil {
+		return err
+	}
+
 	// ユーザーを削除する
 	return models.DeleteUser(userid)
 }
@@ -142,15 +166,16 @@ func DeleteUser(userid string) error {
 
 // ここからユーザー一覧取得
 type User struct {
-	ID         string   `json:"id"`
-	Name       string   `json:"name"`
-	Email      string   `json:"email"`
-	Provider   string   `json:"provider"`
-	ProviderID string   `json:"providerId"`
-	Avatar     string   `json:"avatar"`
-	Labels     []string `json:"labels"`
-	CreatedAt  string   `json:"createdAt"` // 日時型にする場合は time.Time を使用し、適切なフォーマットでパース・フォーマットする必要があります
-	Banned     bool     `json:"banned"`
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Email      string         `json:"email"`
+	Provider   string         `json:"provider"`
+	ProviderID string         `json:"providerId"`
+	Avatar     string         `json:"avatar"`
+	Labels     []string       `json:"labels"`
+	CreatedAt  string         `json:"createdAt"` // 日時型にする場合は time.Time を使用し、適切なフォーマットでパース・フォーマットする必要があります
+	Banned     bool           `json:"banned"`
+	Identities []IdentityInfo `json:"identities"` // 連携している外部アカウント
 }
 
 func GetUsers() ([]User, error) {
@@ -162,8 +187,22 @@ func GetUsers() ([]User, error) {
 		return []User{}, err
 	}
 
+	// 連携を取得
+	identities, err := models.GetAllIdentities()
+
+	// エラー処理
+	if err != nil {
+		return []User{}, err
+	}
+
 	userResponse := []User{}
 	for _, user := range users {
+		// 連携を変換
+		identityInfos := []IdentityInfo{}
+		for _, identity := range identities[user.UserID] {
+			identityInfos = append(identityInfos, toIdentityInfo(identity))
+		}
+
 		// ラベルを取得
 		labels, err := user.GetLabelNames()
 
@@ -183,6 +222,7 @@ func GetUsers() ([]User, error) {
 			Labels:     labels,
 			CreatedAt:  FormatUnixTimestampToString(user.CreatedAt, time.RFC3339),
 			Banned:     user.IsBanned == 1,
+			Identities: identityInfos,
 		})
 	}
 
@@ -225,7 +265,30 @@ func ToggleBan(args BanArgs) error {
 	}
 
 	// ユーザーを更新する
-	return models.UpdateUser(user)
+	if err := models.UpdateUser(user); err != nil {
+		return err
+	}
+
+	// BANした時はセッションと発行済みのトークンを失効させる
+	if args.IsBanned {
+		return RevokeUserTokens(user.UserID, RevocationBan)
+	}
+
+	return nil
+}
+
+// 管理者がユーザーを強制的にログアウトさせる
+func ForceLogout(userID string) error {
+	// ユーザーが存在するか
+	_, result := models.GetUser(userID)
+	if !result.IsExists {
+		return ErrUserNotFound
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return RevokeUserTokens(userID, RevocationAdminLogout)
 }
 
 // ここまで
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ユーザーエージェントを解析した結果 (表示用の大まかなもの)
+type userAgentInfo struct {
+	Browser string // ブラウザ名とメジャーバージョン
+	OS      string // OS 名
+	Device  string // desktop / mobile / tablet / bot / unknown
+}
+
+type userAgentRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var (
+	// ブラウザの判定 (他のブラウザの名前も含むので順番に意味がある)
+	browserRules = []userAgentRule{
+		{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+		{"Safari", regexp.MustCompile(`Version/(\d+).*Safari/`)},
+	}
+
+	// OS の判定
+	osRules = []userAgentRule{
+		{"iOS", regexp.MustCompile(`iPhone|iPad|iPod`)},
+		{"Android", regexp.MustCompile(`Android`)},
+		{"ChromeOS", regexp.MustCompile(`CrOS`)},
+		{"Windows", regexp.MustCompile(`Windows`)},
+		{"macOS", regexp.MustCompile(`Macintosh|Mac OS X`)},
+		{"Linux", regexp.MustCompile(`Linux`)},
+	}
+
+	// ボットの判定 (名前の部分を取り出す)
+	botPattern = regexp.MustCompile(`(?i)[\w.-]*(?:bot|crawler|spider|curl|wget|python-requests|go-http-client)[\w.-]*`)
+)
+
+// ユーザーエージェントを解析する
+func parseUserAgent(userAgent string) userAgentInfo {
+	info := userAgentInfo{
+		Browser: "Unknown",
+		OS:      "Unknown",
+		Device:  "unknown",
+	}
+
+	if userAgent == "" {
+		return info
+	}
+
+	// ボットやコマンドラインのツール
+	if name := botPattern.FindString(userAgent); name != "" {
+		info.Browser = name
+		info.Device = "bot"
+		return info
+	}
+
+	// ブラウザ
+	for _, rule := range browserRules {
+		if match := rule.pattern.FindStringSubmatch(userAgent); match != nil {
+			info.Browser = rule.name + " " + match[1]
+			break
+		}
+	}
+
+	// OS
+	for _, rule := range osRules {
+		if rule.pattern.MatchString(userAgent) {
+			info.OS = rule.name
+			break
+		}
+	}
+
+	// 端末の種類
+	switch {
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet") || (info.OS == "Android" && !strings.Contains(userAgent, "Mobile")):
+		info.Device = "tablet"
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "iPhone"):
+		info.Device = "mobile"
+	case info.OS != "Unknown":
+		info.Device = "desktop"
+	}
+
+	return info
+}
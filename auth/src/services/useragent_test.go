@@ -0,0 +1,54 @@
+package services
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		name      string
+		userAgent string
+		expected  userAgentInfo
+	}{
+		// Edge は Chrome と Safari の名前も含む
+		{"edge", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			userAgentInfo{"Edge 120", "Windows", "desktop"}},
+		// Chrome は Safari の名前も含む
+		{"chrome", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36",
+			userAgentInfo{"Chrome 121", "macOS", "desktop"}},
+		{"chrome ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			userAgentInfo{"Chrome 120", "iOS", "mobile"}},
+		{"chrome android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			userAgentInfo{"Chrome 120", "Android", "mobile"}},
+		{"safari", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			userAgentInfo{"Safari 17", "macOS", "desktop"}},
+		{"safari iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			userAgentInfo{"Safari 17", "iOS", "mobile"}},
+		// iPad は Mobile を含むがタブレットにする
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			userAgentInfo{"Safari 17", "iOS", "tablet"}},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgentInfo{"Chrome 120", "Android", "tablet"}},
+		{"firefox", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			userAgentInfo{"Firefox 121", "Linux", "desktop"}},
+		// Firefox for iOS は Safari の名前も含む
+		{"firefox ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/121.0 Mobile/15E148 Safari/605.1.15",
+			userAgentInfo{"Firefox 121", "iOS", "mobile"}},
+		{"opera", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/106.0.0.0",
+			userAgentInfo{"Opera 106", "Windows", "desktop"}},
+		{"chromeos", "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			userAgentInfo{"Chrome 120", "ChromeOS", "desktop"}},
+		// ボットやコマンドラインのツールは名前だけ
+		{"googlebot", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			userAgentInfo{"Googlebot", "Unknown", "bot"}},
+		{"curl", "curl/8.4.0", userAgentInfo{"curl", "Unknown", "bot"}},
+		{"go", "Go-http-client/1.1", userAgentInfo{"Go-http-client", "Unknown", "bot"}},
+		{"python", "python-requests/2.31.0", userAgentInfo{"python-requests", "Unknown", "bot"}},
+		{"empty", "", userAgentInfo{"Unknown", "Unknown", "unknown"}},
+		{"unknown", "SomeClient/1.0", userAgentInfo{"Unknown", "Unknown", "unknown"}},
+	}
+
+	for _, c := range cases {
+		if info := parseUserAgent(c.userAgent); info != c.expected {
+			t.Fatalf("%s: expected %+v, got %+v", c.name, c.expected, info)
+		}
+	}
+}
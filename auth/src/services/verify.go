@@ -0,0 +1,143 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/mailer"
+	"auth/models"
+	"auth/utils"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// メールアドレス確認トークンの有効期限
+	verifyEmailExpiry = time.Hour * 24
+)
+
+var (
+	// メールアドレスが確認されていない時のエラー
+	ErrEmailNotVerified = errors.New("email address is not verified")
+)
+
+// メールアドレスの確認が必要か
+func isEmailVerificationRequired(user *models.User) (bool, error) {
+	// basic 以外、確認済みの時
+	if !needsEmailVerification(user, nil) {
+		return false, nil
+	}
+
+	// プロバイダを取得
+	provider, err := models.GetProvider(models.Basic)
+
+	// エラー処理
+	if err != nil {
+		return false, err
+	}
+
+	return needsEmailVerification(user, provider), nil
+}
+
+// ユーザーとプロバイダ設定から確認が必要か判定する (provider が nil の時は設定を見ない)
+func needsEmailVerification(user *models.User, provider *models.Provider) bool {
+	// basic 以外、確認済みの時
+	if user.ProvCode != models.Basic || user.EmailVerifiedAt != 0 {
+		return false
+	}
+
+	return provider == nil || provider.RequireEmailVerification == 1
+}
+
+// 確認メールを送信する
+func SendVerificationEmail(user *models.User) error {
+	// 以前のトークンを無効にする
+	err := models.RevokeOneTimeTokens(user.UserID, models.PurposeVerifyEmail, utils.NowTime())
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// トークンを発行する
+	token, err := IssueOneTimeToken(user, models.PurposeVerifyEmail, verifyEmailExpiry)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 確認用 URL
+	link := PublicURL + "/basic/verify?token=" + url.QueryEscape(token)
+
+	// 送信する
+	return mailer.Send(mailer.Mail{
+		To:      user.Email,
+		Subject: "メールアドレスの確認",
+		Body: user.Name + " 様\r\n\r\n" +
+			"以下のリンクからメールアドレスを確認してください。\r\n" +
+			link + "\r\n\r\n" +
+			"このリンクの有効期限は 24 時間です。\r\n" +
+			"心当たりがない場合はこのメールを破棄してください。\r\n",
+	})
+}
+
+// メールアドレスを確認する
+func VerifyEmail(token string) error {
+	// トークンを使用する
+	user, err := ConsumeOneTimeToken(token, models.PurposeVerifyEmail)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	// 既に確認済みの時
+	if user.EmailVerifiedAt != 0 {
+		return nil
+	}
+
+	// 確認済みにする
+	user.EmailVerifiedAt = utils.NowTime()
+
+	// ユーザーを更新する
+	return models.UpdateUser(user)
+}
+
+// 確認メールを再送信する (ユーザーの存在は返さない)
+func ResendVerificationEmail(email string, remoteIP string) error {
+	email = strings.TrimSpace(email)
+
+	// 制限する
+	allowed, err := throttleMailRequest(email, remoteIP)
+	if err != nil {
+		return err
+	}
+
+	if allowed {
+		// 応答時間で存在を判別されないように非同期で送る
+		go resendVerificationEmail(email)
+	}
+
+	return nil
+}
+
+func resendVerificationEmail(email string) {
+	// ユーザーを取得する
+	user, result := models.GetUserByEmail(email)
+
+	// 存在しない時
+	if result.Error != nil {
+		return
+	}
+
+	// basic 以外、確認済みの時
+	if user.ProvCode != models.Basic || user.EmailVerifiedAt != 0 {
+		return
+	}
+
+	// 送信する
+	if err := SendVerificationEmail(user); err != nil {
+		logger.PrintErr(err)
+	}
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"auth/mailer"
+	"auth/models"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// メール本文から確認トークンを取り出す
+func verificationToken(t *testing.T, mail mailer.Mail) string {
+	t.Helper()
+
+	for _, line := range strings.Split(mail.Body, "\r\n") {
+		if !strings.Contains(line, "/basic/verify?") {
+			continue
+		}
+
+		link, err := url.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return link.Query().Get("token")
+	}
+
+	t.Fatalf("no verification link in mail: %q", mail.Body)
+	return ""
+}
+
+func TestVerificationEmail(t *testing.T) {
+	requireTestDB(t)
+	sender := useMemoryMailer(t)
+	user := createTestUser(t)
+
+	// 発行する
+	if err := SendVerificationEmail(user); err != nil {
+		t.Fatal(err)
+	}
+
+	mails := sender.Sent()
+	if len(mails) != 1 || mails[0].To != user.Email {
+		t.Fatalf("unexpected mails: %+v", mails)
+	}
+	token := verificationToken(t, mails[0])
+
+	// 確認する
+	if err := VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, result := models.GetUser(user.UserID)
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if verified.EmailVerifiedAt == 0 {
+		t.Fatal("EmailVerifiedAt was not set")
+	}
+
+	// 二度は使えない
+	if err := VerifyEmail(token); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("reused token: got %v", err)
+	}
+}
+
+func TestVerificationEmailExpired(t *testing.T) {
+	requireTestDB(t)
+	user := createTestUser(t)
+
+	token, err := IssueOneTimeToken(user, models.PurposeVerifyEmail, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyEmail(token); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("expired token: got %v", err)
+	}
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	requireTestDB(t)
+	sender := useMemoryMailer(t)
+	user := createTestUser(t)
+
+	if err := SendVerificationEmail(user); err != nil {
+		t.Fatal(err)
+	}
+	first := verificationToken(t, sender.Sent()[0])
+
+	// 再送は非同期で送られる
+	if err := ResendVerificationEmail(user.Email, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sender.Sent()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("resent mail was not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	second := verificationToken(t, sender.Sent()[1])
+
+	// 以前のトークンは使えない
+	if err := VerifyEmail(first); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("previous token: got %v", err)
+	}
+
+	if err := VerifyEmail(second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNeedsEmailVerification(t *testing.T) {
+	required := &models.Provider{RequireEmailVerification: 1}
+	optional := &models.Provider{RequireEmailVerification: 0}
+
+	tests := []struct {
+		name     string
+		user     models.User
+		provider *models.Provider
+		want     bool
+	}{
+		{"basic unverified required", models.User{ProvCode: models.Basic}, required, true},
+		{"basic unverified optional", models.User{ProvCode: models.Basic}, optional, false},
+		{"basic verified", models.User{ProvCode: models.Basic, EmailVerifiedAt: 1}, required, false},
+		{"external provider", models.User{ProvCode: models.Github}, required, false},
+		{"basic without provider", models.User{ProvCode: models.Basic}, nil, true},
+		{"external without provider", models.User{ProvCode: models.Github}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := needsEmailVerification(&test.user, test.provider); got != test.want {
+				t.Fatalf("needsEmailVerification() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOneTimeClaims(t *testing.T) {
+	user := &models.User{UserID: "user-1", Email: "alice@example.com"}
+	expiresAt := time.Now().Add(time.Hour)
+
+	token, err := signOneTimeToken("token-1", user, models.PurposeVerifyEmail, expiresAt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 正しい用途
+	claims, err := parseOneTimeClaims(token, models.PurposeVerifyEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.TokenID != "token-1" || claims.UserID != user.UserID || claims.Email != user.Email || claims.Purpose != models.PurposeVerifyEmail {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	// 別の用途には使えない
+	if _, err := parseOneTimeClaims(token, models.PurposeResetPassword); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("expected ErrInvalidOneTimeToken for other purpose, got %v", err)
+	}
+
+	// 改ざんされたトークン
+	if _, err := parseOneTimeClaims(token+"x", models.PurposeVerifyEmail); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("expected ErrInvalidOneTimeToken for tampered token, got %v", err)
+	}
+
+	// 期限切れ
+	expired, err := signOneTimeToken("token-2", user, models.PurposeVerifyEmail, time.Now().Add(-time.Minute), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOneTimeClaims(expired, models.PurposeVerifyEmail); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("expected ErrInvalidOneTimeToken for expired token, got %v", err)
+	}
+
+	// 別の鍵で署名されたトークン
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"jti":     "token-3",
+		"sub":     user.UserID,
+		"purpose": string(models.PurposeVerifyEmail),
+		"email":   user.Email,
+		"exp":     expiresAt.Unix(),
+	}).SignedString([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOneTimeClaims(forged, models.PurposeVerifyEmail); !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Fatalf("expected ErrInvalidOneTimeToken for forged token, got %v", err)
+	}
+}
+
+func TestOneTimeRecordValid(t *testing.T) {
+	const now = int64(1_000_000)
+	claims := &oneTimeClaims{TokenID: "token-1", UserID: "user-1", Purpose: models.PurposeVerifyEmail}
+	valid := models.OneTimeToken{TokenID: "token-1", UserID: "user-1", Purpose: models.PurposeVerifyEmail, ExpiresAt: now + 60}
+
+	tests := []struct {
+		name   string
+		modify func(record *models.OneTimeToken)
+		want   bool
+	}{
+		{"valid", func(record *models.OneTimeToken) {}, true},
+		{"used", func(record *models.OneTimeToken) { record.UsedAt = now - 1 }, false},
+		{"expired", func(record *models.OneTimeToken) { record.ExpiresAt = now }, false},
+		{"other user", func(record *models.OneTimeToken) { record.UserID = "user-2" }, false},
+		{"other purpose", func(record *models.OneTimeToken) { record.Purpose = models.PurposeResetPassword }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := valid
+			test.modify(&record)
+
+			if got := isOneTimeRecordValid(&record, claims, now); got != test.want {
+				t.Fatalf("isOneTimeRecordValid() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
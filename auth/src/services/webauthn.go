@@ -0,0 +1,496 @@
+package services
+
+import (
+	"auth/logger"
+	"auth/models"
+	"auth/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	// 登録、認証の有効期限
+	webauthnCeremonyExpiry = time.Minute * 5
+
+	// 同時に進行できる登録、認証の数 (認証なしで開始できるので上限を設ける)
+	webauthnMaxCeremonies = 10000
+)
+
+var (
+	// WebAuthn の設定
+	webAuthn *webauthn.WebAuthn
+
+	// 進行中の登録、認証
+	webauthnCeremonies = newCeremonyStore(webauthnMaxCeremonies)
+
+	// IP ごとのパスキーログイン開始の制限
+	webauthnLoginLimiter = newRateLimiter(20, time.Minute)
+
+	// 登録、認証が見つからない時のエラー
+	ErrCeremonyNotFound = errors.New("webauthn ceremony not found or expired")
+
+	// パスキーが見つからない時のエラー
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
+
+func initWebauthn() {
+	// RP ID (ドメイン)
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+
+	// 表示名
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "AuthBase"
+	}
+
+	// 許可するオリジン
+	origins := []string{}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	if len(origins) == 0 {
+		origins = []string{"https://localhost:8370"}
+	}
+
+	// 初期化する
+	wauth, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+
+	// エラー処理
+	if err != nil {
+		logger.PrintErr("WebAuthn の初期化に失敗しました", err)
+		return
+	}
+
+	webAuthn = wauth
+}
+
+// webauthn.User の実装
+type webauthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (wuser *webauthnUser) WebAuthnID() []byte {
+	return []byte(wuser.user.UserID)
+}
+
+func (wuser *webauthnUser) WebAuthnName() string {
+	return wuser.user.Email
+}
+
+func (wuser *webauthnUser) WebAuthnDisplayName() string {
+	return wuser.user.Name
+}
+
+func (wuser *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return wuser.credentials
+}
+
+// ユーザーとクレデンシャルを読み込む
+func loadWebauthnUser(userID string) (*webauthnUser, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// クレデンシャルを取得
+	records, err := user.GetWebauthnCredentials()
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := []webauthn.Credential{}
+	for _, record := range records {
+		var credential webauthn.Credential
+
+		// デコードする
+		if err := json.Unmarshal([]byte(record.Data), &credential); err != nil {
+			logger.PrintErr(err)
+			continue
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+// ここから進行中の登録、認証の保存
+type ceremony struct {
+	session webauthn.SessionData // ライブラリのセッション
+	userID  string               // ユーザーID (ログイン時は空)
+	expires time.Time            // 有効期限
+}
+
+type ceremonyStore struct {
+	mutex      sync.Mutex
+	size       int // 最大数
+	ceremonies map[string]ceremony
+}
+
+func newCeremonyStore(size int) *ceremonyStore {
+	return &ceremonyStore{size: size, ceremonies: map[string]ceremony{}}
+}
+
+// 保存してIDを返す (上限に達している時はエラー)
+func (store *ceremonyStore) Put(session *webauthn.SessionData, userID string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+
+	// 期限切れを削除する
+	for id, value := range store.ceremonies {
+		if value.expires.Before(now) {
+			delete(store.ceremonies, id)
+		}
+	}
+
+	// 上限に達している時
+	if len(store.ceremonies) >= store.size {
+		return "", ErrTooManyRequests
+	}
+
+	id := utils.GenID()
+	store.ceremonies[id] = ceremony{
+		session: *session,
+		userID:  userID,
+		expires: now.Add(webauthnCeremonyExpiry),
+	}
+
+	return id, nil
+}
+
+// 取り出す (一度しか取り出せない)
+func (store *ceremonyStore) Take(id string, userID string) (webauthn.SessionData, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	value, ok := store.ceremonies[id]
+	if !ok {
+		return webauthn.SessionData{}, ErrCeremonyNotFound
+	}
+
+	delete(store.ceremonies, id)
+
+	// 期限切れ、ユーザー違いの時
+	if value.expires.Before(time.Now()) || value.userID != userID {
+		return webauthn.SessionData{}, ErrCeremonyNotFound
+	}
+
+	return value.session, nil
+}
+
+// ここまで
+
+// WebAuthn が使えるか
+func requireWebauthn() error {
+	if webAuthn == nil {
+		return errors.New("webauthn is not configured")
+	}
+
+	return nil
+}
+
+// ここからパスキー登録
+type PasskeyCeremony struct {
+	CeremonyID string      `json:"ceremonyId"` // 完了時に送るID
+	Options    interface{} `json:"options"`    // navigator.credentials に渡すオプション
+}
+
+// パスキー登録を開始する
+func BeginPasskeyRegistration(userID string) (PasskeyCeremony, error) {
+	if err := requireWebauthn(); err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	// ユーザーを読み込む
+	wuser, err := loadWebauthnUser(userID)
+
+	// エラー処理
+	if err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	// 登録済みのクレデンシャルを除外する
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range wuser.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	// 開始する (パスキーとして保存させる)
+	creation, session, err := webAuthn.BeginRegistration(wuser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+
+	// エラー処理
+	if err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	// 保存する
+	ceremonyID, err := webauthnCeremonies.Put(session, userID)
+	if err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	return PasskeyCeremony{
+		CeremonyID: ceremonyID,
+		Options:    creation,
+	}, nil
+}
+
+// パスキー登録を完了する
+func FinishPasskeyRegistration(userID string, ceremonyID string, name string, request *http.Request) (Passkey, error) {
+	if err := requireWebauthn(); err != nil {
+		return Passkey{}, err
+	}
+
+	// セッションを取り出す
+	session, err := webauthnCeremonies.Take(ceremonyID, userID)
+
+	// エラー処理
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	// ユーザーを読み込む
+	wuser, err := loadWebauthnUser(userID)
+
+	// エラー処理
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	// 検証する
+	credential, err := webAuthn.FinishRegistration(wuser, session, request)
+
+	// エラー処理
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	// エンコードする
+	data, err := json.Marshal(credential)
+
+	// エラー処理
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	// 名前がない時
+	if name = strings.TrimSpace(name); name == "" {
+		name = "Passkey"
+	}
+
+	// 保存する
+	record := models.WebauthnCredential{
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserID:       userID,
+		Name:         name,
+		Data:         string(data),
+	}
+
+	if err := models.CreateWebauthnCredential(&record); err != nil {
+		return Passkey{}, err
+	}
+
+	return toPasskey(record), nil
+}
+
+// ここまで
+
+// ここからパスキーログイン
+// パスキーログインを開始する
+func BeginPasskeyLogin(remoteIP string) (PasskeyCeremony, error) {
+	if err := requireWebauthn(); err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	// IP ごとの制限
+	if !webauthnLoginLimiter.Allow(remoteIP) {
+		return PasskeyCeremony{}, ErrTooManyRequests
+	}
+
+	// ユーザーを指定せずに開始する
+	assertion, session, err := webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+
+	// エラー処理
+	if err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	// 保存する
+	ceremonyID, err := webauthnCeremonies.Put(session, "")
+	if err != nil {
+		return PasskeyCeremony{}, err
+	}
+
+	return PasskeyCeremony{
+		CeremonyID: ceremonyID,
+		Options:    assertion,
+	}, nil
+}
+
+// パスキーログインを完了してリフレッシュトークンを返す
+func FinishPasskeyLogin(ceremonyID string, request *http.Request, args SessionArgs) (string, error) {
+	if err := requireWebauthn(); err != nil {
+		return "", err
+	}
+
+	// セッションを取り出す
+	session, err := webauthnCeremonies.Take(ceremonyID, "")
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	// 使われたクレデンシャル
+	var record *models.WebauthnCredential
+
+	// クレデンシャルからユーザーを探す
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		// クレデンシャルを取得
+		found, result := models.GetWebauthnCredential(base64.RawURLEncoding.EncodeToString(rawID))
+		if result.Error != nil {
+			return nil, ErrPasskeyNotFound
+		}
+
+		// ユーザーが一致しない時
+		if found.UserID != string(userHandle) {
+			return nil, ErrPasskeyNotFound
+		}
+
+		record = found
+		return loadWebauthnUser(found.UserID)
+	}
+
+	// 検証する
+	credential, err := webAuthn.FinishDiscoverableLogin(handler, session, request)
+
+	// エラー処理
+	if err != nil {
+		return "", err
+	}
+
+	// 複製された認証器の可能性がある時
+	if credential.Authenticator.CloneWarning {
+		return "", errors.New("authenticator may be cloned")
+	}
+
+	// 署名カウンタを更新する
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return "", err
+	}
+
+	record.Data = string(data)
+	record.LastUsedAt = utils.NowTime()
+	if err := models.UpdateWebauthnCredential(record); err != nil {
+		return "", err
+	}
+
+	// セッションを作成する
+	args.UserID = record.UserID
+	return NewSession(args)
+}
+
+// ここまで
+
+// ここからパスキー管理
+type Passkey struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt"`
+}
+
+func toPasskey(record models.WebauthnCredential) Passkey {
+	return Passkey{
+		ID:         record.CredentialID,
+		Name:       record.Name,
+		CreatedAt:  record.CreatedAt * 1000,
+		LastUsedAt: record.LastUsedAt * 1000,
+	}
+}
+
+// パスキー一覧を取得する
+func GetPasskeys(userID string) ([]Passkey, error) {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	// クレデンシャルを取得
+	records, err := user.GetWebauthnCredentials()
+
+	// エラー処理
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []Passkey{}
+	for _, record := range records {
+		passkeys = append(passkeys, toPasskey(record))
+	}
+
+	return passkeys, nil
+}
+
+// パスキーを削除する
+func DeletePasskey(userID string, credentialID string) error {
+	// ユーザーを取得
+	user, result := models.GetUser(userID)
+
+	// エラー処理
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// 削除する
+	deleted, err := user.DeleteWebauthnCredential(credentialID)
+
+	// エラー処理
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+// ここまで
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func TestCeremonyStore(t *testing.T) {
+	store := newCeremonyStore(2)
+	session := &webauthn.SessionData{Challenge: "challenge"}
+
+	first, err := store.Put(session, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put(session, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// 上限に達している時
+	if _, err := store.Put(session, ""); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("expected ErrTooManyRequests, got %v", err)
+	}
+
+	// ユーザー違いの時は取り出せず、消える
+	if _, err := store.Take(first, "other"); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Fatalf("expected ErrCeremonyNotFound, got %v", err)
+	}
+	if _, err := store.Take(first, "user"); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Fatal("ceremony must be taken only once")
+	}
+
+	// 期限切れは追加する時に掃除される
+	for id, value := range store.ceremonies {
+		value.expires = time.Now().Add(-time.Second)
+		store.ceremonies[id] = value
+	}
+	id, err := store.Put(session, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.ceremonies) != 1 {
+		t.Fatalf("expired ceremonies were not swept: %d left", len(store.ceremonies))
+	}
+
+	taken, err := store.Take(id, "user")
+	if err != nil || taken.Challenge != "challenge" {
+		t.Fatalf("unexpected take: %+v %v", taken, err)
+	}
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTP の時間ステップ (RFC 6238)
+	TotpPeriod = 30
+
+	// TOTP の桁数
+	TotpDigits = 6
+
+	// 前後に許容するステップ数
+	totpSkew = 1
+)
+
+var (
+	// パディングなしの base32
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// TOTP のシークレットを生成 (160 bit)
+func GenTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	// 乱数を生成
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// 指定したステップのコードを計算する (RFC 4226)
+func TotpCode(secret string, step int64) (string, error) {
+	// シークレットをデコード
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	// カウンタ
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	// HMAC-SHA1
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// 動的切り捨て
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TotpDigits, value%1000000), nil
+}
+
+// コードを検証して一致したステップを返す
+func ValidateTotp(secret string, code string, now time.Time) (int64, bool) {
+	// 空白を削除
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+
+	// 現在のステップ
+	current := now.Unix() / TotpPeriod
+
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		// コードを計算
+		expected, err := TotpCode(secret, current+offset)
+		if err != nil {
+			return 0, false
+		}
+
+		// 定数時間で比較
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + offset, true
+		}
+	}
+
+	return 0, false
+}
+
+// 認証アプリ用の otpauth:// URI を生成する
+func TotpURI(issuer string, account string, secret string) string {
+	// ラベル
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	// パラメータ
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TotpDigits))
+	params.Set("period", fmt.Sprint(TotpPeriod))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B の SHA1 用シークレット ("12345678901234567890" の base32)
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCodeRFC6238(t *testing.T) {
+	// 8 桁の値の下 6 桁
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := TotpCode(rfcTotpSecret, test.unix/TotpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.want {
+			t.Errorf("TotpCode(T=%d) = %s, want %s", test.unix, code, test.want)
+		}
+	}
+}
+
+func TestTotpCodeLowercaseSecret(t *testing.T) {
+	code, err := TotpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 59/TotpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Fatalf("TotpCode() = %s, want 287082", code)
+	}
+}
+
+func TestValidateTotpWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / TotpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"previous step", -1, true},
+		{"current step", 0, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := TotpCode(rfcTotpSecret, current+test.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := ValidateTotp(rfcTotpSecret, code, now)
+			if ok != test.ok {
+				t.Fatalf("ValidateTotp() ok = %v, want %v", ok, test.ok)
+			}
+			if ok && step != current+test.offset {
+				t.Fatalf("ValidateTotp() step = %d, want %d", step, current+test.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTotpFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	// 空白は無視する
+	if _, ok := ValidateTotp(rfcTotpSecret, "287 082", now); !ok {
+		t.Fatal("code with a space was rejected")
+	}
+
+	// 桁数が違う時
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := ValidateTotp(rfcTotpSecret, code, now); ok {
+			t.Errorf("ValidateTotp(%q) accepted a code of the wrong length", code)
+		}
+	}
+
+	// 違うコード
+	if _, ok := ValidateTotp(rfcTotpSecret, "000000", now); ok {
+		t.Fatal("wrong code was accepted")
+	}
+}
+
+func TestTotpMalformedSecret(t *testing.T) {
+	// base32 でないシークレット
+	if _, err := TotpCode("not-base32!", 1); err == nil {
+		t.Fatal("TotpCode() accepted a malformed secret")
+	}
+
+	if _, ok := ValidateTotp("not-base32!", "123456", time.Now()); ok {
+		t.Fatal("ValidateTotp() accepted a malformed secret")
+	}
+}
+
+func TestGenTotpSecret(t *testing.T) {
+	secret, err := GenTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 160 bit = base32 で 32 文字
+	if len(secret) != 32 {
+		t.Fatalf("secret length = %d, want 32", len(secret))
+	}
+	if _, err := TotpCode(secret, 1); err != nil {
+		t.Fatal(err)
+	}
+}
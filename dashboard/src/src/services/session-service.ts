@@ -9,7 +9,8 @@ export interface Session {
   ipAddress: string
   userAgent: string
   createdAt: string
-  expiresAt: string // 最終アクティブから有効期限に変更
+  lastSeenAt: string // 最後に使われた日時
+  expiresAt: string // 絶対期限とアイドル期限の早い方
   isActive: boolean
 }
 
@@ -17,12 +17,12 @@ class AuthBase {
 
     // ログアウトする関数
     async Logout() {
-        // ログアウトする (リフレッシュトークンでログアウトする)
+        // ログアウトする (アクセストークンでセッションを終了する)
         const req = await fetch("/auth/logout", {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
-                "Authorization": localStorage.getItem("token")
+                "Authorization": await this.getToken()
             }
         });
 
@@ -70,27 +70,43 @@ class AuthBase {
             return window.sessionStorage.getItem("actoken");
         }
 
-        // トークンを取得
-        const req = await fetch(this.baseURL + '/token', {
-            method: 'GET',
-            headers: {
-                'Content-Type': 'application/json',
-                "Authorization": localStorage.getItem("token")
+        // 別のタブが先にローテーションした時は一度だけやり直す
+        for (let retry = 0; retry < 2; retry++) {
+            const refreshToken = localStorage.getItem("token");
+            if (!refreshToken) {
+                return null;
             }
-        });
 
-        // トークンを取得
-        if (req.ok) {
-            const data = await req.json();
+            // リフレッシュトークンをトークンに交換する
+            const req = await fetch(this.baseURL + '/token', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    "Authorization": refreshToken
+                }
+            });
+
+            // トークンを取得
+            if (req.ok) {
+                const data = await req.json();
+
+                console.log("Get Token");
 
-            console.log("Get Token");
+                // ローテーションしたリフレッシュトークンを保存する
+                localStorage.setItem("token", data.refreshToken);
 
-            // トークンを保存する
-            window.sessionStorage.setItem("actoken", data.token);
-            // 現在の時間を保存する
-            window.sessionStorage.setItem("actime", Date.now());
+                // トークンを保存する
+                window.sessionStorage.setItem("actoken", data.token);
+                // 現在の時間を保存する
+                window.sessionStorage.setItem("actime", Date.now());
 
-            return data.token;
+                return data.token;
+            }
+
+            // 他のタブで更新されていなければ諦める
+            if (localStorage.getItem("token") === refreshToken) {
+                break;
+            }
         }
 
         return null;
@@ -106,7 +122,7 @@ class AuthBase {
         const req = await fetch(this.baseURL + '/icon', {
             method: 'POST',
             headers: {
-                "Authorization": localStorage.getItem("token")
+                "Authorization": await this.getToken()
             },
             body: payload
         })
@@ -159,7 +175,7 @@ class AuthBase {
                 method: 'GET',
                 headers: {
                     'Content-Type': 'application/json',
-                    "Authorization": localStorage.getItem("token")
+                    "Authorization": await this.getToken()
                 }
             });
 